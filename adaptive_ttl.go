@@ -0,0 +1,109 @@
+package query
+
+import (
+	"sync"
+	"time"
+)
+
+// AdaptiveTTL describes how the cache duration of a query type adapts to the usage of its cache keys.
+// Frequently accessed keys are cached longer (up to the Max duration), while rarely accessed keys expire sooner (after the Min duration).
+type AdaptiveTTL struct {
+	// Min is the cache duration of the keys accessed at most once per window.
+	Min time.Duration
+	// Max is the cache duration of the keys accessed at least Frequent times per window.
+	Max time.Duration
+	// Frequent is the number of accesses per window from which a key is considered frequently accessed.
+	Frequent int
+	// Window is the period over which the accesses are counted. It defaults to the Max duration.
+	Window time.Duration
+}
+
+// AdaptiveTTL may optionally be provided to adapt the cache duration of the given query type (ID) to the usage of its cache keys.
+// The accesses of each cache key (both hits and misses) are counted, the duration being interpolated between the Min and Max durations
+// once the result is cached. The CacheDuration of the queries is then only used to disable the caching (lesser or equal to zero).
+// Providing a zero AdaptiveTTL removes the adaptation of the query type.
+func (bus *Bus) AdaptiveTTL(queryID []byte, ttl AdaptiveTTL) {
+	bus.cacheUsage.Lock()
+	defer bus.cacheUsage.Unlock()
+	if ttl == (AdaptiveTTL{}) {
+		delete(bus.cacheUsage.ttls, string(queryID))
+		return
+	}
+	if ttl.Window <= 0 {
+		ttl.Window = ttl.Max
+	}
+	bus.cacheUsage.ttls[string(queryID)] = ttl
+}
+
+//------Internal------//
+
+// cacheUsageSweep is the number of accesses recorded between the removals of the keys no longer accessed.
+const cacheUsageSweep = 1024
+
+// cacheUsage counts the accesses of the cache keys of the query types with an adaptive TTL.
+type cacheUsage struct {
+	sync.Mutex
+	ttls    map[string]AdaptiveTTL
+	keys    map[string]*keyUsage
+	records int
+}
+
+// keyUsage is the number of accesses of a cache key since the start of its current window.
+type keyUsage struct {
+	since    time.Time
+	window   time.Duration
+	accesses int
+}
+
+func newCacheUsage() *cacheUsage {
+	return &cacheUsage{
+		ttls: make(map[string]AdaptiveTTL),
+		keys: make(map[string]*keyUsage),
+	}
+}
+
+// record an access of the cache key of the query at the given moment, if its type has an adaptive TTL.
+func (cu *cacheUsage) record(now time.Time, qry Query, key []byte) {
+	cu.Lock()
+	defer cu.Unlock()
+	ttl, adaptive := cu.ttls[string(qry.ID())]
+	if !adaptive {
+		return
+	}
+	usage, exists := cu.keys[string(key)]
+	if !exists || now.Sub(usage.since) >= ttl.Window {
+		usage = &keyUsage{since: now, window: ttl.Window}
+		cu.keys[string(key)] = usage
+	}
+	usage.accesses++
+
+	cu.records++
+	if cu.records%cacheUsageSweep == 0 {
+		for k, u := range cu.keys {
+			if now.Sub(u.since) >= u.window {
+				delete(cu.keys, k)
+			}
+		}
+	}
+}
+
+// duration returns the cache duration of the query according to the usage of its cache key, as of the given moment.
+func (cu *cacheUsage) duration(now time.Time, qry Query, key []byte, d time.Duration) time.Duration {
+	cu.Lock()
+	defer cu.Unlock()
+	ttl, adaptive := cu.ttls[string(qry.ID())]
+	if !adaptive {
+		return d
+	}
+	accesses := 0
+	if usage, exists := cu.keys[string(key)]; exists && now.Sub(usage.since) < ttl.Window {
+		accesses = usage.accesses
+	}
+	switch {
+	case accesses <= 1:
+		return ttl.Min
+	case accesses >= ttl.Frequent:
+		return ttl.Max
+	}
+	return ttl.Min + (ttl.Max-ttl.Min)*time.Duration(accesses-1)/time.Duration(ttl.Frequent-1)
+}
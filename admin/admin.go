@@ -0,0 +1,180 @@
+// Package admin provides an HTTP API exposing the internals of a query bus to its operators.
+package admin
+
+import (
+	"encoding/json"
+	"errors"
+	"html/template"
+	"net/http"
+	"strings"
+
+	"github.com/io-da/query"
+	"github.com/io-da/query/querydoc"
+)
+
+// Handler is the http.Handler serving the admin API of a bus.
+// It serves the following routes, relative to where it is mounted:
+//   - /queries: browsable catalog of the queries known to the bus.
+//   - /queries.json: machine-readable catalog of the queries known to the bus.
+//   - /dump.json: snapshot of the runtime state of the bus (see query.Bus.Dump).
+//
+// The operators are authenticated using their bearer token, and authorized to the admin operations (see Authorization).
+// Every route is denied until an authorization is provided, unless the handler is explicitly made insecure (see Insecure).
+type Handler struct {
+	bus      *query.Bus
+	auth     *query.Authorization
+	insecure bool
+	docs     map[string]querydoc.QueryDoc
+	mux      *http.ServeMux
+}
+
+// CatalogEntry describes a query known to the bus, including its documentation when available.
+type CatalogEntry struct {
+	query.QueryDescription
+	Doc    string              `json:"doc,omitempty"`
+	Fields []querydoc.FieldDoc `json:"fields,omitempty"`
+}
+
+// NewHandler initializes a new admin API for the provided bus.
+func NewHandler(bus *query.Bus) *Handler {
+	hdl := &Handler{
+		bus:  bus,
+		docs: make(map[string]querydoc.QueryDoc),
+		mux:  http.NewServeMux(),
+	}
+	hdl.mux.HandleFunc("/queries", hdl.catalogHTML)
+	hdl.mux.HandleFunc("/queries.json", hdl.catalogJSON)
+	hdl.mux.HandleFunc("/dump.json", hdl.dumpJSON)
+	return hdl
+}
+
+// Docs provides the documentation of the query types, usually extracted by the querydoc package.
+// Documentation is matched with the queries known to the bus by type.
+// It should be used *before* the handler serves any request.
+func (hdl *Handler) Docs(docs []querydoc.QueryDoc) {
+	for _, doc := range docs {
+		hdl.docs[doc.Type()] = doc
+	}
+}
+
+// Catalog returns the catalog of the queries known to the bus.
+func (hdl *Handler) Catalog() []CatalogEntry {
+	descs := hdl.bus.Describe()
+	entries := make([]CatalogEntry, 0, len(descs))
+	for _, desc := range descs {
+		entry := CatalogEntry{QueryDescription: desc}
+		if doc, documented := hdl.docs[desc.Type]; documented {
+			entry.Doc = doc.Doc
+			entry.Fields = doc.Fields
+		}
+		entries = append(entries, entry)
+	}
+	return entries
+}
+
+// Authorization may optionally be provided to authenticate the operators using the bearer token of their Authorization header.
+// Every route is then denied (403 Forbidden) unless the admin permission of the authorization is granted to the operator (see query.Authorization.AdminPermission).
+// Unauthenticated operators are answered with 401 Unauthorized.
+func (hdl *Handler) Authorization(auth *query.Authorization) {
+	hdl.auth = auth
+}
+
+// Insecure may optionally be enabled to serve every route to any operator when no authorization is provided
+// (e.g. when the admin API is only reachable from a trusted network).
+// It is disabled by default, every route being denied (403 Forbidden) until an authorization is provided.
+func (hdl *Handler) Insecure(enabled bool) {
+	hdl.insecure = enabled
+}
+
+// ServeHTTP implements the http.Handler interface.
+func (hdl *Handler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if hdl.auth == nil && !hdl.insecure {
+		deny(w, query.NewErrorPermissionDenied(nil, ""))
+		return
+	}
+	if hdl.auth != nil {
+		ctx, err := hdl.auth.Authenticate(r.Context(), query.BearerToken(r.Header.Get("Authorization")))
+		if err == nil {
+			err = hdl.auth.AuthorizeAdmin(ctx)
+		}
+		if err != nil {
+			deny(w, err)
+			return
+		}
+		r = r.WithContext(ctx)
+	}
+	hdl.mux.ServeHTTP(w, r)
+}
+
+//------Internal------//
+
+func (hdl *Handler) catalogJSON(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		w.Header().Set("Allow", http.MethodGet)
+		http.Error(w, http.StatusText(http.StatusMethodNotAllowed), http.StatusMethodNotAllowed)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	_ = json.NewEncoder(w).Encode(hdl.Catalog())
+}
+
+func (hdl *Handler) dumpJSON(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		w.Header().Set("Allow", http.MethodGet)
+		http.Error(w, http.StatusText(http.StatusMethodNotAllowed), http.StatusMethodNotAllowed)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	_ = enc.Encode(hdl.bus.Dump())
+}
+
+func (hdl *Handler) catalogHTML(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		w.Header().Set("Allow", http.MethodGet)
+		http.Error(w, http.StatusText(http.StatusMethodNotAllowed), http.StatusMethodNotAllowed)
+		return
+	}
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	_ = catalogTemplate.Execute(w, hdl.Catalog())
+}
+
+// deny answers the request of an operator not authorized, challenging the unauthenticated operators.
+func deny(w http.ResponseWriter, err error) {
+	if errors.Is(err, query.UnauthenticatedError) {
+		w.Header().Set("WWW-Authenticate", "Bearer")
+		http.Error(w, err.Error(), http.StatusUnauthorized)
+		return
+	}
+	http.Error(w, err.Error(), http.StatusForbidden)
+}
+
+var catalogTemplate = template.Must(template.New("catalog").Funcs(template.FuncMap{
+	"join": strings.Join,
+}).Parse(`<!DOCTYPE html>
+<html>
+<head><meta charset="utf-8"><title>Query Catalog</title></head>
+<body>
+<h1>Query Catalog</h1>
+{{range .}}
+<section id="{{.ID}}">
+<h2>{{.Type}}{{if .Deprecated}} <small>(deprecated{{if .Replacement}}, use {{.Replacement}}{{end}})</small>{{end}}</h2>
+<p><code>{{.ID}}</code>{{if .Cacheable}} &middot; cacheable{{end}}</p>
+{{if .Doc}}<p>{{.Doc}}</p>{{end}}
+{{if .Fields}}
+<table>
+<tr><th>Field</th><th>Type</th><th>JSON</th><th>Description</th></tr>
+{{range .Fields}}<tr><td>{{.Name}}</td><td><code>{{.Type}}</code></td><td>{{.JSON}}</td><td>{{.Doc}}</td></tr>
+{{end}}</table>
+{{end}}
+{{if .Handlers}}<p>Handlers: {{join .Handlers ", "}}</p>{{end}}
+{{if .IteratorHandlers}}<p>Iterator handlers: {{join .IteratorHandlers ", "}}</p>{{end}}
+{{if .SubscriptionHandlers}}<p>Subscription handlers: {{join .SubscriptionHandlers ", "}}</p>{{end}}
+</section>
+{{else}}
+<p>No queries are routed to specific handlers.</p>
+{{end}}
+</body>
+</html>
+`))
@@ -0,0 +1,151 @@
+package admin
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/io-da/query"
+	"github.com/io-da/query/querydoc"
+)
+
+type testQuery struct {
+	Name string
+}
+
+func (*testQuery) ID() []byte {
+	return []byte("TEST")
+}
+
+type testHandler struct {
+}
+
+func (*testHandler) Handle(_ context.Context, _ query.Query, res *query.Result) error {
+	res.Add(true)
+	return nil
+}
+
+func TestHandler_Catalog(t *testing.T) {
+	bus := query.NewBus()
+	bus.Handle(&testQuery{}, &testHandler{})
+	bus.Deprecate(&testQuery{}, "NewTestQuery")
+
+	hdl := NewHandler(bus)
+	hdl.Insecure(true)
+	hdl.Docs([]querydoc.QueryDoc{{
+		Package: "admin",
+		Name:    "testQuery",
+		Doc:     "testQuery <is> documented.",
+		Fields:  []querydoc.FieldDoc{{Name: "Name", Type: "string"}},
+	}})
+
+	rec := httptest.NewRecorder()
+	hdl.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/queries.json", nil))
+	if rec.Code != http.StatusOK || rec.Header().Get("Content-Type") != "application/json" {
+		t.Fatalf("Unexpected response %d.", rec.Code)
+	}
+	var entries []CatalogEntry
+	if err := json.NewDecoder(rec.Body).Decode(&entries); err != nil {
+		t.Fatal(err)
+	}
+	if len(entries) != 1 {
+		t.Fatalf("Expected 1 catalog entry, got %d.", len(entries))
+	}
+	entry := entries[0]
+	if entry.ID != "TEST" || entry.Type != "admin.testQuery" || entry.Doc != "testQuery <is> documented." ||
+		len(entry.Fields) != 1 || len(entry.Handlers) != 1 || !entry.Deprecated || entry.Replacement != "NewTestQuery" {
+		t.Errorf("Unexpected catalog entry %+v.", entry)
+	}
+
+	rec = httptest.NewRecorder()
+	hdl.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/queries", nil))
+	if rec.Code != http.StatusOK {
+		t.Fatalf("Unexpected response %d.", rec.Code)
+	}
+	body := rec.Body.String()
+	if !strings.Contains(body, "admin.testQuery") || !strings.Contains(body, "testQuery &lt;is&gt; documented.") {
+		t.Errorf("Unexpected catalog page %s.", body)
+	}
+
+	rec = httptest.NewRecorder()
+	hdl.ServeHTTP(rec, httptest.NewRequest(http.MethodPost, "/queries.json", nil))
+	if rec.Code != http.StatusMethodNotAllowed {
+		t.Errorf("Unexpected response %d.", rec.Code)
+	}
+}
+
+func TestHandler_Authorization(t *testing.T) {
+	bus := query.NewBus()
+	bus.Handle(&testQuery{}, &testHandler{})
+	hdl := NewHandler(bus)
+
+	// every route is denied until an authorization is provided
+	rec := httptest.NewRecorder()
+	hdl.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/queries.json", nil))
+	if rec.Code != http.StatusForbidden {
+		t.Errorf("Expected the admin operations to be denied without authorization, got %d.", rec.Code)
+	}
+
+	auth := query.NewAuthorization(query.AuthenticatorFunc(func(_ context.Context, token string) (*query.Principal, error) {
+		return &query.Principal{Subject: token, Permissions: []string{token}}, nil
+	}))
+	hdl.Authorization(auth)
+
+	serve := func(authorization string) int {
+		req := httptest.NewRequest(http.MethodGet, "/queries.json", nil)
+		if authorization != "" {
+			req.Header.Set("Authorization", authorization)
+		}
+		rec := httptest.NewRecorder()
+		hdl.ServeHTTP(rec, req)
+		return rec.Code
+	}
+	if code := serve(""); code != http.StatusUnauthorized {
+		t.Errorf("Expected the operator not to be authenticated, got %d.", code)
+	}
+	// the admin operations are denied by default
+	if code := serve("Bearer operator"); code != http.StatusForbidden {
+		t.Errorf("Expected the admin operations to be denied, got %d.", code)
+	}
+
+	auth.AdminPermission("operator")
+	if code := serve("Bearer operator"); code != http.StatusOK {
+		t.Errorf("Expected the admin operations to be allowed, got %d.", code)
+	}
+	if code := serve("Bearer reader"); code != http.StatusForbidden {
+		t.Errorf("Expected the admin operations to be denied, got %d.", code)
+	}
+	if code := serve("Basic b3BlcmF0b3I="); code != http.StatusUnauthorized {
+		t.Errorf("Expected the operator not to be authenticated, got %d.", code)
+	}
+}
+
+func TestHandler_Dump(t *testing.T) {
+	bus := query.NewBus()
+	bus.Handle(&testQuery{}, &testHandler{})
+	defer bus.Shutdown()
+
+	hdl := NewHandler(bus)
+	rec := httptest.NewRecorder()
+	hdl.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/dump.json", nil))
+	if rec.Code != http.StatusForbidden {
+		t.Fatalf("Expected the dump to be denied without authorization, got %d.", rec.Code)
+	}
+
+	hdl.Insecure(true)
+	rec = httptest.NewRecorder()
+	hdl.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/dump.json", nil))
+	if rec.Code != http.StatusOK || rec.Header().Get("Content-Type") != "application/json" {
+		t.Fatalf("Unexpected response %d.", rec.Code)
+	}
+	d := &query.Dump{}
+	if err := json.NewDecoder(rec.Body).Decode(d); err != nil {
+		t.Fatal(err)
+	}
+	if diffs := query.Diff(d, bus.Dump()); len(diffs) != 0 || len(d.Queries) != 1 {
+		t.Errorf("Unexpected dump %+v (%v).", d, diffs)
+	}
+}
@@ -0,0 +1,140 @@
+package query
+
+import (
+	"context"
+	"strings"
+)
+
+// Principal is the authenticated caller of the queries served outside of the process (see Authorization).
+type Principal struct {
+	// Subject identifies the caller (e.g. the subject of its token).
+	Subject string
+	// Permissions are the permissions granted to the caller.
+	Permissions []string
+	// Claims may optionally provide the attributes of the caller, for the handlers to use.
+	Claims map[string]interface{}
+}
+
+// HasPermission reports whether the permission is granted to the principal.
+func (p *Principal) HasPermission(permission string) bool {
+	for _, granted := range p.Permissions {
+		if granted == permission {
+			return true
+		}
+	}
+	return false
+}
+
+// Authenticator must be implemented for a type to qualify as an authenticator.
+// Authenticators validate the tokens of the callers, returning the principal they identify.
+// A failed validation returns an error, reported to the caller as an ErrorUnauthenticated.
+type Authenticator interface {
+	Authenticate(ctx context.Context, token string) (*Principal, error)
+}
+
+// AuthenticatorFunc is a function implementing the Authenticator interface.
+type AuthenticatorFunc func(ctx context.Context, token string) (*Principal, error)
+
+// Authenticate validates the token using the function itself.
+func (fn AuthenticatorFunc) Authenticate(ctx context.Context, token string) (*Principal, error) {
+	return fn(ctx, token)
+}
+
+// Authorization is the authentication and authorization shared by the surfaces serving the bus outside of the process
+// (the HTTP gateway, the gRPC server and the admin API).
+// Every caller must provide a token, validated by the authenticator. The query types (identified by their ID) may require a permission
+// (see Require), the query types not requiring any being allowed to every authenticated caller.
+// The admin operations are denied by default, unless the admin permission is provided (see AdminPermission) and granted to the caller.
+type Authorization struct {
+	authenticator Authenticator
+	queries       map[string]string
+	admin         string
+}
+
+// NewAuthorization initializes a new *Authorization validating the tokens using the provided authenticator.
+func NewAuthorization(authenticator Authenticator) *Authorization {
+	return &Authorization{
+		authenticator: authenticator,
+		queries:       make(map[string]string),
+	}
+}
+
+// Require the permission to perform the query type, identified by its ID. An empty permission removes the requirement.
+// It should be used *before* any query is performed.
+func (a *Authorization) Require(qry Query, permission string) {
+	if permission == "" {
+		delete(a.queries, string(qry.ID()))
+		return
+	}
+	a.queries[string(qry.ID())] = permission
+}
+
+// AdminPermission may optionally be provided to allow the admin operations to the callers granted the permission.
+// The admin operations are denied to every caller otherwise.
+func (a *Authorization) AdminPermission(permission string) {
+	a.admin = permission
+}
+
+// Authenticate validates the token of the caller, returning a copy of the context providing its principal (see PrincipalFrom).
+// It returns an ErrorUnauthenticated if the token is missing or invalid.
+func (a *Authorization) Authenticate(ctx context.Context, token string) (context.Context, error) {
+	if token == "" {
+		return ctx, NewErrorUnauthenticated(nil)
+	}
+	p, err := a.authenticator.Authenticate(ctx, token)
+	if err != nil {
+		return ctx, NewErrorUnauthenticated(err)
+	}
+	if p == nil {
+		return ctx, NewErrorUnauthenticated(nil)
+	}
+	return WithPrincipal(ctx, p), nil
+}
+
+// AuthorizeQuery verifies whether the authenticated caller of the context may perform the query.
+// It returns an ErrorUnauthenticated if the context provides no principal, or an ErrorPermissionDenied if the permission required is not granted.
+func (a *Authorization) AuthorizeQuery(ctx context.Context, qry Query) error {
+	p, authenticated := PrincipalFrom(ctx)
+	if !authenticated {
+		return NewErrorUnauthenticated(nil)
+	}
+	if permission, required := a.queries[string(qry.ID())]; required && !p.HasPermission(permission) {
+		return NewErrorPermissionDenied(qry, permission)
+	}
+	return nil
+}
+
+// AuthorizeAdmin verifies whether the authenticated caller of the context may perform the admin operations.
+// It returns an ErrorUnauthenticated if the context provides no principal, or an ErrorPermissionDenied if the admin permission
+// is not granted (or not provided at all).
+func (a *Authorization) AuthorizeAdmin(ctx context.Context) error {
+	p, authenticated := PrincipalFrom(ctx)
+	if !authenticated {
+		return NewErrorUnauthenticated(nil)
+	}
+	if a.admin == "" || !p.HasPermission(a.admin) {
+		return NewErrorPermissionDenied(nil, a.admin)
+	}
+	return nil
+}
+
+// WithPrincipal returns a copy of the context providing the authenticated caller of its queries.
+func WithPrincipal(ctx context.Context, p *Principal) context.Context {
+	return context.WithValue(ctx, principalKey, p)
+}
+
+// PrincipalFrom returns the authenticated caller of the queries of the context, if any.
+func PrincipalFrom(ctx context.Context) (*Principal, bool) {
+	p, ok := ctx.Value(principalKey).(*Principal)
+	return p, ok && p != nil
+}
+
+// BearerToken extracts the token of a bearer authorization (e.g. the Authorization header "Bearer <token>").
+// It returns an empty token if the authorization is not a bearer authorization.
+func BearerToken(authorization string) string {
+	scheme, token, found := strings.Cut(strings.TrimSpace(authorization), " ")
+	if !found || !strings.EqualFold(scheme, "Bearer") {
+		return ""
+	}
+	return strings.TrimSpace(token)
+}
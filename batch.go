@@ -0,0 +1,63 @@
+package query
+
+import (
+	"context"
+	"sync"
+)
+
+// BatchConcurrency may optionally be provided to tweak the amount of batch queries handled concurrently.
+// It defaults to 10.
+func (bus *Bus) BatchConcurrency(concurrency int) {
+	if concurrency > 0 {
+		bus.batchConcurrency = concurrency
+	}
+}
+
+// QueryBatch handles the queries concurrently (see Bus.BatchConcurrency), as if provided to Bus.Query individually.
+// The results preserve the order of the queries. The results of the failed queries are nil.
+// If any of the queries fails, an ErrorBatch is returned, providing the error of every query.
+func (bus *Bus) QueryBatch(ctx context.Context, qrys ...Query) ([]*Result, error) {
+	results := make([]*Result, len(qrys))
+	errs := make([]error, len(qrys))
+	failed := false
+
+	sem := make(chan bool, bus.batchConcurrency)
+	wg := sync.WaitGroup{}
+	for i, qry := range qrys {
+		select {
+		case sem <- true:
+		case <-ctx.Done():
+			errs[i] = ctx.Err()
+			failed = true
+			continue
+		}
+		wg.Add(1)
+		i, qry := i, qry
+		err := spawn(ctx, qry, "batch", true, func() {
+			defer func() {
+				<-sem
+				wg.Done()
+			}()
+			res, err := bus.Query(ctx, qry)
+			if err != nil {
+				errs[i] = err
+				return
+			}
+			results[i] = res
+		})
+		if err != nil {
+			errs[i] = err
+			<-sem
+			wg.Done()
+		}
+	}
+	wg.Wait()
+
+	for _, err := range errs {
+		failed = failed || err != nil
+	}
+	if failed {
+		return results, NewErrorBatch(errs)
+	}
+	return results, nil
+}
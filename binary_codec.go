@@ -0,0 +1,314 @@
+package query
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"math"
+	"time"
+)
+
+// BinaryValue may be implemented by result values to be serialized by the BinaryCodec.
+// The MarshalBinary function is usually generated, avoiding reflection altogether.
+type BinaryValue interface {
+	ValueType() string
+	MarshalBinary() ([]byte, error)
+}
+
+// BinaryValueDecoder is the signature of the functions used to decode registered value types.
+type BinaryValueDecoder func(data []byte) (interface{}, error)
+
+// BinaryCodec is a reflection-free Codec for results, using a compact binary format.
+// The result values must either be of a basic type (string, []byte, bool, int, int64, float64)
+// or implement the BinaryValue interface and have their type registered using the Register function.
+// It can only be used to serialize results (MarshalResult and UnmarshalResult).
+type BinaryCodec struct {
+	decoders map[string]BinaryValueDecoder
+}
+
+// NewBinaryCodec initializes a new *BinaryCodec.
+func NewBinaryCodec() *BinaryCodec {
+	return &BinaryCodec{
+		decoders: make(map[string]BinaryValueDecoder),
+	}
+}
+
+// Register the decoder of the BinaryValue type identified by the given name.
+// It should be used *before* the codec is used.
+func (c *BinaryCodec) Register(valueType string, dec BinaryValueDecoder) {
+	c.decoders[valueType] = dec
+}
+
+// Marshal encodes the result.
+func (c *BinaryCodec) Marshal(v interface{}) ([]byte, error) {
+	enc, isResult := v.(*encodedResult)
+	if !isResult {
+		return nil, ErrorUnsupportedValue{value: v}
+	}
+	buf := make([]byte, 0, 64+len(enc.CacheKey)+len(enc.Data)*16)
+	buf = appendBytes(buf, enc.CacheKey)
+	buf = appendTime(buf, enc.CachedAt)
+	buf = appendTime(buf, enc.ExpiresAt)
+	buf = binary.AppendUvarint(buf, uint64(len(enc.Data)))
+	for _, val := range enc.Data {
+		var err error
+		if buf, err = c.appendValue(buf, val); err != nil {
+			return nil, err
+		}
+	}
+	return c.appendMetadata(buf, enc)
+}
+
+// Unmarshal decodes the result.
+func (c *BinaryCodec) Unmarshal(data []byte, v interface{}) error {
+	enc, isResult := v.(*encodedResult)
+	if !isResult {
+		return ErrorUnsupportedValue{value: v}
+	}
+	dec := &binaryDecoder{data: data}
+	enc.CacheKey = dec.bytes()
+	enc.CachedAt = dec.time()
+	enc.ExpiresAt = dec.time()
+	n := dec.uvarint()
+	if dec.err != nil {
+		return dec.err
+	}
+	if n > uint64(len(dec.data)) {
+		return errBinaryCorrupted
+	}
+	enc.Data = make([]interface{}, 0, n)
+	for i := uint64(0); i < n; i++ {
+		val, err := c.value(dec)
+		if err != nil {
+			return err
+		}
+		enc.Data = append(enc.Data, val)
+	}
+	return c.metadata(dec, enc)
+}
+
+//------Internal------//
+
+const (
+	binaryNil byte = iota
+	binaryString
+	binaryBytes
+	binaryBool
+	binaryInt
+	binaryInt64
+	binaryFloat64
+	binaryRegistered
+)
+
+var errBinaryCorrupted = errors.New("query: corrupted binary result")
+
+func (c *BinaryCodec) appendValue(buf []byte, val interface{}) ([]byte, error) {
+	switch val := val.(type) {
+	case nil:
+		return append(buf, binaryNil), nil
+	case string:
+		return appendBytes(append(buf, binaryString), []byte(val)), nil
+	case []byte:
+		return appendBytes(append(buf, binaryBytes), val), nil
+	case bool:
+		if val {
+			return append(buf, binaryBool, 1), nil
+		}
+		return append(buf, binaryBool, 0), nil
+	case int:
+		return binary.AppendVarint(append(buf, binaryInt), int64(val)), nil
+	case int64:
+		return binary.AppendVarint(append(buf, binaryInt64), val), nil
+	case float64:
+		return binary.LittleEndian.AppendUint64(append(buf, binaryFloat64), math.Float64bits(val)), nil
+	case BinaryValue:
+		data, err := val.MarshalBinary()
+		if err != nil {
+			return nil, err
+		}
+		buf = appendBytes(append(buf, binaryRegistered), []byte(val.ValueType()))
+		return appendBytes(buf, data), nil
+	}
+	return nil, ErrorUnsupportedValue{value: val}
+}
+
+func (c *BinaryCodec) value(dec *binaryDecoder) (interface{}, error) {
+	var val interface{}
+	switch dec.byte() {
+	case binaryNil:
+	case binaryString:
+		val = string(dec.view())
+	case binaryBytes:
+		val = dec.bytes()
+	case binaryBool:
+		val = dec.byte() == 1
+	case binaryInt:
+		val = int(dec.varint())
+	case binaryInt64:
+		val = dec.varint()
+	case binaryFloat64:
+		val = math.Float64frombits(dec.uint64())
+	case binaryRegistered:
+		valueType := string(dec.view())
+		data := dec.bytes()
+		if dec.err != nil {
+			return nil, dec.err
+		}
+		decode, registered := c.decoders[valueType]
+		if !registered {
+			return nil, fmt.Errorf("query: the value type %q is not registered", valueType)
+		}
+		return decode(data)
+	default:
+		return nil, errBinaryCorrupted
+	}
+	return val, dec.err
+}
+
+// appendMetadata appends the metadata of the result after its values.
+// The version of the result schema is appended last, if any, so the results serialized beforehand remain readable.
+func (c *BinaryCodec) appendMetadata(buf []byte, enc *encodedResult) ([]byte, error) {
+	if enc.Total == nil {
+		buf = append(buf, 0)
+	} else {
+		buf = binary.AppendVarint(append(buf, 1), int64(*enc.Total))
+	}
+	buf = appendBytes(buf, []byte(enc.Cursor))
+	buf = binary.AppendUvarint(buf, uint64(len(enc.Meta)))
+	for key, val := range enc.Meta {
+		var err error
+		buf = appendBytes(buf, []byte(key))
+		if buf, err = c.appendValue(buf, val); err != nil {
+			return nil, err
+		}
+	}
+	if enc.Version > 0 {
+		buf = binary.AppendUvarint(buf, uint64(enc.Version))
+	}
+	return buf, nil
+}
+
+// metadata decodes the metadata of the result, following its values.
+func (c *BinaryCodec) metadata(dec *binaryDecoder, enc *encodedResult) error {
+	if dec.byte() == 1 {
+		total := int(dec.varint())
+		enc.Total = &total
+	}
+	enc.Cursor = string(dec.view())
+	n := dec.uvarint()
+	if dec.err != nil {
+		return dec.err
+	}
+	if n > uint64(len(dec.data)) {
+		return errBinaryCorrupted
+	}
+	if n > 0 {
+		enc.Meta = make(map[string]interface{}, n)
+	}
+	for i := uint64(0); i < n; i++ {
+		key := string(dec.view())
+		val, err := c.value(dec)
+		if err != nil {
+			return err
+		}
+		enc.Meta[key] = val
+	}
+	if dec.err == nil && len(dec.data) > 0 {
+		enc.Version = int(dec.uvarint())
+	}
+	return dec.err
+}
+
+func appendBytes(buf []byte, data []byte) []byte {
+	return append(binary.AppendUvarint(buf, uint64(len(data))), data...)
+}
+
+func appendTime(buf []byte, t time.Time) []byte {
+	if t.IsZero() {
+		return binary.AppendVarint(buf, 0)
+	}
+	return binary.AppendVarint(buf, t.UnixNano())
+}
+
+// binaryDecoder reads the binary data sequentially, retaining the first error found.
+type binaryDecoder struct {
+	data []byte
+	err  error
+}
+
+func (dec *binaryDecoder) byte() byte {
+	if dec.err != nil || len(dec.data) < 1 {
+		dec.err = errBinaryCorrupted
+		return 0
+	}
+	b := dec.data[0]
+	dec.data = dec.data[1:]
+	return b
+}
+
+func (dec *binaryDecoder) uvarint() uint64 {
+	if dec.err != nil {
+		return 0
+	}
+	v, n := binary.Uvarint(dec.data)
+	if n <= 0 {
+		dec.err = errBinaryCorrupted
+		return 0
+	}
+	dec.data = dec.data[n:]
+	return v
+}
+
+func (dec *binaryDecoder) varint() int64 {
+	if dec.err != nil {
+		return 0
+	}
+	v, n := binary.Varint(dec.data)
+	if n <= 0 {
+		dec.err = errBinaryCorrupted
+		return 0
+	}
+	dec.data = dec.data[n:]
+	return v
+}
+
+func (dec *binaryDecoder) uint64() uint64 {
+	if dec.err != nil || len(dec.data) < 8 {
+		dec.err = errBinaryCorrupted
+		return 0
+	}
+	v := binary.LittleEndian.Uint64(dec.data)
+	dec.data = dec.data[8:]
+	return v
+}
+
+// view returns the next length prefixed bytes without copying them.
+func (dec *binaryDecoder) view() []byte {
+	n := dec.uvarint()
+	if dec.err != nil || n > uint64(len(dec.data)) {
+		dec.err = errBinaryCorrupted
+		return nil
+	}
+	b := dec.data[:n]
+	dec.data = dec.data[n:]
+	return b
+}
+
+// bytes returns a copy of the next length prefixed bytes.
+func (dec *binaryDecoder) bytes() []byte {
+	v := dec.view()
+	if v == nil {
+		return nil
+	}
+	b := make([]byte, len(v))
+	copy(b, v)
+	return b
+}
+
+func (dec *binaryDecoder) time() time.Time {
+	ns := dec.varint()
+	if ns == 0 {
+		return time.Time{}
+	}
+	return time.Unix(0, ns)
+}
@@ -0,0 +1,172 @@
+package query
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+// Limited may optionally be implemented by queries and handlers to limit how many of them are handled concurrently (bulkhead isolation).
+// Queries are limited per type (ID), overriding the limit provided using the ConcurrencyLimit function, while handlers are limited per handler type.
+// A limit lesser or equal to zero leaves them unlimited.
+type Limited interface {
+	ConcurrencyLimit() int
+}
+
+// ConcurrencyLimit may optionally be provided to limit how many queries of the given type (ID) are handled concurrently,
+// for both the regular and iterator queries. This way a single heavy query type cannot consume all the resources and starve the others.
+// Iterator queries acquire their slot before being enqueued, so the waiting queries do not occupy the iterator workers.
+// A limit lesser or equal to zero removes the limit of the query type.
+func (bus *Bus) ConcurrencyLimit(queryID []byte, limit int) {
+	bus.bulkhead.Lock()
+	defer bus.bulkhead.Unlock()
+	if limit <= 0 {
+		delete(bus.bulkhead.limits, string(queryID))
+		return
+	}
+	bus.bulkhead.limits[string(queryID)] = limit
+}
+
+// ConcurrencyLimitTimeout may optionally be provided to limit how long the queries wait for a slot once their concurrency limit is reached.
+// Queries still waiting by then are rejected with ErrorConcurrencyLimitReached. A negative timeout rejects them right away (fail fast).
+// Either way, the waiting is interrupted if the context of the query is done.
+// Nested queries whose ancestors hold a slot of the same limit (e.g. a handler issuing a query of its own type) are always rejected right away,
+// as they could otherwise wait for the slots held by their own ancestors.
+// It defaults to 0 (wait indefinitely).
+func (bus *Bus) ConcurrencyLimitTimeout(d time.Duration) {
+	bus.bulkhead.timeout = d
+}
+
+//------Internal------//
+
+// bulkhead keeps the concurrency limits of the query types and the slots of the limited queries and handlers.
+type bulkhead struct {
+	sync.Mutex
+	limits  map[string]int
+	slots   map[string]*bulkheadSlots
+	timeout time.Duration
+}
+
+// bulkheadSlots counts the slots of a key in use. The slots are keyed regardless of the limit, so a limit changing at runtime
+// (or queries of the same type returning different limits) applies to the slots already in use.
+type bulkheadSlots struct {
+	sync.Mutex
+	inUse int
+	// holders counts the slots in use per query (lineage ID), to detect the nested queries re-entering the limit.
+	holders map[uint64]int
+	// released is closed (and replaced) whenever a slot is released, waking up the callers waiting for a slot.
+	released chan bool
+}
+
+func newBulkhead() *bulkhead {
+	return &bulkhead{
+		limits: make(map[string]int),
+		slots:  make(map[string]*bulkheadSlots),
+	}
+}
+
+// acquireQuery acquires a slot of the query type, if limited.
+func (bh *bulkhead) acquireQuery(ctx context.Context, clock Clock, qry Query) (func(), error) {
+	bh.Lock()
+	limit := bh.limits[string(qry.ID())]
+	bh.Unlock()
+	if lim, implements := qry.(Limited); implements {
+		limit = lim.ConcurrencyLimit()
+	}
+	return bh.acquire(ctx, clock, "query:"+string(qry.ID()), limit, qry, nil)
+}
+
+// acquireHandler acquires a slot of the handler type, if limited.
+func (bh *bulkhead) acquireHandler(ctx context.Context, clock Clock, qry Query, hdl interface{}) (func(), error) {
+	lim, implements := forwarded[Limited](hdl)
+	if !implements {
+		return func() {}, nil
+	}
+	return bh.acquire(ctx, clock, "handler:"+TypeName(hdl), lim.ConcurrencyLimit(), qry, hdl)
+}
+
+// acquire a slot of the given key, waiting according to the timeout unless the context is done first.
+// The slot is only acquired while less than the given limit are in use, whatever the limit of the callers holding them.
+func (bh *bulkhead) acquire(ctx context.Context, clock Clock, key string, limit int, qry Query, hdl interface{}) (func(), error) {
+	if limit <= 0 {
+		return func() {}, nil
+	}
+	bh.Lock()
+	slots, exists := bh.slots[key]
+	if !exists {
+		slots = &bulkheadSlots{holders: make(map[uint64]int), released: make(chan bool)}
+		bh.slots[key] = slots
+	}
+	bh.Unlock()
+
+	lin, _ := ctx.Value(lineageKey).(*Lineage)
+	released, acquired := slots.tryAcquire(limit, lin)
+	if acquired {
+		return func() { slots.release(lin) }, nil
+	}
+	reached := NewErrorConcurrencyLimitReached(qry, hdl, limit)
+	if bh.timeout < 0 || slots.heldByAncestors(lin) {
+		return nil, reached
+	}
+	var expired <-chan time.Time
+	if bh.timeout > 0 {
+		t := clock.NewTimer(bh.timeout)
+		defer t.Stop()
+		expired = t.C()
+	}
+	for {
+		select {
+		case <-released:
+			if released, acquired = slots.tryAcquire(limit, lin); acquired {
+				return func() { slots.release(lin) }, nil
+			}
+		case <-expired:
+			return nil, reached
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		}
+	}
+}
+
+// tryAcquire acquires a slot for the query of the lineage if less than the limit are in use.
+// Otherwise, it returns the channel closed once a slot is released.
+func (s *bulkheadSlots) tryAcquire(limit int, lin *Lineage) (<-chan bool, bool) {
+	s.Lock()
+	defer s.Unlock()
+	if s.inUse < limit {
+		s.inUse++
+		if lin != nil {
+			s.holders[lin.ID]++
+		}
+		return nil, true
+	}
+	return s.released, false
+}
+
+// heldByAncestors verifies whether an ancestor of the query of the lineage holds a slot.
+func (s *bulkheadSlots) heldByAncestors(lin *Lineage) bool {
+	if lin == nil {
+		return false
+	}
+	s.Lock()
+	defer s.Unlock()
+	for l := lin.parent; l != nil; l = l.parent {
+		if s.holders[l.ID] > 0 {
+			return true
+		}
+	}
+	return false
+}
+
+func (s *bulkheadSlots) release(lin *Lineage) {
+	s.Lock()
+	s.inUse--
+	if lin != nil {
+		if s.holders[lin.ID]--; s.holders[lin.ID] <= 0 {
+			delete(s.holders, lin.ID)
+		}
+	}
+	close(s.released)
+	s.released = make(chan bool)
+	s.Unlock()
+}
@@ -3,50 +3,153 @@ package query
 import (
 	"context"
 	"runtime"
+	"runtime/debug"
+	"sync"
 	"sync/atomic"
 	"time"
 )
 
-const iteratorListenerTimeout = time.Second
+const defaultIteratorListenerTimeout = time.Second
 
 // Bus is the only struct exported and required for the query bus usage.
 // The Bus should be instantiated using the NewBus function.
 type Bus struct {
-	iteratorWorkerPoolSize int
-	iteratorQueueBuffer    int
-	iteratorResultBuffer   int
-	initialized            *uint32
-	shuttingDown           *uint32
-	iteratorWorkers        *uint32
-	handlers               []Handler
-	iteratorHandlers       []IteratorHandler
-	errorHandlers          []ErrorHandler
-	cacheAdapters          []CacheAdapter
-	iteratorQueryQueue     chan *pendingIteratorQuery
-	closed                 chan bool
+	iteratorWorkerPoolSize  int
+	iteratorQueueBuffer     int
+	iteratorResultBuffer    int
+	iteratorCacheLimit      int
+	batchConcurrency        int
+	queryTimeout            time.Duration
+	maxQueryDepth           int
+	iteratorListenerTimeout time.Duration
+	iteratorConsumerTimeout time.Duration
+	iteratorEnqueueTimeout  time.Duration
+	deadlinePolicy          DeadlinePolicy
+	clock                   Clock
+	initialized             *uint32
+	shuttingDown            *uint32
+	iteratorWorkers         *uint32
+	handlers                []Handler
+	routes                  map[string][]Handler
+	registry                sync.RWMutex
+	iteratorHandlerSet      *iteratorHandlerSet
+	subscriptionHandlers    []SubscriptionHandler
+	subscriptionRoutes      map[string][]SubscriptionHandler
+	subscriptions           *subscriptions
+	queries                 map[string]Query
+	errorHandlers           []ErrorHandler
+	errorDispatcher         *errorDispatcher
+	warningHandlers         []WarningHandler
+	cache                   *TieredCacheAdapter
+	cacheUsage              *cacheUsage
+	revalidations           *revalidations
+	views                   *views
+	staleness               *staleness
+	migrations              *migrations
+	goroutines              *goroutines
+	middlewares             []Middleware
+	contextDecorators       []ContextDecorator
+	validators              []Validator
+	lintReporter            func(rep LintReport)
+	deprecations            map[string]*deprecation
+	deprecationHandlers     []DeprecationHandler
+	deprecationStackTraces  bool
+	wrapHandlerErrors       bool
+	observers               []Observer
+	breaker                 *circuitBreaker
+	loadShedding            *loadShedding
+	queryChain              QueryFunc
+	iteratorQueryChain      IteratorQueryFunc
+	transport               RemoteTransport
+	cloner                  Cloner
+	flights                 *flightGroup
+	serializer              *serializer
+	activity                *activity
+	asyncPool               *asyncPool
+	coldStart               *coldStart
+	bulkhead                *bulkhead
+	queueFaults             *atomic.Value
+	iteratorLaneWorkers     map[int]int
+	iteratorLanes           []*iteratorLane
+	autoscaling             *autoscaling
+	workerWrapper           WorkerWrapper
+	autoscalerStop          chan bool
+	closed                  chan bool
 }
 
 // NewBus instantiates the Bus struct.
 // The Initialization of IteratorHandlers is performed separately (InitializeIteratorHandlers function) for dependency injection purposes.
 func NewBus() *Bus {
-	return &Bus{
-		iteratorWorkerPoolSize: runtime.GOMAXPROCS(0),
-		iteratorQueueBuffer:    100,
-		iteratorResultBuffer:   0,
-		initialized:            new(uint32),
-		shuttingDown:           new(uint32),
-		iteratorWorkers:        new(uint32),
-		handlers:               make([]Handler, 0),
-		iteratorHandlers:       make([]IteratorHandler, 0),
-		errorHandlers:          make([]ErrorHandler, 0),
-		cacheAdapters:          []CacheAdapter{NewMemoryCacheAdapter()},
-		closed:                 make(chan bool),
+	bus := &Bus{
+		iteratorWorkerPoolSize:  runtime.GOMAXPROCS(0),
+		batchConcurrency:        10,
+		iteratorQueueBuffer:     100,
+		iteratorResultBuffer:    0,
+		iteratorListenerTimeout: defaultIteratorListenerTimeout,
+		maxQueryDepth:           defaultMaxQueryDepth,
+		clock:                   SystemClock,
+		initialized:             new(uint32),
+		shuttingDown:            new(uint32),
+		iteratorWorkers:         new(uint32),
+		handlers:                make([]Handler, 0),
+		routes:                  make(map[string][]Handler),
+		iteratorHandlerSet:      newIteratorHandlerSet(),
+		subscriptionRoutes:      make(map[string][]SubscriptionHandler),
+		subscriptions:           newSubscriptions(),
+		queries:                 make(map[string]Query),
+		errorHandlers:           make([]ErrorHandler, 0),
+		warningHandlers:         make([]WarningHandler, 0),
+		cache:                   NewTieredCacheAdapter(NewMemoryCacheAdapter()),
+		cacheUsage:              newCacheUsage(),
+		revalidations:           newRevalidations(),
+		views:                   newViews(),
+		staleness:               newStaleness(),
+		migrations:              newMigrations(),
+		goroutines:              newGoroutines(),
+		middlewares:             make([]Middleware, 0),
+		deprecations:            make(map[string]*deprecation),
+		deprecationHandlers:     make([]DeprecationHandler, 0),
+		observers:               make([]Observer, 0),
+		flights:                 newFlightGroup(),
+		serializer:              newSerializer(),
+		activity:                newActivity(),
+		asyncPool:               newAsyncPool(runtime.GOMAXPROCS(0)),
+		coldStart:               newColdStart(),
+		bulkhead:                newBulkhead(),
+		queueFaults:             new(atomic.Value),
+		iteratorLaneWorkers:     make(map[int]int),
+		closed:                  make(chan bool),
 	}
+	bus.queueFaults.Store(&queueFaults{changed: make(chan bool)})
+	bus.chain()
+	return bus
 }
 
 // Handlers for the regular queries.
+// Handlers implementing the Routable interface are only provided the queries they handle.
+// Every other handler is provided every query.
+// Calling this function replaces all the previously provided handlers, including the ones provided with Handle.
 func (bus *Bus) Handlers(hdls ...Handler) {
-	bus.handlers = hdls
+	bus.routes = make(map[string][]Handler)
+	bus.handlers = route(bus.routes, bus.queries, hdls)
+}
+
+// Handle routes the queries with the same ID as the given query to the provided handlers.
+// Routed handlers are used before the handlers that are provided every query.
+func (bus *Bus) Handle(qry Query, hdls ...Handler) {
+	key := string(qry.ID())
+	bus.routes[key] = append(bus.routes[key], hdls...)
+	bus.queries[key] = qry
+}
+
+// HandleIterator routes the iterator queries with the same ID as the given query to the provided iterator handlers.
+// It can only be used *before* the bus is initialized.
+func (bus *Bus) HandleIterator(qry Query, hdls ...IteratorHandler) {
+	if !bus.isInitialized() {
+		key := string(qry.ID())
+		bus.iteratorHandlerSet.routes[key] = append(bus.iteratorHandlerSet.routes[key], hdls...)
+		bus.queries[key] = qry
+	}
 }
 
 // ErrorHandlers may optionally be provided.
@@ -55,13 +158,35 @@ func (bus *Bus) ErrorHandlers(hdls ...ErrorHandler) {
 	bus.errorHandlers = hdls
 }
 
+// HandlerErrorWrapping may optionally be enabled to wrap the errors returned by the handlers into an ErrorHandlerFailed,
+// providing the query and the handler that failed. The errors of the handlers remain available through errors.Is and errors.As.
+// The context errors (the handling being interrupted) are never wrapped.
+// It is disabled by default, the errors of the handlers being returned as is (the handlers being provided to the observers instead, see HandlerFinished).
+// It should be used *before* any query is performed.
+func (bus *Bus) HandlerErrorWrapping(enabled bool) {
+	bus.wrapHandlerErrors = enabled
+}
+
+// WarningHandlers may optionally be provided.
+// They will receive any warning attached to the results by the handlers (res.Warn).
+func (bus *Bus) WarningHandlers(hdls ...WarningHandler) {
+	bus.warningHandlers = hdls
+}
+
+// Use appends the provided middlewares to the middleware chain.
+// Middlewares are applied in the order they are provided, the first middleware being the outermost.
+// It should be used *before* any query is performed.
+func (bus *Bus) Use(mws ...Middleware) {
+	bus.middlewares = append(bus.middlewares, mws...)
+	bus.chain()
+}
+
 // CacheAdapters may optionally be provided.
-// They will be used instead of the default MemoryCacheAdapter.
+// They will be used instead of the default MemoryCacheAdapter, as the tiers of a TieredCacheAdapter (from the fastest to the slowest).
 func (bus *Bus) CacheAdapters(adps ...CacheAdapter) {
-	for _, adp := range bus.cacheAdapters {
-		adp.Shutdown()
-	}
-	bus.cacheAdapters = adps
+	bus.cache.Shutdown()
+	bus.cache = NewTieredCacheAdapter(adps...)
+	bus.cache.SetClock(bus.clock)
 }
 
 // IteratorWorkerPoolSize may optionally be provided to tweak the iteratorWorker pool size for iterator query queue.
@@ -83,6 +208,32 @@ func (bus *Bus) IteratorQueueBuffer(buf int) {
 	}
 }
 
+// IteratorListenerTimeout may optionally be provided to tweak how long the iterator queries wait for a result listener (the "Iterate" function of the result).
+// Queries without a listener by then fail with ErrorListenerTimedOut.
+// It defaults to 1 second.
+func (bus *Bus) IteratorListenerTimeout(d time.Duration) {
+	if d > 0 {
+		bus.iteratorListenerTimeout = d
+	}
+}
+
+// IteratorConsumerTimeout may optionally be provided to limit how long the iterator handlers wait for the consumers to read each value,
+// once the buffer of the result is full. A consumer stalling longer than the timeout (e.g. a stuck downstream writer) interrupts the handling of the query,
+// freeing the iterator worker instead of hanging, and the result fails with an ErrorConsumerStalled.
+// It is distinct from the timeout of the handling itself, and can be overridden per result (see IteratorResult.ConsumerTimeout).
+// It defaults to 0 (wait indefinitely).
+func (bus *Bus) IteratorConsumerTimeout(d time.Duration) {
+	bus.iteratorConsumerTimeout = d
+}
+
+// IteratorEnqueueTimeout may optionally be provided to limit how long iterator queries wait for room in a full iterator query queue.
+// Queries still not enqueued by then are rejected with ErrorQueueFull. A negative timeout rejects them right away (non-blocking enqueue).
+// Either way, the enqueuing is interrupted if the context of the query is done.
+// It defaults to 0 (wait indefinitely).
+func (bus *Bus) IteratorEnqueueTimeout(d time.Duration) {
+	bus.iteratorEnqueueTimeout = d
+}
+
 // IteratorResultBuffer may optionally be provided to tweak the buffer size of the results channel for iterator queries.
 // This value may have high impact on performance depending on the use case.
 // It defaults to 1.
@@ -91,53 +242,139 @@ func (bus *Bus) IteratorResultBuffer(buf int) {
 }
 
 // InitializeIteratorHandlers initializes the query bus to support iterator queries.
+// Iterator handlers implementing the Routable interface are only provided the queries they handle.
 func (bus *Bus) InitializeIteratorHandlers(hdls ...IteratorHandler) {
 	if bus.initialize() {
-		bus.iteratorHandlers = hdls
-		bus.iteratorQueryQueue = make(chan *pendingIteratorQuery, bus.iteratorQueueBuffer)
-		for i := 0; i < bus.iteratorWorkerPoolSize; i++ {
-			bus.iteratorWorkerUp()
-			go bus.iteratorWorker(bus.iteratorQueryQueue, bus.closed)
+		bus.registry.Lock()
+		bus.iteratorHandlerSet.handlers = route(bus.iteratorHandlerSet.routes, bus.queries, hdls)
+		bus.registry.Unlock()
+		bus.initializeIteratorLanes()
+		for _, lane := range bus.iteratorLanes {
+			bus.startIteratorWorkers(lane, lane.workers)
+		}
+		bus.startAutoscaler()
+		bus.errorDispatcher.start(bus)
+		bus.coldStart.begin(bus.clock.Now())
+		if bus.lintReporter != nil {
+			bus.lintReporter(bus.Lint(context.Background()))
 		}
 	}
 }
 
 // Query for a single result or a pre-populated collection.
 func (bus *Bus) Query(ctx context.Context, qry Query) (*Result, error) {
-	if err := bus.isValid(ctx, qry); err != nil {
+	ctx, done := bus.activity.start(ctx, bus, qry)
+	defer done()
+	ctx, warning, err := bus.accept(ctx, qry)
+	if err != nil {
 		return nil, err
 	}
-
-	res, cached := bus.result(ctx, qry)
-	if cached {
-		return res, nil
-	}
-
-	return res, bus.query(ctx, qry, res)
+	return bus.execute(ctx, qry, warning)
 }
 
 // IteratorQuery uses a channel to iterate the results while they are being populated.
-// *Iterator queries are not cached*.
+// *Iterator queries are not cached*, unless enabled using IteratorCache.
 func (bus *Bus) IteratorQuery(ctx context.Context, qry Query) (*IteratorResult, error) {
+	ctx, done := bus.activity.start(ctx, bus, qry)
 	if err := bus.isIteratorValid(ctx, qry); err != nil {
+		done()
+		return nil, err
+	}
+	ctx, err := bus.decorate(ctx, qry)
+	if err == nil {
+		err = bus.validate(ctx, qry)
+	}
+	if err != nil {
+		done()
 		return nil, err
 	}
 
+	release, err := bus.bulkhead.acquireQuery(ctx, bus.clock, qry)
+	if err != nil {
+		done()
+		bus.error(ctx, qry, err)
+		return nil, err
+	}
+	finish := func() {
+		release()
+		done()
+	}
+
 	res := newIteratorResult(bus.iteratorResultBuffer)
-	bus.enqueueIteratorQuery(ctx, qry, res)
+	res.ConsumerTimeout(bus.iteratorConsumerTimeout)
+	if warning, deprecated := bus.deprecated(ctx, qry, 1); deprecated {
+		res.deprecate(warning)
+	}
+	if err = bus.enqueueIteratorQuery(ctx, qry, res, finish); err != nil {
+		finish()
+		return nil, err
+	}
 	return res, nil
 }
 
 // Shutdown the query bus gracefully.
-// *Queries handled while shutting down will be disregarded*.
+// New queries are rejected, while the queries in flight are handled before the bus is stopped.
+// Use ShutdownContext to limit the time spent draining them.
 func (bus *Bus) Shutdown() {
-	if atomic.CompareAndSwapUint32(bus.shuttingDown, 0, 1) {
-		bus.shutdown()
+	_ = bus.ShutdownContext(context.Background())
+}
+
+// Run blocks until the provided context is done, shutting down the query bus gracefully afterwards.
+// It is intended to be used with service runners (errgroup, oklog/run, etc.), typically in combination with signal.NotifyContext.
+// The queries in flight are drained during the grace period at most, a non-positive grace period draining them without limit (see ShutdownContext).
+// It returns the error of the shutdown once the bus is fully stopped.
+func (bus *Bus) Run(ctx context.Context, grace time.Duration) error {
+	<-ctx.Done()
+	drain := context.Background()
+	if grace > 0 {
+		var cancel context.CancelFunc
+		drain, cancel = context.WithTimeout(drain, grace)
+		defer cancel()
 	}
+	return bus.ShutdownContext(drain)
 }
 
 //-----Private Functions------//
 
+// accept verifies whether the regular query can be issued, preparing its context.
+// It also returns the deprecation warning of the query, if any.
+func (bus *Bus) accept(ctx context.Context, qry Query) (context.Context, string, error) {
+	if err := bus.isValid(ctx, qry); err != nil {
+		return ctx, "", err
+	}
+	if bus.isShuttingDown() {
+		err := BusIsShuttingDownError
+		bus.error(ctx, qry, err)
+		return ctx, "", err
+	}
+	ctx, err := bus.decorate(ctx, qry)
+	if err != nil {
+		return ctx, "", err
+	}
+	if err = bus.validate(ctx, qry); err != nil {
+		return ctx, "", err
+	}
+	warning, _ := bus.deprecated(ctx, qry, 2)
+	return ctx, warning, nil
+}
+
+// execute the accepted regular query.
+func (bus *Bus) execute(ctx context.Context, qry Query, warning string) (*Result, error) {
+	ctx, cancel := bus.withTimeout(ctx, qry)
+	if cancel != nil {
+		defer cancel()
+	}
+	res, err := bus.queryChain(ctx, qry)
+	if err != nil {
+		err = bus.abortedError(err)
+		bus.error(ctx, qry, err)
+	}
+	if warning != "" && res != nil {
+		res.deprecate(warning)
+	}
+	return res, err
+}
+
 func (bus *Bus) initialize() bool {
 	return atomic.CompareAndSwapUint32(bus.initialized, 0, 1)
 }
@@ -150,62 +387,346 @@ func (bus *Bus) isShuttingDown() bool {
 	return atomic.LoadUint32(bus.shuttingDown) == 1
 }
 
-func (bus *Bus) iteratorWorker(qryQ <-chan *pendingIteratorQuery, closed chan<- bool) {
-	for penQry := range qryQ {
+// iteratorWorker handles the iterator queries of the lane until it is retired or stopped (returning true).
+func (bus *Bus) iteratorWorker(ctx context.Context, lane *iteratorLane) bool {
+	for {
+		var penQry *pendingIteratorQuery
+		select {
+		case penQry = <-lane.queue:
+		case <-lane.retire:
+			return false
+		}
 		// nil queries are used as signals to break out
 		if penQry == nil {
-			break
+			return true
+		}
+		if bus.workerWrapper != nil {
+			penQry.ctx = withWorkerContext(penQry.ctx, ctx)
 		}
+		penQry.ctx = context.WithValue(penQry.ctx, iteratorLaneKey, lane)
+		atomic.AddInt32(lane.busy, 1)
+		bus.delayDequeue(penQry.ctx)
+		bus.recoverIteratorPending(penQry)
+		penQry.done()
+		atomic.AddInt32(lane.busy, -1)
+	}
+}
 
-		// wait for a listener
-		if penQry.res.waitListener(iteratorListenerTimeout) {
-			bus.iteratorQuery(penQry.ctx, penQry.qry, penQry.res)
+func (bus *Bus) iteratorPending(penQry *pendingIteratorQuery) {
+	// wait for a listener
+	start := bus.clock.Now()
+	listening, err := penQry.res.waitListener(penQry.ctx, bus.clock, bus.iteratorListenerTimeout)
+	if err != nil {
+		err = bus.abortedError(err)
+		bus.error(penQry.ctx, penQry.qry, err)
+		penQry.res.fail(err)
+		penQry.res.close()
+		return
+	}
+	if listening {
+		bus.iteratorProcess(penQry)
+		return
+	}
+
+	err = ErrorListenerTimedOut{query: penQry.qry, elapsed: bus.since(start)}
+	bus.error(penQry.ctx, penQry.qry, err)
+	penQry.res.fail(err)
+}
+
+// recoverIteratorPending recovers the panics outside of the iterator handlers (e.g. in the middlewares), failing the query.
+// This way the worker keeps handling the queue and the pool capacity is not lost.
+func (bus *Bus) recoverIteratorPending(penQry *pendingIteratorQuery) {
+	defer func() {
+		if r := recover(); r != nil {
+			err := NewErrorHandlerPanicked(penQry.qry, nil, r, debug.Stack())
+			bus.error(penQry.ctx, penQry.qry, err)
+			penQry.res.fail(err)
 			penQry.res.close()
-			continue
 		}
+	}()
+	bus.iteratorPending(penQry)
+}
 
-		bus.error(penQry.ctx, penQry.qry, NewErrorQueryTimedOut(penQry.qry))
+func (bus *Bus) iteratorProcess(penQry *pendingIteratorQuery) {
+	set := bus.acquireIteratorHandlers()
+	defer set.release()
+	ctx, cancel := bus.withExtendableTimeout(withIteratorHandlers(penQry.ctx, set), penQry.qry)
+	if cancel != nil {
+		defer cancel()
 	}
-	closed <- true
+	ctx, stall := context.WithCancel(ctx)
+	defer stall()
+	penQry.res.consume(penQry.qry, bus.clock, stall)
+	if err := bus.iteratorQueryChain(ctx, penQry.qry, penQry.res); err != nil {
+		err = bus.abortedError(err)
+		bus.error(ctx, penQry.qry, err)
+		penQry.res.fail(err)
+	}
+	penQry.res.close()
 }
 
-func (bus *Bus) iteratorQuery(ctx context.Context, qry Query, res *IteratorResult) {
-	for _, hdl := range bus.iteratorHandlers {
-		if err := hdl.Handle(ctx, qry, res); err != nil {
+func (bus *Bus) chain() {
+	bus.queryChain = bus.dispatch
+	bus.iteratorQueryChain = bus.iteratorQuery
+	for i := len(bus.middlewares) - 1; i >= 0; i-- {
+		bus.queryChain = wrapQuery(bus.middlewares[i], bus.queryChain)
+		bus.iteratorQueryChain = wrapIteratorQuery(bus.middlewares[i], bus.iteratorQueryChain)
+	}
+}
+
+func (bus *Bus) iteratorQuery(ctx context.Context, qry Query, res *IteratorResult) error {
+	cqry, cacheable := bus.iteratorCacheable(qry)
+	cacheable = cacheable && !isPinned(ctx)
+	if cacheable {
+		if bus.replayIterator(ctx, qry, cqry, res) {
+			return res.consumer.err()
+		}
+		res.record(bus.iteratorCacheLimit)
+	}
+	probe, err := bus.enterCircuit(ctx, qry)
+	if err != nil {
+		return err
+	}
+	unlock, err := bus.serialize(ctx, qry)
+	if err == nil {
+		err = bus.iteratorHandleQuery(ctx, qry, res)
+		unlock()
+		bus.exitCircuit(ctx, qry, probe, err)
+	} else {
+		bus.abandonCircuit(qry, probe)
+	}
+	bus.warn(ctx, qry, res.Warnings())
+	// the consumer stalling interrupts the handling, whatever the handlers returned
+	if stalled := res.consumer.err(); stalled != nil {
+		return stalled
+	}
+	if err == nil && cacheable {
+		bus.cacheIterator(ctx, qry, cqry, res)
+	}
+	return err
+}
+
+func (bus *Bus) iteratorHandleQuery(ctx context.Context, qry Query, res *IteratorResult) error {
+	set := bus.iteratorHandlers(ctx)
+	if err := bus.iteratorHandle(ctx, set.routes[string(qry.ID())], qry, res); err != nil {
+		return err
+	}
+	if res.propagationStopped() {
+		return nil
+	}
+	if err := bus.iteratorHandle(ctx, set.handlers, qry, res); err != nil {
+		return err
+	}
+	if !res.isHandled() && bus.transport != nil {
+		if err := bus.transport.IteratorQuery(ctx, qry, res); err != nil {
+			return err
+		}
+	}
+	if !res.isHandled() {
+		return NewErrorNoQueryHandlersFound(qry)
+	}
+	return nil
+}
+
+// enqueueIteratorQuery provides the iterator query to the workers of its lane.
+// When the queue is full, the saturation is observed and passed on to the error handlers (ErrorQueueFull) while the query waits (see IteratorEnqueueTimeout).
+func (bus *Bus) enqueueIteratorQuery(ctx context.Context, qry Query, res *IteratorResult, done func()) error {
+	penQry := &pendingIteratorQuery{
+		ctx:  ctx,
+		qry:  qry,
+		res:  res,
+		done: done,
+	}
+	lane := bus.iteratorLane(qry)
+	faults := bus.currentQueueFaults()
+	if !faults.Saturated {
+		select {
+		case lane.queue <- penQry:
+			return nil
+		default:
+		}
+	}
+	full := NewErrorQueueFull(qry)
+	bus.observe(ctx, Event{Type: IteratorQueueSaturated, Query: qry})
+	bus.error(ctx, qry, full)
+	if bus.iteratorEnqueueTimeout < 0 {
+		return full
+	}
+
+	var timeout <-chan time.Time
+	if bus.iteratorEnqueueTimeout > 0 {
+		t := bus.clock.NewTimer(bus.iteratorEnqueueTimeout)
+		defer t.Stop()
+		timeout = t.C()
+	}
+	for {
+		queue := lane.queue
+		if faults.Saturated {
+			// a nil channel blocks, as a queue that remains full
+			queue = nil
+		}
+		select {
+		case queue <- penQry:
+			return nil
+		case <-faults.changed:
+			faults = bus.currentQueueFaults()
+		case <-timeout:
+			return full
+		case <-ctx.Done():
+			err := bus.abortedError(ctx.Err())
 			bus.error(ctx, qry, err)
-			return
+			return err
+		}
+	}
+}
+
+func (bus *Bus) iteratorHandle(ctx context.Context, hdls []IteratorHandler, qry Query, res *IteratorResult) error {
+	for _, hdl := range hdls {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		if err := bus.invokeIterator(ctx, hdl, qry, res); err != nil {
+			return err
 		}
 		if res.propagationStopped() {
-			return
+			return nil
 		}
 	}
-	if !res.isHandled() {
-		bus.error(ctx, qry, NewErrorNoQueryHandlersFound(qry))
+	return nil
+}
+
+func (bus *Bus) invokeIterator(ctx context.Context, hdl IteratorHandler, qry Query, res *IteratorResult) error {
+	probe, err := bus.enterHandlerCircuit(ctx, qry, hdl)
+	if err != nil {
+		return err
+	}
+	release, err := bus.bulkhead.acquireHandler(ctx, bus.clock, qry, hdl)
+	if err != nil {
+		bus.abandonHandlerCircuit(hdl, probe)
+		return err
 	}
+	defer release()
+	handle := func() error { return hdl.Handle(ctx, qry, res) }
+	if !bus.isObserved() {
+		err = bus.callHandler(qry, hdl, handle)
+		bus.exitHandlerCircuit(ctx, qry, hdl, probe, err)
+		return err
+	}
+	start := bus.clock.Now()
+	err = bus.callHandler(qry, hdl, handle)
+	bus.observe(ctx, Event{Type: HandlerFinished, Query: qry, Handler: hdl, Duration: bus.since(start), Err: err})
+	bus.exitHandlerCircuit(ctx, qry, hdl, probe, err)
+	return err
 }
 
-func (bus *Bus) enqueueIteratorQuery(ctx context.Context, qry Query, res *IteratorResult) {
-	bus.iteratorQueryQueue <- &pendingIteratorQuery{
-		ctx: ctx,
-		qry: qry,
-		res: res,
+func (bus *Bus) dispatch(ctx context.Context, qry Query) (*Result, error) {
+	bus.coldStart.begin(bus.clock.Now())
+	res, cached := bus.result(ctx, qry)
+	if cached {
+		return bus.clone(res), nil
+	}
+	if shed, fallback, err := bus.shed(ctx, qry); shed {
+		return fallback, err
 	}
+
+	// concurrent identical cacheable (or coalescable, while the caches are cold) queries share a single handling
+	if key, coalesce := flightKey(qry, bus.coldStart.active(bus.clock.Now())); coalesce && !isPinned(ctx) {
+		f, leader := bus.flights.join(key)
+		if !leader {
+			select {
+			case <-f.done:
+			case <-ctx.Done():
+				return nil, ctx.Err()
+			}
+			// the shared handling was cancelled by its own caller, not this one
+			if isContextError(f.err) && ctx.Err() == nil {
+				return bus.dispatch(ctx, qry)
+			}
+			return bus.share(f.res), f.err
+		}
+		return bus.lead(ctx, qry, res, key, f)
+	}
+
+	return res, bus.capQuery(ctx, qry, res)
+}
+
+// lead handles the query on behalf of every caller of its flight, landing the flight even if the handling panics
+// (e.g. in a cache adapter). The followers are then provided an ErrorHandlerPanicked, while the panic goes on for the leader.
+func (bus *Bus) lead(ctx context.Context, qry Query, res *Result, key string, f *flight) (*Result, error) {
+	landed := false
+	defer func() {
+		if landed {
+			return
+		}
+		r := recover()
+		bus.flights.land(key, f, nil, NewErrorHandlerPanicked(qry, nil, r, debug.Stack()))
+		if r != nil {
+			panic(r)
+		}
+	}()
+	err := bus.capQuery(ctx, qry, res)
+	bus.flights.land(key, f, res, err)
+	landed = true
+	return bus.clone(res), err
+}
+
+// capQuery handles the query once an execution slot of its type is available (see ColdStartProtection).
+func (bus *Bus) capQuery(ctx context.Context, qry Query, res *Result) error {
+	release, err := bus.coldStart.acquire(ctx, bus.clock.Now(), qry)
+	if err != nil {
+		return err
+	}
+	defer release()
+	return bus.query(ctx, qry, res)
 }
 
 func (bus *Bus) query(ctx context.Context, qry Query, res *Result) error {
-	for _, hdl := range bus.handlers {
-		if err := hdl.Handle(ctx, qry, res); err != nil {
-			bus.error(ctx, qry, err)
+	release, err := bus.bulkhead.acquireQuery(ctx, bus.clock, qry)
+	if err != nil {
+		return err
+	}
+	defer release()
+	probe, err := bus.enterCircuit(ctx, qry)
+	if err != nil {
+		return err
+	}
+	unlock, err := bus.serialize(ctx, qry)
+	if err != nil {
+		bus.abandonCircuit(qry, probe)
+		return err
+	}
+	start := bus.clock.Now()
+	err = bus.handleQuery(ctx, qry, res)
+	bus.measure(ctx, qry, start)
+	unlock()
+	bus.exitCircuit(ctx, qry, probe, err)
+	bus.warn(ctx, qry, res.Warnings())
+	return err
+}
+
+func (bus *Bus) handleQuery(ctx context.Context, qry Query, res *Result) error {
+	if err := bus.handle(ctx, bus.routes[string(qry.ID())], qry, res); err != nil {
+		return err
+	}
+	if !res.propagationStopped() {
+		if err := bus.handle(ctx, bus.handlers, qry, res); err != nil {
 			return err
 		}
-		if res.propagationStopped() {
-			break
+	}
+	if !res.isHandled() && bus.transport != nil {
+		if err := bus.transport.Query(ctx, qry, res); err != nil {
+			return err
 		}
 	}
 
+	// results of cancelled queries may be incomplete and must not be cached
+	if err := ctx.Err(); err != nil {
+		return err
+	}
 	if !res.isHandled() {
-		err := NewErrorNoQueryHandlersFound(qry)
-		bus.error(ctx, qry, err)
+		return NewErrorNoQueryHandlersFound(qry)
+	}
+	if err := bus.count(ctx, qry, res); err != nil {
 		return err
 	}
 
@@ -213,31 +734,106 @@ func (bus *Bus) query(ctx context.Context, qry Query, res *Result) error {
 	return nil
 }
 
+func (bus *Bus) handle(ctx context.Context, hdls []Handler, qry Query, res *Result) error {
+	for _, hdl := range hdls {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		if err := bus.invoke(ctx, hdl, qry, res); err != nil {
+			return err
+		}
+		if res.propagationStopped() {
+			return nil
+		}
+	}
+	return nil
+}
+
+func (bus *Bus) invoke(ctx context.Context, hdl Handler, qry Query, res *Result) error {
+	probe, err := bus.enterHandlerCircuit(ctx, qry, hdl)
+	if err != nil {
+		return err
+	}
+	release, err := bus.bulkhead.acquireHandler(ctx, bus.clock, qry, hdl)
+	if err != nil {
+		bus.abandonHandlerCircuit(hdl, probe)
+		return err
+	}
+	defer release()
+	handle := func() error { return hdl.Handle(ctx, qry, res) }
+	if !bus.isObserved() {
+		err = bus.callHandler(qry, hdl, handle)
+		bus.exitHandlerCircuit(ctx, qry, hdl, probe, err)
+		return err
+	}
+	start := bus.clock.Now()
+	err = bus.callHandler(qry, hdl, handle)
+	bus.observe(ctx, Event{Type: HandlerFinished, Query: qry, Handler: hdl, Duration: bus.since(start), Err: err})
+	bus.exitHandlerCircuit(ctx, qry, hdl, probe, err)
+	return err
+}
+
+// callHandler handles the query, converting a panic of the handler into an ErrorHandlerPanicked.
+// The errors of the handler are wrapped into an ErrorHandlerFailed if enabled (see HandlerErrorWrapping), except for the context errors.
+func (bus *Bus) callHandler(qry Query, hdl interface{}, handle func() error) (err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			err = NewErrorHandlerPanicked(qry, hdl, r, debug.Stack())
+		}
+	}()
+	if err = handle(); err != nil && bus.wrapHandlerErrors && !isContextError(err) {
+		err = NewErrorHandlerFailed(qry, hdl, err)
+	}
+	return err
+}
+
 func (bus *Bus) result(ctx context.Context, qry Query) (*Result, bool) {
-	if qry, implements := qry.(Cacheable); implements {
-		for _, adp := range bus.cacheAdapters {
-			if res := adp.Get(ctx, qry); res != nil {
-				res.loadedFromCache()
-				return res, true
-			}
+	// the results of pinned queries belong to their snapshot
+	if isPinned(ctx) {
+		return newResult(), false
+	}
+	if cqry, implements := qry.(Cacheable); implements {
+		bus.cacheUsage.record(bus.clock.Now(), qry, cqry.CacheKey())
+		if res := bus.cache.Get(ctx, cqry); res != nil {
+			res.loadedFromCache()
+			bus.served(ctx, CacheHit, qry, res.CachedAt())
+			return res, true
 		}
-		return newCacheableResult(qry), false
+		if res, revalidated := bus.revalidate(ctx, qry, cqry); revalidated {
+			res.loadedFromCache()
+			return res, true
+		}
+		bus.observe(ctx, Event{Type: CacheMiss, Query: qry})
+		return newCacheableResult(cqry), false
 	}
 	return newResult(), false
 }
 
 func (bus *Bus) handleCache(ctx context.Context, qry Query, res *Result) {
-	if qry, implements := qry.(Cacheable); implements && qry.CacheDuration() > 0 {
-		at := time.Now()
-		res.expires(at.Add(qry.CacheDuration()))
-		cached := false
-		for _, adp := range bus.cacheAdapters {
-			cached = cached || adp.Set(ctx, qry, res)
-		}
-		if cached {
-			res.cached(at)
+	if cqry, implements := qry.(Cacheable); implements && cqry.CacheDuration() > 0 && !isPinned(ctx) {
+		bus.store(ctx, qry, cqry, res)
+	}
+}
+
+// store caches the result of the cacheable query, registering its freshness check (see Result.Revalidate).
+func (bus *Bus) store(ctx context.Context, qry Query, cqry Cacheable, res *Result) bool {
+	at := bus.clock.Now()
+	if d := bus.cacheUsage.duration(at, qry, cqry.CacheKey(), cqry.CacheDuration()); d != cqry.CacheDuration() {
+		if d <= 0 {
+			return false
 		}
+		cqry = withCacheDuration(cqry, d)
+	}
+	res.expires(at.Add(cqry.CacheDuration()))
+	// the caching moment is provided beforehand for the adapters serializing the result
+	res.cached(at)
+	if !bus.cache.Set(ctx, cqry, res) {
+		res.cached(time.Time{})
+		bus.revalidations.forget(cqry.CacheKey())
+		return false
 	}
+	bus.revalidations.register(at, cqry, res)
+	return true
 }
 
 func (bus *Bus) iteratorWorkerUp() {
@@ -249,14 +845,24 @@ func (bus *Bus) iteratorWorkerDown() {
 }
 
 func (bus *Bus) shutdown() {
-	for atomic.LoadUint32(bus.iteratorWorkers) > 0 {
-		bus.iteratorQueryQueue <- nil
-		<-bus.closed
-		bus.iteratorWorkerDown()
-	}
-	for _, adp := range bus.cacheAdapters {
-		adp.Shutdown()
+	bus.stopAutoscaler()
+	for _, lane := range bus.iteratorLanes {
+		lane.Lock()
+		for i := 0; i < lane.workers; i++ {
+			lane.queue <- nil
+			<-bus.closed
+			bus.iteratorWorkerDown()
+		}
+		lane.workers = 0
+		lane.stopped = true
+		lane.Unlock()
 	}
+	bus.asyncPool.stop()
+	bus.errorDispatcher.stop()
+	bus.cache.Shutdown()
+	bus.revalidations.reset()
+	bus.activity.reset()
+	bus.coldStart.reset()
 	atomic.CompareAndSwapUint32(bus.initialized, 1, 0)
 	atomic.CompareAndSwapUint32(bus.shuttingDown, 1, 0)
 }
@@ -268,6 +874,10 @@ func (bus *Bus) isValid(ctx context.Context, qry Query) error {
 		bus.error(ctx, qry, err)
 		return err
 	}
+	if err = bus.guardLineage(ctx, qry); err != nil {
+		bus.error(ctx, qry, err)
+		return err
+	}
 	return nil
 }
 
@@ -286,11 +896,26 @@ func (bus *Bus) isIteratorValid(ctx context.Context, qry Query) error {
 		bus.error(ctx, qry, err)
 		return err
 	}
+	if err = bus.guardReentrancy(ctx, qry); err != nil {
+		bus.error(ctx, qry, err)
+		return err
+	}
 	return nil
 }
 
 func (bus *Bus) error(ctx context.Context, qry Query, err error) {
+	if bus.errorDispatcher != nil && bus.errorDispatcher.dispatch(bus, ErrorReport{Context: ctx, Query: qry, Err: err}) {
+		return
+	}
 	for _, errHdl := range bus.errorHandlers {
 		errHdl.Handle(ctx, qry, err)
 	}
 }
+
+func (bus *Bus) warn(ctx context.Context, qry Query, warnings []error) {
+	for _, warning := range warnings {
+		for _, wrnHdl := range bus.warningHandlers {
+			wrnHdl.Handle(ctx, qry, warning)
+		}
+	}
+}
@@ -1,8 +1,14 @@
 package query
 
 import (
+	"context"
+	"encoding/json"
+	"errors"
 	"fmt"
+	"reflect"
+	"strings"
 	"sync"
+	"sync/atomic"
 	"testing"
 	"time"
 )
@@ -20,7 +26,7 @@ func TestBus_Initialize(t *testing.T) {
 	}
 
 	bus.InitializeIteratorHandlers(itrHdl, itrHdl2)
-	if len(bus.iteratorHandlers) != 2 {
+	if len(bus.iteratorHandlerSet.handlers) != 2 {
 		t.Error("Unexpected number of handlers.")
 	}
 }
@@ -38,7 +44,7 @@ func TestBus_QueueBuffer(t *testing.T) {
 	bus := NewBus()
 	bus.IteratorQueueBuffer(1000)
 	bus.InitializeIteratorHandlers()
-	if cap(bus.iteratorQueryQueue) != 1000 {
+	if cap(bus.iteratorLane(&testQueryStruct{}).queue) != 1000 {
 		t.Error("Unexpected query queue capacity.")
 	}
 }
@@ -59,14 +65,14 @@ func TestBus_Query(t *testing.T) {
 	hdlCache := &testCacheHandler{}
 	bus.Handlers(hdl, hdlWErr, hdlCache)
 
-	_, err := bus.Query(nil)
+	_, err := bus.Query(context.Background(), nil)
 	if err == nil || err != InvalidQueryError {
 		t.Error("Expected InvalidQueryError error.")
 	} else if err.Error() != "query: invalid query" {
 		t.Error("Unexpected InvalidQueryError message.")
 	}
 
-	res, err := bus.Query(testQueryString("test"))
+	res, err := bus.Query(context.Background(), testQueryString("test"))
 	if err != nil {
 		t.Error(err.Error())
 	}
@@ -78,7 +84,7 @@ func TestBus_Query(t *testing.T) {
 	}
 
 	chQry := &testCacheQuery{}
-	res, err = bus.Query(chQry)
+	res, err = bus.Query(context.Background(), chQry)
 	if err != nil {
 		t.Error(err.Error())
 	}
@@ -92,7 +98,7 @@ func TestBus_Query(t *testing.T) {
 	chAdt := NewMemoryCacheAdapter()
 	bus.CacheAdapters(chAdt)
 	// should return a fresh result again since we just replaced the cache adapter
-	res, err = bus.Query(chQry)
+	res, err = bus.Query(context.Background(), chQry)
 	if err != nil {
 		t.Error(err.Error())
 	}
@@ -107,7 +113,7 @@ func TestBus_Query(t *testing.T) {
 		t.Error("Query returned an unexpected value.")
 	}
 	// should return the cached result and thus avoid the one second processing time
-	res, err = bus.Query(chQry)
+	res, err = bus.Query(context.Background(), chQry)
 	if err != nil {
 		t.Error(err.Error())
 	}
@@ -126,7 +132,7 @@ func TestBus_Query(t *testing.T) {
 	}
 	time.Sleep(time.Second * 2)
 	// should return a fresh result since we are waiting more then 1 second (this query is configured to have 1 second cache)
-	res, err = bus.Query(chQry)
+	res, err = bus.Query(context.Background(), chQry)
 	if err != nil {
 		t.Error(err.Error())
 	}
@@ -137,9 +143,9 @@ func TestBus_Query(t *testing.T) {
 	if res.First() != "bar" {
 		t.Error("Query returned an unexpected value.")
 	}
-	chAdt.Expire(chQry)
+	chAdt.Expire(context.Background(), chQry)
 	// should return a fresh result since we are expiring the cache
-	res, err = bus.Query(chQry)
+	res, err = bus.Query(context.Background(), chQry)
 	if err != nil {
 		t.Error(err.Error())
 	}
@@ -151,7 +157,7 @@ func TestBus_Query(t *testing.T) {
 		t.Error("Query returned an unexpected value.")
 	}
 
-	res, err = bus.Query(&testCacheQuery2{})
+	res, err = bus.Query(context.Background(), &testCacheQuery2{})
 	if err != nil {
 		t.Error(err.Error())
 	}
@@ -163,7 +169,7 @@ func TestBus_Query(t *testing.T) {
 		t.Error("Query returned an unexpected value.")
 	}
 
-	res, err = bus.Query(&testQueryEmptyResult{})
+	res, err = bus.Query(context.Background(), &testQueryEmptyResult{})
 	if err != nil {
 		t.Error(err.Error())
 	}
@@ -172,9 +178,8 @@ func TestBus_Query(t *testing.T) {
 	}
 
 	ok := false
-	if _, err = bus.Query(&testQueryUnsupported{}); err != nil {
-		if err, ok = err.(ErrorNoQueryHandlersFound);
-			ok && err.Error() != fmt.Sprintf("query: no handlers were found for the query %T", &testQueryUnsupported{}) {
+	if _, err = bus.Query(context.Background(), &testQueryUnsupported{}); err != nil {
+		if err, ok = err.(ErrorNoQueryHandlersFound); ok && err.Error() != fmt.Sprintf("query: no handlers were found for the query %T", &testQueryUnsupported{}) {
 			t.Error("Unexpected ErrorNoQueryHandlersFound message.")
 		}
 	}
@@ -182,7 +187,7 @@ func TestBus_Query(t *testing.T) {
 		t.Error("Expected ErrorNoQueryHandlersFound error.")
 	}
 
-	if _, err = bus.Query(&testQueryError{}); err == nil {
+	if _, err = bus.Query(context.Background(), &testQueryError{}); err == nil {
 		t.Error("Query was expected to throw an error.")
 	}
 }
@@ -192,13 +197,13 @@ func TestBus_IteratorQuery(t *testing.T) {
 	itrHdl := &testIteratorHandler{}
 	itrHdlWErr := &testIteratorHandlerWithErrors{}
 
-	_, err := bus.IteratorQuery(nil)
+	_, err := bus.IteratorQuery(context.Background(), nil)
 	if err == nil || err != InvalidQueryError {
 		t.Error("Expected InvalidQueryError error.")
 	} else if err.Error() != "query: invalid query" {
 		t.Error("Unexpected InvalidQueryError message.")
 	}
-	_, err = bus.IteratorQuery(&testQueryStruct{})
+	_, err = bus.IteratorQuery(context.Background(), &testQueryStruct{})
 	if err == nil || err != BusNotInitializedError {
 		t.Error("Expected BusNotInitializedError error.")
 	} else if err.Error() != "query: the bus is not initialized" {
@@ -210,7 +215,7 @@ func TestBus_IteratorQuery(t *testing.T) {
 	}
 	bus.ErrorHandlers(errHdl)
 	bus.InitializeIteratorHandlers(itrHdl, itrHdlWErr)
-	res, err := bus.IteratorQuery(&testQueryStruct{})
+	res, err := bus.IteratorQuery(context.Background(), &testQueryStruct{})
 	if err != nil {
 		t.Error(err.Error())
 	}
@@ -218,7 +223,7 @@ func TestBus_IteratorQuery(t *testing.T) {
 		t.Error("Query returned an unexpected value.")
 	}
 
-	res, err = bus.IteratorQuery(testQueryString("test"))
+	res, err = bus.IteratorQuery(context.Background(), testQueryString("test"))
 	if err != nil {
 		t.Error(err.Error())
 	}
@@ -226,7 +231,7 @@ func TestBus_IteratorQuery(t *testing.T) {
 		t.Error("Query returned an unexpected value.")
 	}
 
-	res, err = bus.IteratorQuery(testQueryString("test"))
+	res, err = bus.IteratorQuery(context.Background(), testQueryString("test"))
 	if err != nil {
 		t.Error(err.Error())
 	}
@@ -237,7 +242,7 @@ func TestBus_IteratorQuery(t *testing.T) {
 	}
 
 	qryTimeout := testQueryString("test")
-	res, err = bus.IteratorQuery(qryTimeout)
+	res, err = bus.IteratorQuery(context.Background(), qryTimeout)
 	if err != nil {
 		t.Error(err.Error())
 	}
@@ -245,23 +250,21 @@ func TestBus_IteratorQuery(t *testing.T) {
 	time.Sleep(time.Second * 6)
 	ok := false
 	if err = errHdl.Error(qryTimeout); err != nil {
-		if err, ok = err.(ErrorQueryTimedOut);
-			ok && err.Error() != fmt.Sprintf("query: the query %T timed out due to lack of result listeners. This may happen if a query was issued but the \"Iterate\" function of the result was not handled", qryTimeout) {
-			t.Error("Unexpected ErrorQueryTimedOut message.")
+		if err, ok = err.(ErrorListenerTimedOut); ok && err.Error() != fmt.Sprintf("query: the query %T timed out due to lack of result listeners. This may happen if a query was issued but the \"Iterate\" function of the result was not handled", qryTimeout) {
+			t.Error("Unexpected ErrorListenerTimedOut message.")
 		}
 	}
 	if !ok {
-		t.Error("Expected ErrorQueryTimedOut error.")
+		t.Error("Expected ErrorListenerTimedOut error.")
 	}
 
 	qryUnsup := &testQueryUnsupported{}
-	res, err = bus.IteratorQuery(qryUnsup)
+	res, err = bus.IteratorQuery(context.Background(), qryUnsup)
 	<-res.Iterate()
 	err = errHdl.Error(qryUnsup)
 	ok = false
-	if _, err = bus.Query(&testQueryUnsupported{}); err != nil {
-		if err, ok = err.(ErrorNoQueryHandlersFound);
-			ok && err.Error() != fmt.Sprintf("query: no handlers were found for the query %T", &testQueryUnsupported{}) {
+	if _, err = bus.Query(context.Background(), &testQueryUnsupported{}); err != nil {
+		if err, ok = err.(ErrorNoQueryHandlersFound); ok && err.Error() != fmt.Sprintf("query: no handlers were found for the query %T", &testQueryUnsupported{}) {
 			t.Error("Unexpected ErrorNoQueryHandlersFound message.")
 		}
 	}
@@ -270,13 +273,48 @@ func TestBus_IteratorQuery(t *testing.T) {
 	}
 
 	qryErr := &testQueryError{}
-	res, err = bus.IteratorQuery(qryErr)
+	res, err = bus.IteratorQuery(context.Background(), qryErr)
 	<-res.Iterate()
 	if err = errHdl.Error(qryErr); err == nil {
 		t.Error("Iterator query was expected to throw an error.")
 	}
 }
 
+func TestBus_IteratorQueryForward(t *testing.T) {
+	to := NewBus()
+	to.InitializeIteratorHandlers(&testIteratorHandler{})
+	bus := NewBus()
+	bus.InitializeIteratorHandlers(&testForwardIteratorHandler{to: to})
+
+	res, err := bus.IteratorQuery(context.Background(), &testQueryStruct{})
+	if err != nil {
+		t.Error(err.Error())
+	}
+	vals := make([]interface{}, 0)
+	for val := range res.Iterate() {
+		vals = append(vals, val)
+	}
+	if len(vals) != 1 || vals[0] != "bar" {
+		t.Error("Iterator query returned unexpected values.")
+	}
+	if res.Err() != nil {
+		t.Error(res.Err().Error())
+	}
+
+	res, err = bus.IteratorQuery(context.Background(), &testQueryUnsupported{})
+	if err != nil {
+		t.Error(err.Error())
+	}
+	for range res.Iterate() {
+		t.Error("Iterator query was not expected to yield values.")
+	}
+	if !errors.As(res.Err(), new(ErrorNoQueryHandlersFound)) {
+		t.Error("Expected ErrorNoQueryHandlersFound error from the forwarded query.")
+	}
+	bus.Shutdown()
+	to.Shutdown()
+}
+
 func TestBus_Shutdown(t *testing.T) {
 	bus := NewBus()
 	hdl := &testHandler{}
@@ -288,7 +326,7 @@ func TestBus_Shutdown(t *testing.T) {
 	bus.Handlers(hdl)
 	bus.IteratorWorkerPoolSize(10)
 	bus.InitializeIteratorHandlers(itrHdl)
-	_, err := bus.Query(&testQueryStruct{})
+	_, err := bus.Query(context.Background(), &testQueryStruct{})
 	if err != nil {
 		t.Error(err.Error())
 	}
@@ -300,14 +338,14 @@ func TestBus_Shutdown(t *testing.T) {
 	})
 
 	for i := 0; i < 1000; i++ {
-		_, _ = bus.Query(&testQueryStruct{})
-		_, _ = bus.IteratorQuery(&testQueryStruct{})
+		_, _ = bus.Query(context.Background(), &testQueryStruct{})
+		_, _ = bus.IteratorQuery(context.Background(), &testQueryStruct{})
 	}
 	time.Sleep(time.Nanosecond * 300)
 	if !bus.isShuttingDown() {
 		t.Error("The bus should be shutting down.")
 	}
-	_, err = bus.IteratorQuery(&testQueryStruct{})
+	_, err = bus.IteratorQuery(context.Background(), &testQueryStruct{})
 	if err == nil || err != BusIsShuttingDownError {
 		t.Error("Expected BusIsShuttingDownError error.")
 	} else if err.Error() != "query: the bus is shutting down" {
@@ -316,44 +354,3426 @@ func TestBus_Shutdown(t *testing.T) {
 	wg.Wait()
 }
 
-func TestBus_HandlerOrder(t *testing.T) {
+func TestBus_ResultCloner(t *testing.T) {
+	mutate := func(res *Result) {
+		res.All()[0].([]string)[0] = "mutated"
+		res.All()[1].(*testRecord).Tags[0] = "mutated"
+		res.All()[2].(*testCloneableRecord).meta["foo"] = "mutated"
+	}
+
+	// without a cloner, the cached result is shared
 	bus := NewBus()
-	hdls := make([]Handler, 0, 1000)
-	for i := 0; i < 1000; i++ {
-		hdls = append(hdls, &testHandlerOrder{position: uint32(i)})
+	bus.Handlers(&testMutableHandler{})
+	res, err := bus.Query(context.Background(), &testCacheQuery{})
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	mutate(res)
+	res, _ = bus.Query(context.Background(), &testCacheQuery{})
+	if res.First().([]string)[0] != "mutated" {
+		t.Error("Expected the cached result to be shared.")
 	}
-	bus.Handlers(hdls...)
 
-	qry := &testHandlerOrderQuery{position: new(uint32), unordered: new(uint32)}
-	_, err := bus.Query(qry)
+	bus = NewBus()
+	bus.Handlers(&testMutableHandler{})
+	bus.ResultCloner(ShallowCloner{})
+	res, _ = bus.Query(context.Background(), &testCacheQuery{})
+	mutate(res)
+	res, _ = bus.Query(context.Background(), &testCacheQuery{})
+	if !res.IsCached() {
+		t.Error("Expected the result to be cached.")
+	}
+	if res.First().([]string)[0] != "foo" {
+		t.Error("Expected the slice to be copied.")
+	}
+	if res.All()[1].(*testRecord).Tags[0] != "mutated" {
+		t.Error("Expected the nested values to be shared by the shallow copy.")
+	}
+	if res.All()[2].(*testCloneableRecord).meta["foo"] != "bar" {
+		t.Error("Expected the Cloneable value to be copied.")
+	}
+
+	bus = NewBus()
+	bus.Handlers(&testMutableHandler{})
+	bus.ResultCloner(DeepCloner{})
+	res, _ = bus.Query(context.Background(), &testCacheQuery{})
+	mutate(res)
+	res, _ = bus.Query(context.Background(), &testCacheQuery{})
+	if res.First().([]string)[0] != "foo" || res.All()[1].(*testRecord).Tags[0] != "foo" || res.All()[2].(*testCloneableRecord).meta["foo"] != "bar" {
+		t.Error("Expected the result to be deeply copied.")
+	}
+
+	rec := &testRecord{Tags: []string{"foo"}, meta: map[string]string{"foo": "bar"}}
+	cp := DeepCloner{}.Clone(rec).(*testRecord)
+	if cp == rec || cp.meta["foo"] != "bar" {
+		t.Error("Expected the unexported fields to be kept.")
+	}
+	if (DeepCloner{}).Clone(nil) != nil {
+		t.Error("Expected nil to be cloned as nil.")
+	}
+}
+
+func TestBus_AsyncErrorHandling(t *testing.T) {
+	bus := NewBus()
+	hdl := &testBatchErrorHandler{started: make(chan bool), release: make(chan bool)}
+	obs := &storeEventsObserver{}
+	bus.ErrorHandlers(hdl)
+	bus.Observers(obs)
+	bus.AsyncErrorHandling(10, 4)
+	bus.InitializeIteratorHandlers()
+
+	if _, err := bus.Query(context.Background(), nil); err != InvalidQueryError {
+		t.Error("Expected InvalidQueryError error.")
+	}
+	// the first batch blocks the dispatch, filling the buffer
+	<-hdl.started
+	for i := 0; i < 12; i++ {
+		_, _ = bus.Query(context.Background(), nil)
+	}
+	if bus.DroppedErrors() != 2 {
+		t.Errorf("Expected 2 dropped errors, got %d.", bus.DroppedErrors())
+	}
+	dropped := 0
+	for _, typ := range obs.Types() {
+		if typ == ErrorDropped {
+			dropped++
+		}
+	}
+	if dropped != 2 {
+		t.Error("Expected the dropped errors to be observed.")
+	}
+
+	close(hdl.release)
+	// shutting down delivers the buffered errors
+	bus.Shutdown()
+	reports := 0
+	for _, batch := range hdl.Batches() {
+		if len(batch) > 4 {
+			t.Error("Expected batches of at most 4 errors.")
+		}
+		for _, rep := range batch {
+			if rep.Err != InvalidQueryError {
+				t.Error("Expected InvalidQueryError error.")
+			}
+		}
+		reports += len(batch)
+	}
+	if reports != 11 {
+		t.Errorf("Expected 11 delivered errors, got %d.", reports)
+	}
+
+	// once stopped, the errors are handled synchronously
+	_, _ = bus.Query(context.Background(), nil)
+	if len(hdl.Batches()) == 0 || len(hdl.Batches()[len(hdl.Batches())-1]) != 1 {
+		t.Error("Expected the error to be handled synchronously.")
+	}
+}
+
+func TestBus_ContextDecorators(t *testing.T) {
+	bus := NewBus()
+	errHdl := &storeErrorsHandler{errs: make(map[string]error)}
+	bus.ErrorHandlers(errHdl)
+	bus.ContextDecorators(&testContextDecorator{tenant: "acme"}, &testContextDecorator{tenant: "eu"})
+	bus.Handlers(&testTenantHandler{})
+	bus.InitializeIteratorHandlers(&testTenantIteratorHandler{})
+
+	res, err := bus.Query(context.Background(), &testQueryStruct{})
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	if res.First() != "acme/eu" {
+		t.Error("Expected the context to be decorated in order.")
+	}
+	itrRes, err := bus.IteratorQuery(context.Background(), &testQueryStruct{})
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	for val := range itrRes.Iterate() {
+		if val != "acme/eu" {
+			t.Error("Expected the iterator query context to be decorated.")
+		}
+	}
+
+	if _, err = bus.Query(context.Background(), &testQueryError{}); err == nil || err.Error() != "missing tenant" {
+		t.Error("Expected the decorator error.")
+	}
+	if errHdl.Error(&testQueryError{}) == nil {
+		t.Error("Expected the decorator error to be handled.")
+	}
+	if _, err = bus.IteratorQuery(context.Background(), &testQueryError{}); err == nil || err.Error() != "missing tenant" {
+		t.Error("Expected the decorator error.")
+	}
+	bus.Shutdown()
+}
+
+func TestBus_Validators(t *testing.T) {
+	bus := NewBus()
+	errHdl := &storeErrorsHandler{errs: make(map[string]error)}
+	hdl := &testCountingHandler{calls: new(uint32)}
+	itrHdl := &testCountingIteratorHandler{calls: new(uint32)}
+	bus.ErrorHandlers(errHdl)
+	bus.Validators(&testNameValidator{})
+	bus.Handlers(hdl)
+	bus.InitializeIteratorHandlers(itrHdl)
+
+	_, err := bus.Query(context.Background(), &testValidatedQuery{})
+	var inputErr ErrorInvalidQueryInput
+	if !errors.As(err, &inputErr) {
+		t.Fatal("Expected ErrorInvalidQueryInput error.")
+	} else if err.Error() != "query: invalid input for the query *query.testValidatedQuery: missing name" {
+		t.Error("Unexpected ErrorInvalidQueryInput message.")
+	}
+	if errHdl.Error(&testValidatedQuery{}) != err {
+		t.Error("Expected the validation error to be handled.")
+	}
+	if _, err = bus.Query(context.Background(), &testValidatedQuery{name: "toolong"}); err == nil || errors.Unwrap(err).Error() != "name too long" {
+		t.Error("Expected the validator error.")
+	}
+	if _, err = bus.IteratorQuery(context.Background(), &testValidatedQuery{}); !errors.As(err, &inputErr) {
+		t.Error("Expected ErrorInvalidQueryInput error.")
+	}
+	if atomic.LoadUint32(hdl.calls) != 0 || atomic.LoadUint32(itrHdl.calls) != 0 {
+		t.Error("Expected the handlers not to be invoked for invalid queries.")
+	}
+
+	res, err := bus.Query(context.Background(), &testValidatedQuery{name: "foo"})
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	if res.First() != "bar" || atomic.LoadUint32(hdl.calls) != 1 {
+		t.Error("Expected the valid query to be handled.")
+	}
+	bus.Shutdown()
+}
+
+func TestBus_QueryAsync(t *testing.T) {
+	bus := NewBus()
+	hdl := &testSlowHandler{calls: new(uint32)}
+	bus.Handlers(hdl)
+	bus.AsyncWorkerPoolSize(3)
+
+	start := time.Now()
+	ftrs := make([]*Future, 3)
+	for i := range ftrs {
+		ftrs[i] = bus.QueryAsync(context.Background(), &testQueryStruct{})
+	}
+	thens := make(chan string, 2)
+	ftrs[0].Then(func(res *Result, err error) {
+		if err == nil {
+			thens <- res.First().(string)
+		}
+	})
+	for _, ftr := range ftrs {
+		res, err := ftr.Result(context.Background())
+		if err != nil {
+			t.Fatal(err.Error())
+		}
+		if res.First() != "bar" {
+			t.Error("Unexpected future result.")
+		}
+	}
+	if time.Since(start) >= time.Millisecond*90 {
+		t.Error("Expected the queries to be handled concurrently.")
+	}
+	// callbacks registered after the query is handled are also executed
+	ftrs[0].Then(func(res *Result, err error) {
+		thens <- "late"
+	})
+	if <-thens != "bar" || <-thens != "late" {
+		t.Error("Expected the callbacks to be executed.")
+	}
+	// and executed one at a time, in the order they are registered
+	var order []int
+	called := make(chan bool)
+	for i := 0; i < 50; i++ {
+		i := i
+		ftrs[1].Then(func(res *Result, err error) {
+			order = append(order, i)
+			if i == 49 {
+				close(called)
+			}
+		})
+	}
+	<-called
+	for i, v := range order {
+		if i != v {
+			t.Fatalf("Expected the callbacks to be executed in order, got %v.", order)
+		}
+	}
+
+	ftr := bus.QueryAsync(context.Background(), nil)
+	<-ftr.Done()
+	if _, err := ftr.Result(context.Background()); err != InvalidQueryError {
+		t.Error("Expected InvalidQueryError error.")
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	ftr = bus.QueryAsync(context.Background(), &testQueryStruct{})
+	if _, err := ftr.Result(ctx); err != context.Canceled {
+		t.Error("Expected context.Canceled error.")
+	}
+
+	// pending asynchronous queries are drained on shutdown
+	bus.Shutdown()
+	if res, err := ftr.Result(context.Background()); err != nil || res.First() != "bar" {
+		t.Error("Expected the asynchronous query to be drained.")
+	}
+	if atomic.LoadUint32(hdl.calls) != 4 {
+		t.Error("Expected 4 handled queries.")
+	}
+}
+
+func TestAsyncPool_Stop(t *testing.T) {
+	p := newAsyncPool(1)
+	p.buffer = 0
+	release := make(chan bool)
+	nested := make(chan bool)
+	p.submit(func() {
+		<-release
+		nested <- p.submitIfRunning(func() {})
+	})
+	// the pool can be stopped while tasks wait for the queue
+	waiting := make(chan bool)
+	go func() { waiting <- p.submitIfRunning(func() {}) }()
+	stopped := make(chan bool)
+	go func() {
+		p.stop()
+		close(stopped)
+	}()
+	if <-waiting {
+		t.Error("Expected the waiting task to be refused once the pool is stopped.")
+	}
+	close(release)
+	if <-nested {
+		t.Error("Expected the nested task to be refused once the pool is stopped.")
+	}
+	select {
+	case <-stopped:
+	case <-time.After(time.Second):
+		t.Error("Expected the pool to be stopped.")
+	}
+}
+
+func TestBus_Subscribe(t *testing.T) {
+	bus := NewBus()
+	errHdl := &storeErrorsHandler{errs: make(map[string]error)}
+	bus.ErrorHandlers(errHdl)
+	bus.SubscriptionHandlers(&testTickerSubscriptionHandler{}, &testFailingSubscriptionHandler{})
+
+	sub, err := bus.Subscribe(context.Background(), &testQueryStruct{})
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	for i := 1; i <= 3; i++ {
+		if update := <-sub.Updates(); update != i {
+			t.Errorf("Unexpected update %v.", update)
+		}
+	}
+	sub.Unsubscribe()
+	<-sub.Done()
+	for range sub.Updates() {
+	}
+	if sub.Err() != nil {
+		t.Error("Unsubscribing was not expected to fail the subscription.")
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	sub, _ = bus.Subscribe(ctx, &testQueryStruct{})
+	<-sub.Updates()
+	cancel()
+	<-sub.Done()
+
+	sub, err = bus.Subscribe(context.Background(), &testQueryError{})
 	if err != nil {
+		t.Fatal(err.Error())
+	}
+	if update := <-sub.Updates(); update != "foo" {
+		t.Errorf("Unexpected update %v.", update)
+	}
+	<-sub.Done()
+	if sub.Err() == nil || sub.Err().Error() != "feed lost" {
+		t.Error("Expected the subscription handler error.")
+	}
+	if errHdl.Error(&testQueryError{}) != sub.Err() {
+		t.Error("Expected the subscription handler error to be handled.")
+	}
+
+	if _, err = bus.Subscribe(context.Background(), &testCacheQuery{}); err == nil {
+		t.Error("Expected ErrorNoQueryHandlersFound error.")
+	}
+	if _, err = bus.Subscribe(context.Background(), nil); err != InvalidQueryError {
+		t.Error("Expected InvalidQueryError error.")
+	}
+
+	// subscriptions are ended on shutdown
+	sub, _ = bus.Subscribe(context.Background(), &testQueryStruct{})
+	<-sub.Updates()
+	ctx, cancel = context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	if err = bus.ShutdownContext(ctx); err != nil {
 		t.Error(err.Error())
 	}
+	<-sub.Done()
+}
 
-	if qry.IsUnordered() {
-		t.Error("The Handler order MUST be respected.")
+func TestBus_IteratorEnqueueTimeout(t *testing.T) {
+	bus := NewBus()
+	errHdl := &storeErrorsHandler{errs: make(map[string]error)}
+	hdl := &testValueIteratorHandler{value: "foo", started: make(chan bool), release: make(chan bool)}
+	bus.ErrorHandlers(errHdl)
+	bus.IteratorWorkerPoolSize(1)
+	bus.IteratorQueueBuffer(1)
+	bus.IteratorListenerTimeout(time.Millisecond * 10)
+	bus.IteratorEnqueueTimeout(-1)
+	bus.InitializeIteratorHandlers(hdl)
+
+	// the only worker is kept busy while the queue is filled
+	res, err := bus.IteratorQuery(context.Background(), &testQueryStruct{})
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	go func() {
+		for range res.Iterate() {
+		}
+	}()
+	<-hdl.started
+	if _, err = bus.IteratorQuery(context.Background(), &testQueryStruct{}); err != nil {
+		t.Fatal(err.Error())
+	}
+
+	_, err = bus.IteratorQuery(context.Background(), &testQueryStruct{})
+	var full ErrorQueueFull
+	if !errors.As(err, &full) {
+		t.Fatal("Expected ErrorQueueFull error.")
+	} else if err.Error() != "query: the iterator query queue is full, unable to enqueue the query *query.testQueryStruct" {
+		t.Error("Unexpected ErrorQueueFull message.")
+	}
+	if !errors.As(errHdl.Error(&testQueryStruct{}), &full) {
+		t.Error("Expected the saturation to be passed on to the error handlers.")
+	}
+
+	bus.IteratorEnqueueTimeout(time.Millisecond * 20)
+	start := time.Now()
+	if _, err = bus.IteratorQuery(context.Background(), &testQueryStruct{}); !errors.As(err, &full) {
+		t.Error("Expected ErrorQueueFull error.")
+	}
+	if time.Since(start) < time.Millisecond*20 {
+		t.Error("Expected the enqueuing to wait for the timeout.")
+	}
+
+	bus.IteratorEnqueueTimeout(0)
+	ctx, cancel := context.WithTimeout(context.Background(), time.Millisecond*20)
+	defer cancel()
+	if _, err = bus.IteratorQuery(ctx, &testQueryStruct{}); err != context.DeadlineExceeded {
+		t.Error("Expected context.DeadlineExceeded error.")
+	}
+
+	close(hdl.release)
+	// the queued query is never iterated, timing out waiting for a listener
+	deadline := time.Now().Add(time.Second)
+	for !errors.As(errHdl.Error(&testQueryStruct{}), new(ErrorListenerTimedOut)) {
+		if time.Now().After(deadline) {
+			t.Fatal("Expected ErrorListenerTimedOut error.")
+		}
+		time.Sleep(time.Millisecond)
 	}
+	bus.Shutdown()
 }
 
-func BenchmarkBus_Query(b *testing.B) {
+func TestBus_QueueFaults(t *testing.T) {
 	bus := NewBus()
-	bus.Handlers(&testHandler{})
-	for n := 0; n < b.N; n++ {
-		_, err := bus.Query(&testQueryStruct{})
+	bus.IteratorEnqueueTimeout(-1)
+	bus.InitializeIteratorHandlers(&testValueIteratorHandler{value: "foo"})
+
+	bus.InjectQueueFaults(QueueFaults{Saturated: true})
+	if _, err := bus.IteratorQuery(context.Background(), &testQueryStruct{}); !errors.As(err, new(ErrorQueueFull)) {
+		t.Error("Expected ErrorQueueFull error.")
+	}
+
+	// the queries waiting for room are enqueued once the faults are removed
+	bus.IteratorEnqueueTimeout(0)
+	enqueued := make(chan error)
+	go func() {
+		_, err := bus.IteratorQuery(context.Background(), &testQueryStruct{})
+		enqueued <- err
+	}()
+	select {
+	case <-enqueued:
+		t.Fatal("Expected the query to wait for room in the saturated queue.")
+	case <-time.After(time.Millisecond * 20):
+	}
+	bus.InjectQueueFaults(QueueFaults{})
+	if err := <-enqueued; err != nil {
+		t.Error(err.Error())
+	}
+
+	bus.InjectQueueFaults(QueueFaults{DequeueDelay: time.Millisecond * 50})
+	start := time.Now()
+	res, err := bus.IteratorQuery(context.Background(), &testQueryStruct{})
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	for val := range res.Iterate() {
+		if val != "foo" {
+			t.Error("Unexpected iterator value.")
+		}
+	}
+	if time.Since(start) < time.Millisecond*50 {
+		t.Error("Expected the dequeued query to be delayed.")
+	}
+	bus.Shutdown()
+}
+
+func TestBus_PanicRecovery(t *testing.T) {
+	bus := NewBus()
+	errHdl := &storeErrorsHandler{errs: make(map[string]error)}
+	bus.ErrorHandlers(errHdl)
+	bus.Handlers(&testPanicHandler{})
+	bus.Use(&testPanicMiddleware{})
+	bus.IteratorWorkerPoolSize(1)
+	bus.InitializeIteratorHandlers(&testPanicIteratorHandler{})
+
+	_, err := bus.Query(context.Background(), &testQueryStruct{})
+	var panicked ErrorHandlerPanicked
+	if !errors.As(err, &panicked) {
+		t.Fatal("Expected ErrorHandlerPanicked error.")
+	}
+	if err.Error() != "query: the handler *query.testPanicHandler panicked while handling the query *query.testQueryStruct: handler panic" {
+		t.Error("Unexpected ErrorHandlerPanicked message.")
+	}
+	if _, isHandler := panicked.Handler().(*testPanicHandler); !isHandler || panicked.Value() != "handler panic" || len(panicked.Stack()) == 0 {
+		t.Error("Expected the handler, panic value and stack trace to be provided.")
+	}
+	if !errors.As(errHdl.Error(&testQueryStruct{}), &panicked) {
+		t.Error("Expected the panic to be passed on to the error handlers.")
+	}
+
+	// the only iterator worker survives the panics, inside and outside of the iterator handlers
+	for _, qry := range []testQueryString{"panic", "middleware", "foo"} {
+		res, err := bus.IteratorQuery(context.Background(), qry)
 		if err != nil {
-			b.Error(err.Error())
+			t.Fatal(err.Error())
 		}
+		for range res.Iterate() {
+		}
+		if qry == "foo" {
+			if res.Err() != nil {
+				t.Error("Expected the iterator worker to keep handling the queries.")
+			}
+			continue
+		}
+		if !errors.As(res.Err(), &panicked) {
+			t.Error("Expected ErrorHandlerPanicked error.")
+		}
+	}
+	if panicked.Handler() != nil {
+		t.Error("Expected no handler to be provided for panics outside of the handlers.")
 	}
+	bus.Shutdown()
 }
 
-func BenchmarkBus_IteratorQuery(b *testing.B) {
+func TestBus_TypedErrors(t *testing.T) {
 	bus := NewBus()
+	bus.Handlers(&testSentinelHandler{})
+	bus.IteratorListenerTimeout(time.Millisecond * 10)
 	bus.InitializeIteratorHandlers(&testIteratorHandler{})
-	for n := 0; n < b.N; n++ {
-		res, err := bus.IteratorQuery(&testQueryStruct{})
-		if err != nil {
-			b.Error(err.Error())
+
+	// the errors of the handlers are returned as is by default
+	_, err := bus.Query(context.Background(), &testQueryStruct{})
+	if err != errTestUnavailable {
+		t.Fatal("Expected the handler error to be returned as is.")
+	}
+
+	bus.HandlerErrorWrapping(true)
+	_, err = bus.Query(context.Background(), &testQueryStruct{})
+	if !errors.Is(err, HandlerFailedError) || !errors.Is(err, errTestUnavailable) {
+		t.Fatal("Expected the handler error to be wrapped into an ErrorHandlerFailed.")
+	}
+	var failed ErrorHandlerFailed
+	if !errors.As(err, &failed) {
+		t.Fatal("Expected ErrorHandlerFailed error.")
+	}
+	if _, isQuery := failed.Query().(*testQueryStruct); !isQuery || TypeName(failed.Handler()) != "query.testSentinelHandler" {
+		t.Error("Expected the query and handler to be provided.")
+	}
+	if err.Error() != "query: the handler *query.testSentinelHandler failed handling the query *query.testQueryStruct: unavailable" {
+		t.Error("Unexpected ErrorHandlerFailed message.")
+	}
+	if errors.Is(err, NoQueryHandlersFoundError) {
+		t.Error("Expected the sentinel errors to only match their own errors.")
+	}
+
+	if _, err = bus.Query(context.Background(), &testQueryUnsupported{}); !errors.Is(err, NoQueryHandlersFoundError) {
+		t.Error("Expected the NoQueryHandlersFoundError sentinel to match.")
+	}
+
+	// the result is never iterated, the query timing out waiting for a listener
+	res, err := bus.IteratorQuery(context.Background(), &testQueryStruct{})
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	deadline := time.Now().Add(time.Second)
+	for res.Err() == nil && time.Now().Before(deadline) {
+		time.Sleep(time.Millisecond)
+	}
+	var timedOut ErrorListenerTimedOut
+	if !errors.Is(res.Err(), ListenerTimedOutError) || !errors.As(res.Err(), &timedOut) {
+		t.Fatal("Expected ErrorListenerTimedOut error.")
+	}
+	if timedOut.Elapsed() < time.Millisecond*10 || timedOut.Query() == nil {
+		t.Error("Expected the elapsed time and query to be provided.")
+	}
+	bus.Shutdown()
+}
+
+func TestBus_Lint(t *testing.T) {
+	bus := NewBus()
+	routed := &testRoutedHandler{handled: new(uint32)}
+	broadcast := &testHandler{}
+	bus.Handlers(routed, broadcast, &testLazyProbeHandler{}, &testProbeHandler{})
+	bus.Handle(&testQueryStruct{}, routed)
+	bus.Handle(&testQueryError{}, broadcast)
+
+	var rep LintReport
+	bus.LintOnInitialize(func(r LintReport) {
+		rep = r
+	})
+	bus.InitializeIteratorHandlers(&testCacheableIteratorHandler{}, &testRoutedIteratorHandler{handled: new(uint32)})
+	defer bus.Shutdown()
+
+	if rep.Passed() {
+		t.Fatal("Expected the lint report to contain findings.")
+	}
+	kinds := map[LintKind][]LintFinding{}
+	for _, fnd := range rep {
+		kinds[fnd.Kind] = append(kinds[fnd.Kind], fnd)
+	}
+	if dups := kinds[LintDuplicateHandler]; len(dups) != 2 || dups[0].Handler == dups[1].Handler {
+		t.Error("Expected the duplicate registrations to be found.")
+	}
+	if cached := kinds[LintCacheableIteratorQuery]; len(cached) != 1 ||
+		cached[0].Message != "the iterator handler query.testCacheableIteratorHandler handles the cacheable query query.testCacheQuery, iterator queries are not cached" {
+		t.Error("Expected the cacheable iterator query to be found.")
+	}
+	if unhandled := kinds[LintUnhandledProbe]; len(unhandled) != 1 {
+		t.Error("Expected the unhandled probe to be found.")
+	} else if _, lazy := unhandled[0].Handler.(*testLazyProbeHandler); !lazy {
+		t.Error("Unexpected handler of the unhandled probe.")
+	}
+
+	// handlers of non comparable types are always considered different
+	fn := testFuncHandler(func(ctx context.Context, qry Query, res *Result) error { return nil })
+	bus = NewBus()
+	bus.Handlers(&testHandler{}, &testProbeHandler{}, fn, fn)
+	bus.InitializeIteratorHandlers(&testIteratorHandler{})
+	if rep = bus.Lint(context.Background()); !rep.Passed() {
+		t.Errorf("Unexpected lint findings %v.", rep)
+	}
+	bus.Shutdown()
+}
+
+func TestBus_ShutdownContext(t *testing.T) {
+	bus := NewBus()
+	hdl := &testDrainHandler{started: make(chan bool), release: make(chan bool)}
+	bus.Handlers(hdl)
+	bus.InitializeIteratorHandlers()
+
+	var res *Result
+	var err error
+	handled := make(chan bool)
+	go func() {
+		res, err = bus.Query(context.Background(), &testQueryStruct{})
+		handled <- true
+	}()
+	<-hdl.started
+
+	stopped := make(chan error)
+	go func() {
+		stopped <- bus.ShutdownContext(context.Background())
+	}()
+	for !bus.isShuttingDown() {
+		time.Sleep(time.Millisecond)
+	}
+	if _, qryErr := bus.Query(context.Background(), &testQueryStruct{}); qryErr != BusIsShuttingDownError {
+		t.Error("Expected BusIsShuttingDownError error.")
+	}
+
+	close(hdl.release)
+	<-handled
+	if err != nil {
+		t.Error(err.Error())
+	} else if res.First() != "drained" {
+		t.Error("Expected the query in flight to be drained.")
+	}
+	if err = <-stopped; err != nil {
+		t.Error(err.Error())
+	}
+
+	// queries exceeding the deadline are aborted
+	bus = NewBus()
+	itrHdl := &testDrainIteratorHandler{started: make(chan bool)}
+	bus.InitializeIteratorHandlers(itrHdl)
+	itrRes, err := bus.IteratorQuery(context.Background(), &testQueryStruct{})
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	go func() {
+		for range itrRes.Iterate() {
 		}
-		<-res.Iterate()
+	}()
+	<-itrHdl.started
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Millisecond*50)
+	defer cancel()
+	if err = bus.ShutdownContext(ctx); err != context.DeadlineExceeded {
+		t.Error("Expected context.DeadlineExceeded error.")
+	}
+	if err = itrRes.Err(); err != QueryAbortedError {
+		t.Error("Expected QueryAbortedError error.")
+	} else if err.Error() != "query: the query was aborted by the shutdown of the bus" {
+		t.Error("Unexpected QueryAbortedError message.")
+	}
+	if err = bus.ShutdownContext(context.Background()); err != nil {
+		t.Error(err.Error())
+	}
+
+	// the cache adapters are shut down once the aborted queries are finished
+	bus = NewBus()
+	slowHdl := &testSlowAbortHandler{started: make(chan bool), finished: new(uint32)}
+	bus.Handlers(slowHdl)
+	var finishedFirst bool
+	bus.CacheAdapters(&testShutdownCacheAdapter{
+		MemoryCacheAdapter: NewMemoryCacheAdapter(),
+		shutdown:           func() { finishedFirst = atomic.LoadUint32(slowHdl.finished) == 1 },
+	})
+	bus.InitializeIteratorHandlers()
+	aborted := make(chan error)
+	go func() {
+		_, err := bus.Query(context.Background(), &testQueryStruct{})
+		aborted <- err
+	}()
+	<-slowHdl.started
+	ctx, cancel = context.WithTimeout(context.Background(), time.Millisecond*10)
+	defer cancel()
+	if err = bus.ShutdownContext(ctx); err != context.DeadlineExceeded {
+		t.Error("Expected context.DeadlineExceeded error.")
+	}
+	if !finishedFirst {
+		t.Error("Expected the cache adapters to be shut down once the aborted queries are finished.")
+	}
+	if err = <-aborted; err != QueryAbortedError {
+		t.Errorf("Expected QueryAbortedError error, got %v.", err)
+	}
+}
+
+func TestBus_Run(t *testing.T) {
+	bus := NewBus()
+	bus.InitializeIteratorHandlers(&testIteratorHandler{})
+
+	ctx, cancel := context.WithCancel(context.Background())
+	time.AfterFunc(time.Millisecond*10, cancel)
+	if err := bus.Run(ctx, 0); err != nil {
+		t.Error(err.Error())
+	}
+	if _, err := bus.IteratorQuery(context.Background(), &testQueryStruct{}); err != BusNotInitializedError {
+		t.Error("The bus was expected to be stopped.")
+	}
+
+	// the queries in flight are drained during the grace period at most
+	bus = NewBus()
+	slowHdl := &testSlowAbortHandler{started: make(chan bool), finished: new(uint32)}
+	bus.Handlers(slowHdl)
+	bus.InitializeIteratorHandlers()
+	aborted := make(chan error)
+	go func() {
+		_, err := bus.Query(context.Background(), &testQueryStruct{})
+		aborted <- err
+	}()
+	<-slowHdl.started
+	ctx, cancel = context.WithCancel(context.Background())
+	cancel()
+	if err := bus.Run(ctx, time.Millisecond*10); err != context.DeadlineExceeded {
+		t.Errorf("Expected context.DeadlineExceeded error, got %v.", err)
+	}
+	if err := <-aborted; err != QueryAbortedError {
+		t.Errorf("Expected QueryAbortedError error, got %v.", err)
+	}
+}
+
+func TestBus_HandlerOrder(t *testing.T) {
+	bus := NewBus()
+	hdls := make([]Handler, 0, 1000)
+	for i := 0; i < 1000; i++ {
+		hdls = append(hdls, &testHandlerOrder{position: uint32(i)})
+	}
+	bus.Handlers(hdls...)
+
+	qry := &testHandlerOrderQuery{position: new(uint32), unordered: new(uint32)}
+	_, err := bus.Query(context.Background(), qry)
+	if err != nil {
+		t.Error(err.Error())
+	}
+
+	if qry.IsUnordered() {
+		t.Error("The Handler order MUST be respected.")
+	}
+}
+
+func TestBus_HandlerRouting(t *testing.T) {
+	bus := NewBus()
+	rtdHdl := &testRoutedHandler{handled: new(uint32)}
+	bus.Handlers(&testHandler{}, rtdHdl)
+	if len(bus.handlers) != 1 {
+		t.Error("Unexpected number of broadcast handlers.")
+	}
+
+	res, err := bus.Query(context.Background(), &testQueryStruct{})
+	if err != nil {
+		t.Error(err.Error())
+	}
+	if res.First() != "routed" {
+		t.Error("Query was expected to be handled by the routed handler.")
+	}
+	// routing is based on the query ID, not on the query type
+	res, err = bus.Query(context.Background(), testQueryString("test"))
+	if err != nil {
+		t.Error(err.Error())
+	}
+	if res.First() != "routed" {
+		t.Error("Query was expected to be handled by the routed handler.")
+	}
+	if _, err = bus.Query(context.Background(), &testQueryEmptyResult{}); err != nil {
+		t.Error("Query was expected to be handled by the broadcast handler.")
+	}
+	if _, err = bus.Query(context.Background(), &testQueryUnsupported{}); err == nil {
+		t.Error("Expected ErrorNoQueryHandlersFound error.")
+	}
+	if atomic.LoadUint32(rtdHdl.handled) != 2 {
+		t.Error("Routed handler was expected to only handle the queries it handles.")
+	}
+
+	bus.Handle(&testQueryError{}, &testHandlerWithErrors{})
+	if _, err = bus.Query(context.Background(), &testQueryError{}); err == nil || err.Error() != "query failed" {
+		t.Error("Query was expected to be handled by the handler provided with Handle.")
+	}
+
+	rtdItrHdl := &testRoutedIteratorHandler{handled: new(uint32)}
+	bus.InitializeIteratorHandlers(&testIteratorHandler{}, rtdItrHdl)
+	itrRes, err := bus.IteratorQuery(context.Background(), &testQueryStruct{})
+	if err != nil {
+		t.Error(err.Error())
+	}
+	if val := <-itrRes.Iterate(); val != "routed" {
+		t.Error("Iterator query was expected to be handled by the routed iterator handler.")
+	}
+	if atomic.LoadUint32(rtdItrHdl.handled) != 1 {
+		t.Error("Routed iterator handler was expected to handle the query once.")
+	}
+	bus.Shutdown()
+}
+
+func TestBus_Middlewares(t *testing.T) {
+	bus := NewBus()
+	calls := make([]string, 0)
+	bus.Handlers(&testHandler{})
+	bus.Use(&testMiddleware{name: "first", calls: &calls}, &testMiddleware{name: "second", calls: &calls})
+
+	res, err := bus.Query(context.Background(), &testQueryStruct{})
+	if err != nil {
+		t.Error(err.Error())
+	}
+	if res.First() != "bar" {
+		t.Error("Query returned an unexpected value.")
+	}
+	if len(calls) != 2 || calls[0] != "first" || calls[1] != "second" {
+		t.Error("The Middleware order MUST be respected.")
+	}
+
+	calls = calls[:0]
+	bus.InitializeIteratorHandlers(&testIteratorHandler{})
+	itrRes, err := bus.IteratorQuery(context.Background(), &testQueryStruct{})
+	if err != nil {
+		t.Error(err.Error())
+	}
+	if val := <-itrRes.Iterate(); val != "bar" {
+		t.Error("Iterator query returned an unexpected value.")
+	}
+	if len(calls) != 2 || calls[0] != "first" || calls[1] != "second" {
+		t.Error("The Middleware order MUST be respected.")
+	}
+	bus.Shutdown()
+
+	bus = NewBus()
+	errHdl := &storeErrorsHandler{
+		errs: make(map[string]error),
+	}
+	bus.ErrorHandlers(errHdl)
+	bus.Handlers(&testHandler{})
+	bus.Use(&testMiddleware{name: "denied", calls: &calls, err: errors.New("denied")})
+	qry := &testQueryStruct{}
+	if _, err = bus.Query(context.Background(), qry); err == nil || err.Error() != "denied" {
+		t.Error("Expected the middleware error.")
+	}
+	if err = errHdl.Error(qry); err == nil || err.Error() != "denied" {
+		t.Error("Expected the middleware error to be passed on to the error handlers.")
+	}
+}
+
+func TestBus_SelfTest(t *testing.T) {
+	bus := NewBus()
+	errHdl := &storeErrorsHandler{
+		errs: make(map[string]error),
+	}
+	bus.ErrorHandlers(errHdl)
+	prbHdl := &testProbeHandler{}
+	bus.Handlers(&testHandler{}, prbHdl)
+	bus.Handle(&testQueryError{}, prbHdl)
+	bus.InitializeIteratorHandlers(&testIteratorHandler{}, &testProbeIteratorHandler{})
+
+	rep := bus.SelfTest(context.Background())
+	if len(rep) != 2 {
+		t.Error("Unexpected number of probes.")
+	}
+	if !rep.Passed() {
+		t.Error("Self-test was expected to pass.")
+	}
+
+	bus.Handlers(&testProbeHandler{err: errors.New("unavailable")}, &testProbeHandler{})
+	rep = bus.SelfTest(context.Background())
+	if rep.Passed() {
+		t.Error("Self-test was expected to fail.")
+	}
+	if rep[0].Err == nil || rep[1].Err != nil {
+		t.Error("Unexpected probe outcome.")
+	}
+	if err := errHdl.Error(&testQueryStruct{}); err == nil || err.Error() != "unavailable" {
+		t.Error("Expected the probe error to be passed on to the error handlers.")
+	}
+	bus.Shutdown()
+}
+
+func TestBus_Deprecate(t *testing.T) {
+	bus := NewBus()
+	depHdl := &storeDeprecationsHandler{}
+	bus.Handlers(&testHandler{})
+	bus.InitializeIteratorHandlers(&testIteratorHandler{})
+	bus.DeprecationHandlers(depHdl)
+	bus.DeprecationStackTraces(true)
+	bus.Deprecate(&testQueryStruct{}, "testQueryString")
+
+	res, err := bus.Query(context.Background(), &testQueryStruct{})
+	if err != nil {
+		t.Error(err.Error())
+	}
+	if res.First() != "bar" {
+		t.Error("Deprecated query was expected to be handled.")
+	}
+	if res.DeprecationWarning() != "query: the query *query.testQueryStruct is deprecated, use testQueryString instead" {
+		t.Error("Unexpected deprecation warning.")
+	}
+	itrRes, err := bus.IteratorQuery(context.Background(), &testQueryStruct{})
+	if err != nil {
+		t.Error(err.Error())
+	}
+	<-itrRes.Iterate()
+	if itrRes.DeprecationWarning() == "" {
+		t.Error("Iterator result was expected to have a deprecation warning.")
+	}
+
+	res, err = bus.Query(context.Background(), &testQueryEmptyResult{})
+	if err != nil {
+		t.Error(err.Error())
+	}
+	if res.DeprecationWarning() != "" {
+		t.Error("Result was not expected to have a deprecation warning.")
+	}
+
+	deps := bus.Deprecations()
+	if len(deps) != 1 || deps[0].Usages != 2 || deps[0].Replacement != "testQueryString" {
+		t.Error("Unexpected deprecations.")
+	}
+	if len(depHdl.usages) != 2 || !strings.Contains(depHdl.usages[0].Caller, "bus_test.go") || len(depHdl.usages[0].Stack) == 0 {
+		t.Error("Deprecation handlers were expected to receive the caller information.")
+	}
+	bus.Shutdown()
+}
+
+func TestBus_QueryDeduplication(t *testing.T) {
+	bus := NewBus()
+	hdl := &testCountingCacheHandler{calls: new(uint32)}
+	bus.Handlers(hdl)
+
+	wg := &sync.WaitGroup{}
+	results := make([]*Result, 10)
+	for i := range results {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			res, err := bus.Query(context.Background(), &testCacheQuery{})
+			if err != nil {
+				t.Error(err.Error())
+			}
+			results[i] = res
+		}(i)
+	}
+	wg.Wait()
+
+	if atomic.LoadUint32(hdl.calls) != 1 {
+		t.Error("Concurrent identical cacheable queries were expected to be handled once.")
+	}
+	for _, res := range results {
+		if res.First() != "bar" {
+			t.Error("Query returned an unexpected value.")
+		}
+	}
+	bus.Shutdown()
+}
+
+func TestBus_QueryDeduplicationPanic(t *testing.T) {
+	bus := NewBus()
+	hdl := &testDrainHandler{started: make(chan bool), release: make(chan bool)}
+	bus.Handlers(hdl)
+	bus.CacheAdapters(&testPanickingCacheAdapter{MemoryCacheAdapter: NewMemoryCacheAdapter()})
+	defer bus.Shutdown()
+
+	panicked := make(chan interface{})
+	go func() {
+		defer func() {
+			panicked <- recover()
+		}()
+		_, _ = bus.Query(context.Background(), &testCacheQuery{})
+	}()
+	<-hdl.started
+	followed := make(chan error)
+	go func() {
+		_, err := bus.Query(context.Background(), &testCacheQuery{})
+		followed <- err
+	}()
+	for bus.flights.len() == 0 {
+		time.Sleep(time.Millisecond)
+	}
+	time.Sleep(time.Millisecond * 20)
+	hdl.release <- true
+
+	if r := <-panicked; r != "cache adapter panic" {
+		t.Errorf("Expected the panic to go on for the leader, got %v.", r)
+	}
+	var hp ErrorHandlerPanicked
+	if err := <-followed; !errors.As(err, &hp) || hp.Value() != "cache adapter panic" || hp.Handler() != nil {
+		t.Errorf("Expected the panic to be provided to the followers, got %v.", err)
+	}
+	if bus.flights.len() != 0 {
+		t.Error("Expected the flight to be landed.")
+	}
+}
+
+func TestBus_MaxStaleness(t *testing.T) {
+	bus := NewBus()
+	hdl := &testCountingCacheHandler{calls: new(uint32)}
+	bus.Handlers(hdl)
+
+	if _, err := bus.Query(context.Background(), &testCacheQuery{}); err != nil {
+		t.Error(err.Error())
+	}
+	time.Sleep(time.Millisecond * 20)
+	res, err := bus.Query(WithMaxStaleness(context.Background(), time.Minute), &testCacheQuery{})
+	if err != nil {
+		t.Error(err.Error())
+	}
+	if !res.IsCached() {
+		t.Error("Result was expected to be cached.")
+	}
+	res, err = bus.Query(WithMaxStaleness(context.Background(), time.Millisecond*10), &testCacheQuery{})
+	if err != nil {
+		t.Error(err.Error())
+	}
+	if !res.IsFresh() || atomic.LoadUint32(hdl.calls) != 2 {
+		t.Error("Result was expected to be fresh.")
+	}
+	bus.Shutdown()
+}
+
+func TestBus_TieredCache(t *testing.T) {
+	bus := NewBus()
+	hdl := &testCountingCacheHandler{calls: new(uint32)}
+	bus.Handlers(hdl)
+	fast := NewMemoryCacheAdapter()
+	slow := NewMemoryCacheAdapter()
+	bus.CacheAdapters(fast, slow)
+
+	fresh, err := bus.Query(context.Background(), &testCacheQuery{})
+	if err != nil {
+		t.Error(err.Error())
+	}
+	if fast.Get(context.Background(), &testCacheQuery{}) == nil || slow.Get(context.Background(), &testCacheQuery{}) == nil {
+		t.Error("Result was expected to be cached in every tier.")
+	}
+	fast.Expire(context.Background(), &testCacheQuery{})
+	res, err := bus.Query(context.Background(), &testCacheQuery{})
+	if err != nil {
+		t.Error(err.Error())
+	}
+	if !res.IsCached() || atomic.LoadUint32(hdl.calls) != 1 {
+		t.Error("Result was expected to be cached by the slower tier.")
+	}
+	if backfilled := fast.Get(context.Background(), &testCacheQuery{}); backfilled == nil || !backfilled.ExpiresAt().Equal(fresh.ExpiresAt()) {
+		t.Error("Result was expected to be back-filled in the faster tier, keeping its expiration.")
+	}
+	bus.Invalidate(context.Background(), &testCacheQuery{})
+	if fast.Get(context.Background(), &testCacheQuery{}) != nil || slow.Get(context.Background(), &testCacheQuery{}) != nil {
+		t.Error("Result was expected to be expired in every tier.")
+	}
+
+	fast = NewMemoryCacheAdapter()
+	slow = NewMemoryCacheAdapter()
+	adp := NewTieredCacheAdapter(fast, slow)
+	adp.TTLScale(0, 0.1)
+	bus.CacheAdapters(adp)
+	fresh, err = bus.Query(context.Background(), &testCacheQuery{})
+	if err != nil {
+		t.Error(err.Error())
+	}
+	if !fresh.ExpiresAt().Equal(fresh.CachedAt().Add(time.Second)) {
+		t.Error("Result was expected to expire according to the query duration.")
+	}
+	if res = fast.Get(context.Background(), &testCacheQuery{}); res == nil || !res.ExpiresAt().Equal(fresh.CachedAt().Add(time.Millisecond*100)) {
+		t.Error("Result was expected to expire sooner in the scaled tier.")
+	}
+	if res = slow.Get(context.Background(), &testCacheQuery{}); res != fresh {
+		t.Error("Result was expected to be cached as is in the unscaled tier.")
+	}
+	time.Sleep(time.Millisecond * 150)
+	if fast.Get(context.Background(), &testCacheQuery{}) != nil {
+		t.Error("Result was expected to be expired in the scaled tier.")
+	}
+	if res = adp.Get(context.Background(), &testCacheQuery{}); res != fresh {
+		t.Error("Result was expected to be cached by the slower tier.")
+	}
+	if fast.Get(context.Background(), &testCacheQuery{}) != nil {
+		t.Error("Result was not expected to be back-filled in the scaled tier past its scaled duration.")
+	}
+	bus.Shutdown()
+}
+
+func TestBus_ColdStartProtection(t *testing.T) {
+	bus := NewBus()
+	hdl := &testDrainHandler{started: make(chan bool), release: make(chan bool)}
+	bus.Handlers(hdl)
+	bus.ColdStartProtection(time.Millisecond*300, 1)
+	bus.InitializeIteratorHandlers()
+
+	issue := func(qrys ...Query) chan *Result {
+		results := make(chan *Result, len(qrys))
+		for _, qry := range qrys {
+			go func(qry Query) {
+				res, err := bus.Query(context.Background(), qry)
+				if err != nil {
+					t.Error(err.Error())
+				}
+				results <- res
+			}(qry)
+		}
+		return results
+	}
+	notStarted := func(msg string) {
+		select {
+		case <-hdl.started:
+			t.Fatal(msg)
+		case <-time.After(time.Millisecond * 20):
+		}
+	}
+
+	// identical cacheable queries are coalesced, every caller receiving its own copy of the result
+	results := issue(&testCacheQuery2{}, &testCacheQuery2{})
+	<-hdl.started
+	notStarted("Expected the identical cacheable queries to share a single handling.")
+	hdl.release <- true
+	shared := make([]*Result, 0)
+	for i := 0; i < 2; i++ {
+		res := <-results
+		if res == nil || res.First() != "drained" {
+			t.Fatal("Expected the shared result.")
+		}
+		shared = append(shared, res)
+	}
+	if shared[0] == shared[1] {
+		t.Error("Expected every caller to receive its own copy of the shared result.")
+	}
+
+	// identical coalescable queries are coalesced during the window
+	results = issue(testCoalesceQuery("foo"), testCoalesceQuery("foo"))
+	<-hdl.started
+	notStarted("Expected the identical coalescable queries to share a single handling.")
+	hdl.release <- true
+	if first, second := <-results, <-results; first == nil || second == nil || first == second || second.First() != "drained" {
+		t.Error("Expected every caller to receive its own copy of the shared result.")
+	}
+
+	// identical queries that are not cacheable are not coalesced
+	results = issue(testQueryString("foo"), testQueryString("foo"))
+	<-hdl.started
+	notStarted("Expected the concurrent handling of the query type to be capped.")
+	hdl.release <- true
+	<-hdl.started
+	hdl.release <- true
+	<-results
+	<-results
+
+	// the concurrent handling of the query type is capped
+	results = issue(testQueryString("foo"), testQueryString("bar"))
+	<-hdl.started
+	notStarted("Expected the concurrent handling of the query type to be capped.")
+	hdl.release <- true
+	<-hdl.started
+	hdl.release <- true
+	<-results
+	<-results
+
+	// neither capped nor coalesced once the window is over
+	time.Sleep(time.Millisecond * 300)
+	results = issue(testCoalesceQuery("foo"), testCoalesceQuery("foo"))
+	<-hdl.started
+	<-hdl.started
+	hdl.release <- true
+	hdl.release <- true
+	<-results
+	<-results
+	bus.Shutdown()
+}
+
+func TestBus_Cancellation(t *testing.T) {
+	bus := NewBus()
+	errHdl := &storeErrorsHandler{
+		errs: make(map[string]error),
+	}
+	bus.ErrorHandlers(errHdl)
+	hdl := &testSlowHandler{calls: new(uint32)}
+	bus.Handlers(hdl, hdl)
+	bus.InitializeIteratorHandlers(&testIteratorHandler{})
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if _, err := bus.Query(ctx, &testQueryStruct{}); err != context.Canceled {
+		t.Error("Expected context.Canceled error.")
+	}
+	if atomic.LoadUint32(hdl.calls) != 0 {
+		t.Error("Handlers were not expected to be used with a cancelled context.")
+	}
+	if err := errHdl.Error(&testQueryStruct{}); err != context.Canceled {
+		t.Error("Expected context.Canceled error to be passed on to the error handlers.")
+	}
+
+	qry := &testQueryError{}
+	itrRes, err := bus.IteratorQuery(ctx, qry)
+	if err != nil {
+		t.Error(err.Error())
+	}
+	for range itrRes.Iterate() {
+		t.Error("Iterator query was not expected to yield values with a cancelled context.")
+	}
+	if err = errHdl.Error(qry); err != context.Canceled {
+		t.Error("Expected context.Canceled error to be passed on to the error handlers.")
+	}
+
+	bus.QueryTimeout(time.Millisecond * 10)
+	if _, err = bus.Query(context.Background(), &testQueryStruct{}); err != context.DeadlineExceeded {
+		t.Error("Expected context.DeadlineExceeded error.")
+	}
+	if atomic.LoadUint32(hdl.calls) != 1 {
+		t.Error("Handlers were expected to be aborted once the deadline was exceeded.")
+	}
+
+	res, err := bus.Query(context.Background(), &testTimeoutQuery{timeout: time.Second})
+	if err != nil {
+		t.Error(err.Error())
+	}
+	if len(res.All()) != 2 {
+		t.Error("Query timeout was expected to override the bus timeout.")
+	}
+	bus.Shutdown()
+}
+
+func TestBus_Observers(t *testing.T) {
+	bus := NewBus()
+	obs := &storeEventsObserver{}
+	bus.Observers(obs)
+	bus.Handlers(&testCountingCacheHandler{calls: new(uint32)})
+
+	for i := 0; i < 2; i++ {
+		if _, err := bus.Query(context.Background(), &testCacheQuery{}); err != nil {
+			t.Error(err.Error())
+		}
+	}
+	types := obs.Types()
+	if len(types) != 3 || types[0] != CacheMiss || types[1] != HandlerFinished || types[2] != CacheHit {
+		t.Error("Unexpected observed events.")
+	}
+	if obs.events[1].Duration <= 0 || obs.events[1].Handler == nil {
+		t.Error("Handler duration was expected to be observed.")
+	}
+
+	obs.events = nil
+	bus.Invalidate(context.Background(), &testCacheQuery{})
+	bus.InvalidateTags(context.Background(), []byte("products"))
+	if types = obs.Types(); len(types) != 2 || types[0] != CacheInvalidated || types[1] != CacheInvalidated {
+		t.Error("Cache invalidations were expected to be observed.")
+	}
+	if obs.events[0].Query == nil || len(obs.events[1].Tags) != 1 {
+		t.Error("The invalidated query and tags were expected to be observed.")
+	}
+
+	obs.events = nil
+	bus.IteratorWorkerPoolSize(1)
+	bus.IteratorQueueBuffer(1)
+	bus.InitializeIteratorHandlers(&testIteratorHandler{})
+	// the worker waits for a listener of the first result while the second result fills the queue
+	res1, _ := bus.IteratorQuery(context.Background(), &testQueryStruct{})
+	time.Sleep(time.Millisecond * 10)
+	res2, _ := bus.IteratorQuery(context.Background(), &testQueryStruct{})
+	res3 := make(chan *IteratorResult)
+	go func() {
+		res, _ := bus.IteratorQuery(context.Background(), &testQueryStruct{})
+		res3 <- res
+	}()
+	time.Sleep(time.Millisecond * 10)
+	<-res1.Iterate()
+	<-res2.Iterate()
+	<-(<-res3).Iterate()
+	if types = obs.Types(); len(types) == 0 || types[0] != IteratorQueueSaturated {
+		t.Error("Iterator queue saturation was expected to be observed.")
+	}
+	bus.Shutdown()
+}
+
+func TestBus_CircuitBreaker(t *testing.T) {
+	bus := NewBus()
+	obs := &storeEventsObserver{}
+	bus.Observers(obs)
+	hdl := &testFlakyHandler{calls: new(uint32), failing: new(uint32)}
+	bus.Handlers(hdl)
+	bus.CircuitBreaker(2, time.Millisecond*20)
+	qry := &testQueryStruct{}
+
+	atomic.StoreUint32(hdl.failing, 1)
+	for i := 0; i < 2; i++ {
+		if _, err := bus.Query(context.Background(), qry); err == nil || err.Error() != "query failed" {
+			t.Error("Query was expected to throw an error.")
+		}
+	}
+	if bus.CircuitState(qry) != CircuitOpen {
+		t.Error("Circuit was expected to be open.")
+	}
+	if _, err := bus.Query(context.Background(), qry); err == nil {
+		t.Error("Expected ErrorCircuitOpen error.")
+	} else if _, ok := err.(ErrorCircuitOpen); !ok {
+		t.Error("Expected ErrorCircuitOpen error.")
+	}
+	if atomic.LoadUint32(hdl.calls) != 2 {
+		t.Error("Handlers were not expected to be used while the circuit is open.")
+	}
+	if bus.CircuitState(&testQueryEmptyResult{}) != CircuitClosed {
+		t.Error("Circuits were expected to be isolated per query type.")
+	}
+
+	// the half-open probe fails, opening the circuit again
+	time.Sleep(time.Millisecond * 30)
+	if _, err := bus.Query(context.Background(), qry); err == nil || err.Error() != "query failed" {
+		t.Error("Probe query was expected to be handled.")
+	}
+	if bus.CircuitState(qry) != CircuitOpen {
+		t.Error("Circuit was expected to be open.")
+	}
+
+	time.Sleep(time.Millisecond * 30)
+	atomic.StoreUint32(hdl.failing, 0)
+	if _, err := bus.Query(context.Background(), qry); err != nil {
+		t.Error(err.Error())
+	}
+	if bus.CircuitState(qry) != CircuitClosed {
+		t.Error("Circuit was expected to be closed.")
+	}
+	types := obs.Types()
+	expected := []EventType{CircuitOpened, CircuitHalfOpened, CircuitOpened, CircuitHalfOpened, CircuitRecovered}
+	circuitTypes := make([]EventType, 0)
+	for _, typ := range types {
+		if typ != HandlerFinished {
+			circuitTypes = append(circuitTypes, typ)
+		}
+	}
+	if fmt.Sprint(circuitTypes) != fmt.Sprint(expected) {
+		t.Errorf("Unexpected circuit events %v.", circuitTypes)
+	}
+}
+
+func TestBus_CircuitBreakerProbes(t *testing.T) {
+	bus := NewBus()
+	hdl := &testCircuitHandler{failing: new(uint32)}
+	bus.Handlers(hdl)
+	bus.CircuitBreaker(1, time.Millisecond*20)
+	qry := &testCircuitQuery{}
+
+	// admitted while the circuit is closed, then finishing while it is half-open
+	admitted := newTestSlowCircuitQuery(bus)
+	atomic.StoreUint32(hdl.failing, 1)
+	if _, err := bus.Query(context.Background(), qry); err == nil {
+		t.Fatal("Query was expected to fail.")
+	}
+	if bus.CircuitState(qry) != CircuitOpen {
+		t.Fatal("Circuit was expected to be open.")
+	}
+	time.Sleep(time.Millisecond * 30)
+	probe := newTestSlowCircuitQuery(bus)
+	if _, err := bus.Query(context.Background(), qry); !errors.Is(err, CircuitOpenError) {
+		t.Errorf("Expected a single probe to be admitted, got %v.", err)
+	}
+
+	// the queries which are not probes do not decide the recovery, nor free probe slots
+	atomic.StoreUint32(hdl.failing, 0)
+	admitted <- nil
+	if err := <-admitted; err != nil {
+		t.Error(err.Error())
+	}
+	if bus.CircuitState(qry) != CircuitHalfOpen {
+		t.Error("Circuit was expected to remain half-open.")
+	}
+	if _, err := bus.Query(context.Background(), qry); !errors.Is(err, CircuitOpenError) {
+		t.Errorf("Expected a single probe to be admitted, got %v.", err)
+	}
+
+	probe <- nil
+	if err := <-probe; err != nil {
+		t.Error(err.Error())
+	}
+	if bus.CircuitState(qry) != CircuitClosed {
+		t.Error("Circuit was expected to be closed by the probe.")
+	}
+}
+
+func TestBus_CircuitBreakerCancelledProbe(t *testing.T) {
+	bus := NewBus()
+	hdl := &testCircuitHandler{failing: new(uint32)}
+	bus.Handlers(hdl)
+	bus.CircuitBreaker(1, time.Millisecond*20)
+	qry := &testCircuitQuery{}
+
+	atomic.StoreUint32(hdl.failing, 1)
+	if _, err := bus.Query(context.Background(), qry); err == nil {
+		t.Fatal("Query was expected to fail.")
+	}
+	time.Sleep(time.Millisecond * 30)
+
+	// the probe is cancelled by its caller, telling nothing about the recovery of the handlers
+	ctx, cancel := context.WithCancel(context.Background())
+	probe := &testCircuitQuery{entered: make(chan bool), release: make(chan bool)}
+	errs := make(chan error)
+	go func() {
+		_, err := bus.Query(ctx, probe)
+		errs <- err
+	}()
+	<-probe.entered
+	cancel()
+	if err := <-errs; !errors.Is(err, context.Canceled) {
+		t.Errorf("Expected the probe to be cancelled, got %v.", err)
+	}
+	if bus.CircuitState(qry) != CircuitHalfOpen {
+		t.Error("Circuit was expected to remain half-open.")
+	}
+
+	// the probe slot is freed, for the next probe to decide
+	if _, err := bus.Query(context.Background(), qry); err == nil || errors.Is(err, CircuitOpenError) {
+		t.Errorf("Expected the next probe to be handled, got %v.", err)
+	}
+	if bus.CircuitState(qry) != CircuitOpen {
+		t.Error("Circuit was expected to be opened by the failing probe.")
+	}
+}
+
+func TestBus_CircuitBreakerPerHandler(t *testing.T) {
+	bus := NewBus()
+	hdl := &testFlakyHandler{calls: new(uint32), failing: new(uint32)}
+	bus.Handle(&testQueryStruct{}, hdl)
+	bus.Handle(&testQueryEmptyResult{}, hdl)
+	bus.Handle(&testCircuitQuery{}, &testCircuitHandler{failing: new(uint32)})
+	bus.CircuitBreaker(2, time.Second)
+	bus.CircuitBreakerPerHandler(true)
+
+	atomic.StoreUint32(hdl.failing, 1)
+	for i := 0; i < 2; i++ {
+		if _, err := bus.Query(context.Background(), &testQueryStruct{}); err == nil || err.Error() != "query failed" {
+			t.Error("Query was expected to throw an error.")
+		}
+	}
+	if bus.HandlerCircuitState(hdl) != CircuitOpen || bus.CircuitState(&testQueryStruct{}) != CircuitClosed {
+		t.Error("The circuit of the handler was expected to be open.")
+	}
+
+	// the circuit of the handler guards every query type it handles
+	_, err := bus.Query(context.Background(), &testQueryEmptyResult{})
+	var open ErrorCircuitOpen
+	if !errors.As(err, &open) || open.Handler() != hdl {
+		t.Errorf("Expected ErrorCircuitOpen providing the handler, got %v.", err)
+	}
+	if atomic.LoadUint32(hdl.calls) != 2 {
+		t.Error("The handler was not expected to be used while its circuit is open.")
+	}
+	if _, err = bus.Query(context.Background(), &testCircuitQuery{}); err != nil {
+		t.Errorf("The other handlers were not expected to be affected, got %v.", err)
+	}
+}
+
+func TestBus_Warnings(t *testing.T) {
+	bus := NewBus()
+	wrnHdl := &storeErrorsHandler{
+		errs: make(map[string]error),
+	}
+	bus.WarningHandlers(wrnHdl)
+	bus.Handlers(&testWarnHandler{})
+	bus.InitializeIteratorHandlers(&testWarnIteratorHandler{})
+
+	res, err := bus.Query(context.Background(), &testQueryStruct{})
+	if err != nil {
+		t.Error(err.Error())
+	}
+	if res.First() != "bar" {
+		t.Error("Query returned an unexpected value.")
+	}
+	if warnings := res.Warnings(); len(warnings) != 1 || warnings[0].Error() != "stale index" {
+		t.Error("Result was expected to contain the warning.")
+	}
+	if err = wrnHdl.Error(&testQueryStruct{}); err == nil || err.Error() != "stale index" {
+		t.Error("Expected the warning to be passed on to the warning handlers.")
+	}
+
+	qry := &testQueryEmptyResult{}
+	itrRes, err := bus.IteratorQuery(context.Background(), qry)
+	if err != nil {
+		t.Error(err.Error())
+	}
+	for range itrRes.Iterate() {
+	}
+	if len(itrRes.Warnings()) != 1 {
+		t.Error("Iterator result was expected to contain the warning.")
+	}
+	if err = wrnHdl.Error(qry); err == nil {
+		t.Error("Expected the warning to be passed on to the warning handlers.")
+	}
+	bus.Shutdown()
+}
+
+func BenchmarkBus_Query(b *testing.B) {
+	bus := NewBus()
+	bus.Handlers(&testHandler{})
+	for n := 0; n < b.N; n++ {
+		_, err := bus.Query(context.Background(), &testQueryStruct{})
+		if err != nil {
+			b.Error(err.Error())
+		}
+	}
+}
+
+func BenchmarkBus_IteratorQuery(b *testing.B) {
+	bus := NewBus()
+	bus.InitializeIteratorHandlers(&testIteratorHandler{})
+	for n := 0; n < b.N; n++ {
+		res, err := bus.IteratorQuery(context.Background(), &testQueryStruct{})
+		if err != nil {
+			b.Error(err.Error())
+		}
+		<-res.Iterate()
+	}
+}
+
+func TestBus_Describe(t *testing.T) {
+	bus := NewBus()
+	bus.Handlers(&testRoutedHandler{}, &testHandler{})
+	bus.Handle(&testCacheQuery{}, &testHandler{})
+	bus.InitializeIteratorHandlers(&testRoutedIteratorHandler{})
+	bus.Deprecate(&testQueryStruct{}, "testQueryString")
+
+	expected := []QueryDescription{
+		{
+			ID:               "UUID",
+			Type:             "query.testQueryStruct",
+			Handlers:         []string{"query.testRoutedHandler"},
+			IteratorHandlers: []string{"query.testRoutedIteratorHandler"},
+			Deprecated:       true,
+			Replacement:      "testQueryString",
+		},
+		{
+			ID:        "UUID-CACHE",
+			Type:      "query.testCacheQuery",
+			Handlers:  []string{"query.testHandler"},
+			Cacheable: true,
+		},
+	}
+	if descs := bus.Describe(); !reflect.DeepEqual(descs, expected) {
+		t.Errorf("Unexpected descriptions %+v.", descs)
+	}
+}
+
+func TestBus_Invalidate(t *testing.T) {
+	bus := NewBus()
+	hdl := &testSlowHandler{calls: new(uint32)}
+	bus.Handlers(hdl)
+	defer bus.Shutdown()
+
+	query := func(qry Query) *Result {
+		res, err := bus.Query(context.Background(), qry)
+		if err != nil {
+			t.Error(err.Error())
+		}
+		return res
+	}
+	query(&testCacheQuery{})
+	query(&testTaggedCacheQuery{key: "A"})
+	query(&testTaggedCacheQuery{key: "B"})
+
+	bus.Invalidate(context.Background(), &testCacheQuery{})
+	if !query(&testCacheQuery{}).IsFresh() {
+		t.Error("Result was expected to be fresh after invalidation.")
+	}
+	if !query(&testTaggedCacheQuery{key: "A"}).IsCached() {
+		t.Error("Tagged result was not expected to be invalidated.")
+	}
+
+	bus.InvalidateTags(context.Background(), []byte("OTHER"))
+	if !query(&testTaggedCacheQuery{key: "B"}).IsCached() {
+		t.Error("Tagged result was not expected to be invalidated by another tag.")
+	}
+	bus.InvalidateTags(context.Background(), []byte("TAG"))
+	if !query(&testTaggedCacheQuery{key: "A"}).IsFresh() || !query(&testTaggedCacheQuery{key: "B"}).IsFresh() {
+		t.Error("Tagged results were expected to be fresh after invalidation.")
+	}
+	if !query(&testCacheQuery{}).IsCached() {
+		t.Error("Untagged result was not expected to be invalidated.")
+	}
+	if calls := atomic.LoadUint32(hdl.calls); calls != 6 {
+		t.Errorf("Unexpected handler calls %d.", calls)
+	}
+}
+
+func TestBus_Serialization(t *testing.T) {
+	bus := NewBus()
+	hdl := &testConcurrencyHandler{running: new(int32), max: new(int32)}
+	bus.Handlers(hdl)
+	defer bus.Shutdown()
+
+	wg := sync.WaitGroup{}
+	for i := 0; i < 5; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if _, err := bus.Query(context.Background(), &testSerializedQuery{key: "A"}); err != nil {
+				t.Error(err.Error())
+			}
+		}()
+	}
+	wg.Wait()
+	if max := atomic.LoadInt32(hdl.max); max != 1 {
+		t.Errorf("Queries with the same serialization key were handled concurrently (%d).", max)
+	}
+
+	atomic.StoreInt32(hdl.max, 0)
+	for _, key := range []string{"A", "B"} {
+		wg.Add(1)
+		go func(key string) {
+			defer wg.Done()
+			if _, err := bus.Query(context.Background(), &testSerializedQuery{key: key}); err != nil {
+				t.Error(err.Error())
+			}
+		}(key)
+	}
+	wg.Wait()
+	if max := atomic.LoadInt32(hdl.max); max != 2 {
+		t.Errorf("Queries with different serialization keys were expected to be handled concurrently (%d).", max)
+	}
+
+	go func() {
+		_, _ = bus.Query(context.Background(), &testSerializedQuery{key: "A"})
+	}()
+	time.Sleep(time.Millisecond * 5)
+	ctx, cancel := context.WithTimeout(context.Background(), time.Millisecond*5)
+	defer cancel()
+	if _, err := bus.Query(ctx, &testSerializedQuery{key: "A"}); !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("Expected the deadline to be exceeded while waiting, got %v.", err)
+	}
+}
+
+func TestBus_QueryBatch(t *testing.T) {
+	bus := NewBus()
+	bus.Handlers(&testHandler{}, &testHandlerWithErrors{}, &testCountingCacheHandler{calls: new(uint32)})
+	defer bus.Shutdown()
+
+	if _, err := bus.Query(context.Background(), &testCacheQuery{}); err != nil {
+		t.Error(err.Error())
+	}
+	results, err := bus.QueryBatch(context.Background(), &testQueryStruct{}, &testQueryError{}, &testCacheQuery{})
+	batchErr := ErrorBatch{}
+	if !errors.As(err, &batchErr) || batchErr.Error() != "query: 1 of the 3 batch queries failed" {
+		t.Fatalf("Expected a batch error, got %v.", err)
+	}
+	if errs := batchErr.Errors(); len(errs) != 3 || errs[0] != nil || errs[1] == nil || errs[2] != nil {
+		t.Errorf("Unexpected batch errors %v.", errs)
+	}
+	if len(results) != 3 || results[0].First() != "bar" || results[1] != nil || !results[2].IsCached() {
+		t.Error("Unexpected batch results.")
+	}
+
+	results, err = bus.QueryBatch(context.Background(), &testQueryStruct{}, &testQueryEmptyResult{})
+	if err != nil || len(results) != 2 {
+		t.Errorf("Unexpected batch outcome %v.", err)
+	}
+
+	hdl := &testConcurrencyHandler{running: new(int32), max: new(int32)}
+	bus = NewBus()
+	bus.Handlers(hdl)
+	bus.BatchConcurrency(2)
+	defer bus.Shutdown()
+	qrys := make([]Query, 6)
+	for i := range qrys {
+		qrys[i] = &testQueryStruct{}
+	}
+	if _, err = bus.QueryBatch(context.Background(), qrys...); err != nil {
+		t.Error(err.Error())
+	}
+	if max := atomic.LoadInt32(hdl.max); max != 2 {
+		t.Errorf("Unexpected batch concurrency %d.", max)
+	}
+}
+
+func TestBus_ReplaceIteratorHandlers(t *testing.T) {
+	bus := NewBus()
+	if err := bus.ReplaceIteratorHandlers(context.Background()); err != BusNotInitializedError {
+		t.Errorf("Expected the bus not initialized error, got %v.", err)
+	}
+	blocking := &testValueIteratorHandler{value: "old", started: make(chan bool), release: make(chan bool)}
+	bus.IteratorWorkerPoolSize(2)
+	bus.InitializeIteratorHandlers(blocking, &testValueIteratorHandler{value: "old2"})
+	defer bus.Shutdown()
+
+	collect := func(res *IteratorResult) []interface{} {
+		values := make([]interface{}, 0)
+		for val := range res.Iterate() {
+			values = append(values, val)
+		}
+		return values
+	}
+	oldRes, err := bus.IteratorQuery(context.Background(), &testQueryStruct{})
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	oldValues := make(chan []interface{})
+	go func() {
+		oldValues <- collect(oldRes)
+	}()
+	<-blocking.started
+
+	// the replacement waits for the queries using the previous handlers
+	ctx, cancel := context.WithTimeout(context.Background(), time.Millisecond*10)
+	defer cancel()
+	if err = bus.ReplaceIteratorHandlers(ctx, &testValueIteratorHandler{value: "new"}); !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("Expected the deadline to be exceeded while the previous handlers are in use, got %v.", err)
+	}
+
+	newRes, err := bus.IteratorQuery(context.Background(), &testQueryStruct{})
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	if values := collect(newRes); !reflect.DeepEqual(values, []interface{}{"new"}) {
+		t.Errorf("Unexpected values %v using the new handlers.", values)
+	}
+
+	close(blocking.release)
+	if values := <-oldValues; !reflect.DeepEqual(values, []interface{}{"old", "old2"}) {
+		t.Errorf("Unexpected values %v using the previous handlers.", values)
+	}
+	if err = bus.ReplaceIteratorHandlers(context.Background(), &testValueIteratorHandler{value: "newer"}); err != nil {
+		t.Error(err.Error())
+	}
+}
+
+func TestBus_PaginatedCache(t *testing.T) {
+	if key := string(PageCacheKey("products", Page{Number: 2, Size: 20}, "shoes|red")); key != "products|shoes%7Cred|page=2&size=20" {
+		t.Errorf("Unexpected page cache key %s.", key)
+	}
+	if key := string(PageCacheKey("products", Cursor{After: "a&b", Limit: 10})); key != "products|after=a%26b&limit=10" {
+		t.Errorf("Unexpected cursor cache key %s.", key)
+	}
+	if key := string(PageCacheKey("products", nil, "shoes|red")); key != "products|shoes%7Cred" {
+		t.Errorf("Unexpected unpaginated cache key %s.", key)
+	}
+	if offset := (Page{Number: 3, Size: 20}).Offset(); offset != 40 {
+		t.Errorf("Unexpected offset %d.", offset)
+	}
+
+	bus := NewBus()
+	hdl := &testSlowHandler{calls: new(uint32)}
+	bus.Handlers(hdl)
+	defer bus.Shutdown()
+
+	query := func(qry Query) *Result {
+		res, err := bus.Query(context.Background(), qry)
+		if err != nil {
+			t.Error(err.Error())
+		}
+		return res
+	}
+	for _, qry := range []Query{
+		newTestPaginatedQuery("products", Page{Number: 1, Size: 10}),
+		newTestPaginatedQuery("products", Page{Number: 2, Size: 10}),
+		newTestPaginatedQuery("orders", Page{Number: 1, Size: 10}),
+		newTestPaginatedQuery("orders", nil),
+	} {
+		if !query(qry).IsFresh() {
+			t.Error("Every page was expected to be handled.")
+		}
+		if !query(qry).IsCached() {
+			t.Error("Every page was expected to be cached.")
+		}
+	}
+
+	bus.InvalidateCollection(context.Background(), "products")
+	if !query(newTestPaginatedQuery("products", Page{Number: 1, Size: 10})).IsFresh() ||
+		!query(newTestPaginatedQuery("products", Page{Number: 2, Size: 10})).IsFresh() {
+		t.Error("Every page of the collection was expected to be invalidated.")
+	}
+	if !query(newTestPaginatedQuery("orders", Page{Number: 1, Size: 10})).IsCached() {
+		t.Error("The pages of other collections were not expected to be invalidated.")
+	}
+}
+
+func TestBus_RemoteTransport(t *testing.T) {
+	bus := NewBus()
+	tpt := &testRemoteTransport{calls: new(uint32)}
+	bus.Handlers(&testHandler{})
+	bus.RemoteTransport(tpt)
+	bus.InitializeIteratorHandlers(&testIteratorHandler{})
+	defer bus.Shutdown()
+
+	res, err := bus.Query(context.Background(), &testQueryStruct{})
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	if res.First() != "bar" {
+		t.Error("Locally handled queries were not expected to be dispatched.")
+	}
+	res, err = bus.Query(context.Background(), &testQueryUnsupported{})
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	if res.First() != "remote" {
+		t.Error("Queries without local handlers were expected to be dispatched.")
+	}
+
+	itrRes, err := bus.IteratorQuery(context.Background(), &testQueryUnsupported{})
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	values := make([]interface{}, 0)
+	for val := range itrRes.Iterate() {
+		values = append(values, val)
+	}
+	if !reflect.DeepEqual(values, []interface{}{"remote"}) {
+		t.Errorf("Unexpected iterator values %v.", values)
+	}
+	if calls := atomic.LoadUint32(tpt.calls); calls != 2 {
+		t.Errorf("Unexpected transport calls %d.", calls)
+	}
+}
+
+func TestBus_IteratorPriorityLanes(t *testing.T) {
+	bus := NewBus()
+	hdl := &testPriorityIteratorHandler{release: make(chan bool)}
+	bus.IteratorWorkerPoolSize(1)
+	bus.IteratorPriorityLane(10, 1)
+	bus.InitializeIteratorHandlers(hdl)
+	defer bus.Shutdown()
+
+	if lane := bus.iteratorLane(&testPriorityQuery{priority: 20}); lane.priority != 10 || lane.workers != 1 {
+		t.Error("Unexpected lane for a higher priority.")
+	}
+	if lane := bus.iteratorLane(&testPriorityQuery{priority: -5}); lane.priority != 0 {
+		t.Error("Unexpected lane for a lower priority.")
+	}
+	if lane := bus.iteratorLane(&testQueryStruct{}); lane.priority != 0 || lane.workers != 1 {
+		t.Error("Unexpected lane for a query without priority.")
+	}
+
+	iterate := func(priority int) <-chan interface{} {
+		res, err := bus.IteratorQuery(context.Background(), &testPriorityQuery{priority: priority})
+		if err != nil {
+			t.Fatal(err.Error())
+		}
+		return res.Iterate()
+	}
+	// the worker of the default lane is blocked by low priority queries
+	low := iterate(0)
+	lower := iterate(-1)
+	if val := <-iterate(10); val != 10 {
+		t.Errorf("Unexpected value %v.", val)
+	}
+	if bus.IteratorQueueLength() == 0 {
+		t.Error("The lower priority query was expected to be waiting in the queue.")
+	}
+	close(hdl.release)
+	if <-low != 0 || <-lower != -1 {
+		t.Error("Low priority queries were expected to be handled eventually.")
+	}
+}
+
+func TestBus_Stream(t *testing.T) {
+	bus := NewBus()
+	bus.InitializeIteratorHandlers(&testIteratorHandler{}, &testIteratorHandlerWithErrors{})
+	defer bus.Shutdown()
+
+	stream := func(qry Query) *IteratorResult {
+		res, err := bus.IteratorQuery(context.Background(), qry)
+		if err != nil {
+			t.Fatal(err.Error())
+		}
+		return res
+	}
+
+	values, err := NewStream[string](stream(&testQueryStruct{})).Collect(context.Background())
+	if err != nil || !reflect.DeepEqual(values, []string{"bar"}) {
+		t.Errorf("Unexpected stream values %v (%v).", values, err)
+	}
+
+	intStream := NewStream[int](stream(&testQueryStruct{}))
+	if _, ok, err := intStream.Next(context.Background()); !ok || err == nil || err.Error() != "query: unexpected value type string, expected int" {
+		t.Errorf("Expected an unexpected value type error, got %v.", err)
+	}
+	if _, ok, err := intStream.Next(context.Background()); ok || err != nil {
+		t.Error("The stream was expected to be finished.")
+	}
+
+	errs := make([]error, 0)
+	NewStream[string](stream(&testQueryError{})).Seq(context.Background())(func(val string, err error) bool {
+		errs = append(errs, err)
+		return true
+	})
+	if len(errs) != 1 || errs[0] == nil {
+		t.Errorf("Expected the query error to be provided by the sequence, got %v.", errs)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	strStream := NewStream[string](stream(&testQueryStruct{}))
+	if _, _, err = strStream.Next(ctx); !errors.Is(err, context.Canceled) {
+		t.Errorf("Expected the context error, got %v.", err)
+	}
+	// the abandoned values must still be consumed
+	if _, err = strStream.Collect(context.Background()); err != nil {
+		t.Error(err.Error())
+	}
+}
+
+func TestBus_StreamAbandoned(t *testing.T) {
+	bus := NewBus()
+	values := []interface{}{"a", 1, "b"}
+	for i := 0; i < 20; i++ {
+		values = append(values, "c")
+	}
+	bus.IteratorWorkerPoolSize(1)
+	bus.IteratorResultBuffer(1)
+	bus.InitializeIteratorHandlers(&testYieldingIteratorHandler{calls: new(uint32), values: values})
+	defer bus.Shutdown()
+
+	stream := func() *Stream[string] {
+		res, err := bus.IteratorQuery(context.Background(), &testQueryStruct{})
+		if err != nil {
+			t.Fatal(err.Error())
+		}
+		return NewStream[string](res)
+	}
+
+	// the values of another type are skipped without interrupting the collection
+	collected, err := stream().Collect(context.Background())
+	var typeErr ErrorUnexpectedValueType
+	if len(collected) != len(values)-1 || collected[1] != "b" || !errors.As(err, &typeErr) {
+		t.Errorf("Expected the unexpected value to be skipped, got %d values (%v).", len(collected), err)
+	}
+
+	// the sequence stopped early releases the worker
+	stream().Seq(context.Background())(func(val string, err error) bool {
+		if err != nil || val != "a" {
+			t.Errorf("Unexpected stream value %v (%v).", val, err)
+		}
+		return false
+	})
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if _, err = stream().Collect(ctx); !errors.Is(err, context.Canceled) {
+		t.Errorf("Expected the context error, got %v.", err)
+	}
+	closed := stream()
+	closed.Close()
+	if _, ok, _ := closed.Next(context.Background()); ok {
+		t.Error("The closed stream was not expected to provide values.")
+	}
+
+	done := make(chan bool)
+	go func() {
+		collected, _ = stream().Collect(context.Background())
+		done <- len(collected) == len(values)-1
+	}()
+	select {
+	case complete := <-done:
+		if !complete {
+			t.Error("Expected the next stream to be complete.")
+		}
+	case <-time.After(time.Second):
+		t.Error("Expected the abandoned streams to be drained, releasing the worker.")
+	}
+}
+
+func TestBus_DecorateIteratorTimeouts(t *testing.T) {
+	bus := NewBus()
+	bus.IteratorListenerTimeout(time.Millisecond * 10)
+	bus.IteratorConsumerTimeout(time.Millisecond * 10)
+	bus.InitializeIteratorHandlers(&testStreamHandler{})
+	defer bus.Shutdown()
+	qr := Decorate(Decorate(bus))
+
+	// the decorated querier waits for the listener up to the listener timeout of the bus
+	res, err := qr.IteratorQuery(context.Background(), &testStreamQuery{count: 1})
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	time.Sleep(time.Millisecond * 50)
+	for range res.Iterate() {
+	}
+	if !errors.Is(res.Err(), ListenerTimedOutError) {
+		t.Errorf("Expected the listener to time out, got %v.", res.Err())
+	}
+
+	// and for the consumer up to the consumer timeout of the bus
+	if res, err = qr.IteratorQuery(context.Background(), &testStreamQuery{count: 100}); err != nil {
+		t.Fatal(err.Error())
+	}
+	values := res.Iterate()
+	<-values
+	time.Sleep(time.Millisecond * 50)
+	for range values {
+	}
+	if !errors.Is(res.Err(), ConsumerStalledError) {
+		t.Errorf("Expected the consumer to stall, got %v.", res.Err())
+	}
+}
+
+func TestBus_ScaleIteratorWorkers(t *testing.T) {
+	bus := NewBus()
+	bus.ScaleIteratorWorkers(3)
+	if bus.IteratorWorkers() != 0 {
+		t.Error("Workers were not expected to be scaled before the initialization.")
+	}
+	hdl := &testPriorityIteratorHandler{release: make(chan bool)}
+	bus.IteratorWorkerPoolSize(2)
+	bus.InitializeIteratorHandlers(hdl, &testIteratorHandler{})
+	defer bus.Shutdown()
+
+	bus.ScaleIteratorWorkers(5)
+	if bus.IteratorWorkers() != 5 || atomic.LoadUint32(bus.iteratorWorkers) != 5 {
+		t.Error("Unexpected number of workers after scaling up.")
+	}
+
+	// the workers are scaled down while a query is being handled
+	blocked, err := bus.IteratorQuery(context.Background(), &testPriorityQuery{})
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	values := blocked.Iterate()
+	bus.ScaleIteratorWorkers(1)
+	if bus.IteratorWorkers() != 1 || atomic.LoadUint32(bus.iteratorWorkers) != 1 {
+		t.Error("Unexpected number of workers after scaling down.")
+	}
+	close(hdl.release)
+	if <-values != 0 {
+		t.Error("The query being handled was expected to finish.")
+	}
+
+	res, err := bus.IteratorQuery(context.Background(), &testQueryStruct{})
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	if <-res.Iterate() != "bar" {
+		t.Error("Queries were expected to be handled by the remaining worker.")
+	}
+}
+
+func TestBus_ScaleIteratorWorkersBusy(t *testing.T) {
+	bus := NewBus()
+	hdl := &testPriorityIteratorHandler{release: make(chan bool)}
+	bus.IteratorWorkerPoolSize(2)
+	bus.InitializeIteratorHandlers(hdl)
+	defer bus.Shutdown()
+
+	results := make([]<-chan interface{}, 0)
+	for i := 0; i < 2; i++ {
+		res, err := bus.IteratorQuery(context.Background(), &testPriorityQuery{})
+		if err != nil {
+			t.Fatal(err.Error())
+		}
+		results = append(results, res.Iterate())
+	}
+	scaled := make(chan bool)
+	go func() {
+		bus.ScaleIteratorWorkers(1)
+		close(scaled)
+	}()
+
+	// the lane is not locked while the surplus worker finishes its query
+	time.Sleep(time.Millisecond * 20)
+	counted := make(chan int)
+	go func() {
+		counted <- bus.IteratorWorkers()
+	}()
+	select {
+	case workers := <-counted:
+		if workers != 1 {
+			t.Errorf("Unexpected number of workers %d while scaling down.", workers)
+		}
+	case <-time.After(time.Second):
+		t.Error("The lane was not expected to be locked while retiring the busy workers.")
+	}
+	close(hdl.release)
+	for _, values := range results {
+		<-values
+	}
+	<-scaled
+}
+
+func TestBus_IteratorAutoscaling(t *testing.T) {
+	bus := NewBus()
+	hdl := &testPriorityIteratorHandler{release: make(chan bool)}
+	bus.IteratorWorkerPoolSize(10)
+	bus.IteratorAutoscaling(1, 3, time.Millisecond*5)
+	bus.InitializeIteratorHandlers(hdl)
+	defer bus.Shutdown()
+	if bus.IteratorWorkers() != 3 {
+		t.Errorf("Unexpected initial number of workers %d.", bus.IteratorWorkers())
+	}
+
+	waitWorkers := func(workers int) {
+		deadline := time.Now().Add(time.Second)
+		for bus.IteratorWorkers() != workers && time.Now().Before(deadline) {
+			time.Sleep(time.Millisecond)
+		}
+		if bus.IteratorWorkers() != workers {
+			t.Errorf("Expected %d workers, got %d.", workers, bus.IteratorWorkers())
+		}
+	}
+	waitWorkers(1)
+
+	results := make([]<-chan interface{}, 0)
+	for i := 0; i < 5; i++ {
+		res, err := bus.IteratorQuery(context.Background(), &testPriorityQuery{})
+		if err != nil {
+			t.Fatal(err.Error())
+		}
+		results = append(results, res.Iterate())
+	}
+	waitWorkers(3)
+
+	close(hdl.release)
+	for _, values := range results {
+		<-values
+	}
+	waitWorkers(1)
+}
+
+func TestBus_IteratorWorkerWrapper(t *testing.T) {
+	bus := NewBus()
+	wrp := &testWorkerWrapper{wrapped: new(int32), unwrapped: new(int32)}
+	bus.IteratorWorkerPoolSize(2)
+	bus.IteratorWorkerWrapper(wrp)
+	bus.InitializeIteratorHandlers(&testWorkerIteratorHandler{})
+
+	res, err := bus.IteratorQuery(context.Background(), &testQueryStruct{})
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	if id, _ := (<-res.Iterate()).(int32); id != 1 && id != 2 {
+		t.Errorf("Unexpected worker context value %v.", id)
+	}
+	if _, ok := WorkerContext(context.Background()); ok {
+		t.Error("Unexpected worker context.")
+	}
+
+	bus.ScaleIteratorWorkers(3)
+	bus.ScaleIteratorWorkers(1)
+	bus.Shutdown()
+	if atomic.LoadInt32(wrp.wrapped) != 3 || atomic.LoadInt32(wrp.unwrapped) != 3 {
+		t.Errorf("Unexpected worker wraps %d/%d.", atomic.LoadInt32(wrp.wrapped), atomic.LoadInt32(wrp.unwrapped))
+	}
+}
+
+func TestBus_Decorate(t *testing.T) {
+	bus := NewBus()
+	bus.Handlers(&testHandler{})
+	bus.InitializeIteratorHandlers(&testIteratorHandler{})
+	defer bus.Shutdown()
+	calls := make([]string, 0)
+	var qr Querier = bus
+	qr = Decorate(qr, &testMiddleware{name: "first", calls: &calls}, &testMiddleware{name: "second", calls: &calls})
+
+	res, err := qr.Query(context.Background(), &testQueryStruct{})
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	if res.First() != "bar" {
+		t.Error("Query returned an unexpected value.")
+	}
+	if len(calls) != 2 || calls[0] != "first" || calls[1] != "second" {
+		t.Error("The Middleware order MUST be respected.")
+	}
+
+	calls = calls[:0]
+	itrRes, err := qr.IteratorQuery(context.Background(), &testQueryStruct{})
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	vals := make([]interface{}, 0)
+	for val := range itrRes.Iterate() {
+		vals = append(vals, val)
+	}
+	if len(vals) != 1 || vals[0] != "bar" || itrRes.Err() != nil || !itrRes.isHandled() {
+		t.Error("Iterator query returned an unexpected result.")
+	}
+	if len(calls) != 2 || calls[0] != "first" || calls[1] != "second" {
+		t.Error("The Middleware order MUST be respected.")
+	}
+
+	denied := errors.New("denied")
+	qr = Decorate(bus, &testMiddleware{name: "denied", calls: &calls, err: denied})
+	if _, err = qr.Query(context.Background(), &testQueryStruct{}); err != denied {
+		t.Error("Expected the middleware error.")
+	}
+	if itrRes, err = qr.IteratorQuery(context.Background(), &testQueryStruct{}); err != nil {
+		t.Fatal(err.Error())
+	}
+	for range itrRes.Iterate() {
+	}
+	if itrRes.Err() != denied {
+		t.Error("Expected the middleware error to be provided by the iterator result.")
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	itrRes, _ = qr.IteratorQuery(ctx, &testQueryStruct{})
+	cancel()
+	for range itrRes.Iterate() {
+	}
+	if itrRes.Err() != context.Canceled && itrRes.Err() != denied {
+		t.Error("Expected the iterator result to be closed once the context is done.")
+	}
+}
+
+func TestBus_IteratorCache(t *testing.T) {
+	bus := NewBus()
+	obs := &storeEventsObserver{}
+	bus.Observers(obs)
+	hdl := &testYieldingIteratorHandler{calls: new(uint32), values: []interface{}{"foo", "bar"}}
+	plainHdl := &testCountingCacheHandler{calls: new(uint32)}
+	bus.Handlers(plainHdl)
+	bus.IteratorCache(2)
+	bus.InitializeIteratorHandlers(hdl)
+	defer bus.Shutdown()
+
+	for i := 0; i < 2; i++ {
+		res, err := bus.IteratorQuery(context.Background(), &testCacheQuery{})
+		if err != nil {
+			t.Fatal(err.Error())
+		}
+		if vals := iterateAll(res); len(vals) != 2 || vals[0] != "foo" || vals[1] != "bar" || res.Err() != nil {
+			t.Errorf("Unexpected values %v.", vals)
+		}
+		if res.IsCached() != (i == 1) {
+			t.Error("Expected the second result to be replayed from cache.")
+		}
+	}
+	if calls := atomic.LoadUint32(hdl.calls); calls != 1 {
+		t.Errorf("Expected the iterator handler to be used once, got %d.", calls)
+	}
+	if types := obs.Types(); len(types) != 3 || types[0] != CacheMiss || types[1] != HandlerFinished || types[2] != CacheHit {
+		t.Error("Unexpected observed events.")
+	}
+
+	// the values are cached apart from the result of the same query
+	if res, err := bus.Query(context.Background(), &testCacheQuery{}); err != nil || res.IsCached() || len(res.All()) != 1 || atomic.LoadUint32(plainHdl.calls) != 1 {
+		t.Error("Expected the query not to read the cached values of the iterator query.")
+	}
+	if res, _ := bus.IteratorQuery(context.Background(), &testCacheQuery{}); len(iterateAll(res)) != 2 || !res.IsCached() {
+		t.Error("Expected the iterator query not to be overwritten by the result of the query.")
+	}
+
+	// results exceeding the limit are not cached
+	bus.Invalidate(context.Background(), &testCacheQuery{})
+	hdl.values = []interface{}{"foo", "bar", "baz"}
+	for i := 0; i < 2; i++ {
+		res, _ := bus.IteratorQuery(context.Background(), &testCacheQuery{})
+		if vals := iterateAll(res); len(vals) != 3 || res.IsCached() {
+			t.Errorf("Unexpected values %v.", vals)
+		}
+	}
+	if calls := atomic.LoadUint32(hdl.calls); calls != 3 {
+		t.Errorf("Expected the iterator handler to be used for every query, got %d.", calls)
+	}
+
+	// queries that are not cacheable are never cached
+	res, _ := bus.IteratorQuery(context.Background(), &testQueryStruct{})
+	iterateAll(res)
+	res, _ = bus.IteratorQuery(context.Background(), &testQueryStruct{})
+	if iterateAll(res); res.IsCached() || atomic.LoadUint32(hdl.calls) != 5 {
+		t.Error("Expected the query not to be cached.")
+	}
+}
+
+func TestBus_DeadlineExtension(t *testing.T) {
+	newBus := func(policy DeadlinePolicy) (*Bus, *testExtendingIteratorHandler) {
+		bus := NewBus()
+		bus.QueryTimeout(time.Millisecond * 60)
+		if policy != nil {
+			bus.DeadlinePolicy(policy)
+		}
+		hdl := &testExtendingIteratorHandler{extension: time.Millisecond * 100, errs: make(chan error, 3)}
+		bus.InitializeIteratorHandlers(hdl)
+		return bus, hdl
+	}
+
+	bus, hdl := newBus(RuntimePolicy{MaxRuntime: time.Second})
+	res, err := bus.IteratorQuery(context.Background(), &testQueryStruct{})
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	if vals := iterateAll(res); len(vals) != 3 || res.Err() != nil {
+		t.Errorf("Expected the deadline to be extended, got %v (%v).", vals, res.Err())
+	}
+	if len(hdl.errs) != 0 {
+		t.Error("Expected the extensions to be granted.")
+	}
+	bus.Shutdown()
+
+	bus, hdl = newBus(RuntimePolicy{MaxRuntime: time.Second, Entitled: func(ctx context.Context, qry Query) bool { return false }})
+	res, _ = bus.IteratorQuery(context.Background(), &testQueryStruct{})
+	if iterateAll(res); !errors.Is(res.Err(), context.DeadlineExceeded) {
+		t.Error("Expected the query to time out.")
+	}
+	if err = <-hdl.errs; !errors.Is(err, DeadlineExtensionDeniedError) || !errors.Is(err, errNotEntitled) {
+		t.Error("Expected the extension to be denied to the callers not entitled.")
+	}
+	bus.Shutdown()
+
+	bus, hdl = newBus(RuntimePolicy{MaxRuntime: time.Millisecond * 120})
+	res, _ = bus.IteratorQuery(context.Background(), &testQueryStruct{})
+	if vals := iterateAll(res); len(vals) == 3 || !errors.Is(res.Err(), context.DeadlineExceeded) {
+		t.Error("Expected the query to time out once the maximum runtime is reached.")
+	}
+	bus.Shutdown()
+
+	bus, hdl = newBus(nil)
+	res, _ = bus.IteratorQuery(context.Background(), &testQueryStruct{})
+	iterateAll(res)
+	var denied ErrorDeadlineExtensionDenied
+	if err = <-hdl.errs; !errors.As(err, &denied) || denied.Query() == nil || !errors.Is(err, errNoDeadlinePolicy) {
+		t.Error("Expected the extension to be denied without a deadline policy.")
+	}
+	bus.Shutdown()
+
+	if at, err := ExtendDeadline(context.Background(), time.Second); !at.IsZero() || err != nil {
+		t.Error("Expected contexts without a deadline to be left as is.")
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	if _, err := ExtendDeadline(ctx, time.Minute); !errors.As(err, &denied) || denied.Query() != nil || !errors.Is(err, DeadlineExtensionDeniedError) ||
+		!errors.Is(err, errDeadlineNotExtendable) {
+		t.Errorf("Expected the deadlines not applied by the bus to be denied, got %v.", err)
+	}
+}
+
+func TestBus_ConcurrencyLimit(t *testing.T) {
+	bus := NewBus()
+	hdl := &testDrainHandler{started: make(chan bool), release: make(chan bool)}
+	bus.Handlers(hdl)
+	bus.ConcurrencyLimit(testQueryString("").ID(), 1)
+	bus.InitializeIteratorHandlers()
+
+	issue := func(ctx context.Context, qry Query) chan error {
+		errs := make(chan error, 1)
+		go func() {
+			_, err := bus.Query(ctx, qry)
+			errs <- err
+		}()
+		return errs
+	}
+
+	// excess queries wait for a slot
+	first := issue(context.Background(), testQueryString("foo"))
+	<-hdl.started
+	second := issue(context.Background(), testQueryString("bar"))
+	select {
+	case <-hdl.started:
+		t.Fatal("Expected the concurrent handling of the query type to be limited.")
+	case <-time.After(time.Millisecond * 20):
+	}
+	hdl.release <- true
+	<-hdl.started
+	hdl.release <- true
+	if <-first != nil || <-second != nil {
+		t.Error("Expected the queries to be handled.")
+	}
+
+	// waiting queries are interrupted by their context
+	first = issue(context.Background(), testQueryString("foo"))
+	<-hdl.started
+	ctx, cancel := context.WithCancel(context.Background())
+	second = issue(ctx, testQueryString("bar"))
+	cancel()
+	if err := <-second; !errors.Is(err, context.Canceled) {
+		t.Error("Expected the waiting to be interrupted by the context.")
+	}
+
+	// excess queries fail once the timeout passes (or right away)
+	bus.ConcurrencyLimitTimeout(time.Millisecond * 10)
+	var reached ErrorConcurrencyLimitReached
+	if err := <-issue(context.Background(), testQueryString("bar")); !errors.As(err, &reached) || reached.Limit() != 1 || reached.Handler() != nil {
+		t.Error("Expected the query to be rejected once the timeout passed.")
+	}
+	bus.ConcurrencyLimitTimeout(-1)
+	if err := <-issue(context.Background(), testQueryString("bar")); !errors.Is(err, ConcurrencyLimitReachedError) {
+		t.Error("Expected the query to be rejected right away.")
+	}
+	other := issue(context.Background(), &testQueryUnsupported{})
+	<-hdl.started
+	hdl.release <- true
+	hdl.release <- true
+	if err := <-other; err != nil {
+		t.Error("Expected the other query types not to be limited.")
+	}
+	<-first
+
+	// a limit changed at runtime applies to the slots in use
+	first = issue(context.Background(), testQueryString("foo"))
+	<-hdl.started
+	bus.ConcurrencyLimit(testQueryString("").ID(), 2)
+	second = issue(context.Background(), testQueryString("bar"))
+	<-hdl.started
+	if err := <-issue(context.Background(), testQueryString("baz")); !errors.Is(err, ConcurrencyLimitReachedError) {
+		t.Error("Expected the slots in use to count against the raised limit.")
+	}
+	bus.ConcurrencyLimit(testQueryString("").ID(), 1)
+	hdl.release <- true
+	<-first
+	if err := <-issue(context.Background(), testQueryString("baz")); !errors.Is(err, ConcurrencyLimitReachedError) {
+		t.Error("Expected the slots in use to count against the lowered limit.")
+	}
+	hdl.release <- true
+	<-second
+
+	// the limit can be removed
+	bus.ConcurrencyLimit(testQueryString("").ID(), 0)
+	first = issue(context.Background(), testQueryString("foo"))
+	second = issue(context.Background(), testQueryString("bar"))
+	<-hdl.started
+	<-hdl.started
+	hdl.release <- true
+	hdl.release <- true
+	<-first
+	<-second
+	bus.Shutdown()
+
+	// nested queries re-entering the limit held by their ancestors are rejected right away, instead of waiting forever
+	bus = NewBus()
+	bus.Handlers(&testNestingHandler{bus: bus})
+	bus.ConcurrencyLimit((&testNestedQuery{}).ID(), 1)
+	bus.InitializeIteratorHandlers()
+	select {
+	case err := <-issue(context.Background(), &testNestedQuery{max: 1}):
+		if !errors.Is(err, ConcurrencyLimitReachedError) {
+			t.Errorf("Expected the nested query to be rejected, got %v.", err)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("Expected the nested query not to wait for the slot held by its ancestor.")
+	}
+	bus.ConcurrencyLimit((&testNestedQuery{}).ID(), 2)
+	if err := <-issue(context.Background(), &testNestedQuery{max: 1}); err != nil {
+		t.Errorf("Expected the nested query to acquire the remaining slot, got %v.", err)
+	}
+	bus.Shutdown()
+
+	// handlers may be limited as well
+	bus = NewBus()
+	limitedHdl := &testLimitedDrainHandler{testDrainHandler{started: make(chan bool), release: make(chan bool)}}
+	bus.Handlers(limitedHdl)
+	bus.ConcurrencyLimitTimeout(-1)
+	bus.InitializeIteratorHandlers()
+	first = issue(context.Background(), testQueryString("foo"))
+	<-limitedHdl.started
+	if err := <-issue(context.Background(), testQueryString("bar")); !errors.As(err, &reached) || reached.Handler() != limitedHdl {
+		t.Error("Expected the handler to be limited.")
+	}
+	limitedHdl.release <- true
+	<-first
+	bus.Shutdown()
+
+	// iterator queries acquire their slot before being enqueued
+	bus = NewBus()
+	itrHdl := &testDrainIteratorHandler{started: make(chan bool)}
+	bus.ConcurrencyLimit((&testQueryStruct{}).ID(), 1)
+	bus.ConcurrencyLimitTimeout(-1)
+	bus.InitializeIteratorHandlers(itrHdl)
+	ctx, cancel = context.WithCancel(context.Background())
+	res, err := bus.IteratorQuery(ctx, &testQueryStruct{})
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	go iterateAll(res)
+	<-itrHdl.started
+	if _, err = bus.IteratorQuery(context.Background(), &testQueryStruct{}); !errors.Is(err, ConcurrencyLimitReachedError) {
+		t.Error("Expected the iterator query to be rejected.")
+	}
+	cancel()
+	bus.Shutdown()
+}
+
+func TestBus_AdaptiveTTL(t *testing.T) {
+	bus := NewBus()
+	bus.Handlers(&testCacheHandler{})
+	bus.AdaptiveTTL((&testCacheQuery{}).ID(), AdaptiveTTL{Min: time.Millisecond * 20, Max: time.Minute, Frequent: 3})
+	bus.InitializeIteratorHandlers()
+	defer bus.Shutdown()
+
+	duration := func() time.Duration {
+		res, err := bus.Query(context.Background(), &testCacheQuery{})
+		if err != nil {
+			t.Fatal(err.Error())
+		}
+		return res.ExpiresAt().Sub(res.CachedAt())
+	}
+
+	// rarely accessed keys expire sooner
+	if d := duration(); d != time.Millisecond*20 {
+		t.Errorf("Expected the minimum duration, got %s.", d)
+	}
+	time.Sleep(time.Millisecond * 30)
+	if d := duration(); d != time.Millisecond*20+(time.Minute-time.Millisecond*20)/2 {
+		t.Errorf("Expected an interpolated duration, got %s.", d)
+	}
+	// hits are accesses as well
+	if res, _ := bus.Query(context.Background(), &testCacheQuery{}); !res.IsCached() {
+		t.Error("Expected the cached result.")
+	}
+	bus.Invalidate(context.Background(), &testCacheQuery{})
+	if d := duration(); d != time.Minute {
+		t.Errorf("Expected the maximum duration for frequently accessed keys, got %s.", d)
+	}
+
+	// the adaptation can be removed
+	bus.AdaptiveTTL((&testCacheQuery{}).ID(), AdaptiveTTL{})
+	bus.Invalidate(context.Background(), &testCacheQuery{})
+	if d := duration(); d != time.Second {
+		t.Errorf("Expected the query cache duration, got %s.", d)
+	}
+}
+
+func TestBus_Sandbox(t *testing.T) {
+	ctx := context.WithValue(context.Background(), testTenantKey{}, "foo")
+	hdl := &testSandboxedHandler{values: []interface{}{"foo", "bar"}}
+	bus := NewBus()
+	bus.Handlers(Sandbox(hdl, SandboxLimits{MaxValues: 2, MaxBytes: 10, DeniedContextKeys: []interface{}{testTenantKey{}}}))
+	bus.InitializeIteratorHandlers()
+
+	res, err := bus.Query(ctx, &testQueryStruct{})
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	if vals := res.All(); len(vals) != 2 || vals[0] != "foo" || vals[1] != "bar" {
+		t.Errorf("Unexpected values %v.", vals)
+	}
+	if total, known := res.Total(); !known || total != 2 {
+		t.Error("Expected the metadata to be merged.")
+	}
+	if tenant, _ := res.MetaValue("tenant"); tenant != nil {
+		t.Error("Expected the denied context values to be hidden.")
+	}
+
+	// the limits are enforced as the values are provided, before they are merged
+	hdl.values = []interface{}{"foo", "bar", "baz"}
+	var violation ErrorSandboxViolation
+	if _, err = bus.Query(ctx, &testQueryStruct{}); !errors.As(err, &violation) || violation.Handler() != hdl {
+		t.Error("Expected the maximum values to be enforced.")
+	}
+	hdl.values = []interface{}{"foo", "foobarbaz"}
+	if _, err = bus.Query(ctx, &testQueryStruct{}); !errors.Is(err, SandboxViolationError) {
+		t.Error("Expected the maximum bytes to be enforced.")
+	}
+	// the values replaced are no longer accounted for
+	bus.Handlers(Sandbox(&testResettingHandler{values: []interface{}{1, 2, 3, 4, 5}}, SandboxLimits{MaxValues: 8}))
+	if res, err = bus.Query(ctx, &testQueryStruct{}); err != nil || len(res.All()) != 5 {
+		t.Errorf("Expected the values to be replaced within the limits, got %v.", err)
+	}
+	bus.Handlers(Sandbox(&testResettingHandler{values: []interface{}{1, 2, 3, 4, 5, 6, 7, 8, 9}}, SandboxLimits{MaxValues: 8}))
+	if _, err = bus.Query(ctx, &testQueryStruct{}); !errors.Is(err, SandboxViolationError) {
+		t.Error("Expected the maximum values to be enforced on the values set.")
+	}
+	unbounded := &testUnboundedHandler{done: make(chan bool)}
+	bus.Handlers(Sandbox(unbounded, SandboxLimits{MaxValues: 100}))
+	if _, err = bus.Query(ctx, &testQueryStruct{}); !errors.Is(err, SandboxViolationError) {
+		t.Errorf("Expected the maximum values to be enforced, got %v.", err)
+	}
+	if <-unbounded.done; unbounded.added != 101 {
+		t.Errorf("Expected the handler to be interrupted by the first value exceeding the limits, got %d values.", unbounded.added)
+	}
+	bus.Shutdown()
+
+	// panics are isolated and handlers ignoring their context are abandoned
+	bus = NewBus()
+	var panicked ErrorHandlerPanicked
+	bus.Handlers(Sandbox(&testPanicHandler{}, SandboxLimits{}))
+	if _, err = bus.Query(ctx, &testQueryStruct{}); !errors.As(err, &panicked) || panicked.Value() != "handler panic" {
+		t.Error("Expected the panic to be isolated.")
+	}
+	blocked := &testSandboxedHandler{block: make(chan bool)}
+	defer close(blocked.block)
+	bus.Handlers(Sandbox(blocked, SandboxLimits{Timeout: time.Millisecond * 10}))
+	if _, err = bus.Query(ctx, &testQueryStruct{}); !errors.Is(err, context.DeadlineExceeded) {
+		t.Error("Expected the handler to be abandoned once the timeout passed.")
+	}
+	bus.Shutdown()
+
+	// iterator handlers are verified per value
+	bus = NewBus()
+	bus.InitializeIteratorHandlers(
+		SandboxIterator(&testYieldingIteratorHandler{calls: new(uint32), values: []interface{}{"foo", "bar", "baz"}}, SandboxLimits{MaxValues: 2}),
+	)
+	itrRes, err := bus.IteratorQuery(ctx, &testQueryStruct{})
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	if vals := iterateAll(itrRes); len(vals) != 2 || !errors.Is(itrRes.Err(), SandboxViolationError) {
+		t.Errorf("Expected the maximum values to be enforced, got %v (%v).", vals, itrRes.Err())
+	}
+	bus.Shutdown()
+
+	if _, routable := SandboxIterator(&testCacheableIteratorHandler{}, SandboxLimits{}).(Routable); !routable {
+		t.Error("Expected the sandbox to remain routable.")
+	}
+	if _, routable := Sandbox(hdl, SandboxLimits{}).(Routable); routable {
+		t.Error("Expected the sandbox not to be routable.")
+	}
+}
+
+func TestBus_SandboxIdentity(t *testing.T) {
+	flaky := &testFlakyHandler{calls: new(uint32), failing: new(uint32)}
+	countable := &testCountableHandler{total: 25, counts: new(uint32)}
+	sbxFlaky := Sandbox(flaky, SandboxLimits{})
+	sbxCountable := Sandbox(countable, SandboxLimits{})
+	bus := NewBus()
+	bus.Handlers(sbxFlaky, sbxCountable)
+	bus.InitializeIteratorHandlers(SandboxIterator(&testProbeIteratorHandler{}, SandboxLimits{}))
+	bus.CircuitBreaker(2, time.Second)
+	bus.CircuitBreakerPerHandler(true)
+	defer bus.Shutdown()
+
+	// the sandboxes are identified by the handlers they wrap
+	if name := TypeName(sbxFlaky); name != "query.testFlakyHandler" {
+		t.Errorf("Expected the sandbox to be named after its handler, got %s.", name)
+	}
+	if hdls := bus.Dump().Handlers; len(hdls) != 2 || hdls[1] != "query.testCountableHandler" {
+		t.Errorf("Expected the dump to provide the sandboxed handler types, got %v.", hdls)
+	}
+	for _, desc := range bus.Describe() {
+		if len(desc.Handlers) != 2 || desc.Handlers[0] != "query.testFlakyHandler" {
+			t.Errorf("Expected the description to provide the sandboxed handler type, got %v.", desc.Handlers)
+		}
+	}
+
+	// the optional interfaces are forwarded
+	res, err := bus.Query(context.Background(), newTestPaginatedQuery("products", Page{Number: 1, Size: 10}))
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	if total, known := res.Total(); !known || total != 25 || atomic.LoadUint32(countable.counts) != 1 {
+		t.Errorf("Expected the sandboxed handler to count the collection, got %d (%t).", total, known)
+	}
+	if rep := bus.SelfTest(context.Background()); len(rep) != 1 || !rep.Passed() {
+		t.Errorf("Expected the sandboxed iterator handler to be probed, got %v.", rep)
+	}
+	if lim, implements := forwarded[Limited](Sandbox(&testLimitedDrainHandler{}, SandboxLimits{})); !implements || lim.ConcurrencyLimit() != 1 {
+		t.Error("Expected the concurrency limit of the sandboxed handler to be forwarded.")
+	}
+
+	// each sandboxed handler has its own circuit
+	atomic.StoreUint32(flaky.failing, 1)
+	for i := 0; i < 2; i++ {
+		if _, err := bus.Query(context.Background(), &testQueryStruct{}); err == nil {
+			t.Error("Query was expected to throw an error.")
+		}
+	}
+	if bus.HandlerCircuitState(sbxFlaky) != CircuitOpen || bus.HandlerCircuitState(sbxCountable) != CircuitClosed {
+		t.Error("Expected only the circuit of the failing sandboxed handler to be open.")
+	}
+}
+
+func TestBus_Lineage(t *testing.T) {
+	bus := NewBus()
+	hdl := &testNestingHandler{bus: bus}
+	bus.Handlers(hdl)
+	bus.InitializeIteratorHandlers()
+	defer bus.Shutdown()
+
+	qry := &testNestedQuery{max: 2}
+	if res, err := bus.Query(context.Background(), qry); err != nil || res.First() != 2 {
+		t.Fatal("Expected the nested queries to be handled.")
+	}
+	if len(hdl.lineages) != 3 {
+		t.Fatalf("Unexpected number of lineages %d.", len(hdl.lineages))
+	}
+	root, leaf := hdl.lineages[0], hdl.lineages[2]
+	if root.Parent != 0 || root.Depth != 0 || root.Root != root.ID {
+		t.Error("Unexpected lineage of the root query.")
+	}
+	if leaf.Parent != hdl.lineages[1].ID || leaf.Root != root.ID || leaf.Depth != 2 {
+		t.Error("Unexpected lineage of the nested query.")
+	}
+	if path := leaf.Path(); len(path) != 3 || path[0] != qry || path[2].(*testNestedQuery).depth != 2 {
+		t.Error("Unexpected path of the nested query.")
+	}
+	if len(hdl.inFlight) != 3 || hdl.inFlight[2].Lineage.ID != leaf.ID || hdl.inFlight[0].Started.IsZero() {
+		t.Error("Expected the nested queries to be in flight.")
+	}
+	if len(bus.InFlight()) != 0 {
+		t.Error("Expected no queries in flight.")
+	}
+
+	// runaway recursion is caught
+	bus.MaxQueryDepth(3)
+	var exceeded ErrorQueryDepthExceeded
+	if _, err := bus.Query(context.Background(), &testNestedQuery{max: 10}); !errors.As(err, &exceeded) || exceeded.Lineage().Depth != 4 || exceeded.Max() != 3 {
+		t.Error("Expected the maximum depth to be enforced.")
+	}
+	bus.MaxQueryDepth(0)
+	hdl.cycle = true
+	if _, err := bus.Query(context.Background(), &testNestedQuery{max: 10}); !errors.Is(err, QueryCycleError) {
+		t.Error("Expected the cycle to be detected.")
+	}
+	// contexts not provided by the bus carry no lineage
+	if lin, ok := LineageFrom(context.Background()); ok || lin.ID != 0 {
+		t.Error("Expected no lineage.")
+	}
+}
+
+func TestBus_ReentrancyGuard(t *testing.T) {
+	if NewBus().maxQueryDepth != defaultMaxQueryDepth {
+		t.Error("Expected the depth of the nested queries to be limited by default.")
+	}
+
+	bus := NewBus()
+	bus.IteratorWorkerPoolSize(1)
+	bus.InitializeIteratorHandlers(&testNestingIteratorHandler{bus: bus})
+	defer bus.Shutdown()
+
+	// the only iterator worker is occupied by the issuing handler
+	res, err := bus.IteratorQuery(context.Background(), &testNestedQuery{max: 1})
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	if vals := iterateAll(res); len(vals) != 0 {
+		t.Error("Expected no values.")
+	}
+	if !errors.Is(res.Err(), QueryReentrantError) {
+		t.Errorf("Expected the re-entrant query to fail fast, got %v.", res.Err())
+	}
+
+	bus = NewBus()
+	bus.IteratorWorkerPoolSize(2)
+	bus.InitializeIteratorHandlers(&testNestingIteratorHandler{bus: bus})
+	defer bus.Shutdown()
+
+	res, err = bus.IteratorQuery(context.Background(), &testNestedQuery{max: 1})
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	if vals := iterateAll(res); len(vals) != 1 || vals[0] != 1 || res.Err() != nil {
+		t.Error("Expected the nested query to be handled by the idle iterator worker.")
+	}
+}
+
+func TestBus_Revalidate(t *testing.T) {
+	bus := NewBus()
+	hdl := &testRevalidatingHandler{calls: new(uint32), checks: new(uint32), fresh: new(uint32)}
+	obs := &storeEventsObserver{}
+	bus.Handlers(hdl)
+	bus.Observers(obs)
+	bus.InitializeIteratorHandlers()
+	defer bus.Shutdown()
+
+	ctx := context.Background()
+	qry := &testRevalidatedQuery{}
+	if res, err := bus.Query(ctx, qry); err != nil || res.First() != uint32(1) {
+		t.Fatal("Expected the query to be handled.")
+	}
+
+	// the expired result is still fresh, its cache duration being extended
+	atomic.StoreUint32(hdl.fresh, 1)
+	time.Sleep(time.Millisecond * 250)
+	res, err := bus.Query(ctx, qry)
+	if err != nil || res.First() != uint32(1) || !res.IsCached() {
+		t.Error("Expected the expired result to be revalidated.")
+	}
+	if atomic.LoadUint32(hdl.checks) != 1 || atomic.LoadUint32(hdl.calls) != 1 {
+		t.Error("Expected the freshness check to be used instead of the handler.")
+	}
+	if types := obs.Types(); types[len(types)-1] != CacheRevalidated {
+		t.Error("Expected the revalidation to be observed.")
+	}
+
+	// the expired result is no longer fresh, the query being handled again
+	atomic.StoreUint32(hdl.fresh, 0)
+	time.Sleep(time.Millisecond * 250)
+	if res, err = bus.Query(ctx, qry); err != nil || res.First() != uint32(2) || res.IsCached() {
+		t.Error("Expected the stale result to be handled again.")
+	}
+	if atomic.LoadUint32(hdl.checks) != 2 {
+		t.Error("Expected the freshness check to be used.")
+	}
+
+	// invalidated results are not revalidated
+	atomic.StoreUint32(hdl.fresh, 1)
+	bus.InvalidateTags(ctx, []byte("REVALIDATED"))
+	if res, err = bus.Query(ctx, qry); err != nil || res.First() != uint32(3) {
+		t.Error("Expected the invalidated result to be handled again.")
+	}
+	if atomic.LoadUint32(hdl.checks) != 2 {
+		t.Error("Expected the freshness check of the invalidated result to be discarded.")
+	}
+
+	// the revalidations keep their own copy of the result, and the concurrent misses run a single freshness check
+	res.Add(uint32(99))
+	time.Sleep(time.Millisecond * 250)
+	wg := &sync.WaitGroup{}
+	for i := 0; i < 5; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if res, err := bus.Query(ctx, qry); err != nil || (res.IsCached() && len(res.All()) != 1) {
+				t.Error("Expected the revalidated result not to be modified by the caller.")
+			}
+		}()
+	}
+	wg.Wait()
+	if checks := atomic.LoadUint32(hdl.checks); checks != 3 {
+		t.Errorf("Expected the freshness check to be used once, got %d.", checks)
+	}
+}
+
+func TestBus_ResultSchemaTiers(t *testing.T) {
+	bus := NewBus()
+	bus.Handlers(&testCountingCacheHandler{calls: new(uint32)})
+	bus.ResultSchema(&testCacheQuery{}, func(val interface{}) (interface{}, error) {
+		return val.(string) + ".v1", nil
+	})
+	versioned := newTestVersionedCacheAdapter()
+	tiered := NewTieredCacheAdapter(NewMemoryCacheAdapter(), versioned)
+	tiered.TTLScale(1, 0.5)
+	replica := newTestVersionedCacheAdapter()
+	bus.CacheAdapters(NewReplicatedCacheAdapter(tiered, 1, ReplicaAdapter(replica)))
+	defer bus.Shutdown()
+
+	if _, err := bus.Query(context.Background(), &testCacheQuery{}); err != nil {
+		t.Fatal(err.Error())
+	}
+	// the scaled tier and the replica serialize the result with the current version of the schema
+	if v := versioned.version(&testCacheQuery{}); v != 1 {
+		t.Errorf("Expected the result to be serialized with the schema version in the scaled tier, got %d.", v)
+	}
+	deadline := time.Now().Add(time.Second)
+	for replica.version(&testCacheQuery{}) == -1 && time.Now().Before(deadline) {
+		time.Sleep(time.Millisecond * 5)
+	}
+	if v := replica.version(&testCacheQuery{}); v != 1 {
+		t.Errorf("Expected the result to be serialized with the schema version in the replica, got %d.", v)
+	}
+	// the current results are not migrated again when read
+	ctx := context.WithValue(context.Background(), lineageKey, &Lineage{owner: bus})
+	if res := versioned.Get(ctx, &testCacheQuery{}); res == nil || res.First() != "bar" {
+		t.Error("Expected the result of the scaled tier not to be migrated again.")
+	}
+	if res := replica.Get(ctx, &testCacheQuery{}); res == nil || res.First() != "bar" {
+		t.Error("Expected the replicated result not to be migrated again.")
+	}
+}
+
+func TestBus_ReplicatedCache(t *testing.T) {
+	bus := NewBus()
+	hdl := &testCountingCacheHandler{calls: new(uint32)}
+	bus.Handlers(hdl)
+	primary := NewMemoryCacheAdapter()
+	secondary := NewMemoryCacheAdapter()
+	blocking := &testBlockingReplicator{release: make(chan bool)}
+	adp := NewReplicatedCacheAdapter(primary, 1, ReplicaAdapter(secondary), blocking)
+	bus.CacheAdapters(adp)
+
+	ctx := context.Background()
+	fresh, err := bus.Query(ctx, &testCacheQuery{})
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	waitReplicated := func(n uint64) ReplicationStats {
+		deadline := time.Now().Add(time.Second)
+		for time.Now().Before(deadline) {
+			if stats := adp.Stats()[0]; stats.Replicated == n {
+				return stats
+			}
+			time.Sleep(time.Millisecond)
+		}
+		t.Fatal("Expected the operations to be replicated.")
+		return ReplicationStats{}
+	}
+	stats := waitReplicated(1)
+	if res := secondary.Get(ctx, &testCacheQuery{}); res == nil || !res.ExpiresAt().Equal(fresh.ExpiresAt()) {
+		t.Error("Expected the result to be replicated, keeping its expiration.")
+	}
+	if stats.Lag <= 0 || stats.MaxLag < stats.Lag || stats.Failed != 0 {
+		t.Error("Unexpected replication stats.")
+	}
+
+	bus.Invalidate(ctx, &testCacheQuery{})
+	waitReplicated(2)
+	if secondary.Get(ctx, &testCacheQuery{}) != nil {
+		t.Error("Expected the expiration to be replicated.")
+	}
+	bus.InvalidateTags(ctx, []byte("TAG"))
+	waitReplicated(3)
+
+	// the slow replicator does not hold back the others, its operations being dropped once its buffer is full
+	if stats = adp.Stats()[1]; stats.Dropped == 0 || stats.Pending != 1 {
+		t.Error("Expected the operations of the slow replicator to be dropped.")
+	}
+	close(blocking.release)
+	bus.Shutdown()
+	if stats = adp.Stats()[1]; stats.Failed != 2 || stats.LastError == nil || stats.Pending != 0 {
+		t.Error("Expected the buffered operations to be replicated before shutting down.")
+	}
+}
+
+func TestBus_GoroutineLeakAudit(t *testing.T) {
+	bus := NewBus()
+	errHdl := &storeErrorsHandler{errs: make(map[string]error)}
+	blocked := &testSandboxedHandler{block: make(chan bool)}
+	defer close(blocked.block)
+	bus.Handlers(Sandbox(blocked, SandboxLimits{Timeout: time.Millisecond * 10}))
+	bus.ErrorHandlers(errHdl)
+	bus.GoroutineGracePeriod(time.Millisecond * 20)
+
+	if _, err := bus.Query(context.Background(), &testQueryStruct{}); !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatal("Expected the handler to be abandoned once the timeout passed.")
+	}
+	gs := bus.Goroutines()
+	if len(gs) != 1 || gs[0].Purpose != "sandbox" || gs[0].Query == nil || gs[0].Lineage.ID == 0 || gs[0].Started.IsZero() {
+		t.Fatalf("Expected the abandoned handler to be tracked, got %v.", gs)
+	}
+	bus.Shutdown()
+	var leak ErrorGoroutineLeak
+	if err := errHdl.Error(&testQueryStruct{}); !errors.As(err, &leak) || leak.Goroutine().ID != gs[0].ID || leak.Running() <= 0 {
+		t.Errorf("Expected the leaked goroutine to be reported, got %v.", err)
+	}
+
+	// the goroutines of a tree of nested queries are capped, the deadline watchers counting towards the limit
+	bus = NewBus()
+	bus.QueryTimeout(time.Minute)
+	bus.MaxQueryGoroutines(1)
+	bus.InitializeIteratorHandlers(SandboxIterator(&testYieldingIteratorHandler{calls: new(uint32), values: []interface{}{"foo"}}, SandboxLimits{}))
+	res, err := bus.IteratorQuery(context.Background(), &testQueryStruct{})
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	var reached ErrorGoroutineLimitReached
+	if vals := iterateAll(res); len(vals) != 0 || !errors.As(res.Err(), &reached) || reached.Limit() != 1 {
+		t.Errorf("Expected the goroutine limit to be enforced, got %v.", res.Err())
+	}
+	bus.MaxQueryGoroutines(3)
+	if res, err = bus.IteratorQuery(context.Background(), &testQueryStruct{}); err != nil {
+		t.Fatal(err.Error())
+	}
+	if vals := iterateAll(res); len(vals) != 1 || res.Err() != nil {
+		t.Errorf("Expected the query to be handled within the goroutine limit, got %v.", res.Err())
+	}
+	bus.Shutdown()
+	if gs = bus.Goroutines(); len(gs) != 0 {
+		t.Errorf("Expected no goroutines left, got %v.", gs)
+	}
+}
+
+func TestBus_MaterializedView(t *testing.T) {
+	bus := NewBus()
+	hdl := &testViewHandler{calls: new(uint32)}
+	bus.Handlers(hdl)
+	bus.InitializeIteratorHandlers()
+
+	ctx := context.Background()
+	if _, err := bus.View(ctx, "counter"); !errors.Is(err, ViewNotFoundError) {
+		t.Fatalf("Expected the view not to be found, got %v.", err)
+	}
+	bus.Materialize("counter", ViewDefinition{Query: &testViewQuery{}, RefreshInterval: time.Millisecond * 100})
+	if gs := bus.Goroutines(); len(gs) != 1 || gs[0].Purpose != "view refresh" {
+		t.Errorf("Expected the goroutine refreshing the view to be tracked, got %v.", gs)
+	}
+
+	// the first read falls back to the live query, materializing its result
+	for i := 0; i < 3; i++ {
+		if res, err := bus.View(ctx, "counter"); err != nil || res.First() != uint32(1) {
+			t.Fatal("Expected the view to serve the first result.")
+		}
+	}
+	if stats := bus.Views(); len(stats) != 1 || stats[0].Misses != 1 || stats[0].Hits != 2 || !stats[0].Materialized {
+		t.Errorf("Unexpected view stats %+v.", stats)
+	}
+
+	// the view is refreshed periodically, bypassing the cached result
+	if !waitForView(bus, "counter", 2) {
+		t.Fatal("Expected the view to be refreshed periodically.")
+	}
+
+	// the view is refreshed whenever its tags are invalidated, or when requested
+	calls := atomic.LoadUint32(hdl.calls)
+	bus.InvalidateTags(ctx, []byte("VIEW"))
+	if !waitForView(bus, "counter", calls+1) {
+		t.Error("Expected the view to be refreshed once invalidated.")
+	}
+	calls = atomic.LoadUint32(hdl.calls)
+	if err := bus.RefreshView("counter"); err != nil || !waitForView(bus, "counter", calls+1) {
+		t.Error("Expected the view to be refreshed when requested.")
+	}
+
+	bus.Shutdown()
+	if _, err := bus.View(ctx, "counter"); !errors.Is(err, ViewNotFoundError) {
+		t.Errorf("Expected the view to be discarded by the shutdown, got %v.", err)
+	}
+	if gs := bus.Goroutines(); len(gs) != 0 {
+		t.Errorf("Expected the goroutine refreshing the view to be stopped by the shutdown, got %v.", gs)
+	}
+}
+
+func TestBus_MaterializedViewMisses(t *testing.T) {
+	bus := NewBus()
+	hdl := &testDrainHandler{started: make(chan bool), release: make(chan bool)}
+	bus.Handlers(hdl)
+	bus.InitializeIteratorHandlers()
+	defer bus.Shutdown()
+	bus.Materialize("drained", ViewDefinition{Query: &testViewQuery{}})
+
+	// the concurrent misses share a single live query, every reader receiving its own copy of the result
+	results := make(chan *Result, 5)
+	for i := 0; i < 5; i++ {
+		go func() {
+			res, err := bus.View(context.Background(), "drained")
+			if err != nil {
+				t.Error(err.Error())
+			}
+			results <- res
+		}()
+	}
+	<-hdl.started
+	if gs := bus.Goroutines(); len(gs) != 2 || gs[1].Purpose != "view" || gs[1].Query == nil {
+		t.Errorf("Expected the goroutine of the live query to be tracked, got %v.", gs)
+	}
+	// the reader cancelling its read does not cancel the shared live query
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if _, err := bus.View(ctx, "drained"); !errors.Is(err, context.Canceled) {
+		t.Errorf("Expected the read to be cancelled, got %v.", err)
+	}
+	select {
+	case <-hdl.started:
+		t.Fatal("Expected the concurrent misses to share a single live query.")
+	case <-time.After(time.Millisecond * 20):
+	}
+	hdl.release <- true
+	seen := make(map[*Result]bool)
+	for i := 0; i < 5; i++ {
+		res := <-results
+		if res == nil || res.First() != "drained" || seen[res] {
+			t.Fatal("Expected every reader to receive its own copy of the shared result.")
+		}
+		seen[res] = true
+	}
+	if res, err := bus.View(context.Background(), "drained"); err != nil || seen[res] {
+		t.Error("Expected the materialized result not to be shared with the readers of the live query.")
+	}
+}
+
+func TestBus_Staleness(t *testing.T) {
+	bus := NewBus()
+	obs := &storeEventsObserver{}
+	bus.Handlers(&testCountingCacheHandler{calls: new(uint32)}, &testViewHandler{calls: new(uint32)})
+	bus.Observers(obs)
+	bus.StalenessBuckets(time.Millisecond*20, time.Millisecond*500)
+	bus.FreshnessSLO(&testCacheQuery{}, time.Millisecond*50)
+	bus.InitializeIteratorHandlers()
+	defer bus.Shutdown()
+
+	ctx := context.Background()
+	for i := 0; i < 2; i++ {
+		if _, err := bus.Query(ctx, &testCacheQuery{}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	time.Sleep(time.Millisecond * 70)
+	if _, err := bus.Query(ctx, &testCacheQuery{}); err != nil {
+		t.Fatal(err)
+	}
+	if types := obs.Types(); types[len(types)-2] != CacheHit || types[len(types)-1] != StaleResultServed {
+		t.Errorf("Expected the stale result to be observed, got %v.", types)
+	}
+
+	bus.Materialize("view", ViewDefinition{Query: &testViewQuery{}})
+	for i := 0; i < 2; i++ {
+		if _, err := bus.View(ctx, "view"); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if types := obs.Types(); types[len(types)-1] != ViewHit {
+		t.Errorf("Expected the view hit to be observed, got %v.", types)
+	}
+
+	stats := bus.Staleness()
+	if len(stats) != 2 {
+		t.Fatalf("Expected the staleness of 2 query types, got %d.", len(stats))
+	}
+	cached, viewed := stats[0], stats[1]
+	if cached.Type != "query.testCacheQuery" || cached.Count != 2 || cached.Buckets[0].Count != 1 || cached.Buckets[1].Count != 1 ||
+		cached.Overflow != 0 || cached.Violations != 1 || cached.SLO != time.Millisecond*50 || cached.Max < time.Millisecond*70 {
+		t.Errorf("Unexpected staleness %+v.", cached)
+	}
+	if viewed.Type != "query.testViewQuery" || viewed.Count != 1 || viewed.Violations != 0 {
+		t.Errorf("Unexpected staleness %+v.", viewed)
+	}
+}
+
+func TestBus_SnapshotPinning(t *testing.T) {
+	bus := NewBus()
+	bus.Handlers(&testSnapshotHandler{})
+	bus.InitializeIteratorHandlers()
+	defer bus.Shutdown()
+
+	if res, err := bus.Query(context.Background(), &testSnapshotQuery{}); err != nil || res.First() != "live" {
+		t.Fatal("Expected the unpinned query to be handled live.")
+	}
+
+	opened := new(uint32)
+	snap := &testSnapshot{released: new(uint32)}
+	ctx, release := Pin(context.Background(), func(ctx context.Context) (Snapshot, error) {
+		atomic.AddUint32(opened, 1)
+		return snap, nil
+	})
+	// pinning a pinned context joins its snapshot
+	nested, releaseNested := Pin(ctx, nil)
+	releaseNested()
+
+	// the pinned queries bypass the cached result, sharing a single snapshot
+	wg := &sync.WaitGroup{}
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if res, err := bus.Query(nested, &testSnapshotQuery{}); err != nil || res.First() != "TX-1" || res.IsCached() {
+				t.Error("Expected the pinned query to be handled as of the snapshot.")
+			}
+		}()
+	}
+	wg.Wait()
+	if atomic.LoadUint32(opened) != 1 {
+		t.Errorf("Expected the snapshot to be opened once, got %d.", atomic.LoadUint32(opened))
+	}
+
+	// the results of the pinned queries are not cached
+	if res, err := bus.Query(context.Background(), &testSnapshotQuery{}); err != nil || res.First() != "live" || !res.IsCached() {
+		t.Error("Expected the cached result to remain.")
+	}
+
+	release()
+	release()
+	if atomic.LoadUint32(snap.released) != 1 {
+		t.Errorf("Expected the snapshot to be released once, got %d.", atomic.LoadUint32(snap.released))
+	}
+	if _, err := bus.Query(ctx, &testSnapshotQuery{}); !errors.Is(err, SnapshotReleasedError) {
+		t.Errorf("Expected the snapshot to be released, got %v.", err)
+	}
+}
+
+func TestBus_SnapshotOpenerContext(t *testing.T) {
+	bus := NewBus()
+	bus.Handlers(&testSnapshotHandler{})
+	bus.InitializeIteratorHandlers()
+	defer bus.Shutdown()
+
+	var openCtx context.Context
+	pinned, release := Pin(context.WithValue(context.Background(), testTenantKey{}, "foo"), func(ctx context.Context) (Snapshot, error) {
+		openCtx = ctx
+		return &testSnapshot{released: new(uint32)}, nil
+	})
+	defer release()
+
+	qctx, cancel := context.WithCancel(pinned)
+	if res, err := bus.Query(qctx, &testSnapshotQuery{}); err != nil || res.First() != "TX-1" {
+		t.Fatal("Expected the pinned query to be handled as of the snapshot.")
+	}
+	// the snapshot outlives the query opening it
+	cancel()
+	if res, err := bus.Query(pinned, &testSnapshotQuery{}); err != nil || res.First() != "TX-1" {
+		t.Error("Expected the next pinned query to share the snapshot.")
+	}
+	if openCtx.Err() != nil || openCtx.Value(testTenantKey{}) != "foo" {
+		t.Error("Expected the snapshot to be opened with the values of the pinned context, without its cancellation.")
+	}
+}
+
+func TestBus_LoadShedding(t *testing.T) {
+	bus := NewBus()
+	hdl := &testShedHandler{slow: new(uint32)}
+	bus.Handlers(hdl)
+	bus.LoadShedding(LoadSheddingPolicy{Target: time.Millisecond * 5, Window: time.Millisecond * 30, Step: 0.5, Max: 0.5})
+	bus.InitializeIteratorHandlers()
+	defer bus.Shutdown()
+
+	// the slow query type is progressively shed
+	atomic.StoreUint32(hdl.slow, 1)
+	if shedding := waitForShedding(bus, func(share float64) bool { return share == 0.5 }); !shedding {
+		t.Fatal("Expected the slow query type to be shed.")
+	}
+	var errs []error
+	for i := 0; i < 10; i++ {
+		if _, err := bus.Query(context.Background(), &testShedQuery{}); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	if len(errs) == 0 {
+		t.Fatal("Expected queries to be shed.")
+	}
+	for _, err := range errs {
+		if !errors.Is(err, QueryShedError) {
+			t.Fatalf("Expected the query to be shed, got %v.", err)
+		}
+	}
+	if stats := bus.LoadSheddingStats(); stats[0].ID != "UUID-SHED" || stats[0].Shed < uint64(len(errs)) || stats[0].Latency <= time.Millisecond*5 {
+		t.Errorf("Unexpected stats %+v.", stats[0])
+	}
+
+	// the query type recovers once handled fast again
+	atomic.StoreUint32(hdl.slow, 0)
+	if recovered := waitForShedding(bus, func(share float64) bool { return share == 0 }); !recovered {
+		t.Fatal("Expected the query type to recover.")
+	}
+
+	// the fallback serves the queries shed
+	bus = NewBus()
+	bus.Handlers(hdl)
+	bus.LoadShedding(LoadSheddingPolicy{
+		Target: time.Millisecond * 5,
+		Window: time.Millisecond * 30,
+		Fallback: func(ctx context.Context, qry Query, res *Result) error {
+			res.Add("fallback")
+			return nil
+		},
+	})
+	bus.InitializeIteratorHandlers()
+	defer bus.Shutdown()
+	atomic.StoreUint32(hdl.slow, 1)
+	if shedding := waitForShedding(bus, func(share float64) bool { return share > 0 }); !shedding {
+		t.Fatal("Expected the slow query type to be shed.")
+	}
+	for i := 0; i < 20; i++ {
+		res, err := bus.Query(context.Background(), &testShedQuery{})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if res.First() == "fallback" {
+			return
+		}
+	}
+	t.Error("Expected the fallback to serve the queries shed.")
+}
+
+func TestBus_IteratorConsumerTimeout(t *testing.T) {
+	bus := NewBus()
+	errHdl := &storeErrorsHandler{errs: make(map[string]error)}
+	bus.ErrorHandlers(errHdl)
+	bus.IteratorWorkerPoolSize(1)
+	bus.IteratorConsumerTimeout(time.Millisecond * 20)
+	bus.InitializeIteratorHandlers(&testStreamHandler{})
+	defer bus.Shutdown()
+
+	stalled, err := bus.IteratorQuery(context.Background(), &testStreamQuery{count: 1000})
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	values := stalled.Iterate()
+	<-values
+	time.Sleep(time.Millisecond * 60)
+
+	// the stalled consumer no longer occupies the only iterator worker
+	res, err := bus.IteratorQuery(context.Background(), &testStreamQuery{count: 3})
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	count := 0
+	for range res.Iterate() {
+		count++
+	}
+	if count != 3 || res.Err() != nil {
+		t.Errorf("Expected the query to be handled, got %d values (%v).", count, res.Err())
+	}
+
+	count = 1
+	for range values {
+		count++
+	}
+	var stalledErr ErrorConsumerStalled
+	if !errors.As(stalled.Err(), &stalledErr) || stalledErr.Timeout() != time.Millisecond*20 || count >= 1000 {
+		t.Errorf("Expected the consumer to stall, got %d values (%v).", count, stalled.Err())
+	}
+	if !errors.Is(errHdl.Error(&testStreamQuery{}), ConsumerStalledError) {
+		t.Errorf("Expected the stall to be reported, got %v.", errHdl.Error(&testStreamQuery{}))
+	}
+
+	// the consumers may wait for the values indefinitely instead
+	res, err = bus.IteratorQuery(context.Background(), &testStreamQuery{count: 10})
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	res.ConsumerTimeout(0)
+	values = res.Iterate()
+	time.Sleep(time.Millisecond * 60)
+	count = 0
+	for range values {
+		count++
+	}
+	if count != 10 || res.Err() != nil {
+		t.Errorf("Expected every value to be read, got %d values (%v).", count, res.Err())
+	}
+}
+
+func TestBus_CountableHandler(t *testing.T) {
+	if key := string(CountCacheKey("products", "shoes|red")); key != "count|products|shoes%7Cred" {
+		t.Errorf("Unexpected count cache key %s.", key)
+	}
+
+	bus := NewBus()
+	hdl := &testCountableHandler{total: 25, counts: new(uint32)}
+	bus.Handlers(hdl, &testHandler{})
+	defer bus.Shutdown()
+
+	for i := 1; i <= 3; i++ {
+		res, err := bus.Query(context.Background(), newTestPaginatedQuery("products", Page{Number: i, Size: 10}))
+		if err != nil {
+			t.Fatal(err.Error())
+		}
+		if total, known := res.Total(); !known || total != 25 {
+			t.Errorf("Expected the total of page %d to be 25, got %d (%t).", i, total, known)
+		}
+	}
+	// the count is shared by every page
+	if counts := atomic.LoadUint32(hdl.counts); counts != 1 {
+		t.Errorf("Expected the collection to be counted once, got %d.", counts)
+	}
+
+	// the count is invalidated along with the collection
+	hdl.total = 5
+	bus.InvalidateCollection(context.Background(), "products")
+	res, err := bus.Query(context.Background(), newTestPaginatedQuery("products", Page{Number: 2, Size: 10}))
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	if total, _ := res.Total(); total != 5 || atomic.LoadUint32(hdl.counts) != 2 {
+		t.Errorf("Expected the collection to be counted again, got %d.", total)
+	}
+
+	n, err := Count(context.Background(), bus, newTestPaginatedQuery("products", Page{Number: 1, Size: 10}))
+	if err != nil || n != 5 {
+		t.Errorf("Expected a count of 5, got %d (%v).", n, err)
+	}
+	exists, err := Exists(context.Background(), bus, newTestPaginatedQuery("orders", Page{Number: 1, Size: 10}))
+	if err != nil || !exists {
+		t.Errorf("Expected the collection to exist, got %t (%v).", exists, err)
+	}
+	hdl.total = 0
+	exists, err = Exists(context.Background(), bus, newTestPaginatedQuery("empty", Page{Number: 1, Size: 10}))
+	if err != nil || exists {
+		t.Errorf("Expected the collection not to exist, got %t (%v).", exists, err)
+	}
+	n, err = Count(context.Background(), bus, &testQueryStruct{})
+	if err != nil || n != 1 {
+		t.Errorf("Expected the number of values to be counted, got %d (%v).", n, err)
+	}
+
+	// the errors of the counting fail the query
+	hdl.total = -1
+	_, err = bus.Query(context.Background(), newTestPaginatedQuery("failing", Page{Number: 1, Size: 10}))
+	if err == nil || err.Error() != "count failed" {
+		t.Errorf("Expected the query to fail, got %v.", err)
+	}
+
+	// the count is cached as any other result, adapting its duration to its usage
+	bus.AdaptiveTTL((&testPaginatedQuery{}).ID(), AdaptiveTTL{Min: time.Second, Max: time.Minute, Frequent: 3})
+	hdl.total = 25
+	qry := newTestPaginatedQuery("adaptive", Page{Number: 1, Size: 10})
+	if _, err = bus.Query(context.Background(), qry); err != nil {
+		t.Fatal(err.Error())
+	}
+	cqry, _ := countCacheQuery(context.Background(), qry)
+	if cached := bus.cache.Get(context.Background(), cqry); cached == nil || cached.ExpiresAt().Sub(cached.CachedAt()) != time.Second {
+		t.Error("Expected the count to be cached for the adapted duration.")
+	}
+}
+
+func TestBus_Dump(t *testing.T) {
+	bus := NewBus()
+	bus.Handlers(&testRoutedHandler{}, &testSlowHandler{calls: new(uint32)})
+	bus.CircuitBreaker(3, time.Second)
+	bus.QueryTimeout(time.Second)
+	defer bus.Shutdown()
+
+	if _, err := bus.Query(context.Background(), &testCacheQuery{}); err != nil {
+		t.Fatal(err.Error())
+	}
+	d := bus.Dump()
+	if len(d.Queries) != 1 || d.Queries[0].ID != "UUID" || !reflect.DeepEqual(d.Handlers, []string{"query.testSlowHandler"}) {
+		t.Errorf("Unexpected handlers %+v %v.", d.Queries, d.Handlers)
+	}
+	if d.Config.QueryTimeout != time.Second || d.Config.CircuitBreakerThreshold != 3 || d.Config.Clock != "query.systemClock" {
+		t.Errorf("Unexpected configuration %+v.", d.Config)
+	}
+	if d.Stats.Initialized || len(d.InFlight) != 0 || d.Stats.Circuits != nil {
+		t.Errorf("Unexpected stats %+v (%d queries in flight).", d.Stats, len(d.InFlight))
+	}
+	expected := []DumpedCacheTier{{Adapter: "query.MemoryCacheAdapter", TTLScale: 1, Entries: 1}}
+	if !reflect.DeepEqual(d.Cache.Tiers, expected) {
+		t.Errorf("Unexpected cache summary %+v.", d.Cache)
+	}
+
+	// the dumps survive their serialization, to be compared
+	data, err := json.Marshal(d)
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	decoded := &Dump{}
+	if err = json.Unmarshal(data, decoded); err != nil {
+		t.Fatal(err.Error())
+	}
+	if diffs := Diff(d, decoded); len(diffs) != 0 {
+		t.Errorf("Expected the dumps to be equivalent, got %v.", diffs)
+	}
+
+	other := NewBus()
+	other.Handlers(&testRoutedHandler{}, &testHandler{})
+	other.CircuitBreaker(3, time.Second)
+	defer other.Shutdown()
+	expectedDiffs := []string{
+		"config.queryTimeout: 1s != 0s",
+		"handlers: [query.testSlowHandler] != [query.testHandler]",
+	}
+	if diffs := Diff(decoded, other.Dump()); !reflect.DeepEqual(diffs, expectedDiffs) {
+		t.Errorf("Unexpected differences %q.", diffs)
 	}
 }
@@ -9,3 +9,9 @@ type CacheAdapter interface {
 	Expire(ctx context.Context, qry Cacheable)
 	Shutdown()
 }
+
+// ClockSetter may optionally be implemented by the cache adapters to be provided the clock of the bus (see Bus.Clock),
+// so the results are expired according to the time of the bus.
+type ClockSetter interface {
+	SetClock(clock Clock)
+}
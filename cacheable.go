@@ -7,3 +7,43 @@ type Cacheable interface {
 	CacheKey() []byte
 	CacheDuration() time.Duration
 }
+
+//------Internal------//
+
+// cacheableOverride overrides the cache key and/or the cache duration of a cacheable query, keeping its tags.
+// The bus uses it to cache a query differently than the query itself does (e.g. per tier, for its iterator values or its count).
+type cacheableOverride struct {
+	Cacheable
+	key      []byte
+	duration time.Duration
+}
+
+// withCacheKey overrides the cache key of the query.
+func withCacheKey(qry Cacheable, key []byte) cacheableOverride {
+	return cacheableOverride{Cacheable: qry, key: key, duration: qry.CacheDuration()}
+}
+
+// withCacheDuration overrides the cache duration of the query.
+func withCacheDuration(qry Cacheable, d time.Duration) cacheableOverride {
+	return cacheableOverride{Cacheable: qry, key: qry.CacheKey(), duration: d}
+}
+
+func (qry cacheableOverride) CacheKey() []byte {
+	return qry.key
+}
+
+func (qry cacheableOverride) CacheDuration() time.Duration {
+	return qry.duration
+}
+
+// Unwrap returns the wrapped cacheable query.
+func (qry cacheableOverride) Unwrap() Cacheable {
+	return qry.Cacheable
+}
+
+func (qry cacheableOverride) CacheTags() [][]byte {
+	if tgb, implements := qry.Cacheable.(Taggable); implements {
+		return tgb.CacheTags()
+	}
+	return nil
+}
@@ -0,0 +1,340 @@
+// Package cachetest provides the conformance suite of the query cache adapters.
+// Cache adapter authors can use it to verify their adapters behave as the bus expects.
+package cachetest
+
+import (
+	"context"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/io-da/query"
+)
+
+// Suite describes the cache adapter under test.
+type Suite struct {
+	// New returns a new (empty) cache adapter. It is called once per test.
+	New func(t *testing.T) query.CacheAdapter
+	// Advance moves the clock of the cache forward, for the cached results to expire.
+	// It defaults to time.Sleep (e.g. use the FastForward function of miniredis instead).
+	Advance func(d time.Duration)
+}
+
+// RunConformance runs the conformance suite against the cache adapters returned by the factory.
+// It is equivalent to Run with the default Suite options.
+func RunConformance(t *testing.T, factory func(t *testing.T) query.CacheAdapter) {
+	Run(t, Suite{New: factory})
+}
+
+// Run the conformance suite against the cache adapter.
+// The result values used are strings, so adapters serializing the results using any codec can be verified.
+// The tags are only verified if the adapter implements the query.TagExpirer interface.
+func Run(t *testing.T, s Suite) {
+	if s.Advance == nil {
+		s.Advance = time.Sleep
+	}
+	t.Run("SetGet", s.testSetGet)
+	t.Run("Miss", s.testMiss)
+	t.Run("Keys", s.testKeys)
+	t.Run("Expire", s.testExpire)
+	t.Run("Duration", s.testDuration)
+	t.Run("Metadata", s.testMetadata)
+	t.Run("Tags", s.testTags)
+	t.Run("Concurrency", s.testConcurrency)
+	t.Run("LargeValues", s.testLargeValues)
+	t.Run("Shutdown", s.testShutdown)
+}
+
+//------Internal------//
+
+const (
+	ttl             = time.Millisecond * 100
+	largeKey        = "large"
+	largeValueSize  = 1 << 20
+	largeValueCount = 10000
+)
+
+type conformanceQuery struct {
+	key      string
+	duration time.Duration
+	tags     []string
+}
+
+func (*conformanceQuery) ID() []byte {
+	return []byte("CACHETEST")
+}
+
+func (qry *conformanceQuery) CacheKey() []byte {
+	return []byte("cachetest:" + qry.key)
+}
+
+func (qry *conformanceQuery) CacheDuration() time.Duration {
+	if qry.duration == 0 {
+		return time.Minute
+	}
+	return qry.duration
+}
+
+func (qry *conformanceQuery) CacheTags() [][]byte {
+	tags := make([][]byte, len(qry.tags))
+	for i, tag := range qry.tags {
+		tags[i] = []byte(tag)
+	}
+	return tags
+}
+
+type conformanceHandler struct {
+}
+
+func (hdl *conformanceHandler) Handle(ctx context.Context, qry query.Query, res *query.Result) error {
+	cqry := qry.(*conformanceQuery)
+	if cqry.key == largeKey {
+		res.Add(strings.Repeat("x", largeValueSize))
+		for i := 0; i < largeValueCount; i++ {
+			res.Add(strconv.Itoa(i))
+		}
+		return nil
+	}
+	res.Add(cqry.key)
+	res.Add("bar")
+	res.SetTotal(2)
+	res.SetCursor("next:" + cqry.key)
+	res.Meta("key", cqry.key)
+	return nil
+}
+
+// setup returns a bus using only the adapter under test.
+func (s Suite) setup(t *testing.T) (*query.Bus, query.CacheAdapter) {
+	adp := s.New(t)
+	bus := query.NewBus()
+	bus.Handlers(&conformanceHandler{})
+	bus.CacheAdapters(adp)
+	t.Cleanup(bus.Shutdown)
+	return bus, adp
+}
+
+// cache handles the query, for its result to be cached.
+func (s Suite) cache(t *testing.T, bus *query.Bus, qry *conformanceQuery) *query.Result {
+	t.Helper()
+	res, err := bus.Query(context.Background(), qry)
+	if err != nil {
+		t.Fatalf("Unexpected error: %s.", err.Error())
+	}
+	if res.CachedAt().IsZero() {
+		t.Fatal("Expected the adapter to cache the result (Set returning true).")
+	}
+	return res
+}
+
+func (s Suite) testSetGet(t *testing.T) {
+	bus, adp := s.setup(t)
+	qry := &conformanceQuery{key: "foo"}
+	fresh := s.cache(t, bus, qry)
+
+	res := adp.Get(context.Background(), qry)
+	if res == nil {
+		t.Fatal("Expected the cached result.")
+	}
+	vals := res.All()
+	if len(vals) != 2 || vals[0] != "foo" || vals[1] != "bar" {
+		t.Errorf("Unexpected cached values %v.", vals)
+	}
+	if string(res.CacheKey()) != "cachetest:foo" {
+		t.Errorf("Unexpected cache key %q.", res.CacheKey())
+	}
+	if !res.CachedAt().Equal(fresh.CachedAt()) || !res.ExpiresAt().Equal(fresh.ExpiresAt()) {
+		t.Error("Expected the caching moments to be kept.")
+	}
+
+	res, err := bus.Query(context.Background(), qry)
+	if err != nil {
+		t.Fatalf("Unexpected error: %s.", err.Error())
+	}
+	if !res.IsCached() || res.First() != "foo" {
+		t.Error("Expected the bus to use the cached result.")
+	}
+}
+
+func (s Suite) testMiss(t *testing.T) {
+	_, adp := s.setup(t)
+	if adp.Get(context.Background(), &conformanceQuery{key: "missing"}) != nil {
+		t.Error("Expected a cache miss.")
+	}
+}
+
+func (s Suite) testKeys(t *testing.T) {
+	bus, adp := s.setup(t)
+	s.cache(t, bus, &conformanceQuery{key: "foo"})
+	s.cache(t, bus, &conformanceQuery{key: "baz"})
+	for _, key := range []string{"foo", "baz"} {
+		res := adp.Get(context.Background(), &conformanceQuery{key: key})
+		if res == nil || res.First() != key {
+			t.Errorf("Expected the result cached with the key %q.", key)
+		}
+	}
+}
+
+func (s Suite) testExpire(t *testing.T) {
+	bus, adp := s.setup(t)
+	foo := &conformanceQuery{key: "foo"}
+	baz := &conformanceQuery{key: "baz"}
+	s.cache(t, bus, foo)
+	s.cache(t, bus, baz)
+
+	adp.Expire(context.Background(), foo)
+	if adp.Get(context.Background(), foo) != nil {
+		t.Error("Expected the result to be expired.")
+	}
+	if adp.Get(context.Background(), baz) == nil {
+		t.Error("Expected the other results to remain cached.")
+	}
+	// expiring twice must be harmless
+	adp.Expire(context.Background(), foo)
+}
+
+func (s Suite) testDuration(t *testing.T) {
+	bus, adp := s.setup(t)
+	qry := &conformanceQuery{key: "foo", duration: ttl}
+	s.cache(t, bus, qry)
+	if adp.Get(context.Background(), qry) == nil {
+		t.Fatal("Expected the cached result.")
+	}
+
+	s.Advance(ttl)
+	// the adapters may expire the results asynchronously
+	deadline := time.Now().Add(time.Second)
+	for adp.Get(context.Background(), qry) != nil {
+		if time.Now().After(deadline) {
+			t.Fatal("Expected the result to expire after its duration.")
+		}
+		time.Sleep(time.Millisecond * 10)
+	}
+}
+
+func (s Suite) testMetadata(t *testing.T) {
+	bus, adp := s.setup(t)
+	qry := &conformanceQuery{key: "foo"}
+	s.cache(t, bus, qry)
+
+	res := adp.Get(context.Background(), qry)
+	if res == nil {
+		t.Fatal("Expected the cached result.")
+	}
+	if total, known := res.Total(); !known || total != 2 {
+		t.Error("Expected the total to be cached.")
+	}
+	if res.Cursor() != "next:foo" {
+		t.Error("Expected the cursor to be cached.")
+	}
+	if key, found := res.MetaValue("key"); !found || key != "foo" {
+		t.Error("Expected the metadata to be cached.")
+	}
+}
+
+func (s Suite) testTags(t *testing.T) {
+	bus, adp := s.setup(t)
+	exp, implements := adp.(query.TagExpirer)
+	if !implements {
+		t.Skip("The adapter does not implement query.TagExpirer.")
+	}
+	foo := &conformanceQuery{key: "foo", tags: []string{"products", "shoes"}}
+	baz := &conformanceQuery{key: "baz", tags: []string{"products"}}
+	qux := &conformanceQuery{key: "qux", tags: []string{"orders"}}
+	s.cache(t, bus, foo)
+	s.cache(t, bus, baz)
+	s.cache(t, bus, qux)
+
+	exp.ExpireTags(context.Background(), []byte("shoes"))
+	if adp.Get(context.Background(), foo) != nil {
+		t.Error("Expected the tagged result to be expired.")
+	}
+	if adp.Get(context.Background(), baz) == nil {
+		t.Error("Expected the results of other tags to remain cached.")
+	}
+
+	exp.ExpireTags(context.Background(), []byte("products"), []byte("orders"))
+	if adp.Get(context.Background(), baz) != nil || adp.Get(context.Background(), qux) != nil {
+		t.Error("Expected the results of every tag to be expired.")
+	}
+	// unknown tags must be harmless
+	exp.ExpireTags(context.Background(), []byte("unknown"))
+}
+
+func (s Suite) testConcurrency(t *testing.T) {
+	bus, adp := s.setup(t)
+	qrys := []*conformanceQuery{{key: "foo"}, {key: "baz"}, {key: "qux"}}
+	results := make([]*query.Result, len(qrys))
+	for i, qry := range qrys {
+		results[i] = s.cache(t, bus, qry)
+	}
+
+	wg := sync.WaitGroup{}
+	for w := 0; w < 8; w++ {
+		wg.Add(1)
+		go func(w int) {
+			defer wg.Done()
+			for i := 0; i < 50; i++ {
+				n := (w + i) % len(qrys)
+				switch i % 3 {
+				case 0:
+					adp.Set(context.Background(), qrys[n], results[n])
+				case 1:
+					if res := adp.Get(context.Background(), qrys[n]); res != nil && res.First() != qrys[n].key {
+						t.Errorf("Unexpected cached value %v for the key %q.", res.First(), qrys[n].key)
+					}
+				default:
+					adp.Expire(context.Background(), qrys[n])
+				}
+			}
+		}(w)
+	}
+	wg.Wait()
+
+	// the adapter remains consistent
+	for i, qry := range qrys {
+		adp.Set(context.Background(), qry, results[i])
+		if res := adp.Get(context.Background(), qry); res == nil || res.First() != qry.key {
+			t.Errorf("Expected the result cached with the key %q.", qry.key)
+		}
+	}
+}
+
+func (s Suite) testLargeValues(t *testing.T) {
+	bus, adp := s.setup(t)
+	qry := &conformanceQuery{key: largeKey}
+	s.cache(t, bus, qry)
+
+	res := adp.Get(context.Background(), qry)
+	if res == nil {
+		t.Fatal("Expected the cached result.")
+	}
+	vals := res.All()
+	if len(vals) != largeValueCount+1 {
+		t.Fatalf("Expected %d cached values, got %d.", largeValueCount+1, len(vals))
+	}
+	if val, _ := vals[0].(string); len(val) != largeValueSize {
+		t.Error("Expected the large value to be cached.")
+	}
+	if vals[largeValueCount] != strconv.Itoa(largeValueCount-1) {
+		t.Error("Expected the values to keep their order.")
+	}
+}
+
+// testShutdown verifies the adapters tolerate being shut down more than once (e.g. by Bus.CacheAdapters and Bus.Shutdown),
+// and being used afterwards. The results of the operations after the shutdown are unspecified.
+func (s Suite) testShutdown(t *testing.T) {
+	bus, adp := s.setup(t)
+	qry := &conformanceQuery{key: "foo"}
+	res := s.cache(t, bus, qry)
+
+	adp.Shutdown()
+	adp.Shutdown()
+	adp.Set(context.Background(), qry, res)
+	adp.Get(context.Background(), qry)
+	adp.Expire(context.Background(), qry)
+	if exp, implements := adp.(query.TagExpirer); implements {
+		exp.ExpireTags(context.Background(), []byte("products"))
+	}
+}
@@ -0,0 +1,25 @@
+package cachetest
+
+import (
+	"testing"
+
+	"github.com/io-da/query"
+)
+
+func TestRun_MemoryCacheAdapter(t *testing.T) {
+	RunConformance(t, func(t *testing.T) query.CacheAdapter {
+		return query.NewMemoryCacheAdapter()
+	})
+}
+
+func TestRun_TieredCacheAdapter(t *testing.T) {
+	RunConformance(t, func(t *testing.T) query.CacheAdapter {
+		return query.NewTieredCacheAdapter(query.NewMemoryCacheAdapter(), query.NewMemoryCacheAdapter())
+	})
+}
+
+func TestRun_ReplicatedCacheAdapter(t *testing.T) {
+	RunConformance(t, func(t *testing.T) query.CacheAdapter {
+		return query.NewReplicatedCacheAdapter(query.NewMemoryCacheAdapter(), 100, query.ReplicaAdapter(query.NewMemoryCacheAdapter()))
+	})
+}
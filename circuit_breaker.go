@@ -0,0 +1,295 @@
+package query
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"time"
+)
+
+// CircuitState is the state of the circuit of a query type.
+type CircuitState int
+
+const (
+	// CircuitClosed means the queries are handled normally.
+	CircuitClosed CircuitState = iota
+	// CircuitOpen means the queries fail fast with ErrorCircuitOpen, without being handled.
+	CircuitOpen
+	// CircuitHalfOpen means a limited number of queries are handled to probe the recovery of the handlers.
+	CircuitHalfOpen
+)
+
+// CircuitBreaker may optionally be enabled to stop handling the queries of a type after consecutive failures.
+// The circuit of a query type (identified by its ID) opens once the failure threshold is reached, failing the queries fast with ErrorCircuitOpen.
+// After the open duration the circuit becomes half-open, allowing probe queries to be handled. A successful probe closes the circuit again.
+// Only handler errors and exceeded deadlines are considered failures. The queries cancelled by their caller, or without any handler,
+// decide nothing: a cancelled probe frees its slot, the circuit remaining half-open. Cached results are still provided while the circuit is open.
+// The state changes are provided to the observers (CircuitOpened, CircuitHalfOpened and CircuitRecovered events).
+// A threshold lesser or equal to zero disables the circuit breaker. It is disabled by default.
+// It should be used *before* any query is performed.
+func (bus *Bus) CircuitBreaker(threshold int, openDuration time.Duration) {
+	if threshold <= 0 {
+		bus.breaker = nil
+		return
+	}
+	bus.breaker = &circuitBreaker{
+		threshold:    threshold,
+		openDuration: openDuration,
+		probes:       1,
+		circuits:     make(map[string]*circuit),
+	}
+}
+
+// CircuitBreakerProbes may optionally be provided to tweak the number of concurrent probe queries allowed while a circuit is half-open.
+// It defaults to 1.
+func (bus *Bus) CircuitBreakerProbes(probes int) {
+	if bus.breaker != nil && probes > 0 {
+		bus.breaker.probes = probes
+	}
+}
+
+// CircuitBreakerPerHandler may optionally be enabled to key the circuits by handler (identified by its type, see TypeName) instead of by query type.
+// The circuit of a handler then guards every invocation of the handler, whatever the query, so a handler whose dependencies are down
+// fails fast for every query type it handles, while the other handlers of these queries remain unaffected.
+// The queries reaching a handler whose circuit is open fail with ErrorCircuitOpen (providing the handler).
+// It should be used after enabling the circuit breaker, *before* any query is performed.
+func (bus *Bus) CircuitBreakerPerHandler(enabled bool) {
+	if bus.breaker != nil {
+		bus.breaker.perHandler = enabled
+	}
+}
+
+// CircuitState returns the state of the circuit of the given query type.
+func (bus *Bus) CircuitState(qry Query) CircuitState {
+	if bus.breaker == nil {
+		return CircuitClosed
+	}
+	return bus.breaker.circuit(string(qry.ID())).currentState()
+}
+
+// HandlerCircuitState returns the state of the circuit of the given handler (see CircuitBreakerPerHandler).
+func (bus *Bus) HandlerCircuitState(hdl interface{}) CircuitState {
+	if bus.breaker == nil {
+		return CircuitClosed
+	}
+	return bus.breaker.circuit(handlerCircuitKey(hdl)).currentState()
+}
+
+//------Internal------//
+
+type circuitBreaker struct {
+	sync.Mutex
+	threshold    int
+	openDuration time.Duration
+	probes       int
+	perHandler   bool
+	circuits     map[string]*circuit
+}
+
+func (cb *circuitBreaker) circuit(key string) *circuit {
+	cb.Lock()
+	defer cb.Unlock()
+	c, exists := cb.circuits[key]
+	if !exists {
+		c = &circuit{}
+		cb.circuits[key] = c
+	}
+	return c
+}
+
+// circuitOutcome is the outcome of a handling, as accounted for by the circuits.
+type circuitOutcome int
+
+const (
+	circuitSucceeded circuitOutcome = iota
+	circuitFailed
+	// circuitInconclusive is the outcome of the handlings telling nothing about the health of the handlers
+	// (e.g. cancelled by the caller, or without any handler).
+	circuitInconclusive
+)
+
+type circuit struct {
+	sync.Mutex
+	state    CircuitState
+	failures int
+	openedAt time.Time
+	probing  int
+	// round identifies the current half-open period, for the probes admitted during a previous one to be told apart.
+	round uint64
+}
+
+func (c *circuit) currentState() CircuitState {
+	c.Lock()
+	defer c.Unlock()
+	return c.state
+}
+
+// enter verifies if the query may be handled at the given moment, returning the state change if any.
+// The queries admitted while the circuit is half-open are probes, provided the half-open round they belong to (0 for the other queries).
+func (c *circuit) enter(cb *circuitBreaker, now time.Time) (bool, uint64, CircuitState, bool) {
+	c.Lock()
+	defer c.Unlock()
+	changed := false
+	if c.state == CircuitOpen {
+		if now.Sub(c.openedAt) < cb.openDuration {
+			return false, 0, c.state, false
+		}
+		c.state = CircuitHalfOpen
+		c.round++
+		changed = true
+	}
+	if c.state == CircuitHalfOpen {
+		if c.probing >= cb.probes {
+			return false, 0, c.state, changed
+		}
+		c.probing++
+		return true, c.round, c.state, changed
+	}
+	return true, 0, c.state, changed
+}
+
+// exit accounts for the outcome of a query handled until the given moment, returning the state change if any.
+// While the circuit is half-open, only the outcome of the probes of the current round decides whether it closes or opens again.
+// The inconclusive outcomes (e.g. the query being cancelled) decide nothing, the probe slot being freed instead (see abandon).
+func (c *circuit) exit(cb *circuitBreaker, now time.Time, probe uint64, outcome circuitOutcome) (CircuitState, bool) {
+	if outcome == circuitInconclusive {
+		c.abandon(probe)
+		return c.currentState(), false
+	}
+	c.Lock()
+	defer c.Unlock()
+	switch c.state {
+	case CircuitHalfOpen:
+		if probe == 0 || probe != c.round {
+			return c.state, false
+		}
+		c.probing--
+		if outcome == circuitFailed {
+			c.open(now)
+			return c.state, true
+		}
+		c.state = CircuitClosed
+		c.failures = 0
+		c.probing = 0
+		return c.state, true
+	case CircuitClosed:
+		if outcome == circuitSucceeded {
+			c.failures = 0
+			return c.state, false
+		}
+		c.failures++
+		if c.failures >= cb.threshold {
+			c.open(now)
+			return c.state, true
+		}
+	}
+	return c.state, false
+}
+
+// abandon frees the probe slot of a query admitted but not handled, without deciding the state of the circuit.
+func (c *circuit) abandon(probe uint64) {
+	c.Lock()
+	defer c.Unlock()
+	if c.state == CircuitHalfOpen && probe != 0 && probe == c.round {
+		c.probing--
+	}
+}
+
+func (c *circuit) open(now time.Time) {
+	c.state = CircuitOpen
+	c.openedAt = now
+	c.failures = 0
+	c.probing = 0
+}
+
+// enterCircuit verifies if the circuit of the query allows it to be handled, returning its probe round (see circuit.enter).
+// The circuits keyed by handler are verified by each invocation instead (see enterHandlerCircuit).
+func (bus *Bus) enterCircuit(ctx context.Context, qry Query) (uint64, error) {
+	if bus.breaker == nil || bus.breaker.perHandler {
+		return 0, nil
+	}
+	allowed, probe, state, changed := bus.breaker.circuit(string(qry.ID())).enter(bus.breaker, bus.clock.Now())
+	if changed {
+		bus.observeCircuit(ctx, qry, nil, state)
+	}
+	if !allowed {
+		return 0, NewErrorCircuitOpen(qry)
+	}
+	return probe, nil
+}
+
+// exitCircuit accounts for the outcome of the handling of the query.
+func (bus *Bus) exitCircuit(ctx context.Context, qry Query, probe uint64, err error) {
+	if bus.breaker == nil || bus.breaker.perHandler {
+		return
+	}
+	if state, changed := bus.breaker.circuit(string(qry.ID())).exit(bus.breaker, bus.clock.Now(), probe, circuitOutcomeOf(err)); changed {
+		bus.observeCircuit(ctx, qry, nil, state)
+	}
+}
+
+// abandonCircuit frees the probe slot of a query admitted by its circuit but not handled (e.g. cancelled while waiting to be serialized).
+func (bus *Bus) abandonCircuit(qry Query, probe uint64) {
+	if probe != 0 {
+		bus.breaker.circuit(string(qry.ID())).abandon(probe)
+	}
+}
+
+// enterHandlerCircuit verifies if the circuit of the handler allows it to handle the query, returning its probe round (see circuit.enter).
+func (bus *Bus) enterHandlerCircuit(ctx context.Context, qry Query, hdl interface{}) (uint64, error) {
+	if bus.breaker == nil || !bus.breaker.perHandler {
+		return 0, nil
+	}
+	allowed, probe, state, changed := bus.breaker.circuit(handlerCircuitKey(hdl)).enter(bus.breaker, bus.clock.Now())
+	if changed {
+		bus.observeCircuit(ctx, qry, hdl, state)
+	}
+	if !allowed {
+		return 0, ErrorCircuitOpen{query: qry, handler: hdl}
+	}
+	return probe, nil
+}
+
+// exitHandlerCircuit accounts for the outcome of the handling of the query by the handler.
+func (bus *Bus) exitHandlerCircuit(ctx context.Context, qry Query, hdl interface{}, probe uint64, err error) {
+	if bus.breaker == nil || !bus.breaker.perHandler {
+		return
+	}
+	if state, changed := bus.breaker.circuit(handlerCircuitKey(hdl)).exit(bus.breaker, bus.clock.Now(), probe, circuitOutcomeOf(err)); changed {
+		bus.observeCircuit(ctx, qry, hdl, state)
+	}
+}
+
+// abandonHandlerCircuit frees the probe slot of a handler that did not handle the query (e.g. rejected by its concurrency limit).
+func (bus *Bus) abandonHandlerCircuit(hdl interface{}, probe uint64) {
+	if probe != 0 {
+		bus.breaker.circuit(handlerCircuitKey(hdl)).abandon(probe)
+	}
+}
+
+// circuitOutcomeOf returns the outcome of a handling from its error.
+// The handlings cancelled by their caller or without any handler are inconclusive.
+func circuitOutcomeOf(err error) circuitOutcome {
+	if err == nil {
+		return circuitSucceeded
+	}
+	if _, unhandled := err.(ErrorNoQueryHandlersFound); unhandled || errors.Is(err, context.Canceled) {
+		return circuitInconclusive
+	}
+	return circuitFailed
+}
+
+func handlerCircuitKey(hdl interface{}) string {
+	return "handler:" + TypeName(hdl)
+}
+
+func (bus *Bus) observeCircuit(ctx context.Context, qry Query, hdl interface{}, state CircuitState) {
+	switch state {
+	case CircuitOpen:
+		bus.observe(ctx, Event{Type: CircuitOpened, Query: qry, Handler: hdl})
+	case CircuitHalfOpen:
+		bus.observe(ctx, Event{Type: CircuitHalfOpened, Query: qry, Handler: hdl})
+	case CircuitClosed:
+		bus.observe(ctx, Event{Type: CircuitRecovered, Query: qry, Handler: hdl})
+	}
+}
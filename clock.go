@@ -0,0 +1,71 @@
+package query
+
+import (
+	"context"
+	"time"
+)
+
+// Clock must be implemented for a type to qualify as a clock.
+// The bus uses its clock for every time related behavior (cache durations, timeouts, circuit breaker, cold start window,
+// autoscaling, handler durations), so the time can be simulated in tests (see querytest.Clock).
+type Clock interface {
+	Now() time.Time
+	NewTimer(d time.Duration) Timer
+}
+
+// Timer must be implemented for a type to qualify as a timer of a Clock. It behaves as a *time.Timer.
+type Timer interface {
+	C() <-chan time.Time
+	Stop() bool
+	Reset(d time.Duration) bool
+}
+
+// SystemClock is the Clock using the system time.
+var SystemClock Clock = systemClock{}
+
+// Clock may optionally be provided to replace the time used by the bus (e.g. with a fake clock in tests).
+// The clock is also provided to the cache adapters through the context of their operations (see ClockFrom),
+// and to the cache adapters implementing the ClockSetter interface.
+// It should be provided *before* the bus is initialized.
+// It defaults to the SystemClock.
+func (bus *Bus) Clock(clock Clock) {
+	if clock == nil {
+		clock = SystemClock
+	}
+	bus.clock = clock
+	bus.cache.SetClock(clock)
+}
+
+// ClockFrom returns the Clock of the bus providing the context, or the SystemClock if none was provided.
+// It is intended for cache adapters, so they share the time of the bus.
+func ClockFrom(ctx context.Context) Clock {
+	if owner, ok := ownerFrom(ctx); ok {
+		return owner.clock
+	}
+	return SystemClock
+}
+
+//------Internal------//
+
+type systemClock struct{}
+
+func (systemClock) Now() time.Time {
+	return time.Now()
+}
+
+func (systemClock) NewTimer(d time.Duration) Timer {
+	return systemTimer{time.NewTimer(d)}
+}
+
+type systemTimer struct {
+	*time.Timer
+}
+
+func (t systemTimer) C() <-chan time.Time {
+	return t.Timer.C
+}
+
+// since returns the time elapsed since the given moment, according to the clock of the bus.
+func (bus *Bus) since(t time.Time) time.Duration {
+	return bus.clock.Now().Sub(t)
+}
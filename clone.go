@@ -0,0 +1,164 @@
+package query
+
+import (
+	"reflect"
+)
+
+// Cloner must be implemented for a type to qualify as a result cloner.
+// Cloners are used to return copies of the cached results, so callers mutating their results do not corrupt the cache for everyone else.
+type Cloner interface {
+	Clone(v interface{}) interface{}
+}
+
+// Cloneable can be implemented by result values to provide their own copies.
+// Both the ShallowCloner and the DeepCloner use it as a fast path, skipping reflection.
+type Cloneable interface {
+	Clone() interface{}
+}
+
+// ShallowCloner is a Cloner copying the top level of the values.
+// Slices and maps are copied into new ones and pointers to structs into new structs, while their contents remain shared.
+type ShallowCloner struct{}
+
+// Clone returns a shallow copy of the value.
+func (ShallowCloner) Clone(v interface{}) interface{} {
+	if cln, implements := v.(Cloneable); implements {
+		return cln.Clone()
+	}
+	val := reflect.ValueOf(v)
+	switch val.Kind() {
+	case reflect.Slice:
+		if val.IsNil() {
+			return v
+		}
+		cp := reflect.MakeSlice(val.Type(), val.Len(), val.Len())
+		reflect.Copy(cp, val)
+		return cp.Interface()
+	case reflect.Map:
+		if val.IsNil() {
+			return v
+		}
+		cp := reflect.MakeMapWithSize(val.Type(), val.Len())
+		iter := val.MapRange()
+		for iter.Next() {
+			cp.SetMapIndex(iter.Key(), iter.Value())
+		}
+		return cp.Interface()
+	case reflect.Ptr:
+		if val.IsNil() || val.Elem().Kind() != reflect.Struct {
+			return v
+		}
+		cp := reflect.New(val.Elem().Type())
+		cp.Elem().Set(val.Elem())
+		return cp.Interface()
+	}
+	return v
+}
+
+// DeepCloner is a Cloner recursively copying the values.
+// Unexported struct fields cannot be copied through reflection and remain shared, types holding them should implement Cloneable.
+type DeepCloner struct{}
+
+// Clone returns a deep copy of the value.
+func (DeepCloner) Clone(v interface{}) interface{} {
+	if v == nil {
+		return nil
+	}
+	return deepClone(reflect.ValueOf(v), make(map[uintptr]reflect.Value)).Interface()
+}
+
+// ResultCloner sets the Cloner used to copy the cached results returned to the callers.
+// By default, the cached results are shared between the callers and must not be mutated.
+func (bus *Bus) ResultCloner(cln Cloner) {
+	bus.cloner = cln
+}
+
+//------Internal------//
+
+var cloneableType = reflect.TypeOf((*Cloneable)(nil)).Elem()
+
+func deepClone(val reflect.Value, visited map[uintptr]reflect.Value) reflect.Value {
+	if val.Type().Implements(cloneableType) && (val.Kind() != reflect.Ptr || !val.IsNil()) {
+		if cp := reflect.ValueOf(val.Interface().(Cloneable).Clone()); cp.IsValid() && cp.Type().AssignableTo(val.Type()) {
+			return cp
+		}
+	}
+	switch val.Kind() {
+	case reflect.Ptr:
+		if val.IsNil() {
+			return val
+		}
+		if cp, ok := visited[val.Pointer()]; ok {
+			return cp
+		}
+		cp := reflect.New(val.Elem().Type())
+		visited[val.Pointer()] = cp
+		cp.Elem().Set(deepClone(val.Elem(), visited))
+		return cp
+	case reflect.Interface:
+		if val.IsNil() {
+			return val
+		}
+		cp := reflect.New(val.Type()).Elem()
+		cp.Set(deepClone(val.Elem(), visited))
+		return cp
+	case reflect.Slice:
+		if val.IsNil() {
+			return val
+		}
+		cp := reflect.MakeSlice(val.Type(), val.Len(), val.Len())
+		for i := 0; i < val.Len(); i++ {
+			cp.Index(i).Set(deepClone(val.Index(i), visited))
+		}
+		return cp
+	case reflect.Array:
+		cp := reflect.New(val.Type()).Elem()
+		for i := 0; i < val.Len(); i++ {
+			cp.Index(i).Set(deepClone(val.Index(i), visited))
+		}
+		return cp
+	case reflect.Map:
+		if val.IsNil() {
+			return val
+		}
+		cp := reflect.MakeMapWithSize(val.Type(), val.Len())
+		iter := val.MapRange()
+		for iter.Next() {
+			cp.SetMapIndex(deepClone(iter.Key(), visited), deepClone(iter.Value(), visited))
+		}
+		return cp
+	case reflect.Struct:
+		cp := reflect.New(val.Type()).Elem()
+		cp.Set(val)
+		for i := 0; i < val.NumField(); i++ {
+			if cp.Field(i).CanSet() {
+				cp.Field(i).Set(deepClone(val.Field(i), visited))
+			}
+		}
+		return cp
+	}
+	return val
+}
+
+// clone copies the result for the caller, if a Cloner is set.
+func (bus *Bus) clone(res *Result) *Result {
+	if bus.cloner == nil || res == nil {
+		return res
+	}
+	cp := res.copy()
+	for i, v := range cp.data {
+		cp.data[i] = bus.cloner.Clone(v)
+	}
+	for k, v := range cp.meta {
+		cp.meta[k] = bus.cloner.Clone(v)
+	}
+	return cp
+}
+
+// share copies the result of a shared handling for a caller waiting for it, cloning its values if a Cloner is set.
+func (bus *Bus) share(res *Result) *Result {
+	if bus.cloner == nil && res != nil {
+		return res.copy()
+	}
+	return bus.clone(res)
+}
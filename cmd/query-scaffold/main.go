@@ -0,0 +1,127 @@
+// Command query-scaffold generates a runnable example service wiring a query bus with its handlers, a cache adapter,
+// the HTTP gateway, the admin API and metrics, as a starting point following the intended architecture.
+//
+// Usage:
+//
+//	query-scaffold [-module path] [-replace dir] [-force] dir
+package main
+
+import (
+	"bytes"
+	"embed"
+	"errors"
+	"flag"
+	"fmt"
+	"go/format"
+	"io/fs"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"text/template"
+)
+
+//go:embed templates
+var templates embed.FS
+
+// Options configures the generated service.
+type Options struct {
+	// Module is the module path of the service, defaulting to the name of its directory.
+	Module string
+	// Replace may optionally be provided to replace the query module with a local directory (e.g. a fork).
+	Replace string
+	// Force overwrites the existing files.
+	Force bool
+}
+
+func main() {
+	opts := Options{}
+	flag.StringVar(&opts.Module, "module", "", "module path of the service (defaults to the name of the directory)")
+	flag.StringVar(&opts.Replace, "replace", "", "local directory replacing the github.com/io-da/query module")
+	flag.BoolVar(&opts.Force, "force", false, "overwrite the existing files")
+	flag.Parse()
+
+	if flag.NArg() != 1 {
+		fmt.Fprintln(os.Stderr, "usage: query-scaffold [-module path] [-replace dir] [-force] dir")
+		os.Exit(2)
+	}
+	dir := flag.Arg(0)
+	files, err := Generate(dir, opts)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "query-scaffold:", err)
+		os.Exit(1)
+	}
+	for _, file := range files {
+		fmt.Println("created", file)
+	}
+	fmt.Printf("\nNext steps:\n\tcd %s\n\tgo mod tidy\n\tgo test ./...\n\tgo run .\n", dir)
+}
+
+// Generate writes the files of the service into the directory, returning their paths.
+// It fails without writing anything if any of the files exists, unless forced.
+func Generate(dir string, opts Options) ([]string, error) {
+	if opts.Module == "" {
+		abs, err := filepath.Abs(dir)
+		if err != nil {
+			return nil, err
+		}
+		opts.Module = filepath.Base(abs)
+	}
+	if opts.Replace != "" {
+		abs, err := filepath.Abs(opts.Replace)
+		if err != nil {
+			return nil, err
+		}
+		opts.Replace = filepath.ToSlash(abs)
+	}
+
+	names, err := fs.Glob(templates, "templates/*.tmpl")
+	if err != nil {
+		return nil, err
+	}
+	contents := make(map[string][]byte, len(names))
+	for _, name := range names {
+		file := strings.TrimSuffix(path.Base(name), ".tmpl")
+		data, err := render(name, opts)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", file, err)
+		}
+		contents[file] = data
+		if _, err = os.Stat(filepath.Join(dir, file)); err == nil && !opts.Force {
+			return nil, fmt.Errorf("%s already exists (use -force to overwrite it)", filepath.Join(dir, file))
+		} else if err != nil && !errors.Is(err, fs.ErrNotExist) {
+			return nil, err
+		}
+	}
+
+	if err = os.MkdirAll(dir, 0o755); err != nil {
+		return nil, err
+	}
+	files := make([]string, 0, len(names))
+	for _, name := range names {
+		file := filepath.Join(dir, strings.TrimSuffix(path.Base(name), ".tmpl"))
+		if err = os.WriteFile(file, contents[filepath.Base(file)], 0o644); err != nil {
+			return files, err
+		}
+		files = append(files, file)
+	}
+	return files, nil
+}
+
+//------Internal------//
+
+// render the template, formatting the generated Go files.
+func render(name string, opts Options) ([]byte, error) {
+	tmpl, err := template.ParseFS(templates, name)
+	if err != nil {
+		return nil, err
+	}
+	buf := &bytes.Buffer{}
+	if err = tmpl.Execute(buf, opts); err != nil {
+		return nil, err
+	}
+	if !strings.HasSuffix(name, ".go.tmpl") {
+		return buf.Bytes(), nil
+	}
+	return format.Source(buf.Bytes())
+}
@@ -0,0 +1,37 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestGenerate(t *testing.T) {
+	dir := filepath.Join(t.TempDir(), "shop")
+	files, err := Generate(dir, Options{Replace: "../query"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(files) != 7 {
+		t.Errorf("Expected 7 files, got %v.", files)
+	}
+	mod, err := os.ReadFile(filepath.Join(dir, "go.mod"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.HasPrefix(string(mod), "module shop\n") || !strings.Contains(string(mod), "replace github.com/io-da/query => /") {
+		t.Errorf("Unexpected go.mod %s.", mod)
+	}
+
+	// existing files are not overwritten unless forced
+	if _, err = Generate(dir, Options{Module: "example.com/shop"}); err == nil || !strings.Contains(err.Error(), "already exists") {
+		t.Errorf("Expected the existing files to be kept, got %v.", err)
+	}
+	if _, err = Generate(dir, Options{Module: "example.com/shop", Force: true}); err != nil {
+		t.Fatal(err)
+	}
+	if mod, _ = os.ReadFile(filepath.Join(dir, "go.mod")); !strings.HasPrefix(string(mod), "module example.com/shop\n") || strings.Contains(string(mod), "replace") {
+		t.Errorf("Unexpected go.mod %s.", mod)
+	}
+}
@@ -0,0 +1,51 @@
+// Command querydoc extracts the documentation of the query types found in a directory tree and writes it as JSON.
+// The output can be provided to the admin API catalog.
+//
+// Usage:
+//
+//	querydoc [-o output.json] [dir]
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+
+	"github.com/io-da/query/querydoc"
+)
+
+func main() {
+	output := flag.String("o", "", "output file (defaults to stdout)")
+	flag.Parse()
+
+	dir := "."
+	if flag.NArg() > 0 {
+		dir = flag.Arg(0)
+	}
+	if err := run(dir, *output); err != nil {
+		fmt.Fprintln(os.Stderr, "querydoc:", err)
+		os.Exit(1)
+	}
+}
+
+func run(dir string, output string) error {
+	docs, err := querydoc.Extract(dir)
+	if err != nil {
+		return err
+	}
+
+	var w io.Writer = os.Stdout
+	if output != "" {
+		file, err := os.Create(output)
+		if err != nil {
+			return err
+		}
+		defer file.Close()
+		w = file
+	}
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	return enc.Encode(docs)
+}
@@ -0,0 +1,123 @@
+package query
+
+import (
+	"bytes"
+	"encoding/gob"
+	"encoding/json"
+	"time"
+)
+
+// Codec must be implemented for a type to qualify as a result codec.
+// Codecs are used to serialize results, for instance by cache adapters storing the results outside of the process memory.
+// The functions match the signatures of the standard encoding functions (e.g. json.Marshal and json.Unmarshal).
+type Codec interface {
+	Marshal(v interface{}) ([]byte, error)
+	Unmarshal(data []byte, v interface{}) error
+}
+
+// JSONCodec is a Codec using the encoding/json package.
+// The values are decoded into their generic JSON representation (map[string]interface{}, float64, etc.).
+type JSONCodec struct{}
+
+// Marshal encodes the value as JSON.
+func (JSONCodec) Marshal(v interface{}) ([]byte, error) {
+	return json.Marshal(v)
+}
+
+// Unmarshal decodes the JSON data into the value.
+func (JSONCodec) Unmarshal(data []byte, v interface{}) error {
+	return json.Unmarshal(data, v)
+}
+
+// GobCodec is a Codec using the encoding/gob package.
+// The concrete types of the values must be registered using gob.Register.
+type GobCodec struct{}
+
+// Marshal encodes the value as gob.
+func (GobCodec) Marshal(v interface{}) ([]byte, error) {
+	buf := &bytes.Buffer{}
+	if err := gob.NewEncoder(buf).Encode(v); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// Unmarshal decodes the gob data into the value.
+func (GobCodec) Unmarshal(data []byte, v interface{}) error {
+	return gob.NewDecoder(bytes.NewReader(data)).Decode(v)
+}
+
+// MarshalResult serializes the result using the provided codec.
+// The metadata of the result (total, cursor and arbitrary metadata) is serialized as well.
+func MarshalResult(c Codec, res *Result) ([]byte, error) {
+	return c.Marshal(encodeResult(res))
+}
+
+// UnmarshalResult deserializes a result previously serialized with MarshalResult.
+func UnmarshalResult(c Codec, data []byte) (*Result, error) {
+	enc := &encodedResult{}
+	if err := c.Unmarshal(data, enc); err != nil {
+		return nil, err
+	}
+	return decodeResult(enc), nil
+}
+
+// MarshalValues serializes result values (e.g. the values of an iterator result) using the provided codec.
+func MarshalValues(c Codec, values []interface{}) ([]byte, error) {
+	return c.Marshal(&encodedResult{Data: values})
+}
+
+// UnmarshalValues deserializes result values previously serialized with MarshalValues.
+func UnmarshalValues(c Codec, data []byte) ([]interface{}, error) {
+	enc := &encodedResult{}
+	if err := c.Unmarshal(data, enc); err != nil {
+		return nil, err
+	}
+	return enc.Data, nil
+}
+
+//------Internal------//
+
+type encodedResult struct {
+	CacheKey  []byte
+	Data      []interface{}
+	CachedAt  time.Time
+	ExpiresAt time.Time
+	Total     *int                   `json:",omitempty"`
+	Cursor    string                 `json:",omitempty"`
+	Meta      map[string]interface{} `json:",omitempty"`
+	// Version is the version of the result schema of the query (see Bus.ResultSchema).
+	Version int `json:",omitempty"`
+}
+
+func encodeResult(res *Result) *encodedResult {
+	enc := &encodedResult{
+		CacheKey:  res.CacheKey(),
+		Data:      res.All(),
+		CachedAt:  res.CachedAt(),
+		ExpiresAt: res.ExpiresAt(),
+		Cursor:    res.Cursor(),
+		Meta:      res.Metadata(),
+	}
+	if total, known := res.Total(); known {
+		enc.Total = &total
+	}
+	return enc
+}
+
+func decodeResult(enc *encodedResult) *Result {
+	res := newResult()
+	res.cacheKey = enc.CacheKey
+	if enc.Data != nil {
+		res.data = enc.Data
+	}
+	res.cachedAt = enc.CachedAt
+	res.expiresAt = enc.ExpiresAt
+	if enc.Total != nil {
+		res.total = *enc.Total
+		res.totalKnown = true
+	}
+	res.cursor = enc.Cursor
+	res.meta = enc.Meta
+	return res
+}
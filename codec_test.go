@@ -0,0 +1,211 @@
+package query
+
+import (
+	"context"
+	"encoding/binary"
+	"encoding/gob"
+	"errors"
+	"testing"
+	"time"
+)
+
+type testValue struct {
+	ID   int64
+	Name string
+}
+
+func (v *testValue) ValueType() string {
+	return "testValue"
+}
+
+func (v *testValue) MarshalBinary() ([]byte, error) {
+	buf := binary.AppendVarint(make([]byte, 0, 16+len(v.Name)), v.ID)
+	return append(buf, v.Name...), nil
+}
+
+func (v *testValue) UnmarshalBinary(data []byte) error {
+	id, n := binary.Varint(data)
+	if n <= 0 {
+		return errors.New("invalid test value")
+	}
+	v.ID = id
+	v.Name = string(data[n:])
+	return nil
+}
+
+func decodeTestValue(data []byte) (interface{}, error) {
+	v := &testValue{}
+	return v, v.UnmarshalBinary(data)
+}
+
+func testCodecResult(size int) *Result {
+	res := newResult()
+	res.cacheKey = []byte("CACHE-KEY")
+	res.cachedAt = time.Unix(0, 1000)
+	res.expiresAt = time.Unix(0, 2000)
+	for i := 0; i < size; i++ {
+		res.Add(&testValue{ID: int64(i), Name: "bar"})
+	}
+	return res
+}
+
+func TestBinaryCodec(t *testing.T) {
+	codec := NewBinaryCodec()
+	codec.Register("testValue", decodeTestValue)
+
+	res := testCodecResult(2)
+	res.Add("bar")
+	res.Add(int64(-42))
+	res.Add(1.5)
+	res.Add(true)
+	res.Add(nil)
+	data, err := MarshalResult(codec, res)
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	decRes, err := UnmarshalResult(codec, data)
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	if string(decRes.CacheKey()) != "CACHE-KEY" || !decRes.CachedAt().Equal(res.CachedAt()) || !decRes.ExpiresAt().Equal(res.ExpiresAt()) {
+		t.Error("Unexpected result metadata.")
+	}
+	vals := decRes.All()
+	if len(vals) != 7 || *vals[1].(*testValue) != (testValue{ID: 1, Name: "bar"}) ||
+		vals[2] != "bar" || vals[3] != int64(-42) || vals[4] != 1.5 || vals[5] != true || vals[6] != nil {
+		t.Error("Unexpected result values.")
+	}
+
+	if _, err = UnmarshalResult(NewBinaryCodec(), data); err == nil {
+		t.Error("Unregistered value types were expected to fail decoding.")
+	}
+	if _, err = UnmarshalResult(codec, data[:len(data)-3]); err == nil {
+		t.Error("Corrupted data was expected to fail decoding.")
+	}
+	res.Add(struct{}{})
+	if _, err = MarshalResult(codec, res); err == nil {
+		t.Error("Expected ErrorUnsupportedValue error.")
+	}
+}
+
+func TestMarshalResult_Metadata(t *testing.T) {
+	binaryCodec := NewBinaryCodec()
+	binaryCodec.Register("testValue", decodeTestValue)
+	for name, codec := range map[string]Codec{"binary": binaryCodec, "gob": GobCodec{}, "json": JSONCodec{}} {
+		res := newResult()
+		res.Add("bar")
+		res.SetTotal(42)
+		res.SetCursor("next")
+		res.Meta("page", "2")
+		data, err := MarshalResult(codec, res)
+		if err != nil {
+			t.Fatal(name, err.Error())
+		}
+		decRes, err := UnmarshalResult(codec, data)
+		if err != nil {
+			t.Fatal(name, err.Error())
+		}
+		if total, known := decRes.Total(); !known || total != 42 {
+			t.Errorf("Unexpected total using the %s codec.", name)
+		}
+		if decRes.Cursor() != "next" {
+			t.Errorf("Unexpected cursor using the %s codec.", name)
+		}
+		if page, found := decRes.MetaValue("page"); !found || page != "2" {
+			t.Errorf("Unexpected metadata using the %s codec.", name)
+		}
+
+		data, _ = MarshalResult(codec, newResult())
+		decRes, err = UnmarshalResult(codec, data)
+		if err != nil {
+			t.Fatal(name, err.Error())
+		}
+		if _, known := decRes.Total(); known || decRes.Cursor() != "" || decRes.Metadata() != nil {
+			t.Errorf("Unexpected metadata using the %s codec.", name)
+		}
+	}
+}
+
+func benchmarkCodec(b *testing.B, codec Codec) {
+	data, err := MarshalResult(codec, testCodecResult(10000))
+	if err != nil {
+		b.Fatal(err.Error())
+	}
+	b.ReportAllocs()
+	b.ResetTimer()
+	for n := 0; n < b.N; n++ {
+		if _, err = UnmarshalResult(codec, data); err != nil {
+			b.Error(err.Error())
+		}
+	}
+}
+
+func BenchmarkCodec_BinaryUnmarshal(b *testing.B) {
+	codec := NewBinaryCodec()
+	codec.Register("testValue", decodeTestValue)
+	benchmarkCodec(b, codec)
+}
+
+func BenchmarkCodec_GobUnmarshal(b *testing.B) {
+	gob.Register(&testValue{})
+	benchmarkCodec(b, GobCodec{})
+}
+
+func BenchmarkCodec_JSONUnmarshal(b *testing.B) {
+	benchmarkCodec(b, JSONCodec{})
+}
+
+func TestUnmarshalVersionedResult(t *testing.T) {
+	bus := NewBus()
+	bus.ResultSchema(&testCacheQuery{},
+		func(val interface{}) (interface{}, error) {
+			return val.(string) + ".v1", nil
+		},
+		func(val interface{}) (interface{}, error) {
+			if val == "corrupt.v1" {
+				return nil, errors.New("unexpected value")
+			}
+			return val.(string) + ".v2", nil
+		},
+	)
+	ctx := context.WithValue(context.Background(), lineageKey, &Lineage{owner: bus})
+
+	binaryCodec := NewBinaryCodec()
+	for name, codec := range map[string]Codec{"binary": binaryCodec, "gob": GobCodec{}, "json": JSONCodec{}} {
+		res := newResult()
+		res.Add("bar")
+		res.SetTotal(1)
+		unversioned, err := MarshalVersionedResult(context.Background(), codec, &testCacheQuery{}, res)
+		if err != nil {
+			t.Fatal(name, err.Error())
+		}
+		// the results serialized before the schema are upgraded through every migration
+		decRes, err := UnmarshalVersionedResult(ctx, codec, &testCacheQuery{}, unversioned)
+		if err != nil || decRes.First() != "bar.v1.v2" {
+			t.Errorf("Unexpected migrated result using the %s codec (%v).", name, err)
+		}
+		if total, known := decRes.Total(); !known || total != 1 {
+			t.Errorf("Unexpected total using the %s codec.", name)
+		}
+
+		versioned, err := MarshalVersionedResult(ctx, codec, &testCacheQuery{}, decRes)
+		if err != nil {
+			t.Fatal(name, err.Error())
+		}
+		if decRes, err = UnmarshalVersionedResult(ctx, codec, &testCacheQuery{}, versioned); err != nil || decRes.First() != "bar.v1.v2" {
+			t.Errorf("Unexpected current result using the %s codec (%v).", name, err)
+		}
+		// the results serialized with a newer version are not downgraded
+		var migrationErr ErrorResultMigration
+		if _, err = UnmarshalVersionedResult(context.Background(), codec, &testCacheQuery{}, versioned); !errors.As(err, &migrationErr) || migrationErr.Version() != 2 {
+			t.Errorf("Expected the result not to be migrated using the %s codec, got %v.", name, err)
+		}
+
+		res = newResult()
+		res.Add("corrupt")
+		unversioned, _ = MarshalVersionedResult(context.Background(), codec, &testCacheQuery{}, res)
+		if _, err = UnmarshalVersionedResult(ctx, codec, &testCacheQuery{}, unversioned); !errors.Is(err, ResultMigrationError) || errors.Unwrap(err).Error() != "unexpected value" {
+			t.Errorf("Expected the migration to fail using the %s codec, got %v.", name, err)
+		}
+	}
+}
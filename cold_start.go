@@ -0,0 +1,97 @@
+package query
+
+import (
+	"context"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// Coalescable may optionally be implemented by the queries that are not cacheable, for the identical queries to be coalesced
+// during the cold start window (see ColdStartProtection). The concurrent queries of the same type returning the same coalesce key
+// share a single handling, so the key must account for anything their result depends on (e.g. the tenant or the principal of the context).
+type Coalescable interface {
+	Query
+	CoalesceKey() []byte
+}
+
+// ColdStartProtection may optionally be provided to smooth the thundering herd hitting the backends while the caches are cold.
+// During the given window, the concurrent handling of each query type is capped to the given concurrency (0 leaving it uncapped),
+// and the identical queries implementing the Coalescable interface are coalesced as well. The identical cacheable queries
+// are coalesced whether the window lasts or not, while the other queries are never coalesced, possibly depending on their context
+// (e.g. the tenant or the principal) rather than only on their values.
+// The window starts once the bus is initialized, or with the first query if the bus is not initialized beforehand.
+// It should be used *before* the bus is initialized.
+func (bus *Bus) ColdStartProtection(window time.Duration, concurrency int) {
+	bus.coldStart.window = window
+	bus.coldStart.concurrency = concurrency
+}
+
+//------Internal------//
+
+// coldStart keeps track of the cold start window and the execution slots of each query type.
+type coldStart struct {
+	sync.Mutex
+	window      time.Duration
+	concurrency int
+	began       *int64
+	slots       map[string]chan bool
+}
+
+func newColdStart() *coldStart {
+	return &coldStart{
+		began: new(int64),
+		slots: make(map[string]chan bool),
+	}
+}
+
+// begin the window at the given moment, unless it already began.
+func (cs *coldStart) begin(now time.Time) {
+	if cs.window > 0 {
+		atomic.CompareAndSwapInt64(cs.began, 0, now.UnixNano())
+	}
+}
+
+// active reports whether the window lasts as of the given moment.
+func (cs *coldStart) active(now time.Time) bool {
+	began := atomic.LoadInt64(cs.began)
+	return began != 0 && now.Sub(time.Unix(0, began)) < cs.window
+}
+
+// acquire an execution slot of the query type, waiting unless the context is done first.
+func (cs *coldStart) acquire(ctx context.Context, now time.Time, qry Query) (func(), error) {
+	if cs.concurrency <= 0 || !cs.active(now) {
+		return func() {}, nil
+	}
+	cs.Lock()
+	slots, exists := cs.slots[string(qry.ID())]
+	if !exists {
+		slots = make(chan bool, cs.concurrency)
+		cs.slots[string(qry.ID())] = slots
+	}
+	cs.Unlock()
+
+	select {
+	case slots <- true:
+		return func() { <-slots }, nil
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+}
+
+func (cs *coldStart) reset() {
+	atomic.StoreInt64(cs.began, 0)
+}
+
+// flightKey returns the key shared by the identical queries to be coalesced, if any.
+// The cacheable queries are coalesced, identified by their cache key. During the cold start window, the Coalescable queries are
+// coalesced as well, identified by their ID and coalesce key (prefixed with "coalesce|", apart from the cache keys).
+func flightKey(qry Query, coldStart bool) (string, bool) {
+	if cqry, implements := qry.(Cacheable); implements {
+		return string(cqry.CacheKey()), true
+	}
+	if cqry, implements := qry.(Coalescable); implements && coldStart {
+		return "coalesce|" + string(qry.ID()) + "|" + string(cqry.CoalesceKey()), true
+	}
+	return "", false
+}
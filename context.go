@@ -0,0 +1,32 @@
+package query
+
+import (
+	"context"
+	"time"
+)
+
+type contextKey int
+
+const (
+	maxStalenessKey contextKey = iota
+	iteratorHandlersKey
+	workerContextKey
+	deadlineKey
+	lineageKey
+	iteratorLaneKey
+	ownerKey
+	snapshotKey
+	principalKey
+)
+
+// WithMaxStaleness returns a copy of the context specifying the maximum staleness accepted for cached results.
+// Cached results older than the given duration are bypassed (even if not expired) and the query is handled again.
+func WithMaxStaleness(ctx context.Context, d time.Duration) context.Context {
+	return context.WithValue(ctx, maxStalenessKey, d)
+}
+
+// MaxStaleness returns the maximum staleness specified in the context, if any.
+func MaxStaleness(ctx context.Context) (time.Duration, bool) {
+	d, ok := ctx.Value(maxStalenessKey).(time.Duration)
+	return d, ok
+}
@@ -0,0 +1,32 @@
+package query
+
+import "context"
+
+// ContextDecorator must be implemented for a type to qualify as a context decorator.
+// Context decorators prepare the context of the queries (e.g. injecting the tenant, the locale or the feature flags, or defaulting the deadline),
+// centralizing what would otherwise be duplicated wherever queries are issued.
+// Returning an error rejects the query, the error being passed on to the error handlers and returned to the caller.
+type ContextDecorator interface {
+	Decorate(ctx context.Context, qry Query) (context.Context, error)
+}
+
+// ContextDecorators may optionally be provided.
+// They are applied in the order they are provided, before the query is dispatched (and before the middlewares).
+// The contexts derived by the decorators (e.g. using context.WithTimeout) are released once the query is finished.
+func (bus *Bus) ContextDecorators(decs ...ContextDecorator) {
+	bus.contextDecorators = decs
+}
+
+//------Internal------//
+
+func (bus *Bus) decorate(ctx context.Context, qry Query) (context.Context, error) {
+	for _, dec := range bus.contextDecorators {
+		decCtx, err := dec.Decorate(ctx, qry)
+		if err != nil {
+			bus.error(ctx, qry, err)
+			return ctx, err
+		}
+		ctx = decCtx
+	}
+	return ctx, nil
+}
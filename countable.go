@@ -0,0 +1,128 @@
+package query
+
+import (
+	"context"
+	"net/url"
+)
+
+// CountableHandler may optionally be implemented by handlers to provide the total count of the collections of their list queries
+// (see Result.SetTotal), separately from their pages.
+// Once the query is handled, the bus counts the collection through its handlers implementing the CountableHandler interface
+// (the routed handlers first), unless the total was already provided by the handling. Like the handling, the counting ignores the queries
+// not concerning the handler, the first total provided completing it.
+// The errors of the counting fail the query, as the errors of the handling do.
+type CountableHandler interface {
+	Count(ctx context.Context, qry Query, res *Result) error
+}
+
+// CountCacheable may optionally be implemented by cacheable list queries to cache the total count of their collection separately from their pages,
+// so every page (of the same filters) shares a single count.
+// The count is cached for the duration of the page, with the tags of the page (see Taggable), invalidating the collection invalidating its count too.
+// The PaginatedCache implements it using the collection and the filters of the page.
+type CountCacheable interface {
+	CountCacheKey() []byte
+}
+
+// CountCacheKey returns the cache key of the total count of the collection, shared by every page of the same filters.
+func CountCacheKey(collection string, filters ...string) []byte {
+	key := "count|" + url.QueryEscape(collection)
+	for _, filter := range filters {
+		key += "|" + url.QueryEscape(filter)
+	}
+	return []byte(key)
+}
+
+// CountCacheKey returns the cache key of the total count of the collection (see CountCacheKey).
+func (pc PaginatedCache) CountCacheKey() []byte {
+	return CountCacheKey(pc.Collection, pc.Filters...)
+}
+
+// Count performs the query, returning the total count of its collection (see Result.Total),
+// or the number of values of its result if no total was provided.
+func Count(ctx context.Context, qr Querier, qry Query) (int, error) {
+	res, err := qr.Query(ctx, qry)
+	if err != nil {
+		return 0, err
+	}
+	if total, known := res.Total(); known {
+		return total, nil
+	}
+	return len(res.All()), nil
+}
+
+// Exists performs the query, reporting whether its collection is not empty: its total count is positive,
+// or its result provides values if no total was provided.
+func Exists(ctx context.Context, qr Querier, qry Query) (bool, error) {
+	n, err := Count(ctx, qr, qry)
+	return n > 0, err
+}
+
+//------Internal------//
+
+// count provides the total count of the collection of the handled query through the CountableHandlers, unless already provided.
+func (bus *Bus) count(ctx context.Context, qry Query, res *Result) error {
+	if _, known := res.Total(); known {
+		return nil
+	}
+	counters := bus.counters(qry)
+	if len(counters) == 0 {
+		return nil
+	}
+
+	cqry, cacheable := countCacheQuery(ctx, qry)
+	if cacheable {
+		bus.cacheUsage.record(bus.clock.Now(), qry, cqry.CacheKey())
+		if cached := bus.cache.Get(ctx, cqry); cached != nil {
+			if total, known := cached.Total(); known {
+				res.SetTotal(total)
+				return nil
+			}
+		}
+	}
+
+	counted := newResult()
+	for _, hdl := range counters {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		if err := bus.callHandler(qry, hdl, func() error { return hdl.Count(ctx, qry, counted) }); err != nil {
+			return err
+		}
+		if total, known := counted.Total(); known {
+			res.SetTotal(total)
+			if cacheable {
+				bus.store(ctx, qry, cqry, counted)
+			}
+			return nil
+		}
+	}
+	return nil
+}
+
+// counters returns the handlers of the query implementing the CountableHandler interface, the routed handlers first.
+func (bus *Bus) counters(qry Query) []CountableHandler {
+	var counters []CountableHandler
+	for _, hdls := range [][]Handler{bus.routes[string(qry.ID())], bus.handlers} {
+		for _, hdl := range hdls {
+			if ch, implements := hdl.(CountableHandler); implements {
+				counters = append(counters, ch)
+			} else if sbx, sandboxed := hdl.(sandboxedCountable); sandboxed {
+				if ch, implements := sbx.counter(); implements {
+					counters = append(counters, ch)
+				}
+			}
+		}
+	}
+	return counters
+}
+
+// countCacheQuery returns the cacheable query of the total count of the collection of the query, if its count is cached separately.
+// The count shares the duration and tags of the query.
+func countCacheQuery(ctx context.Context, qry Query) (Cacheable, bool) {
+	cqry, cacheable := qry.(Cacheable)
+	ccqry, countable := qry.(CountCacheable)
+	if !cacheable || !countable || cqry.CacheDuration() <= 0 || isPinned(ctx) {
+		return nil, false
+	}
+	return withCacheKey(cqry, ccqry.CountCacheKey()), true
+}
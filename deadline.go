@@ -0,0 +1,221 @@
+package query
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"time"
+)
+
+// DeadlineExtension describes the request of an iterator handler to extend the deadline of its query (see ExtendDeadline).
+type DeadlineExtension struct {
+	Query Query
+	// Started is the moment the timeout of the query started.
+	Started time.Time
+	// Deadline is the current deadline of the query.
+	Deadline time.Time
+	// Requested is the deadline requested by the handler.
+	Requested time.Time
+}
+
+// DeadlinePolicy must be implemented for a type to qualify as a deadline policy.
+// Deadline policies decide whether the deadline of an iterator query may be extended, returning the deadline granted.
+// The deadline granted may be earlier than requested. Returning an error denies the extension.
+type DeadlinePolicy interface {
+	Extend(ctx context.Context, ext DeadlineExtension) (time.Time, error)
+}
+
+// RuntimePolicy is a DeadlinePolicy granting the extensions within a maximum total runtime of the query.
+// Entitled may optionally be provided to only grant the extensions to entitled callers (e.g. according to the claims of the context).
+type RuntimePolicy struct {
+	MaxRuntime time.Duration
+	Entitled   func(ctx context.Context, qry Query) bool
+}
+
+// Extend grants the requested deadline, limited to the maximum runtime of the query.
+func (p RuntimePolicy) Extend(ctx context.Context, ext DeadlineExtension) (time.Time, error) {
+	if p.Entitled != nil && !p.Entitled(ctx, ext.Query) {
+		return time.Time{}, errNotEntitled
+	}
+	limit := ext.Started.Add(p.MaxRuntime)
+	if !limit.After(ext.Deadline) {
+		return time.Time{}, errMaxRuntimeReached
+	}
+	if ext.Requested.After(limit) {
+		return limit, nil
+	}
+	return ext.Requested, nil
+}
+
+// DeadlinePolicy may optionally be provided to allow the iterator handlers to extend the deadline of their queries (see ExtendDeadline).
+// Only the deadline applied by the bus (see QueryTimeout) is extended, the deadline of the context provided by the caller remains.
+// By default, every extension is denied.
+func (bus *Bus) DeadlinePolicy(p DeadlinePolicy) {
+	bus.deadlinePolicy = p
+}
+
+// ExtendDeadline requests the deadline of the iterator query being handled to be extended, until the given duration from now.
+// It is intended for long running iterator handlers (e.g. exports), to avoid the query dying midway through the stream.
+// The deadline policy of the bus decides on the extension, returning an ErrorDeadlineExtensionDenied when denied.
+// It returns the deadline in effect afterwards, which may be earlier than requested.
+// Contexts without a deadline are returned their zero time, while contexts with a deadline not applied by the bus to an iterator query
+// are always denied (the ErrorDeadlineExtensionDenied providing no query).
+func ExtendDeadline(ctx context.Context, d time.Duration) (time.Time, error) {
+	dl, extendable := ctx.Value(deadlineKey).(*deadline)
+	if !extendable {
+		if at, ok := ctx.Deadline(); ok {
+			return at, NewErrorDeadlineExtensionDenied(nil, errDeadlineNotExtendable)
+		}
+		return time.Time{}, nil
+	}
+	if err := dl.extend(ctx, d); err != nil {
+		at, _ := ctx.Deadline()
+		return at, err
+	}
+	at, _ := ctx.Deadline()
+	return at, nil
+}
+
+//------Internal------//
+
+var (
+	errNoDeadlinePolicy      = errors.New("no deadline policy was provided")
+	errDeadlineNotExtendable = errors.New("the deadline was not applied by the bus to an iterator query")
+	errNotEntitled           = errors.New("the caller is not entitled to extensions")
+	errMaxRuntimeReached     = errors.New("the maximum runtime is reached")
+)
+
+// deadline is the deadline applied by the bus to a query, extendable for the iterator queries.
+type deadline struct {
+	sync.Mutex
+	clock   Clock
+	qry     Query
+	policy  DeadlinePolicy
+	started time.Time
+	at      time.Time
+	timer   Timer
+	done    chan struct{}
+	err     error
+}
+
+// deadlineContext is a context done once its deadline passes according to the clock of the bus (or once its parent is done).
+// It manages its own done channel, so the contexts derived from it are provided its errors.
+type deadlineContext struct {
+	context.Context
+	dl         *deadline
+	extendable bool
+}
+
+// withExtendableTimeout wraps the context with the timeout applicable to the iterator query, allowing its handlers to extend it.
+// The returned cancel function is nil if no timeout is applicable.
+func (bus *Bus) withExtendableTimeout(ctx context.Context, qry Query) (context.Context, context.CancelFunc) {
+	d := bus.timeout(qry)
+	if d <= 0 {
+		return ctx, nil
+	}
+	return bus.withDeadline(ctx, qry, d, true)
+}
+
+// withDeadline wraps the context with a deadline in the given duration, according to the clock of the bus.
+func (bus *Bus) withDeadline(ctx context.Context, qry Query, d time.Duration, extendable bool) (context.Context, context.CancelFunc) {
+	now := bus.clock.Now()
+	dl := &deadline{
+		clock:   bus.clock,
+		qry:     qry,
+		policy:  bus.deadlinePolicy,
+		started: now,
+		at:      now.Add(d),
+		timer:   bus.clock.NewTimer(d),
+		done:    make(chan struct{}),
+	}
+	_ = spawn(ctx, qry, "deadline", false, func() { dl.watch(ctx) })
+	return &deadlineContext{Context: ctx, dl: dl, extendable: extendable}, func() { dl.stop(context.Canceled) }
+}
+
+func (ctx *deadlineContext) Deadline() (time.Time, bool) {
+	ctx.dl.Lock()
+	at := ctx.dl.at
+	ctx.dl.Unlock()
+	if parent, ok := ctx.Context.Deadline(); ok && parent.Before(at) {
+		return parent, true
+	}
+	return at, true
+}
+
+func (ctx *deadlineContext) Done() <-chan struct{} {
+	return ctx.dl.done
+}
+
+func (ctx *deadlineContext) Err() error {
+	ctx.dl.Lock()
+	defer ctx.dl.Unlock()
+	return ctx.dl.err
+}
+
+func (ctx *deadlineContext) Value(key interface{}) interface{} {
+	if key == deadlineKey && ctx.extendable {
+		return ctx.dl
+	}
+	return ctx.Context.Value(key)
+}
+
+func (dl *deadline) extend(ctx context.Context, d time.Duration) error {
+	dl.Lock()
+	ext := DeadlineExtension{Query: dl.qry, Started: dl.started, Deadline: dl.at, Requested: dl.clock.Now().Add(d)}
+	dl.Unlock()
+	if dl.policy == nil {
+		return NewErrorDeadlineExtensionDenied(dl.qry, errNoDeadlinePolicy)
+	}
+	granted, err := dl.policy.Extend(ctx, ext)
+	if err != nil {
+		return NewErrorDeadlineExtensionDenied(dl.qry, err)
+	}
+	dl.Lock()
+	if dl.err == nil && granted.After(dl.at) {
+		dl.at = granted
+	}
+	dl.Unlock()
+	return nil
+}
+
+// watch stops the deadline once passed (or once the parent context is done).
+func (dl *deadline) watch(ctx context.Context) {
+	for {
+		select {
+		case <-ctx.Done():
+			dl.stop(ctx.Err())
+			return
+		case <-dl.done:
+			return
+		case <-dl.timer.C():
+			if dl.expire() {
+				return
+			}
+		}
+	}
+}
+
+// expire stops the deadline once passed, rescheduling the timer if it was extended in the meantime.
+// It returns whether the deadline is stopped.
+func (dl *deadline) expire() bool {
+	dl.Lock()
+	if remaining := dl.at.Sub(dl.clock.Now()); remaining > 0 {
+		dl.timer.Reset(remaining)
+		dl.Unlock()
+		return false
+	}
+	dl.Unlock()
+	dl.stop(context.DeadlineExceeded)
+	return true
+}
+
+func (dl *deadline) stop(err error) {
+	dl.Lock()
+	defer dl.Unlock()
+	if dl.err != nil {
+		return
+	}
+	dl.err = err
+	dl.timer.Stop()
+	close(dl.done)
+}
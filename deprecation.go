@@ -0,0 +1,103 @@
+package query
+
+import (
+	"context"
+	"fmt"
+	"runtime"
+	"runtime/debug"
+	"sort"
+	"sync/atomic"
+)
+
+// Deprecation describes a query type marked as deprecated, including the amount of times it was used.
+type Deprecation struct {
+	QueryID     []byte
+	Replacement string
+	Usages      uint64
+}
+
+// DeprecatedUsage describes a single usage of a deprecated query.
+// Caller identifies the location (file:line) that issued the query.
+// Stack is only provided if DeprecationStackTraces is enabled.
+type DeprecatedUsage struct {
+	Replacement string
+	Caller      string
+	Stack       []byte
+}
+
+// DeprecationHandler must be implemented for a type to qualify as a deprecation handler.
+type DeprecationHandler interface {
+	Handle(ctx context.Context, qry Query, usg DeprecatedUsage)
+}
+
+// Deprecate marks the queries with the same ID as the given query as deprecated.
+// The replacement is a hint provided to the callers (e.g. the name of the query that should be used instead).
+// Deprecated queries are still handled, but their usages are counted and their results annotated with a deprecation warning.
+// It should be used *before* any query is performed.
+func (bus *Bus) Deprecate(qry Query, replacement string) {
+	bus.deprecations[string(qry.ID())] = &deprecation{
+		replacement: replacement,
+		usages:      new(uint64),
+	}
+}
+
+// DeprecationHandlers may optionally be provided.
+// They will receive every usage of a deprecated query, including the caller information.
+func (bus *Bus) DeprecationHandlers(hdls ...DeprecationHandler) {
+	bus.deprecationHandlers = hdls
+}
+
+// DeprecationStackTraces may optionally be enabled to provide the deprecation handlers with the full stack trace of the callers.
+// It defaults to false.
+func (bus *Bus) DeprecationStackTraces(enabled bool) {
+	bus.deprecationStackTraces = enabled
+}
+
+// Deprecations returns every deprecated query type, sorted by query ID.
+func (bus *Bus) Deprecations() []Deprecation {
+	deps := make([]Deprecation, 0, len(bus.deprecations))
+	for id, dep := range bus.deprecations {
+		deps = append(deps, Deprecation{
+			QueryID:     []byte(id),
+			Replacement: dep.replacement,
+			Usages:      atomic.LoadUint64(dep.usages),
+		})
+	}
+	sort.Slice(deps, func(i, j int) bool {
+		return string(deps[i].QueryID) < string(deps[j].QueryID)
+	})
+	return deps
+}
+
+//------Internal------//
+
+type deprecation struct {
+	replacement string
+	usages      *uint64
+}
+
+// deprecated verifies if the query is deprecated, accounting for its usage.
+// The skip argument is the number of stack frames between the caller of the bus and this function.
+func (bus *Bus) deprecated(ctx context.Context, qry Query, skip int) (string, bool) {
+	dep, deprecated := bus.deprecations[string(qry.ID())]
+	if !deprecated {
+		return "", false
+	}
+	atomic.AddUint64(dep.usages, 1)
+	warning := fmt.Sprintf("query: the query %T is deprecated, use %s instead", qry, dep.replacement)
+
+	if len(bus.deprecationHandlers) == 0 {
+		return warning, true
+	}
+	usg := DeprecatedUsage{Replacement: dep.replacement}
+	if _, file, line, ok := runtime.Caller(skip + 1); ok {
+		usg.Caller = fmt.Sprintf("%s:%d", file, line)
+	}
+	if bus.deprecationStackTraces {
+		usg.Stack = debug.Stack()
+	}
+	for _, hdl := range bus.deprecationHandlers {
+		hdl.Handle(ctx, qry, usg)
+	}
+	return warning, true
+}
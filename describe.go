@@ -0,0 +1,70 @@
+package query
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// QueryDescription describes a query type known to the bus (routed to specific handlers).
+type QueryDescription struct {
+	ID                   string   `json:"id"`
+	Type                 string   `json:"type"`
+	Handlers             []string `json:"handlers,omitempty"`
+	IteratorHandlers     []string `json:"iteratorHandlers,omitempty"`
+	SubscriptionHandlers []string `json:"subscriptionHandlers,omitempty"`
+	Cacheable            bool     `json:"cacheable"`
+	Deprecated           bool     `json:"deprecated"`
+	Replacement          string   `json:"replacement,omitempty"`
+}
+
+// Describe returns the descriptions of the query types routed to specific handlers, sorted by query ID.
+// Queries handled by handlers provided every query can not be known by the bus and are not described.
+// The types are formatted as package.Type, matching the documentation extracted by the querydoc package.
+func (bus *Bus) Describe() []QueryDescription {
+	bus.registry.RLock()
+	defer bus.registry.RUnlock()
+	iteratorRoutes := bus.iteratorHandlerSet.routes
+	ids := make([]string, 0, len(bus.queries))
+	for id := range bus.queries {
+		if len(bus.routes[id]) > 0 || len(iteratorRoutes[id]) > 0 || len(bus.subscriptionRoutes[id]) > 0 {
+			ids = append(ids, id)
+		}
+	}
+	sort.Strings(ids)
+
+	descs := make([]QueryDescription, 0, len(ids))
+	for _, id := range ids {
+		qry := bus.queries[id]
+		desc := QueryDescription{
+			ID:                   id,
+			Type:                 TypeName(qry),
+			Handlers:             typeNames(bus.routes[id]),
+			IteratorHandlers:     typeNames(iteratorRoutes[id]),
+			SubscriptionHandlers: typeNames(bus.subscriptionRoutes[id]),
+		}
+		_, desc.Cacheable = qry.(Cacheable)
+		if dep, deprecated := bus.deprecations[id]; deprecated {
+			desc.Deprecated = true
+			desc.Replacement = dep.replacement
+		}
+		descs = append(descs, desc)
+	}
+	return descs
+}
+
+// TypeName returns the type name of the given value formatted as package.Type (pointers are dereferenced).
+// Sandboxed handlers are named after the handler they wrap.
+func TypeName(v interface{}) string {
+	return strings.TrimLeft(fmt.Sprintf("%T", unwrap(v)), "*")
+}
+
+//------Internal------//
+
+func typeNames[H any](hdls []H) []string {
+	var names []string
+	for _, hdl := range hdls {
+		names = append(names, TypeName(hdl))
+	}
+	return names
+}
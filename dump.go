@@ -0,0 +1,415 @@
+package query
+
+import (
+	"fmt"
+	"reflect"
+	"runtime"
+	"sort"
+	"strings"
+	"sync/atomic"
+	"time"
+)
+
+// Dump is a serializable snapshot of the runtime state of a bus (see Bus.Dump), to be attached to bug reports.
+// The dumps are encoded with the encoding/json package (e.g. json.MarshalIndent), and may be decoded the same way to be compared (see Diff).
+type Dump struct {
+	// TakenAt is the moment of the snapshot, according to the clock of the bus.
+	TakenAt time.Time `json:"takenAt"`
+	// Runtime describes the environment of the process.
+	Runtime DumpRuntime `json:"runtime"`
+	// Config is the configuration of the bus.
+	Config DumpConfig `json:"config"`
+	// Queries are the query types routed to specific handlers (see Bus.Describe).
+	Queries []QueryDescription `json:"queries,omitempty"`
+	// Handlers are the handlers provided every query, as are the iterator and subscription handlers.
+	Handlers             []string `json:"handlers,omitempty"`
+	IteratorHandlers     []string `json:"iteratorHandlers,omitempty"`
+	SubscriptionHandlers []string `json:"subscriptionHandlers,omitempty"`
+	// Stats are the counters of the bus.
+	Stats DumpStats `json:"stats"`
+	// InFlight are the queries in flight (see Bus.InFlight).
+	InFlight []DumpedQuery `json:"inFlight,omitempty"`
+	// Goroutines are the goroutines spawned on behalf of the queries (see Bus.Goroutines).
+	Goroutines []DumpedGoroutine `json:"goroutines,omitempty"`
+	// Cache summarizes the cache adapters.
+	Cache DumpCache `json:"cache"`
+}
+
+// DumpRuntime describes the environment of the process of a dump.
+type DumpRuntime struct {
+	GoVersion  string `json:"goVersion"`
+	GOOS       string `json:"goos"`
+	GOARCH     string `json:"goarch"`
+	GOMAXPROCS int    `json:"gomaxprocs"`
+}
+
+// DumpConfig is the configuration of the bus of a dump. The components are described by their type name (see TypeName).
+// The durations and limits of the features disabled are zero.
+type DumpConfig struct {
+	IteratorWorkerPoolSize  int           `json:"iteratorWorkerPoolSize"`
+	IteratorLanes           map[int]int   `json:"iteratorLanes,omitempty"`
+	IteratorQueueBuffer     int           `json:"iteratorQueueBuffer"`
+	IteratorResultBuffer    int           `json:"iteratorResultBuffer"`
+	IteratorCacheLimit      int           `json:"iteratorCacheLimit"`
+	IteratorListenerTimeout time.Duration `json:"iteratorListenerTimeout"`
+	IteratorConsumerTimeout time.Duration `json:"iteratorConsumerTimeout"`
+	IteratorEnqueueTimeout  time.Duration `json:"iteratorEnqueueTimeout"`
+	AsyncWorkerPoolSize     int           `json:"asyncWorkerPoolSize"`
+	AsyncQueueBuffer        int           `json:"asyncQueueBuffer"`
+	BatchConcurrency        int           `json:"batchConcurrency"`
+	QueryTimeout            time.Duration `json:"queryTimeout"`
+	MaxQueryDepth           int           `json:"maxQueryDepth"`
+	CircuitBreakerThreshold int           `json:"circuitBreakerThreshold"`
+	CircuitBreakerOpen      time.Duration `json:"circuitBreakerOpen"`
+	CircuitBreakerProbes    int           `json:"circuitBreakerProbes"`
+	LoadSheddingTarget      time.Duration `json:"loadSheddingTarget"`
+	LoadSheddingWindow      time.Duration `json:"loadSheddingWindow"`
+	ColdStartWindow         time.Duration `json:"coldStartWindow"`
+	ColdStartConcurrency    int           `json:"coldStartConcurrency"`
+	Clock                   string        `json:"clock"`
+	Transport               string        `json:"transport,omitempty"`
+	Cloner                  string        `json:"cloner,omitempty"`
+	DeadlinePolicy          string        `json:"deadlinePolicy,omitempty"`
+	WorkerWrapper           string        `json:"workerWrapper,omitempty"`
+	Middlewares             []string      `json:"middlewares,omitempty"`
+	ContextDecorators       []string      `json:"contextDecorators,omitempty"`
+	Validators              []string      `json:"validators,omitempty"`
+	Observers               []string      `json:"observers,omitempty"`
+	ErrorHandlers           []string      `json:"errorHandlers,omitempty"`
+	WarningHandlers         []string      `json:"warningHandlers,omitempty"`
+	DeprecationHandlers     []string      `json:"deprecationHandlers,omitempty"`
+}
+
+// DumpStats are the counters of the bus of a dump.
+type DumpStats struct {
+	Initialized         bool   `json:"initialized"`
+	ShuttingDown        bool   `json:"shuttingDown"`
+	IteratorWorkers     int    `json:"iteratorWorkers"`
+	IteratorQueueLength int    `json:"iteratorQueueLength"`
+	DroppedErrors       uint64 `json:"droppedErrors"`
+	// NumGoroutine is the number of goroutines of the process.
+	NumGoroutine int `json:"numGoroutine"`
+	// Circuits are the states of the circuits which are not closed, by query ID (or by handler, see Bus.CircuitBreakerPerHandler).
+	Circuits map[string]string `json:"circuits,omitempty"`
+	// Deprecations are the usages of the deprecated query types, by query ID.
+	Deprecations map[string]uint64   `json:"deprecations,omitempty"`
+	Views        []DumpedView        `json:"views,omitempty"`
+	Staleness    []StalenessStats    `json:"staleness,omitempty"`
+	LoadShedding []LoadSheddingStats `json:"loadShedding,omitempty"`
+}
+
+// DumpedQuery describes a query in flight of a dump.
+type DumpedQuery struct {
+	ID      uint64    `json:"id"`
+	Parent  uint64    `json:"parent,omitempty"`
+	Root    uint64    `json:"root"`
+	Depth   int       `json:"depth"`
+	Type    string    `json:"type"`
+	Started time.Time `json:"started"`
+}
+
+// DumpedGoroutine describes a goroutine spawned on behalf of a query of a dump.
+type DumpedGoroutine struct {
+	ID      uint64    `json:"id"`
+	Purpose string    `json:"purpose"`
+	Type    string    `json:"type"`
+	Lineage uint64    `json:"lineage"`
+	Started time.Time `json:"started"`
+}
+
+// DumpedView describes a materialized view of a dump.
+type DumpedView struct {
+	Name         string    `json:"name"`
+	Type         string    `json:"type"`
+	Materialized bool      `json:"materialized"`
+	RefreshedAt  time.Time `json:"refreshedAt"`
+	Hits         uint64    `json:"hits"`
+	Misses       uint64    `json:"misses"`
+	Refreshes    uint64    `json:"refreshes"`
+	LastError    string    `json:"lastError,omitempty"`
+}
+
+// DumpCache summarizes the cache adapters of a dump.
+type DumpCache struct {
+	Tiers []DumpedCacheTier `json:"tiers"`
+	// Revalidations is the number of cached results kept for their revalidation (see Result.Revalidate).
+	Revalidations int `json:"revalidations"`
+}
+
+// DumpedCacheTier describes a tier of the cache of a dump.
+// The number of entries is only provided by the adapters implementing the CacheSizer interface (-1 otherwise).
+type DumpedCacheTier struct {
+	Adapter  string  `json:"adapter"`
+	TTLScale float64 `json:"ttlScale"`
+	Entries  int     `json:"entries"`
+}
+
+// CacheSizer may optionally be implemented by cache adapters to provide their number of entries to the dumps (see Bus.Dump).
+type CacheSizer interface {
+	Len() int
+}
+
+// Dump returns a snapshot of the runtime state of the bus: its configuration, the descriptions of its handlers, its counters,
+// the queries in flight and a summary of its cache. The snapshot is serializable, to be attached to bug reports
+// or compared with the snapshots of other instances (see Diff) when chasing environment-specific issues.
+func (bus *Bus) Dump() *Dump {
+	d := &Dump{
+		TakenAt: bus.clock.Now(),
+		Runtime: DumpRuntime{
+			GoVersion:  runtime.Version(),
+			GOOS:       runtime.GOOS,
+			GOARCH:     runtime.GOARCH,
+			GOMAXPROCS: runtime.GOMAXPROCS(0),
+		},
+		Config:  bus.dumpConfig(),
+		Queries: bus.Describe(),
+		Stats:   bus.dumpStats(),
+		Cache:   bus.dumpCache(),
+	}
+	bus.registry.RLock()
+	d.Handlers = typeNames(bus.handlers)
+	d.IteratorHandlers = typeNames(bus.iteratorHandlerSet.handlers)
+	d.SubscriptionHandlers = typeNames(bus.subscriptionHandlers)
+	bus.registry.RUnlock()
+	for _, f := range bus.InFlight() {
+		d.InFlight = append(d.InFlight, DumpedQuery{
+			ID:      f.Lineage.ID,
+			Parent:  f.Lineage.Parent,
+			Root:    f.Lineage.Root,
+			Depth:   f.Lineage.Depth,
+			Type:    TypeName(f.Lineage.Query),
+			Started: f.Started,
+		})
+	}
+	for _, g := range bus.Goroutines() {
+		d.Goroutines = append(d.Goroutines, DumpedGoroutine{
+			ID:      g.ID,
+			Purpose: g.Purpose,
+			Type:    TypeName(g.Query),
+			Lineage: g.Lineage.ID,
+			Started: g.Started,
+		})
+	}
+	return d
+}
+
+// Diff compares the environment, the configuration, the handlers and the cache tiers of the dumps (the counters and the queries in flight being ignored),
+// returning a line per difference (e.g. "config.queryTimeout: 1s != 2s"). It returns nothing if the dumps are equivalent.
+func Diff(a, b *Dump) []string {
+	var diffs []string
+	diffs = append(diffs, diffFields("runtime", reflect.ValueOf(a.Runtime), reflect.ValueOf(b.Runtime))...)
+	diffs = append(diffs, diffFields("config", reflect.ValueOf(a.Config), reflect.ValueOf(b.Config))...)
+	diffs = append(diffs, diffQueries(a.Queries, b.Queries)...)
+	for _, f := range []struct {
+		name string
+		a, b []string
+	}{
+		{"handlers", a.Handlers, b.Handlers},
+		{"iteratorHandlers", a.IteratorHandlers, b.IteratorHandlers},
+		{"subscriptionHandlers", a.SubscriptionHandlers, b.SubscriptionHandlers},
+	} {
+		if !reflect.DeepEqual(f.a, f.b) {
+			diffs = append(diffs, diffLine(f.name, f.a, f.b))
+		}
+	}
+	tiers := func(d *Dump) []string {
+		var adps []string
+		for _, tier := range d.Cache.Tiers {
+			adps = append(adps, fmt.Sprintf("%s(%g)", tier.Adapter, tier.TTLScale))
+		}
+		return adps
+	}
+	if ta, tb := tiers(a), tiers(b); !reflect.DeepEqual(ta, tb) {
+		diffs = append(diffs, diffLine("cache.tiers", ta, tb))
+	}
+	return diffs
+}
+
+//------Internal------//
+
+func (bus *Bus) dumpConfig() DumpConfig {
+	cfg := DumpConfig{
+		IteratorWorkerPoolSize:  bus.iteratorWorkerPoolSize,
+		IteratorQueueBuffer:     bus.iteratorQueueBuffer,
+		IteratorResultBuffer:    bus.iteratorResultBuffer,
+		IteratorCacheLimit:      bus.iteratorCacheLimit,
+		IteratorListenerTimeout: bus.iteratorListenerTimeout,
+		IteratorConsumerTimeout: bus.iteratorConsumerTimeout,
+		IteratorEnqueueTimeout:  bus.iteratorEnqueueTimeout,
+		BatchConcurrency:        bus.batchConcurrency,
+		QueryTimeout:            bus.queryTimeout,
+		MaxQueryDepth:           bus.maxQueryDepth,
+		Clock:                   TypeName(bus.clock),
+		Middlewares:             typeNames(bus.middlewares),
+		ContextDecorators:       typeNames(bus.contextDecorators),
+		Validators:              typeNames(bus.validators),
+		Observers:               typeNames(bus.observers),
+		ErrorHandlers:           typeNames(bus.errorHandlers),
+		WarningHandlers:         typeNames(bus.warningHandlers),
+		DeprecationHandlers:     typeNames(bus.deprecationHandlers),
+	}
+	if len(bus.iteratorLaneWorkers) > 0 {
+		cfg.IteratorLanes = make(map[int]int, len(bus.iteratorLaneWorkers))
+		for priority, workers := range bus.iteratorLaneWorkers {
+			cfg.IteratorLanes[priority] = workers
+		}
+	}
+	bus.asyncPool.RLock()
+	cfg.AsyncWorkerPoolSize = bus.asyncPool.size
+	cfg.AsyncQueueBuffer = bus.asyncPool.buffer
+	bus.asyncPool.RUnlock()
+	if cb := bus.breaker; cb != nil {
+		cfg.CircuitBreakerThreshold = cb.threshold
+		cfg.CircuitBreakerOpen = cb.openDuration
+		cfg.CircuitBreakerProbes = cb.probes
+	}
+	if ls := bus.loadShedding; ls != nil {
+		cfg.LoadSheddingTarget = ls.policy.Target
+		cfg.LoadSheddingWindow = ls.policy.Window
+	}
+	bus.coldStart.Lock()
+	cfg.ColdStartWindow = bus.coldStart.window
+	cfg.ColdStartConcurrency = bus.coldStart.concurrency
+	bus.coldStart.Unlock()
+	if bus.transport != nil {
+		cfg.Transport = TypeName(bus.transport)
+	}
+	if bus.cloner != nil {
+		cfg.Cloner = TypeName(bus.cloner)
+	}
+	if bus.deadlinePolicy != nil {
+		cfg.DeadlinePolicy = TypeName(bus.deadlinePolicy)
+	}
+	if bus.workerWrapper != nil {
+		cfg.WorkerWrapper = TypeName(bus.workerWrapper)
+	}
+	return cfg
+}
+
+func (bus *Bus) dumpStats() DumpStats {
+	stats := DumpStats{
+		Initialized:         atomic.LoadUint32(bus.initialized) == 1,
+		ShuttingDown:        atomic.LoadUint32(bus.shuttingDown) == 1,
+		IteratorWorkers:     bus.IteratorWorkers(),
+		IteratorQueueLength: bus.IteratorQueueLength(),
+		DroppedErrors:       bus.DroppedErrors(),
+		NumGoroutine:        runtime.NumGoroutine(),
+		Staleness:           bus.Staleness(),
+		LoadShedding:        bus.LoadSheddingStats(),
+	}
+	if cb := bus.breaker; cb != nil {
+		cb.Lock()
+		circuits := make(map[string]*circuit, len(cb.circuits))
+		for id, c := range cb.circuits {
+			circuits[id] = c
+		}
+		cb.Unlock()
+		for id, c := range circuits {
+			if state := c.currentState(); state != CircuitClosed {
+				if stats.Circuits == nil {
+					stats.Circuits = make(map[string]string)
+				}
+				stats.Circuits[id] = circuitStateNames[state]
+			}
+		}
+	}
+	for _, dep := range bus.Deprecations() {
+		if stats.Deprecations == nil {
+			stats.Deprecations = make(map[string]uint64)
+		}
+		stats.Deprecations[string(dep.QueryID)] = dep.Usages
+	}
+	for _, v := range bus.Views() {
+		dv := DumpedView{
+			Name:         v.Name,
+			Type:         TypeName(v.Query),
+			Materialized: v.Materialized,
+			RefreshedAt:  v.RefreshedAt,
+			Hits:         v.Hits,
+			Misses:       v.Misses,
+			Refreshes:    v.Refreshes,
+		}
+		if v.LastError != nil {
+			dv.LastError = v.LastError.Error()
+		}
+		stats.Views = append(stats.Views, dv)
+	}
+	return stats
+}
+
+var circuitStateNames = map[CircuitState]string{
+	CircuitClosed:   "closed",
+	CircuitOpen:     "open",
+	CircuitHalfOpen: "half-open",
+}
+
+func (bus *Bus) dumpCache() DumpCache {
+	c := DumpCache{Tiers: make([]DumpedCacheTier, 0, len(bus.cache.tiers))}
+	for _, tier := range bus.cache.tiers {
+		dt := DumpedCacheTier{Adapter: TypeName(tier.adp), TTLScale: tier.ttlScale, Entries: -1}
+		if sz, implements := tier.adp.(CacheSizer); implements {
+			dt.Entries = sz.Len()
+		}
+		c.Tiers = append(c.Tiers, dt)
+	}
+	bus.revalidations.Lock()
+	c.Revalidations = len(bus.revalidations.entries)
+	bus.revalidations.Unlock()
+	return c
+}
+
+// diffFields compares the exported fields of the structs.
+func diffFields(prefix string, a, b reflect.Value) []string {
+	var diffs []string
+	for i := 0; i < a.NumField(); i++ {
+		field := a.Type().Field(i)
+		fa, fb := a.Field(i).Interface(), b.Field(i).Interface()
+		if reflect.DeepEqual(fa, fb) {
+			continue
+		}
+		name := strings.Split(field.Tag.Get("json"), ",")[0]
+		if name == "" {
+			name = field.Name
+		}
+		diffs = append(diffs, diffLine(prefix+"."+name, fa, fb))
+	}
+	return diffs
+}
+
+// diffQueries compares the query descriptions by ID.
+func diffQueries(a, b []QueryDescription) []string {
+	descs := make(map[string][2]*QueryDescription)
+	for i := range a {
+		pair := descs[a[i].ID]
+		pair[0] = &a[i]
+		descs[a[i].ID] = pair
+	}
+	for i := range b {
+		pair := descs[b[i].ID]
+		pair[1] = &b[i]
+		descs[b[i].ID] = pair
+	}
+	ids := make([]string, 0, len(descs))
+	for id := range descs {
+		ids = append(ids, id)
+	}
+	sort.Strings(ids)
+
+	var diffs []string
+	for _, id := range ids {
+		pair := descs[id]
+		switch {
+		case pair[1] == nil:
+			diffs = append(diffs, fmt.Sprintf("queries.%s: only in the first dump", id))
+		case pair[0] == nil:
+			diffs = append(diffs, fmt.Sprintf("queries.%s: only in the second dump", id))
+		default:
+			diffs = append(diffs, diffFields("queries."+id, reflect.ValueOf(*pair[0]), reflect.ValueOf(*pair[1]))...)
+		}
+	}
+	return diffs
+}
+
+func diffLine(name string, a, b interface{}) string {
+	return fmt.Sprintf("%s: %v != %v", name, a, b)
+}
@@ -0,0 +1,145 @@
+package query
+
+import (
+	"context"
+	"sync"
+	"sync/atomic"
+)
+
+// ErrorReport describes an error reported to the error handlers.
+type ErrorReport struct {
+	Context context.Context
+	Query   Query
+	Err     error
+}
+
+// BatchErrorHandler can optionally be implemented by the error handlers.
+// With asynchronous error handling, these error handlers receive the errors in batches instead of individually.
+type BatchErrorHandler interface {
+	HandleBatch(reports []ErrorReport)
+}
+
+// AsyncErrorHandling enables the asynchronous dispatch of the errors to the error handlers, removing them from the hot path.
+// The errors are buffered (up to the buffer size) and delivered in batches (up to the batch size) by a separate routine.
+// When the buffer is full, the errors are dropped and counted instead (see DroppedErrors). The ErrorDropped event is also observed.
+// By default, the error handlers are called synchronously. It should be used *before* any query is performed.
+func (bus *Bus) AsyncErrorHandling(buffer int, batchSize int) {
+	if batchSize < 1 {
+		batchSize = 1
+	}
+	bus.errorDispatcher.stop()
+	bus.errorDispatcher = newErrorDispatcher(buffer, batchSize)
+	bus.errorDispatcher.start(bus)
+}
+
+// DroppedErrors returns the number of errors dropped due to the error buffer being full.
+func (bus *Bus) DroppedErrors() uint64 {
+	if bus.errorDispatcher == nil {
+		return 0
+	}
+	return atomic.LoadUint64(bus.errorDispatcher.dropped)
+}
+
+//------Internal------//
+
+// errorDispatcher delivers the errors to the error handlers asynchronously.
+type errorDispatcher struct {
+	sync.RWMutex
+	buffer    int
+	batchSize int
+	running   bool
+	queue     chan ErrorReport
+	done      chan bool
+	dropped   *uint64
+}
+
+func newErrorDispatcher(buffer int, batchSize int) *errorDispatcher {
+	return &errorDispatcher{
+		buffer:    buffer,
+		batchSize: batchSize,
+		dropped:   new(uint64),
+	}
+}
+
+func (d *errorDispatcher) start(bus *Bus) {
+	if d == nil {
+		return
+	}
+	d.Lock()
+	defer d.Unlock()
+	if d.running {
+		return
+	}
+	d.running = true
+	d.queue = make(chan ErrorReport, d.buffer)
+	d.done = make(chan bool)
+	go d.run(bus, d.queue, d.done)
+}
+
+// stop delivers the buffered errors and stops the dispatch routine.
+func (d *errorDispatcher) stop() {
+	if d == nil {
+		return
+	}
+	d.Lock()
+	if !d.running {
+		d.Unlock()
+		return
+	}
+	d.running = false
+	close(d.queue)
+	done := d.done
+	d.Unlock()
+	<-done
+}
+
+// dispatch buffers the report, returning false if the dispatcher is not running.
+func (d *errorDispatcher) dispatch(bus *Bus, rep ErrorReport) bool {
+	d.RLock()
+	defer d.RUnlock()
+	if !d.running {
+		return false
+	}
+	select {
+	case d.queue <- rep:
+	default:
+		atomic.AddUint64(d.dropped, 1)
+		bus.observe(rep.Context, Event{Type: ErrorDropped, Query: rep.Query, Err: rep.Err})
+	}
+	return true
+}
+
+func (d *errorDispatcher) run(bus *Bus, queue chan ErrorReport, done chan bool) {
+	batch := make([]ErrorReport, 0, d.batchSize)
+	for rep := range queue {
+		batch = append(batch[:0], rep)
+	collect:
+		for len(batch) < d.batchSize {
+			select {
+			case rep, ok := <-queue:
+				if !ok {
+					break collect
+				}
+				batch = append(batch, rep)
+			default:
+				break collect
+			}
+		}
+		bus.deliverErrors(batch)
+	}
+	done <- true
+}
+
+func (bus *Bus) deliverErrors(batch []ErrorReport) {
+	for _, errHdl := range bus.errorHandlers {
+		if batchHdl, implements := errHdl.(BatchErrorHandler); implements {
+			reports := make([]ErrorReport, len(batch))
+			copy(reports, batch)
+			batchHdl.HandleBatch(reports)
+			continue
+		}
+		for _, rep := range batch {
+			errHdl.Handle(rep.Context, rep.Query, rep.Err)
+		}
+	}
+}
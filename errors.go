@@ -1,6 +1,9 @@
 package query
 
-import "fmt"
+import (
+	"fmt"
+	"time"
+)
 
 // ErrorInvalidQuery is used when invalid queries are handled.
 type ErrorInvalidQuery string
@@ -26,6 +29,31 @@ func (e ErrorBusIsShuttingDown) Error() string {
 	return string(e)
 }
 
+// ErrorQueryAborted is used when queries in flight are aborted by the shutdown of the bus.
+type ErrorQueryAborted string
+
+// Error returns the string message of ErrorQueryAborted.
+func (e ErrorQueryAborted) Error() string {
+	return string(e)
+}
+
+// ErrorSnapshotReleased is used when the snapshot of pinned queries is requested once released (see Pin).
+type ErrorSnapshotReleased string
+
+// Error returns the string message of ErrorSnapshotReleased.
+func (e ErrorSnapshotReleased) Error() string {
+	return string(e)
+}
+
+// ErrorKind is the type of the sentinel errors identifying the typed errors of the bus.
+// e.g. errors.Is(err, query.ListenerTimedOutError) matches any ErrorListenerTimedOut, while errors.As provides its fields.
+type ErrorKind string
+
+// Error returns the string message of ErrorKind.
+func (e ErrorKind) Error() string {
+	return string(e)
+}
+
 // ErrorNoQueryHandlersFound is used when not a single handler is found for a specific query.
 type ErrorNoQueryHandlersFound struct {
 	query Query
@@ -36,24 +64,779 @@ func (e ErrorNoQueryHandlersFound) Error() string {
 	return fmt.Sprintf("query: no handlers were found for the query %T", e.query)
 }
 
+// Query returns the query of the error.
+func (e ErrorNoQueryHandlersFound) Query() Query {
+	return e.query
+}
+
+// Is reports whether the target is NoQueryHandlersFoundError, for the error to be identified using errors.Is.
+func (e ErrorNoQueryHandlersFound) Is(target error) bool {
+	return target == NoQueryHandlersFoundError
+}
+
 // NewErrorNoQueryHandlersFound creates a new ErrorNoQueryHandlersFound.
 func NewErrorNoQueryHandlersFound(query Query) ErrorNoQueryHandlersFound {
 	return ErrorNoQueryHandlersFound{query: query}
 }
 
-// ErrorQueryTimedOut is used when the handling of a query times out.
-type ErrorQueryTimedOut struct {
-	query Query
+// ErrorListenerTimedOut is used when an iterator query times out waiting for a result listener (see Bus.IteratorListenerTimeout).
+type ErrorListenerTimedOut struct {
+	query   Query
+	elapsed time.Duration
 }
 
-// Error returns the string message of ErrorQueryTimedOut.
-func (e ErrorQueryTimedOut) Error() string {
+// ErrorQueryTimedOut is the former name of ErrorListenerTimedOut.
+//
+// Deprecated: use ErrorListenerTimedOut, the error being only used for the iterator queries without a result listener.
+type ErrorQueryTimedOut = ErrorListenerTimedOut
+
+// Error returns the string message of ErrorListenerTimedOut.
+func (e ErrorListenerTimedOut) Error() string {
 	return fmt.Sprintf("query: the query %T timed out due to lack of result listeners. This may happen if a query was issued but the \"Iterate\" function of the result was not handled", e.query)
 }
 
-// NewErrorQueryTimedOut creates a new ErrorQueryTimedOut.
-func NewErrorQueryTimedOut(query Query) ErrorQueryTimedOut {
-	return ErrorQueryTimedOut{query: query}
+// Query returns the query of the error.
+func (e ErrorListenerTimedOut) Query() Query {
+	return e.query
+}
+
+// Is reports whether the target is ListenerTimedOutError, for the error to be identified using errors.Is.
+func (e ErrorListenerTimedOut) Is(target error) bool {
+	return target == ListenerTimedOutError
+}
+
+// Elapsed returns the time waited for a result listener.
+func (e ErrorListenerTimedOut) Elapsed() time.Duration {
+	return e.elapsed
+}
+
+// NewErrorListenerTimedOut creates a new ErrorListenerTimedOut.
+func NewErrorListenerTimedOut(query Query) ErrorListenerTimedOut {
+	return ErrorListenerTimedOut{query: query}
+}
+
+// NewErrorQueryTimedOut creates a new ErrorListenerTimedOut.
+//
+// Deprecated: use NewErrorListenerTimedOut.
+func NewErrorQueryTimedOut(query Query) ErrorListenerTimedOut {
+	return NewErrorListenerTimedOut(query)
+}
+
+// ErrorInvalidQueryInput is used when the input of a query fails validation.
+type ErrorInvalidQueryInput struct {
+	query Query
+	err   error
+}
+
+// Error returns the string message of ErrorInvalidQueryInput.
+func (e ErrorInvalidQueryInput) Error() string {
+	return fmt.Sprintf("query: invalid input for the query %T: %s", e.query, e.err.Error())
+}
+
+// Unwrap returns the validation error.
+func (e ErrorInvalidQueryInput) Unwrap() error {
+	return e.err
+}
+
+// Query returns the query of the error.
+func (e ErrorInvalidQueryInput) Query() Query {
+	return e.query
+}
+
+// Is reports whether the target is InvalidQueryInputError, for the error to be identified using errors.Is.
+func (e ErrorInvalidQueryInput) Is(target error) bool {
+	return target == InvalidQueryInputError
+}
+
+// NewErrorInvalidQueryInput creates a new ErrorInvalidQueryInput.
+func NewErrorInvalidQueryInput(query Query, err error) ErrorInvalidQueryInput {
+	return ErrorInvalidQueryInput{query: query, err: err}
+}
+
+// ErrorQueueFull is used when an iterator query finds the iterator query queue full.
+// It is passed on to the error handlers whenever the queue is saturated, but only returned if the query is rejected (see Bus.IteratorEnqueueTimeout).
+type ErrorQueueFull struct {
+	query Query
+}
+
+// Error returns the string message of ErrorQueueFull.
+func (e ErrorQueueFull) Error() string {
+	return fmt.Sprintf("query: the iterator query queue is full, unable to enqueue the query %T", e.query)
+}
+
+// Query returns the query of the error.
+func (e ErrorQueueFull) Query() Query {
+	return e.query
+}
+
+// Is reports whether the target is QueueFullError, for the error to be identified using errors.Is.
+func (e ErrorQueueFull) Is(target error) bool {
+	return target == QueueFullError
+}
+
+// NewErrorQueueFull creates a new ErrorQueueFull.
+func NewErrorQueueFull(query Query) ErrorQueueFull {
+	return ErrorQueueFull{query: query}
+}
+
+// ErrorCircuitOpen is used when a query is not handled because the circuit of its type (or of one of its handlers) is open.
+type ErrorCircuitOpen struct {
+	query   Query
+	handler interface{}
+}
+
+// Error returns the string message of ErrorCircuitOpen.
+func (e ErrorCircuitOpen) Error() string {
+	if e.handler != nil {
+		return fmt.Sprintf("query: the circuit of the handler %T is open, failing the query %T", unwrap(e.handler), e.query)
+	}
+	return fmt.Sprintf("query: the circuit of the query %T is open", e.query)
+}
+
+// Query returns the query of the error.
+func (e ErrorCircuitOpen) Query() Query {
+	return e.query
+}
+
+// Handler returns the handler whose circuit is open, if the circuits are keyed by handler (see Bus.CircuitBreakerPerHandler).
+func (e ErrorCircuitOpen) Handler() interface{} {
+	return e.handler
+}
+
+// Is reports whether the target is CircuitOpenError, for the error to be identified using errors.Is.
+func (e ErrorCircuitOpen) Is(target error) bool {
+	return target == CircuitOpenError
+}
+
+// NewErrorCircuitOpen creates a new ErrorCircuitOpen.
+func NewErrorCircuitOpen(query Query) ErrorCircuitOpen {
+	return ErrorCircuitOpen{query: query}
+}
+
+// ErrorHandlerPanicked is used when a handler panics, the panic being recovered by the bus.
+type ErrorHandlerPanicked struct {
+	query   Query
+	handler interface{}
+	value   interface{}
+	stack   []byte
+}
+
+// Error returns the string message of ErrorHandlerPanicked.
+func (e ErrorHandlerPanicked) Error() string {
+	if e.handler == nil {
+		return fmt.Sprintf("query: panic while handling the query %T: %v", e.query, e.value)
+	}
+	return fmt.Sprintf("query: the handler %T panicked while handling the query %T: %v", unwrap(e.handler), e.query, e.value)
+}
+
+// Handler returns the handler that panicked (nil if the panic occurred outside of the handlers, e.g. in a middleware).
+func (e ErrorHandlerPanicked) Handler() interface{} {
+	return e.handler
+}
+
+// Value returns the value provided to panic.
+func (e ErrorHandlerPanicked) Value() interface{} {
+	return e.value
+}
+
+// Stack returns the stack trace of the panic.
+func (e ErrorHandlerPanicked) Stack() []byte {
+	return e.stack
+}
+
+// Query returns the query of the error.
+func (e ErrorHandlerPanicked) Query() Query {
+	return e.query
+}
+
+// Is reports whether the target is HandlerPanickedError, for the error to be identified using errors.Is.
+func (e ErrorHandlerPanicked) Is(target error) bool {
+	return target == HandlerPanickedError
+}
+
+// NewErrorHandlerPanicked creates a new ErrorHandlerPanicked.
+func NewErrorHandlerPanicked(query Query, handler interface{}, value interface{}, stack []byte) ErrorHandlerPanicked {
+	return ErrorHandlerPanicked{query: query, handler: handler, value: value, stack: stack}
+}
+
+// ErrorHandlerFailed is used to wrap the errors returned by the handlers, providing the query and handler context (see Bus.HandlerErrorWrapping).
+// The errors of the handlers remain available through errors.Is and errors.As (or Unwrap).
+type ErrorHandlerFailed struct {
+	query   Query
+	handler interface{}
+	err     error
+}
+
+// Error returns the string message of ErrorHandlerFailed.
+func (e ErrorHandlerFailed) Error() string {
+	return fmt.Sprintf("query: the handler %T failed handling the query %T: %s", unwrap(e.handler), e.query, e.err.Error())
+}
+
+// Handler returns the handler that failed.
+func (e ErrorHandlerFailed) Handler() interface{} {
+	return e.handler
+}
+
+// Unwrap returns the error of the handler.
+func (e ErrorHandlerFailed) Unwrap() error {
+	return e.err
+}
+
+// Query returns the query of the error.
+func (e ErrorHandlerFailed) Query() Query {
+	return e.query
+}
+
+// Is reports whether the target is HandlerFailedError, for the error to be identified using errors.Is.
+func (e ErrorHandlerFailed) Is(target error) bool {
+	return target == HandlerFailedError
+}
+
+// NewErrorHandlerFailed creates a new ErrorHandlerFailed.
+func NewErrorHandlerFailed(query Query, handler interface{}, err error) ErrorHandlerFailed {
+	return ErrorHandlerFailed{query: query, handler: handler, err: err}
+}
+
+// ErrorUnsupportedValue is used when a codec is unable to serialize a value.
+type ErrorUnsupportedValue struct {
+	value interface{}
+}
+
+// Error returns the string message of ErrorUnsupportedValue.
+func (e ErrorUnsupportedValue) Error() string {
+	return fmt.Sprintf("query: the value type %T is not supported by the codec", e.value)
+}
+
+// Value returns the value that is not supported.
+func (e ErrorUnsupportedValue) Value() interface{} {
+	return e.value
+}
+
+// Is reports whether the target is UnsupportedValueError, for the error to be identified using errors.Is.
+func (e ErrorUnsupportedValue) Is(target error) bool {
+	return target == UnsupportedValueError
+}
+
+// ErrorUnexpectedValueType is used when a typed stream is provided a value of another type.
+type ErrorUnexpectedValueType struct {
+	value    interface{}
+	expected string
+}
+
+// Error returns the string message of ErrorUnexpectedValueType.
+func (e ErrorUnexpectedValueType) Error() string {
+	return fmt.Sprintf("query: unexpected value type %T, expected %s", e.value, e.expected)
+}
+
+// Value returns the value of the unexpected type.
+func (e ErrorUnexpectedValueType) Value() interface{} {
+	return e.value
+}
+
+// Expected returns the name of the expected type.
+func (e ErrorUnexpectedValueType) Expected() string {
+	return e.expected
+}
+
+// Is reports whether the target is UnexpectedValueTypeError, for the error to be identified using errors.Is.
+func (e ErrorUnexpectedValueType) Is(target error) bool {
+	return target == UnexpectedValueTypeError
+}
+
+// NewErrorUnexpectedValueType creates a new ErrorUnexpectedValueType.
+func NewErrorUnexpectedValueType(value interface{}, expected string) ErrorUnexpectedValueType {
+	return ErrorUnexpectedValueType{value: value, expected: expected}
+}
+
+// ErrorBatch is used when at least one of the queries of a batch fails.
+type ErrorBatch struct {
+	errors []error
+}
+
+// Error returns the string message of ErrorBatch.
+func (e ErrorBatch) Error() string {
+	failed := len(e.Unwrap())
+	return fmt.Sprintf("query: %d of the %d batch queries failed", failed, len(e.errors))
+}
+
+// Errors returns the errors of the batch queries, in the order of the queries (nil for the successful ones).
+func (e ErrorBatch) Errors() []error {
+	return e.errors
+}
+
+// Is reports whether the target is BatchError, for the error to be identified using errors.Is.
+// The errors of the failed batch queries are also matched by errors.Is, through Unwrap.
+func (e ErrorBatch) Is(target error) bool {
+	return target == BatchError
+}
+
+// Unwrap returns the errors of the failed batch queries.
+func (e ErrorBatch) Unwrap() []error {
+	errs := make([]error, 0, len(e.errors))
+	for _, err := range e.errors {
+		if err != nil {
+			errs = append(errs, err)
+		}
+	}
+	return errs
+}
+
+// NewErrorBatch creates a new ErrorBatch.
+func NewErrorBatch(errors []error) ErrorBatch {
+	return ErrorBatch{errors: errors}
+}
+
+// ErrorDeadlineExtensionDenied is used when the deadline extension requested by an iterator handler is denied (see ExtendDeadline).
+type ErrorDeadlineExtensionDenied struct {
+	query  Query
+	reason error
+}
+
+// Error returns the string message of ErrorDeadlineExtensionDenied.
+func (e ErrorDeadlineExtensionDenied) Error() string {
+	if e.query == nil {
+		return fmt.Sprintf("query: the deadline extension was denied: %s", e.reason.Error())
+	}
+	return fmt.Sprintf("query: the deadline extension of the query %T was denied: %s", e.query, e.reason.Error())
+}
+
+// Query returns the query of the error, nil if the deadline was not applied by the bus to an iterator query.
+func (e ErrorDeadlineExtensionDenied) Query() Query {
+	return e.query
+}
+
+// Is reports whether the target is DeadlineExtensionDeniedError, for the error to be identified using errors.Is.
+func (e ErrorDeadlineExtensionDenied) Is(target error) bool {
+	return target == DeadlineExtensionDeniedError
+}
+
+// Unwrap returns the reason of the denial.
+func (e ErrorDeadlineExtensionDenied) Unwrap() error {
+	return e.reason
+}
+
+// NewErrorDeadlineExtensionDenied creates a new ErrorDeadlineExtensionDenied.
+func NewErrorDeadlineExtensionDenied(query Query, reason error) ErrorDeadlineExtensionDenied {
+	return ErrorDeadlineExtensionDenied{query: query, reason: reason}
+}
+
+// ErrorConcurrencyLimitReached is used when a query is rejected because the concurrency limit of its type (or of a handler) is reached.
+type ErrorConcurrencyLimitReached struct {
+	query   Query
+	handler interface{}
+	limit   int
+}
+
+// Error returns the string message of ErrorConcurrencyLimitReached.
+func (e ErrorConcurrencyLimitReached) Error() string {
+	if e.handler == nil {
+		return fmt.Sprintf("query: the concurrency limit (%d) of the query %T is reached", e.limit, e.query)
+	}
+	return fmt.Sprintf("query: the concurrency limit (%d) of the handler %T is reached handling the query %T", e.limit, unwrap(e.handler), e.query)
+}
+
+// Query returns the query of the error.
+func (e ErrorConcurrencyLimitReached) Query() Query {
+	return e.query
+}
+
+// Handler returns the limited handler, or nil if the limit of the query type was reached.
+func (e ErrorConcurrencyLimitReached) Handler() interface{} {
+	return e.handler
+}
+
+// Limit returns the concurrency limit reached.
+func (e ErrorConcurrencyLimitReached) Limit() int {
+	return e.limit
+}
+
+// Is reports whether the target is ConcurrencyLimitReachedError, for the error to be identified using errors.Is.
+func (e ErrorConcurrencyLimitReached) Is(target error) bool {
+	return target == ConcurrencyLimitReachedError
+}
+
+// NewErrorConcurrencyLimitReached creates a new ErrorConcurrencyLimitReached.
+func NewErrorConcurrencyLimitReached(query Query, handler interface{}, limit int) ErrorConcurrencyLimitReached {
+	return ErrorConcurrencyLimitReached{query: query, handler: handler, limit: limit}
+}
+
+// ErrorSandboxViolation is used when a sandboxed handler exceeds its limits (see Sandbox).
+type ErrorSandboxViolation struct {
+	query   Query
+	handler interface{}
+	reason  string
+}
+
+// Error returns the string message of ErrorSandboxViolation.
+func (e ErrorSandboxViolation) Error() string {
+	return fmt.Sprintf("query: the sandboxed handler %T violated its limits handling the query %T: %s", e.handler, e.query, e.reason)
+}
+
+// Query returns the query of the error.
+func (e ErrorSandboxViolation) Query() Query {
+	return e.query
+}
+
+// Handler returns the sandboxed handler.
+func (e ErrorSandboxViolation) Handler() interface{} {
+	return e.handler
+}
+
+// Reason returns the description of the violated limit.
+func (e ErrorSandboxViolation) Reason() string {
+	return e.reason
+}
+
+// Is reports whether the target is SandboxViolationError, for the error to be identified using errors.Is.
+func (e ErrorSandboxViolation) Is(target error) bool {
+	return target == SandboxViolationError
+}
+
+// NewErrorSandboxViolation creates a new ErrorSandboxViolation.
+func NewErrorSandboxViolation(query Query, handler interface{}, reason string) ErrorSandboxViolation {
+	return ErrorSandboxViolation{query: query, handler: handler, reason: reason}
+}
+
+// ErrorQueryDepthExceeded is used when a nested query exceeds the maximum depth of nested queries (see MaxQueryDepth).
+type ErrorQueryDepthExceeded struct {
+	query   Query
+	lineage Lineage
+	max     int
+}
+
+// Error returns the string message of ErrorQueryDepthExceeded.
+func (e ErrorQueryDepthExceeded) Error() string {
+	return fmt.Sprintf("query: the query %T exceeds the maximum depth (%d) of nested queries", e.query, e.max)
+}
+
+// Query returns the query of the error.
+func (e ErrorQueryDepthExceeded) Query() Query {
+	return e.query
+}
+
+// Lineage returns the lineage of the query.
+func (e ErrorQueryDepthExceeded) Lineage() Lineage {
+	return e.lineage
+}
+
+// Max returns the maximum depth exceeded.
+func (e ErrorQueryDepthExceeded) Max() int {
+	return e.max
+}
+
+// Is reports whether the target is QueryDepthExceededError, for the error to be identified using errors.Is.
+func (e ErrorQueryDepthExceeded) Is(target error) bool {
+	return target == QueryDepthExceededError
+}
+
+// NewErrorQueryDepthExceeded creates a new ErrorQueryDepthExceeded.
+func NewErrorQueryDepthExceeded(query Query, lineage Lineage, max int) ErrorQueryDepthExceeded {
+	return ErrorQueryDepthExceeded{query: query, lineage: lineage, max: max}
+}
+
+// ErrorQueryCycle is used when a query is issued by the handling of the exact same query (same type and values).
+type ErrorQueryCycle struct {
+	query   Query
+	lineage Lineage
+}
+
+// Error returns the string message of ErrorQueryCycle.
+func (e ErrorQueryCycle) Error() string {
+	return fmt.Sprintf("query: the query %T is issued by the handling of the same query (cycle)", e.query)
+}
+
+// Query returns the query of the error.
+func (e ErrorQueryCycle) Query() Query {
+	return e.query
+}
+
+// Lineage returns the lineage of the query.
+func (e ErrorQueryCycle) Lineage() Lineage {
+	return e.lineage
+}
+
+// Is reports whether the target is QueryCycleError, for the error to be identified using errors.Is.
+func (e ErrorQueryCycle) Is(target error) bool {
+	return target == QueryCycleError
+}
+
+// NewErrorQueryCycle creates a new ErrorQueryCycle.
+func NewErrorQueryCycle(query Query, lineage Lineage) ErrorQueryCycle {
+	return ErrorQueryCycle{query: query, lineage: lineage}
+}
+
+// ErrorQueryReentrant is used when an iterator handler issues an iterator query on its own bus while no iterator worker is available to handle it.
+// Waiting for it would deadlock the iterator worker pool.
+type ErrorQueryReentrant struct {
+	query Query
+}
+
+// Error returns the string message of ErrorQueryReentrant.
+func (e ErrorQueryReentrant) Error() string {
+	return fmt.Sprintf("query: the iterator query %T is issued by an iterator handler while no iterator worker is available to handle it", e.query)
+}
+
+// Query returns the query of the error.
+func (e ErrorQueryReentrant) Query() Query {
+	return e.query
+}
+
+// Is reports whether the target is QueryReentrantError, for the error to be identified using errors.Is.
+func (e ErrorQueryReentrant) Is(target error) bool {
+	return target == QueryReentrantError
+}
+
+// NewErrorQueryReentrant creates a new ErrorQueryReentrant.
+func NewErrorQueryReentrant(query Query) ErrorQueryReentrant {
+	return ErrorQueryReentrant{query: query}
+}
+
+// ErrorGoroutineLimitReached is used when the goroutines spawned on behalf of a tree of nested queries reach their limit (see MaxQueryGoroutines).
+type ErrorGoroutineLimitReached struct {
+	query Query
+	limit int
+}
+
+// Error returns the string message of ErrorGoroutineLimitReached.
+func (e ErrorGoroutineLimitReached) Error() string {
+	return fmt.Sprintf("query: the goroutine limit (%d) is reached spawning a goroutine for the query %T", e.limit, e.query)
+}
+
+// Query returns the query of the error.
+func (e ErrorGoroutineLimitReached) Query() Query {
+	return e.query
+}
+
+// Limit returns the goroutine limit reached.
+func (e ErrorGoroutineLimitReached) Limit() int {
+	return e.limit
+}
+
+// Is reports whether the target is GoroutineLimitReachedError, for the error to be identified using errors.Is.
+func (e ErrorGoroutineLimitReached) Is(target error) bool {
+	return target == GoroutineLimitReachedError
+}
+
+// NewErrorGoroutineLimitReached creates a new ErrorGoroutineLimitReached.
+func NewErrorGoroutineLimitReached(query Query, limit int) ErrorGoroutineLimitReached {
+	return ErrorGoroutineLimitReached{query: query, limit: limit}
+}
+
+// ErrorGoroutineLeak is used when a goroutine spawned on behalf of a query is still running once the grace period of the shutdown passed
+// (see GoroutineGracePeriod).
+type ErrorGoroutineLeak struct {
+	goroutine Goroutine
+	running   time.Duration
+}
+
+// Error returns the string message of ErrorGoroutineLeak.
+func (e ErrorGoroutineLeak) Error() string {
+	return fmt.Sprintf("query: the %s goroutine %d of the query %T (lineage %d, root %d) is still running after %s", e.goroutine.Purpose, e.goroutine.ID, e.goroutine.Query, e.goroutine.Lineage.ID, e.goroutine.Lineage.Root, e.running)
+}
+
+// Query returns the query of the error.
+func (e ErrorGoroutineLeak) Query() Query {
+	return e.goroutine.Query
+}
+
+// Goroutine returns the goroutine still running.
+func (e ErrorGoroutineLeak) Goroutine() Goroutine {
+	return e.goroutine
+}
+
+// Running returns for how long the goroutine was running when reported.
+func (e ErrorGoroutineLeak) Running() time.Duration {
+	return e.running
+}
+
+// Is reports whether the target is GoroutineLeakError, for the error to be identified using errors.Is.
+func (e ErrorGoroutineLeak) Is(target error) bool {
+	return target == GoroutineLeakError
+}
+
+// NewErrorGoroutineLeak creates a new ErrorGoroutineLeak.
+func NewErrorGoroutineLeak(goroutine Goroutine, running time.Duration) ErrorGoroutineLeak {
+	return ErrorGoroutineLeak{goroutine: goroutine, running: running}
+}
+
+// ErrorQueryShed is used when a query is shed by the load shedding and no stale result or fallback is available (see LoadShedding).
+type ErrorQueryShed struct {
+	query Query
+}
+
+// Error returns the string message of ErrorQueryShed.
+func (e ErrorQueryShed) Error() string {
+	return fmt.Sprintf("query: the query %T was shed, its handlers being slower than the target latency", e.query)
+}
+
+// Query returns the query of the error.
+func (e ErrorQueryShed) Query() Query {
+	return e.query
+}
+
+// Is reports whether the target is QueryShedError, for the error to be identified using errors.Is.
+func (e ErrorQueryShed) Is(target error) bool {
+	return target == QueryShedError
+}
+
+// NewErrorQueryShed creates a new ErrorQueryShed.
+func NewErrorQueryShed(query Query) ErrorQueryShed {
+	return ErrorQueryShed{query: query}
+}
+
+// ErrorViewNotFound is used when reading (or refreshing) a view that was not materialized (see Materialize).
+type ErrorViewNotFound struct {
+	name string
+}
+
+// Error returns the string message of ErrorViewNotFound.
+func (e ErrorViewNotFound) Error() string {
+	return fmt.Sprintf("query: the view %q is not materialized", e.name)
+}
+
+// Name returns the name of the view.
+func (e ErrorViewNotFound) Name() string {
+	return e.name
+}
+
+// Is reports whether the target is ViewNotFoundError, for the error to be identified using errors.Is.
+func (e ErrorViewNotFound) Is(target error) bool {
+	return target == ViewNotFoundError
+}
+
+// NewErrorViewNotFound creates a new ErrorViewNotFound.
+func NewErrorViewNotFound(name string) ErrorViewNotFound {
+	return ErrorViewNotFound{name: name}
+}
+
+// ErrorResultMigration is used when a serialized result can not be upgraded to the current version of its schema (see Bus.ResultSchema).
+type ErrorResultMigration struct {
+	query   Query
+	version int
+	err     error
+}
+
+// Error returns the string message of ErrorResultMigration.
+func (e ErrorResultMigration) Error() string {
+	return fmt.Sprintf("query: the result of the query %T serialized with the version %d of its schema can not be migrated: %s", e.query, e.version, e.err.Error())
+}
+
+// Unwrap returns the error of the migration.
+func (e ErrorResultMigration) Unwrap() error {
+	return e.err
+}
+
+// Query returns the query of the error.
+func (e ErrorResultMigration) Query() Query {
+	return e.query
+}
+
+// Version returns the version of the schema the result was serialized with.
+func (e ErrorResultMigration) Version() int {
+	return e.version
+}
+
+// Is reports whether the target is ResultMigrationError, for the error to be identified using errors.Is.
+func (e ErrorResultMigration) Is(target error) bool {
+	return target == ResultMigrationError
+}
+
+// NewErrorResultMigration creates a new ErrorResultMigration.
+func NewErrorResultMigration(query Query, version int, err error) ErrorResultMigration {
+	return ErrorResultMigration{query: query, version: version, err: err}
+}
+
+// ErrorConsumerStalled is used when the consumer of an iterator result stalls longer than its consumer timeout (see IteratorConsumerTimeout).
+type ErrorConsumerStalled struct {
+	query   Query
+	timeout time.Duration
+}
+
+// Error returns the string message of ErrorConsumerStalled.
+func (e ErrorConsumerStalled) Error() string {
+	return fmt.Sprintf("query: the consumer of the iterator query %T did not read its result for %s", e.query, e.timeout)
+}
+
+// Query returns the query of the error.
+func (e ErrorConsumerStalled) Query() Query {
+	return e.query
+}
+
+// Timeout returns the consumer timeout exceeded.
+func (e ErrorConsumerStalled) Timeout() time.Duration {
+	return e.timeout
+}
+
+// Is reports whether the target is ConsumerStalledError, for the error to be identified using errors.Is.
+func (e ErrorConsumerStalled) Is(target error) bool {
+	return target == ConsumerStalledError
+}
+
+// NewErrorConsumerStalled creates a new ErrorConsumerStalled.
+func NewErrorConsumerStalled(query Query, timeout time.Duration) ErrorConsumerStalled {
+	return ErrorConsumerStalled{query: query, timeout: timeout}
+}
+
+// ErrorUnauthenticated is used when the caller of a query served outside of the process is not authenticated (see Authorization).
+type ErrorUnauthenticated struct {
+	err error
+}
+
+// Error returns the string message of ErrorUnauthenticated.
+func (e ErrorUnauthenticated) Error() string {
+	if e.err == nil {
+		return "query: the caller is not authenticated"
+	}
+	return fmt.Sprintf("query: the caller is not authenticated: %s", e.err.Error())
+}
+
+// Unwrap returns the error of the authenticator, if any.
+func (e ErrorUnauthenticated) Unwrap() error {
+	return e.err
+}
+
+// Is reports whether the target is UnauthenticatedError, for the error to be identified using errors.Is.
+func (e ErrorUnauthenticated) Is(target error) bool {
+	return target == UnauthenticatedError
+}
+
+// NewErrorUnauthenticated creates a new ErrorUnauthenticated.
+func NewErrorUnauthenticated(err error) ErrorUnauthenticated {
+	return ErrorUnauthenticated{err: err}
+}
+
+// ErrorPermissionDenied is used when the caller of a query (or an admin operation) lacks the permission it requires (see Authorization).
+type ErrorPermissionDenied struct {
+	query      Query
+	permission string
+}
+
+// Error returns the string message of ErrorPermissionDenied.
+func (e ErrorPermissionDenied) Error() string {
+	if e.query == nil {
+		return "query: the caller is not allowed to perform admin operations"
+	}
+	return fmt.Sprintf("query: the caller lacks the permission %q required by the query %T", e.permission, e.query)
+}
+
+// Query returns the query of the error, nil for the admin operations.
+func (e ErrorPermissionDenied) Query() Query {
+	return e.query
+}
+
+// Permission returns the permission required, empty for the admin operations if no admin permission was provided.
+func (e ErrorPermissionDenied) Permission() string {
+	return e.permission
+}
+
+// Is reports whether the target is PermissionDeniedError, for the error to be identified using errors.Is.
+func (e ErrorPermissionDenied) Is(target error) bool {
+	return target == PermissionDeniedError
+}
+
+// NewErrorPermissionDenied creates a new ErrorPermissionDenied.
+func NewErrorPermissionDenied(query Query, permission string) ErrorPermissionDenied {
+	return ErrorPermissionDenied{query: query, permission: permission}
 }
 
 const (
@@ -63,4 +846,59 @@ const (
 	BusNotInitializedError = ErrorBusNotInitialized("query: the bus is not initialized")
 	// BusIsShuttingDownError is a constant equivalent of the ErrorBusIsShuttingDown error.
 	BusIsShuttingDownError = ErrorBusIsShuttingDown("query: the bus is shutting down")
+	// QueryAbortedError is a constant equivalent of the ErrorQueryAborted error.
+	QueryAbortedError = ErrorQueryAborted("query: the query was aborted by the shutdown of the bus")
+	// SnapshotReleasedError is a constant equivalent of the ErrorSnapshotReleased error.
+	SnapshotReleasedError = ErrorSnapshotReleased("query: the snapshot of the pinned queries is released")
+)
+
+const (
+	// NoQueryHandlersFoundError identifies the ErrorNoQueryHandlersFound errors.
+	NoQueryHandlersFoundError = ErrorKind("query: no handlers were found")
+	// ListenerTimedOutError identifies the ErrorListenerTimedOut errors.
+	ListenerTimedOutError = ErrorKind("query: the query timed out waiting for a result listener")
+	// InvalidQueryInputError identifies the ErrorInvalidQueryInput errors.
+	InvalidQueryInputError = ErrorKind("query: invalid query input")
+	// QueueFullError identifies the ErrorQueueFull errors.
+	QueueFullError = ErrorKind("query: the iterator query queue is full")
+	// CircuitOpenError identifies the ErrorCircuitOpen errors.
+	CircuitOpenError = ErrorKind("query: the circuit is open")
+	// HandlerPanickedError identifies the ErrorHandlerPanicked errors.
+	HandlerPanickedError = ErrorKind("query: a handler panicked")
+	// HandlerFailedError identifies the ErrorHandlerFailed errors.
+	HandlerFailedError = ErrorKind("query: a handler failed")
+	// UnsupportedValueError identifies the ErrorUnsupportedValue errors.
+	UnsupportedValueError = ErrorKind("query: the value type is not supported by the codec")
+	// UnexpectedValueTypeError identifies the ErrorUnexpectedValueType errors.
+	UnexpectedValueTypeError = ErrorKind("query: unexpected value type")
+	// BatchError identifies the ErrorBatch errors.
+	BatchError = ErrorKind("query: batch queries failed")
+	// DeadlineExtensionDeniedError identifies the ErrorDeadlineExtensionDenied errors.
+	DeadlineExtensionDeniedError = ErrorKind("query: the deadline extension was denied")
+	// ConcurrencyLimitReachedError identifies the ErrorConcurrencyLimitReached errors.
+	ConcurrencyLimitReachedError = ErrorKind("query: the concurrency limit is reached")
+	// SandboxViolationError identifies the ErrorSandboxViolation errors.
+	SandboxViolationError = ErrorKind("query: a sandboxed handler violated its limits")
+	// QueryDepthExceededError identifies the ErrorQueryDepthExceeded errors.
+	QueryDepthExceededError = ErrorKind("query: the maximum depth of nested queries is exceeded")
+	// QueryCycleError identifies the ErrorQueryCycle errors.
+	QueryCycleError = ErrorKind("query: the query is issued by the handling of the same query")
+	// QueryReentrantError identifies the ErrorQueryReentrant errors.
+	QueryReentrantError = ErrorKind("query: no iterator worker is available for the nested iterator query")
+	// GoroutineLimitReachedError identifies the ErrorGoroutineLimitReached errors.
+	GoroutineLimitReachedError = ErrorKind("query: the goroutine limit of the query is reached")
+	// GoroutineLeakError identifies the ErrorGoroutineLeak errors.
+	GoroutineLeakError = ErrorKind("query: a goroutine of the query is still running after the shutdown")
+	// QueryShedError identifies the ErrorQueryShed errors.
+	QueryShedError = ErrorKind("query: the query was shed")
+	// ViewNotFoundError identifies the ErrorViewNotFound errors.
+	ViewNotFoundError = ErrorKind("query: the view is not materialized")
+	// UnauthenticatedError identifies the ErrorUnauthenticated errors.
+	UnauthenticatedError = ErrorKind("query: the caller is not authenticated")
+	// PermissionDeniedError identifies the ErrorPermissionDenied errors.
+	PermissionDeniedError = ErrorKind("query: the caller lacks the permission required")
+	// ResultMigrationError identifies the ErrorResultMigration errors.
+	ResultMigrationError = ErrorKind("query: the result can not be migrated to the current version of its schema")
+	// ConsumerStalledError identifies the ErrorConsumerStalled errors.
+	ConsumerStalledError = ErrorKind("query: the consumer of the iterator result stalled")
 )
@@ -0,0 +1,51 @@
+package query
+
+import "sync"
+
+// flight represents a query being handled, shared by every concurrent caller of an identical cacheable query.
+type flight struct {
+	done chan bool
+	res  *Result
+	err  error
+}
+
+// flightGroup deduplicates the concurrent handling of identical cacheable queries.
+type flightGroup struct {
+	sync.Mutex
+	flights map[string]*flight
+}
+
+func newFlightGroup() *flightGroup {
+	return &flightGroup{
+		flights: make(map[string]*flight),
+	}
+}
+
+// join returns the flight for the given key and whether the caller is the leader (responsible for landing it).
+func (g *flightGroup) join(key string) (*flight, bool) {
+	g.Lock()
+	defer g.Unlock()
+	if f, inFlight := g.flights[key]; inFlight {
+		return f, false
+	}
+	f := &flight{done: make(chan bool)}
+	g.flights[key] = f
+	return f, true
+}
+
+// land provides the outcome of the flight to every caller waiting for it.
+func (g *flightGroup) land(key string, f *flight, res *Result, err error) {
+	g.Lock()
+	delete(g.flights, key)
+	g.Unlock()
+	f.res = res
+	f.err = err
+	close(f.done)
+}
+
+// len returns the number of flights in progress.
+func (g *flightGroup) len() int {
+	g.Lock()
+	defer g.Unlock()
+	return len(g.flights)
+}
@@ -0,0 +1,211 @@
+package query
+
+import (
+	"context"
+	"sync"
+)
+
+// Future is the struct returned from asynchronous queries (see Bus.QueryAsync).
+// It provides the result of the query once it is handled.
+type Future struct {
+	sync.Mutex
+	bus       *Bus
+	done      chan bool
+	res       *Result
+	err       error
+	callbacks []func(res *Result, err error)
+	calling   bool
+}
+
+// AsyncWorkerPoolSize may optionally be provided to tweak the worker pool size for asynchronous queries.
+// This pool is separate from the iterator query workers. It can only be adjusted *before* any asynchronous query is issued.
+// It defaults to the value returned by runtime.GOMAXPROCS(0).
+func (bus *Bus) AsyncWorkerPoolSize(workerPoolSize int) {
+	if workerPoolSize > 0 {
+		bus.asyncPool.size = workerPoolSize
+	}
+}
+
+// AsyncQueueBuffer may optionally be provided to tweak the buffer size of the asynchronous query queue.
+// When the queue is full, QueryAsync blocks until a worker is available. It can only be adjusted *before* any asynchronous query is issued.
+// It defaults to 100.
+func (bus *Bus) AsyncQueueBuffer(buf int) {
+	bus.asyncPool.buffer = buf
+}
+
+// QueryAsync issues the query as if provided to Bus.Query, but without waiting for it to be handled.
+// The query is handled by the asynchronous worker pool, while the result is provided by the returned Future.
+func (bus *Bus) QueryAsync(ctx context.Context, qry Query) *Future {
+	ftr := &Future{bus: bus, done: make(chan bool)}
+	ctx, done := bus.activity.start(ctx, bus, qry)
+	ctx, warning, err := bus.accept(ctx, qry)
+	if err != nil {
+		done()
+		ftr.resolve(nil, err)
+		return ftr
+	}
+	task := func() {
+		res, err := bus.execute(ctx, qry, warning)
+		done()
+		ftr.resolve(res, err)
+	}
+	if !bus.asyncPool.submit(task) {
+		// the pool was stopped meanwhile
+		_ = spawn(ctx, qry, "async query", false, task)
+	}
+	return ftr
+}
+
+// Result blocks until the query is handled (or the context is done), returning its result.
+// It may be called any number of times.
+func (ftr *Future) Result(ctx context.Context) (*Result, error) {
+	select {
+	case <-ftr.done:
+		return ftr.res, ftr.err
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+}
+
+// Done returns a channel that is closed once the query is handled.
+func (ftr *Future) Done() <-chan bool {
+	return ftr.done
+}
+
+// Then registers a callback to be executed once the query is handled.
+// The callbacks of a future are executed one at a time, in the order they are registered.
+// Those registered once the query is handled are executed by the asynchronous worker pool (or directly, if the pool is stopped).
+func (ftr *Future) Then(fn func(res *Result, err error)) *Future {
+	ftr.Lock()
+	ftr.callbacks = append(ftr.callbacks, fn)
+	select {
+	case <-ftr.done:
+		if ftr.calling {
+			// executed by the callbacks in progress
+			ftr.Unlock()
+			return ftr
+		}
+		ftr.calling = true
+		ftr.Unlock()
+		if !ftr.bus.asyncPool.submitIfRunning(ftr.call) {
+			ftr.call()
+		}
+	default:
+		ftr.Unlock()
+	}
+	return ftr
+}
+
+//------Internal------//
+
+func (ftr *Future) resolve(res *Result, err error) {
+	ftr.Lock()
+	ftr.res = res
+	ftr.err = err
+	close(ftr.done)
+	ftr.calling = true
+	ftr.Unlock()
+	ftr.call()
+}
+
+// call executes the registered callbacks in order, until none remain.
+func (ftr *Future) call() {
+	for {
+		ftr.Lock()
+		if len(ftr.callbacks) == 0 {
+			ftr.calling = false
+			ftr.Unlock()
+			return
+		}
+		fn := ftr.callbacks[0]
+		ftr.callbacks = ftr.callbacks[1:]
+		ftr.Unlock()
+		fn(ftr.res, ftr.err)
+	}
+}
+
+// asyncPool is the worker pool of the asynchronous queries.
+// The workers are started once needed and stopped when the bus shuts down.
+type asyncPool struct {
+	sync.RWMutex
+	size    int
+	buffer  int
+	running bool
+	tasks   chan func()
+	stopped chan bool
+	senders sync.WaitGroup
+	workers sync.WaitGroup
+}
+
+func newAsyncPool(size int) *asyncPool {
+	return &asyncPool{
+		size:   size,
+		buffer: 100,
+	}
+}
+
+// submit the task, starting the pool if needed.
+// It returns false if the pool is stopped before the task is queued.
+func (p *asyncPool) submit(task func()) bool {
+	p.start()
+	return p.submitIfRunning(task)
+}
+
+// submitIfRunning submits the task, unless the pool is stopped before the task is queued.
+// The lock is not held while waiting for the queue, so the pool can be stopped meanwhile.
+func (p *asyncPool) submitIfRunning(task func()) bool {
+	p.RLock()
+	if !p.running {
+		p.RUnlock()
+		return false
+	}
+	tasks, stopped := p.tasks, p.stopped
+	p.senders.Add(1)
+	p.RUnlock()
+	defer p.senders.Done()
+	select {
+	case tasks <- task:
+		return true
+	case <-stopped:
+		return false
+	}
+}
+
+func (p *asyncPool) start() {
+	p.Lock()
+	defer p.Unlock()
+	if p.running {
+		return
+	}
+	p.running = true
+	p.tasks = make(chan func(), p.buffer)
+	p.stopped = make(chan bool)
+	for i := 0; i < p.size; i++ {
+		p.workers.Add(1)
+		go p.work(p.tasks)
+	}
+}
+
+// stop the workers, once the queued tasks are executed.
+// The tasks still waiting for the queue are refused (see submitIfRunning).
+func (p *asyncPool) stop() {
+	p.Lock()
+	if !p.running {
+		p.Unlock()
+		return
+	}
+	p.running = false
+	tasks := p.tasks
+	close(p.stopped)
+	p.Unlock()
+	p.senders.Wait()
+	close(tasks)
+	p.workers.Wait()
+}
+
+func (p *asyncPool) work(tasks chan func()) {
+	defer p.workers.Done()
+	for task := range tasks {
+		task()
+	}
+}
@@ -0,0 +1,268 @@
+package gateway
+
+import (
+	"encoding/binary"
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+
+	"github.com/io-da/query"
+)
+
+// The media types of the encodings registered by default.
+const (
+	MediaTypeJSON   = "application/json"
+	MediaTypeNDJSON = "application/x-ndjson"
+	MediaTypeCSV    = "text/csv"
+)
+
+// StreamEncoder must be implemented for a type to qualify as a stream encoder.
+// Stream encoders write the values of an iterator result while they are yielded.
+// The Close function is invoked once every value was encoded, to complete the stream.
+type StreamEncoder interface {
+	Encode(val interface{}) error
+	Close() error
+}
+
+// StreamEncoding initializes the encoder of a stream, writing to the given writer.
+type StreamEncoding func(w io.Writer) StreamEncoder
+
+// CSVMarshaler may be implemented by result values to be encoded as CSV records.
+// The values of basic types (strings, numbers, booleans) and []string are encoded without it.
+type CSVMarshaler interface {
+	MarshalCSV() ([]string, error)
+}
+
+// JSONArrayStream encodes the streams as a JSON array, writing each value while they are yielded.
+func JSONArrayStream(w io.Writer) StreamEncoder {
+	return &jsonArrayStream{w: w}
+}
+
+// NDJSONStream encodes the streams as newline delimited JSON, one value per line.
+func NDJSONStream(w io.Writer) StreamEncoder {
+	return &ndjsonStream{enc: json.NewEncoder(w)}
+}
+
+// CSVStream encodes the streams as CSV, one record per value (see CSVMarshaler).
+func CSVStream(w io.Writer) StreamEncoder {
+	return &csvStream{w: csv.NewWriter(w)}
+}
+
+//------Internal------//
+
+type jsonArrayStream struct {
+	w       io.Writer
+	started bool
+}
+
+func (s *jsonArrayStream) Encode(val interface{}) error {
+	data, err := json.Marshal(val)
+	if err != nil {
+		return err
+	}
+	sep := ","
+	if !s.started {
+		sep = "["
+		s.started = true
+	}
+	if _, err = io.WriteString(s.w, sep); err != nil {
+		return err
+	}
+	_, err = s.w.Write(data)
+	return err
+}
+
+func (s *jsonArrayStream) Close() error {
+	if !s.started {
+		_, err := io.WriteString(s.w, "[]")
+		return err
+	}
+	_, err := io.WriteString(s.w, "]")
+	return err
+}
+
+type ndjsonStream struct {
+	enc *json.Encoder
+}
+
+func (s *ndjsonStream) Encode(val interface{}) error {
+	return s.enc.Encode(val)
+}
+
+func (s *ndjsonStream) Close() error {
+	return nil
+}
+
+type csvStream struct {
+	w *csv.Writer
+}
+
+func (s *csvStream) Encode(val interface{}) error {
+	record, err := csvRecord(val)
+	if err != nil {
+		return err
+	}
+	if err = s.w.Write(record); err != nil {
+		return err
+	}
+	s.w.Flush()
+	return s.w.Error()
+}
+
+func (s *csvStream) Close() error {
+	s.w.Flush()
+	return s.w.Error()
+}
+
+func csvRecord(val interface{}) ([]string, error) {
+	switch v := val.(type) {
+	case CSVMarshaler:
+		return v.MarshalCSV()
+	case []string:
+		return v, nil
+	case string:
+		return []string{v}, nil
+	case []byte:
+		return []string{string(v)}, nil
+	case bool:
+		return []string{strconv.FormatBool(v)}, nil
+	case int, int8, int16, int32, int64, uint, uint8, uint16, uint32, uint64:
+		return []string{fmt.Sprint(v)}, nil
+	case float32:
+		return []string{strconv.FormatFloat(float64(v), 'g', -1, 32)}, nil
+	case float64:
+		return []string{strconv.FormatFloat(v, 'g', -1, 64)}, nil
+	case fmt.Stringer:
+		return []string{v.String()}, nil
+	}
+	return nil, fmt.Errorf("gateway: the value of type %T cannot be encoded as CSV", val)
+}
+
+// framedStream encodes the streams using a codec, writing each value as a frame prefixed by its length.
+func framedStream(c query.Codec) StreamEncoding {
+	return func(w io.Writer) StreamEncoder {
+		return &framedStreamEncoder{c: c, w: w}
+	}
+}
+
+type framedStreamEncoder struct {
+	c query.Codec
+	w io.Writer
+}
+
+func (s *framedStreamEncoder) Encode(val interface{}) error {
+	data, err := s.c.Marshal(val)
+	if err != nil {
+		return err
+	}
+	frame := binary.BigEndian.AppendUint32(make([]byte, 0, 4+len(data)), uint32(len(data)))
+	_, err = s.w.Write(append(frame, data...))
+	return err
+}
+
+func (s *framedStreamEncoder) Close() error {
+	return nil
+}
+
+// registry keeps the encodings of the media types, in the order they were registered.
+type registry[T any] struct {
+	entries map[string]T
+	order   []string
+}
+
+func newRegistry[T any]() *registry[T] {
+	return &registry[T]{entries: make(map[string]T)}
+}
+
+func (reg *registry[T]) add(mediaType string, entry T) {
+	mediaType = strings.ToLower(mediaType)
+	if _, exists := reg.entries[mediaType]; !exists {
+		reg.order = append(reg.order, mediaType)
+	}
+	reg.entries[mediaType] = entry
+}
+
+func (reg *registry[T]) get(mediaType string) (T, bool) {
+	entry, exists := reg.entries[strings.ToLower(mediaType)]
+	return entry, exists
+}
+
+// negotiate the registered media type preferred by the Accept header (the first registered, if none provided).
+// The media types are ranked by the quality of their most specific media range, then by the order of the media ranges.
+func (reg *registry[T]) negotiate(accept string) (string, T, bool) {
+	var zero T
+	if strings.TrimSpace(accept) == "" {
+		if len(reg.order) == 0 {
+			return "", zero, false
+		}
+		return reg.order[0], reg.entries[reg.order[0]], true
+	}
+	ranges := parseAccept(accept)
+	best, bestQ, bestPos := "", 0.0, 0
+	for _, mediaType := range reg.order {
+		q, pos, matched := match(ranges, mediaType)
+		if !matched || q <= 0 {
+			continue
+		}
+		if best == "" || q > bestQ || (q == bestQ && pos < bestPos) {
+			best, bestQ, bestPos = mediaType, q, pos
+		}
+	}
+	if best == "" {
+		return "", zero, false
+	}
+	return best, reg.entries[best], true
+}
+
+type mediaRange struct {
+	typ     string
+	subtype string
+	q       float64
+}
+
+func parseAccept(accept string) []mediaRange {
+	var ranges []mediaRange
+	for _, part := range strings.Split(accept, ",") {
+		params := strings.Split(part, ";")
+		typ, subtype, found := strings.Cut(strings.ToLower(strings.TrimSpace(params[0])), "/")
+		if !found {
+			continue
+		}
+		rng := mediaRange{typ: typ, subtype: subtype, q: 1}
+		for _, param := range params[1:] {
+			key, value, _ := strings.Cut(strings.TrimSpace(param), "=")
+			if strings.ToLower(key) != "q" {
+				continue
+			}
+			if q, err := strconv.ParseFloat(value, 64); err == nil {
+				rng.q = q
+			}
+		}
+		ranges = append(ranges, rng)
+	}
+	return ranges
+}
+
+// match returns the quality and the position of the most specific media range matching the media type.
+func match(ranges []mediaRange, mediaType string) (float64, int, bool) {
+	typ, subtype, _ := strings.Cut(mediaType, "/")
+	q, pos, specificity := 0.0, 0, -1
+	for i, rng := range ranges {
+		s := -1
+		switch {
+		case rng.typ == typ && rng.subtype == subtype:
+			s = 2
+		case rng.typ == typ && rng.subtype == "*":
+			s = 1
+		case rng.typ == "*" && rng.subtype == "*":
+			s = 0
+		}
+		if s > specificity {
+			q, pos, specificity = rng.q, i, s
+		}
+	}
+	return q, pos, specificity >= 0
+}
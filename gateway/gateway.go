@@ -0,0 +1,330 @@
+// Package gateway provides an HTTP API serving the queries of a bus to consumers outside of the process,
+// encoding the results in the format negotiated with each consumer.
+package gateway
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"mime"
+	"net/http"
+	"reflect"
+	"strings"
+
+	"github.com/io-da/query"
+)
+
+// Handler is the http.Handler serving the queries of a bus.
+// It serves the following routes, relative to where it is mounted:
+//   - POST /queries/{id}: performs the query, responding with its encoded Response.
+//   - POST /iterator/{id}: performs the iterator query, streaming its values while they are yielded.
+//
+// The queries are decoded from the request body, using the codec registered for its Content-Type (JSON by default).
+// The results are encoded in the format negotiated using the Accept header of the request (see Codec and StreamEncoding).
+// The callers may optionally be authenticated using their bearer token, and authorized per query type (see Authorization).
+type Handler struct {
+	bus         *query.Bus
+	auth        *query.Authorization
+	queries     map[string]reflect.Type
+	codecs      *registry[query.Codec]
+	streams     *registry[StreamEncoding]
+	maxBodySize int64
+	mux         *http.ServeMux
+}
+
+// Response is the encoded result of a query.
+type Response struct {
+	Data   []interface{}          `json:"data"`
+	Total  *int                   `json:"total,omitempty"`
+	Cursor string                 `json:"cursor,omitempty"`
+	Meta   map[string]interface{} `json:"meta,omitempty"`
+}
+
+// NewHandler initializes a new HTTP gateway for the provided bus.
+// The JSON encoding (application/json) is registered for both the queries and the streams,
+// along with the newline delimited JSON (application/x-ndjson) and CSV (text/csv) encodings of the streams.
+func NewHandler(bus *query.Bus) *Handler {
+	hdl := &Handler{
+		bus:         bus,
+		queries:     make(map[string]reflect.Type),
+		codecs:      newRegistry[query.Codec](),
+		streams:     newRegistry[StreamEncoding](),
+		maxBodySize: defaultMaxBodySize,
+		mux:         http.NewServeMux(),
+	}
+	hdl.Codec(MediaTypeJSON, query.JSONCodec{})
+	hdl.StreamEncoding(MediaTypeJSON, JSONArrayStream)
+	hdl.StreamEncoding(MediaTypeNDJSON, NDJSONStream)
+	hdl.StreamEncoding(MediaTypeCSV, CSVStream)
+	hdl.mux.HandleFunc("/queries/", hdl.query)
+	hdl.mux.HandleFunc("/iterator/", hdl.iteratorQuery)
+	return hdl
+}
+
+// Register the query types served by the gateway, identified by their ID.
+// The received queries are decoded into new values of the same type as the given queries.
+// It should be used *before* the handler serves any request.
+func (hdl *Handler) Register(qrys ...query.Query) {
+	for _, qry := range qrys {
+		hdl.queries[string(qry.ID())] = reflect.TypeOf(qry)
+	}
+}
+
+// Codec registers the codec of the given media type (e.g. a msgpack codec for application/msgpack).
+// The codec decodes the request bodies of its media type, and encodes the results for the consumers accepting it:
+// the Response of the queries, and each value of the streams not registered with a dedicated StreamEncoding.
+// Those values are written as frames, each prefixed by its length (4 bytes, big-endian).
+// The first registered codec is used when the consumer accepts any media type.
+// It should be used *before* the handler serves any request.
+func (hdl *Handler) Codec(mediaType string, c query.Codec) {
+	hdl.codecs.add(mediaType, c)
+}
+
+// StreamEncoding registers the encoding of the streams of the given media type, replacing the framed encoding of its codec (if any).
+// The first registered stream encoding is used when the consumer accepts any media type.
+// It should be used *before* the handler serves any request.
+func (hdl *Handler) StreamEncoding(mediaType string, enc StreamEncoding) {
+	hdl.streams.add(mediaType, enc)
+}
+
+// Authorization may optionally be provided to authenticate the callers using the bearer token of their Authorization header,
+// and to authorize the query types they perform. The principal of the callers is provided to the handlers (see query.PrincipalFrom).
+// Unauthenticated callers are answered with 401 Unauthorized, the callers lacking the permission of the query with 403 Forbidden.
+func (hdl *Handler) Authorization(auth *query.Authorization) {
+	hdl.auth = auth
+}
+
+// MaxBodySize may optionally be provided to limit the size of the request bodies (in bytes).
+// Larger requests are answered with 413 Request Entity Too Large.
+// It defaults to 1 MiB.
+func (hdl *Handler) MaxBodySize(size int64) {
+	hdl.maxBodySize = size
+}
+
+// ServeHTTP implements the http.Handler interface.
+func (hdl *Handler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	hdl.mux.ServeHTTP(w, r)
+}
+
+//------Internal------//
+
+const (
+	// errorTrailer is the trailer reporting the failures of the streams, once their values were (partially) sent.
+	errorTrailer       = "Query-Error"
+	defaultMaxBodySize = 1 << 20
+)
+
+func (hdl *Handler) query(w http.ResponseWriter, r *http.Request) {
+	ctx, ok := hdl.authenticate(w, r)
+	if !ok {
+		return
+	}
+	qry, ok := hdl.decode(w, r, "/queries/")
+	if !ok || !hdl.authorize(ctx, w, qry) {
+		return
+	}
+	mediaType, c, ok := hdl.codecs.negotiate(r.Header.Get("Accept"))
+	if !ok {
+		notAcceptable(w, hdl.codecs.order)
+		return
+	}
+	res, err := hdl.bus.Query(ctx, qry)
+	if err != nil {
+		fail(w, err)
+		return
+	}
+	resp := &Response{Data: res.All(), Cursor: res.Cursor(), Meta: res.Metadata()}
+	if total, known := res.Total(); known {
+		resp.Total = &total
+	}
+	data, err := c.Marshal(resp)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", mediaType)
+	w.Header().Set("Vary", "Accept")
+	_, _ = w.Write(data)
+}
+
+func (hdl *Handler) iteratorQuery(w http.ResponseWriter, r *http.Request) {
+	ctx, ok := hdl.authenticate(w, r)
+	if !ok {
+		return
+	}
+	qry, ok := hdl.decode(w, r, "/iterator/")
+	if !ok || !hdl.authorize(ctx, w, qry) {
+		return
+	}
+	encs := hdl.streamEncodings()
+	mediaType, enc, ok := encs.negotiate(r.Header.Get("Accept"))
+	if !ok {
+		notAcceptable(w, encs.order)
+		return
+	}
+	res, err := hdl.bus.IteratorQuery(ctx, qry)
+	if err != nil {
+		fail(w, err)
+		return
+	}
+	w.Header().Set("Content-Type", mediaType)
+	w.Header().Set("Vary", "Accept")
+	w.Header().Set("Trailer", errorTrailer)
+	flusher, _ := w.(http.Flusher)
+	stream := enc(w)
+	var writeErr error
+	for val := range res.Iterate() {
+		// the result must be fully iterated, even if the values can no longer be written
+		if writeErr != nil {
+			continue
+		}
+		if writeErr = stream.Encode(val); writeErr == nil && flusher != nil {
+			flusher.Flush()
+		}
+	}
+	if writeErr == nil {
+		writeErr = stream.Close()
+	}
+	if writeErr == nil {
+		writeErr = res.Err()
+	}
+	if writeErr != nil {
+		w.Header().Set(errorTrailer, writeErr.Error())
+	}
+}
+
+// authenticate the caller of the request (if an authorization was provided), answering the request itself if not authenticated.
+func (hdl *Handler) authenticate(w http.ResponseWriter, r *http.Request) (context.Context, bool) {
+	if hdl.auth == nil {
+		return r.Context(), true
+	}
+	ctx, err := hdl.auth.Authenticate(r.Context(), query.BearerToken(r.Header.Get("Authorization")))
+	if err != nil {
+		fail(w, err)
+		return nil, false
+	}
+	return ctx, true
+}
+
+// authorize the caller to perform the query (if an authorization was provided), answering the request itself if not allowed.
+func (hdl *Handler) authorize(ctx context.Context, w http.ResponseWriter, qry query.Query) bool {
+	if hdl.auth == nil {
+		return true
+	}
+	if err := hdl.auth.AuthorizeQuery(ctx, qry); err != nil {
+		fail(w, err)
+		return false
+	}
+	return true
+}
+
+// decode the query identified by the path of the request, answering the request itself if it cannot be decoded.
+func (hdl *Handler) decode(w http.ResponseWriter, r *http.Request, prefix string) (query.Query, bool) {
+	if r.Method != http.MethodPost {
+		w.Header().Set("Allow", http.MethodPost)
+		http.Error(w, http.StatusText(http.StatusMethodNotAllowed), http.StatusMethodNotAllowed)
+		return nil, false
+	}
+	id := strings.TrimPrefix(r.URL.Path, prefix)
+	typ, registered := hdl.queries[id]
+	if !registered {
+		http.Error(w, fmt.Sprintf("gateway: the query %q is not registered", id), http.StatusNotFound)
+		return nil, false
+	}
+	c, ok := hdl.requestCodec(r.Header.Get("Content-Type"))
+	if !ok {
+		http.Error(w, http.StatusText(http.StatusUnsupportedMediaType), http.StatusUnsupportedMediaType)
+		return nil, false
+	}
+	payload, err := io.ReadAll(http.MaxBytesReader(w, r.Body, hdl.maxBodySize))
+	if err != nil {
+		var tooLarge *http.MaxBytesError
+		if errors.As(err, &tooLarge) {
+			http.Error(w, err.Error(), http.StatusRequestEntityTooLarge)
+			return nil, false
+		}
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return nil, false
+	}
+
+	ptr := typ.Kind() == reflect.Ptr
+	if ptr {
+		typ = typ.Elem()
+	}
+	val := reflect.New(typ)
+	if len(payload) > 0 {
+		if err = c.Unmarshal(payload, val.Interface()); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return nil, false
+		}
+	}
+	if !ptr {
+		val = val.Elem()
+	}
+	qry, isQuery := val.Interface().(query.Query)
+	if !isQuery {
+		http.Error(w, fmt.Sprintf("gateway: the query %q is not registered", id), http.StatusNotFound)
+		return nil, false
+	}
+	return qry, true
+}
+
+// requestCodec returns the codec registered for the content type of the request, the JSON codec if not provided.
+func (hdl *Handler) requestCodec(contentType string) (query.Codec, bool) {
+	if contentType == "" {
+		return query.JSONCodec{}, true
+	}
+	mediaType, _, err := mime.ParseMediaType(contentType)
+	if err != nil {
+		return nil, false
+	}
+	return hdl.codecs.get(mediaType)
+}
+
+// streamEncodings returns the encodings available to the streams: the stream encodings, then the framed codecs.
+func (hdl *Handler) streamEncodings() *registry[StreamEncoding] {
+	encs := newRegistry[StreamEncoding]()
+	for _, mediaType := range hdl.streams.order {
+		encs.add(mediaType, hdl.streams.entries[mediaType])
+	}
+	for _, mediaType := range hdl.codecs.order {
+		if _, exists := encs.get(mediaType); !exists {
+			encs.add(mediaType, framedStream(hdl.codecs.entries[mediaType]))
+		}
+	}
+	return encs
+}
+
+// notAcceptable answers the request with the media types available instead.
+func notAcceptable(w http.ResponseWriter, available []string) {
+	http.Error(w, "gateway: none of the accepted media types is available, use one of: "+strings.Join(available, ", "), http.StatusNotAcceptable)
+}
+
+// fail answers the request with the error, challenging the unauthenticated callers.
+func fail(w http.ResponseWriter, err error) {
+	if errors.Is(err, query.UnauthenticatedError) {
+		w.Header().Set("WWW-Authenticate", "Bearer")
+	}
+	http.Error(w, err.Error(), statusOf(err))
+}
+
+// statusOf converts the errors of the bus to HTTP status codes.
+func statusOf(err error) int {
+	if errors.As(err, &query.ErrorNoQueryHandlersFound{}) {
+		return http.StatusNotFound
+	}
+	if errors.Is(err, query.UnauthenticatedError) {
+		return http.StatusUnauthorized
+	}
+	if errors.Is(err, query.PermissionDeniedError) {
+		return http.StatusForbidden
+	}
+	if errors.Is(err, context.DeadlineExceeded) {
+		return http.StatusGatewayTimeout
+	}
+	if errors.Is(err, context.Canceled) {
+		return http.StatusServiceUnavailable
+	}
+	return http.StatusInternalServerError
+}
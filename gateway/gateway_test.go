@@ -0,0 +1,237 @@
+package gateway
+
+import (
+	"context"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/io-da/query"
+)
+
+type testQuery struct {
+	Name string
+}
+
+func (*testQuery) ID() []byte {
+	return []byte("TEST")
+}
+
+type testHandler struct {
+}
+
+func (*testHandler) Handle(_ context.Context, qry query.Query, res *query.Result) error {
+	res.Add("hello " + qry.(*testQuery).Name)
+	res.SetTotal(1)
+	return nil
+}
+
+type testRow struct {
+	Name  string
+	Count int
+}
+
+func (row testRow) MarshalCSV() ([]string, error) {
+	return []string{row.Name, "x" + strings.Repeat("!", row.Count)}, nil
+}
+
+type testIteratorHandler struct {
+}
+
+func (*testIteratorHandler) Handle(_ context.Context, qry query.Query, res *query.IteratorResult) error {
+	for i := 1; i <= 3; i++ {
+		res.Yield(testRow{Name: qry.(*testQuery).Name, Count: i})
+	}
+	return nil
+}
+
+type testMapIteratorHandler struct {
+}
+
+func (*testMapIteratorHandler) Handle(_ context.Context, _ query.Query, res *query.IteratorResult) error {
+	res.Yield(map[string]int{"count": 1})
+	return nil
+}
+
+func newTestHandler(t *testing.T) *Handler {
+	bus := query.NewBus()
+	bus.Handlers(&testHandler{})
+	bus.InitializeIteratorHandlers(&testIteratorHandler{})
+	t.Cleanup(bus.Shutdown)
+
+	hdl := NewHandler(bus)
+	hdl.Register(&testQuery{})
+	return hdl
+}
+
+func serve(hdl *Handler, path string, accept string) *httptest.ResponseRecorder {
+	req := httptest.NewRequest(http.MethodPost, path, strings.NewReader(`{"Name":"gopher"}`))
+	req.Header.Set("Content-Type", "application/json; charset=utf-8")
+	if accept != "" {
+		req.Header.Set("Accept", accept)
+	}
+	rec := httptest.NewRecorder()
+	hdl.ServeHTTP(rec, req)
+	return rec
+}
+
+func TestHandler_Query(t *testing.T) {
+	hdl := newTestHandler(t)
+	hdl.Codec("application/x-gob", query.GobCodec{})
+
+	rec := serve(hdl, "/queries/TEST", "")
+	if rec.Code != http.StatusOK || rec.Header().Get("Content-Type") != MediaTypeJSON {
+		t.Fatalf("Unexpected response %d %s.", rec.Code, rec.Body.String())
+	}
+	resp := &Response{}
+	if err := json.NewDecoder(rec.Body).Decode(resp); err != nil {
+		t.Fatal(err)
+	}
+	if len(resp.Data) != 1 || resp.Data[0] != "hello gopher" || resp.Total == nil || *resp.Total != 1 {
+		t.Errorf("Unexpected response %+v.", resp)
+	}
+
+	rec = serve(hdl, "/queries/TEST", "application/json;q=0.5, application/x-gob")
+	if rec.Code != http.StatusOK || rec.Header().Get("Content-Type") != "application/x-gob" {
+		t.Fatalf("Unexpected response %d %s.", rec.Code, rec.Body.String())
+	}
+	resp = &Response{}
+	if err := (query.GobCodec{}).Unmarshal(rec.Body.Bytes(), resp); err != nil {
+		t.Fatal(err)
+	}
+	if len(resp.Data) != 1 || resp.Data[0] != "hello gopher" {
+		t.Errorf("Unexpected response %+v.", resp)
+	}
+
+	if rec = serve(hdl, "/queries/TEST", "text/csv"); rec.Code != http.StatusNotAcceptable {
+		t.Errorf("Unexpected response %d.", rec.Code)
+	}
+	if rec = serve(hdl, "/queries/TEST", "text/*, application/*;q=0"); rec.Code != http.StatusNotAcceptable {
+		t.Errorf("Unexpected response %d.", rec.Code)
+	}
+	if rec = serve(hdl, "/queries/TEST", "text/html, */*;q=0.1"); rec.Header().Get("Content-Type") != MediaTypeJSON {
+		t.Errorf("Unexpected content type %s.", rec.Header().Get("Content-Type"))
+	}
+	if rec = serve(hdl, "/queries/UNKNOWN", ""); rec.Code != http.StatusNotFound {
+		t.Errorf("Unexpected response %d.", rec.Code)
+	}
+
+	req := httptest.NewRequest(http.MethodPost, "/queries/TEST", strings.NewReader("Name=gopher"))
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	rec = httptest.NewRecorder()
+	if hdl.ServeHTTP(rec, req); rec.Code != http.StatusUnsupportedMediaType {
+		t.Errorf("Unexpected response %d.", rec.Code)
+	}
+	rec = httptest.NewRecorder()
+	if hdl.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/queries/TEST", nil)); rec.Code != http.StatusMethodNotAllowed {
+		t.Errorf("Unexpected response %d.", rec.Code)
+	}
+
+	hdl.MaxBodySize(8)
+	if rec = serve(hdl, "/queries/TEST", ""); rec.Code != http.StatusRequestEntityTooLarge {
+		t.Errorf("Expected the request body to exceed the limit, got %d.", rec.Code)
+	}
+}
+
+func TestHandler_IteratorQuery(t *testing.T) {
+	hdl := newTestHandler(t)
+	hdl.Codec("application/x-gob", query.GobCodec{})
+
+	rec := serve(hdl, "/iterator/TEST", "")
+	var rows []testRow
+	if err := json.Unmarshal(rec.Body.Bytes(), &rows); err != nil || len(rows) != 3 || rows[2].Count != 3 {
+		t.Errorf("Unexpected JSON stream %s (%v).", rec.Body.String(), err)
+	}
+
+	rec = serve(hdl, "/iterator/TEST", MediaTypeNDJSON)
+	if lines := strings.Split(strings.TrimSpace(rec.Body.String()), "\n"); len(lines) != 3 || lines[0] != `{"Name":"gopher","Count":1}` {
+		t.Errorf("Unexpected NDJSON stream %s.", rec.Body.String())
+	}
+
+	rec = serve(hdl, "/iterator/TEST", "application/json;q=0.9, text/csv")
+	if rec.Header().Get("Content-Type") != MediaTypeCSV || rec.Body.String() != "gopher,x!\ngopher,x!!\ngopher,x!!!\n" {
+		t.Errorf("Unexpected CSV stream %s.", rec.Body.String())
+	}
+	if rec.Header().Get(errorTrailer) != "" {
+		t.Errorf("Unexpected stream error %s.", rec.Header().Get(errorTrailer))
+	}
+
+	rec = serve(hdl, "/iterator/TEST", "application/x-gob")
+	if rec.Header().Get("Content-Type") != "application/x-gob" {
+		t.Fatalf("Unexpected content type %s.", rec.Header().Get("Content-Type"))
+	}
+	body := rec.Body.Bytes()
+	frames := 0
+	for len(body) >= 4 {
+		n := binary.BigEndian.Uint32(body)
+		body = body[4+n:]
+		frames++
+	}
+	if frames != 3 || len(body) != 0 {
+		t.Errorf("Expected 3 frames, got %d (%d bytes remaining).", frames, len(body))
+	}
+}
+
+func TestHandler_IteratorQueryFailure(t *testing.T) {
+	bus := query.NewBus()
+	bus.InitializeIteratorHandlers(&testMapIteratorHandler{})
+	t.Cleanup(bus.Shutdown)
+	hdl := NewHandler(bus)
+	hdl.Register(&testQuery{})
+
+	rec := serve(hdl, "/iterator/TEST", MediaTypeCSV)
+	if !strings.Contains(rec.Header().Get(errorTrailer), "cannot be encoded as CSV") {
+		t.Errorf("Expected the stream to fail, got %q.", rec.Header().Get(errorTrailer))
+	}
+}
+
+func TestHandler_Authorization(t *testing.T) {
+	hdl := newTestHandler(t)
+	auth := query.NewAuthorization(query.AuthenticatorFunc(func(_ context.Context, token string) (*query.Principal, error) {
+		if token != "reader" && token != "guest" {
+			return nil, errors.New("invalid token")
+		}
+		p := &query.Principal{Subject: token}
+		if token == "reader" {
+			p.Permissions = []string{"read"}
+		}
+		return p, nil
+	}))
+	auth.Require(&testQuery{}, "read")
+	hdl.Authorization(auth)
+
+	serveAs := func(path string, token string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodPost, path, strings.NewReader(`{"Name":"gopher"}`))
+		if token != "" {
+			req.Header.Set("Authorization", "Bearer "+token)
+		}
+		rec := httptest.NewRecorder()
+		hdl.ServeHTTP(rec, req)
+		return rec
+	}
+	if rec := serveAs("/queries/TEST", "reader"); rec.Code != http.StatusOK {
+		t.Errorf("Unexpected response %d %s.", rec.Code, rec.Body.String())
+	}
+	if rec := serveAs("/iterator/TEST", "reader"); rec.Code != http.StatusOK {
+		t.Errorf("Unexpected response %d %s.", rec.Code, rec.Body.String())
+	}
+	for _, path := range []string{"/queries/TEST", "/iterator/TEST"} {
+		if rec := serveAs(path, ""); rec.Code != http.StatusUnauthorized || rec.Header().Get("WWW-Authenticate") != "Bearer" {
+			t.Errorf("Expected the caller not to be authenticated, got %d.", rec.Code)
+		}
+		if rec := serveAs(path, "forged"); rec.Code != http.StatusUnauthorized {
+			t.Errorf("Expected the caller not to be authenticated, got %d.", rec.Code)
+		}
+		if rec := serveAs(path, "guest"); rec.Code != http.StatusForbidden {
+			t.Errorf("Expected the permission to be denied, got %d.", rec.Code)
+		}
+	}
+	// unauthenticated callers can not probe the registered queries
+	if rec := serveAs("/queries/UNKNOWN", ""); rec.Code != http.StatusUnauthorized {
+		t.Errorf("Expected the caller not to be authenticated, got %d.", rec.Code)
+	}
+}
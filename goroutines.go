@@ -0,0 +1,157 @@
+package query
+
+import (
+	"context"
+	"sort"
+	"sync"
+	"time"
+)
+
+// Goroutine describes a goroutine spawned by the bus on behalf of a query (see Goroutines).
+type Goroutine struct {
+	// ID identifies the goroutine within the bus.
+	ID uint64
+	// Purpose describes why the goroutine was spawned (e.g. "sandbox" or "subscription").
+	Purpose string
+	Query   Query
+	// Lineage is the lineage of the query being handled (or issued) when the goroutine was spawned.
+	Lineage Lineage
+	Started time.Time
+}
+
+// Goroutines returns the goroutines spawned on behalf of the queries and still running, ordered by ID.
+// e.g. the goroutines of the sandboxed handlers abandoned once their timeout passed remain listed until the handlers return.
+func (bus *Bus) Goroutines() []Goroutine {
+	bus.goroutines.Lock()
+	gs := make([]Goroutine, 0, len(bus.goroutines.live))
+	for _, g := range bus.goroutines.live {
+		gs = append(gs, *g)
+	}
+	bus.goroutines.Unlock()
+	sort.Slice(gs, func(i, j int) bool {
+		return gs[i].ID < gs[j].ID
+	})
+	return gs
+}
+
+// MaxQueryGoroutines may optionally be provided to cap the goroutines spawned on behalf of a tree of nested queries (see Lineage).
+// e.g. by the sandboxes, subscriptions, batches or forwarded iterator queries of its handlers.
+// Once the limit is reached, the spawning fails with an ErrorGoroutineLimitReached instead.
+// The goroutines required by the bus (e.g. watching the deadlines) count towards the limit, although they are never refused.
+// A limit lesser or equal to zero disables the cap. It defaults to 0 (unlimited).
+func (bus *Bus) MaxQueryGoroutines(limit int) {
+	bus.goroutines.Lock()
+	bus.goroutines.limit = limit
+	bus.goroutines.Unlock()
+}
+
+// GoroutineGracePeriod may optionally be provided to tweak how long the shutdown waits for the goroutines spawned on behalf of the queries,
+// once the queries are finished. The goroutines still running afterwards are reported to the error handlers with an ErrorGoroutineLeak each.
+// It defaults to 1 second.
+func (bus *Bus) GoroutineGracePeriod(d time.Duration) {
+	bus.goroutines.grace = d
+}
+
+//------Internal------//
+
+const defaultGoroutineGracePeriod = time.Second
+
+// goroutines keeps track of the goroutines spawned on behalf of the queries, counting them per tree of nested queries.
+type goroutines struct {
+	sync.Mutex
+	nextID uint64
+	live   map[uint64]*Goroutine
+	roots  map[uint64]int
+	limit  int
+	grace  time.Duration
+	idle   chan bool
+}
+
+func newGoroutines() *goroutines {
+	return &goroutines{
+		live:  make(map[uint64]*Goroutine),
+		roots: make(map[uint64]int),
+		grace: defaultGoroutineGracePeriod,
+	}
+}
+
+// spawn runs the function in a goroutine spawned on behalf of the query, tracked by the bus providing the context (if any).
+// Capped goroutines are refused once the limit of the tree of the query is reached (see MaxQueryGoroutines).
+// Every goroutine spawned on behalf of a query must use it, so it can be reported if leaked.
+func spawn(ctx context.Context, qry Query, purpose string, capped bool, fn func()) error {
+	lin, tracked := ctx.Value(lineageKey).(*Lineage)
+	if !tracked {
+		go fn()
+		return nil
+	}
+	gs := lin.owner.goroutines
+	g, err := gs.track(lin, qry, purpose, capped, lin.owner.clock.Now())
+	if err != nil {
+		return err
+	}
+	go func() {
+		defer gs.untrack(g)
+		fn()
+	}()
+	return nil
+}
+
+// spawn runs the function in a goroutine spawned by the bus itself on behalf of the query (e.g. the live query of a view),
+// outside of the handling of any query. It is tracked as a root of its own, never refused.
+func (bus *Bus) spawn(qry Query, purpose string, fn func()) {
+	ctx := context.WithValue(context.Background(), lineageKey, &Lineage{Query: qry, owner: bus})
+	_ = spawn(ctx, qry, purpose, false, fn)
+}
+
+func (gs *goroutines) track(lin *Lineage, qry Query, purpose string, capped bool, now time.Time) (*Goroutine, error) {
+	gs.Lock()
+	defer gs.Unlock()
+	if capped && gs.limit > 0 && gs.roots[lin.Root] >= gs.limit {
+		return nil, NewErrorGoroutineLimitReached(qry, gs.limit)
+	}
+	gs.nextID++
+	g := &Goroutine{ID: gs.nextID, Purpose: purpose, Query: qry, Lineage: *lin, Started: now}
+	gs.live[g.ID] = g
+	gs.roots[lin.Root]++
+	return g, nil
+}
+
+func (gs *goroutines) untrack(g *Goroutine) {
+	gs.Lock()
+	defer gs.Unlock()
+	delete(gs.live, g.ID)
+	if gs.roots[g.Lineage.Root]--; gs.roots[g.Lineage.Root] <= 0 {
+		delete(gs.roots, g.Lineage.Root)
+	}
+	if len(gs.live) == 0 && gs.idle != nil {
+		close(gs.idle)
+		gs.idle = nil
+	}
+}
+
+// auditGoroutines waits for the goroutines spawned on behalf of the queries during the grace period,
+// reporting the goroutines still running afterwards.
+func (bus *Bus) auditGoroutines() {
+	gs := bus.goroutines
+	gs.Lock()
+	if len(gs.live) == 0 {
+		gs.Unlock()
+		return
+	}
+	if gs.idle == nil {
+		gs.idle = make(chan bool)
+	}
+	idle := gs.idle
+	gs.Unlock()
+
+	t := bus.clock.NewTimer(gs.grace)
+	defer t.Stop()
+	select {
+	case <-idle:
+		return
+	case <-t.C():
+	}
+	for _, g := range bus.Goroutines() {
+		bus.error(context.Background(), g.Query, NewErrorGoroutineLeak(g, bus.since(g.Started)))
+	}
+}
@@ -0,0 +1,39 @@
+package grpcquery
+
+import (
+	"fmt"
+
+	"google.golang.org/grpc/encoding"
+)
+
+// codecName is the content-subtype of the gRPC codec used by the transport.
+const codecName = "io-da-query"
+
+// rawCodec is the gRPC codec transferring the payloads as they are.
+// The payloads are serialized beforehand using the query.Codec of the transport.
+type rawCodec struct{}
+
+func (rawCodec) Marshal(v interface{}) ([]byte, error) {
+	payload, ok := v.(*[]byte)
+	if !ok {
+		return nil, fmt.Errorf("grpcquery: unexpected message type %T", v)
+	}
+	return *payload, nil
+}
+
+func (rawCodec) Unmarshal(data []byte, v interface{}) error {
+	payload, ok := v.(*[]byte)
+	if !ok {
+		return fmt.Errorf("grpcquery: unexpected message type %T", v)
+	}
+	*payload = append((*payload)[:0], data...)
+	return nil
+}
+
+func (rawCodec) Name() string {
+	return codecName
+}
+
+func init() {
+	encoding.RegisterCodec(rawCodec{})
+}
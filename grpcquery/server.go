@@ -0,0 +1,247 @@
+package grpcquery
+
+import (
+	"context"
+	"errors"
+	"reflect"
+
+	"github.com/io-da/query"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+)
+
+const (
+	serviceName         = "io_da.query.Bus"
+	queryMethod         = "/" + serviceName + "/Query"
+	iteratorQueryMethod = "/" + serviceName + "/IteratorQuery"
+	queryIDKey          = "query-id"
+	snapshotTokenKey    = "query-snapshot-token"
+	authorizationKey    = "authorization"
+)
+
+var serviceDesc = grpc.ServiceDesc{
+	ServiceName: serviceName,
+	HandlerType: (*interface{})(nil),
+	Methods: []grpc.MethodDesc{
+		{MethodName: "Query", Handler: queryHandler},
+	},
+	Streams: []grpc.StreamDesc{
+		{StreamName: "IteratorQuery", Handler: iteratorQueryHandler, ServerStreams: true},
+	},
+}
+
+// Server is the remote peer of the Transport, handling the queries it receives using its bus.
+type Server struct {
+	bus     *query.Bus
+	auth    *query.Authorization
+	codec   query.Codec
+	queries map[string]reflect.Type
+}
+
+// NewServer initializes a new *Server for the provided bus.
+// The codec must match the codec of the transports dispatching the queries.
+func NewServer(bus *query.Bus, codec query.Codec) *Server {
+	return &Server{
+		bus:     bus,
+		codec:   codec,
+		queries: make(map[string]reflect.Type),
+	}
+}
+
+// Register the query types handled by the server, identified by their ID.
+// The received queries are deserialized into new values of the same type as the given queries.
+// It should be used *before* the server handles any request.
+func (srv *Server) Register(qrys ...query.Query) {
+	for _, qry := range qrys {
+		srv.queries[string(qry.ID())] = reflect.TypeOf(qry)
+	}
+}
+
+// Authorization may optionally be provided to authenticate the callers using the bearer token of their "authorization" metadata
+// (e.g. provided by the transports using grpc.WithPerRPCCredentials), and to authorize the query types they perform.
+// The principal of the callers is provided to the handlers (see query.PrincipalFrom).
+// The failures are answered with the Unauthenticated and PermissionDenied status codes.
+func (srv *Server) Authorization(auth *query.Authorization) {
+	srv.auth = auth
+}
+
+// RegisterService registers the query service on the provided gRPC server.
+func (srv *Server) RegisterService(s grpc.ServiceRegistrar) {
+	s.RegisterService(&serviceDesc, srv)
+}
+
+//------Internal------//
+
+func (srv *Server) query(ctx context.Context, payload *[]byte) (*[]byte, error) {
+	ctx, release := pinned(ctx)
+	defer release()
+	ctx, err := srv.authenticate(ctx)
+	if err != nil {
+		return nil, err
+	}
+	qry, err := srv.decode(ctx, *payload)
+	if err != nil {
+		return nil, err
+	}
+	if err = srv.authorize(ctx, qry); err != nil {
+		return nil, err
+	}
+	res, err := srv.bus.Query(ctx, qry)
+	if err != nil {
+		return nil, toStatus(err)
+	}
+	data, err := query.MarshalResult(srv.codec, res)
+	if err != nil {
+		return nil, status.Error(codes.Internal, err.Error())
+	}
+	return &data, nil
+}
+
+func (srv *Server) iteratorQuery(stream grpc.ServerStream, payload []byte) error {
+	ctx, err := srv.authenticate(stream.Context())
+	if err != nil {
+		return err
+	}
+	qry, err := srv.decode(ctx, payload)
+	if err != nil {
+		return err
+	}
+	if err = srv.authorize(ctx, qry); err != nil {
+		return err
+	}
+	ctx, release := pinned(ctx)
+	defer release()
+	res, err := srv.bus.IteratorQuery(ctx, qry)
+	if err != nil {
+		return toStatus(err)
+	}
+	var sendErr error
+	for val := range res.Iterate() {
+		// the result must be fully iterated, even if the values can no longer be sent
+		if sendErr != nil {
+			continue
+		}
+		data, err := query.MarshalValues(srv.codec, []interface{}{val})
+		if err != nil {
+			sendErr = status.Error(codes.Internal, err.Error())
+			continue
+		}
+		sendErr = stream.SendMsg(&data)
+	}
+	if sendErr != nil {
+		return sendErr
+	}
+	if err = res.Err(); err != nil {
+		return toStatus(err)
+	}
+	return nil
+}
+
+// authenticate the caller of the query (if an authorization was provided), providing its principal through the context.
+func (srv *Server) authenticate(ctx context.Context) (context.Context, error) {
+	if srv.auth == nil {
+		return ctx, nil
+	}
+	var token string
+	if md, ok := metadata.FromIncomingContext(ctx); ok && len(md.Get(authorizationKey)) > 0 {
+		token = query.BearerToken(md.Get(authorizationKey)[0])
+	}
+	ctx, err := srv.auth.Authenticate(ctx, token)
+	if err != nil {
+		return nil, toStatus(err)
+	}
+	return ctx, nil
+}
+
+// authorize the caller to perform the query (if an authorization was provided).
+func (srv *Server) authorize(ctx context.Context, qry query.Query) error {
+	if srv.auth == nil {
+		return nil
+	}
+	if err := srv.auth.AuthorizeQuery(ctx, qry); err != nil {
+		return toStatus(err)
+	}
+	return nil
+}
+
+func (srv *Server) decode(ctx context.Context, payload []byte) (query.Query, error) {
+	var id string
+	if md, ok := metadata.FromIncomingContext(ctx); ok && len(md.Get(queryIDKey)) > 0 {
+		id = md.Get(queryIDKey)[0]
+	}
+	typ, registered := srv.queries[id]
+	if !registered {
+		return nil, status.Errorf(codes.Unimplemented, "grpcquery: the query %q is not registered", id)
+	}
+
+	ptr := typ.Kind() == reflect.Ptr
+	if ptr {
+		typ = typ.Elem()
+	}
+	val := reflect.New(typ)
+	if err := srv.codec.Unmarshal(payload, val.Interface()); err != nil {
+		return nil, status.Error(codes.InvalidArgument, err.Error())
+	}
+	if !ptr {
+		val = val.Elem()
+	}
+	qry, isQuery := val.Interface().(query.Query)
+	if !isQuery {
+		return nil, status.Errorf(codes.Unimplemented, "grpcquery: the query %q is not registered", id)
+	}
+	return qry, nil
+}
+
+func queryHandler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	payload := []byte{}
+	if err := dec(&payload); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(*Server).query(ctx, &payload)
+	}
+	info := &grpc.UnaryServerInfo{Server: srv, FullMethod: queryMethod}
+	return interceptor(ctx, &payload, info, func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(*Server).query(ctx, req.(*[]byte))
+	})
+}
+
+func iteratorQueryHandler(srv interface{}, stream grpc.ServerStream) error {
+	payload := []byte{}
+	if err := stream.RecvMsg(&payload); err != nil {
+		return err
+	}
+	return srv.(*Server).iteratorQuery(stream, payload)
+}
+
+// pinned pins the queries to the snapshot of the remote queries, if pinned (see query.Pin).
+// The remote handlers are provided the token of the snapshot, as a query.SnapshotToken.
+func pinned(ctx context.Context) (context.Context, func()) {
+	md, ok := metadata.FromIncomingContext(ctx)
+	if !ok || len(md.Get(snapshotTokenKey)) == 0 {
+		return ctx, func() {}
+	}
+	tok := query.SnapshotToken(md.Get(snapshotTokenKey)[0])
+	return query.Pin(ctx, func(ctx context.Context) (query.Snapshot, error) {
+		return tok, nil
+	})
+}
+
+// toStatus converts the errors of the bus to gRPC status errors.
+func toStatus(err error) error {
+	if errors.As(err, &query.ErrorNoQueryHandlersFound{}) {
+		return status.Error(codes.NotFound, err.Error())
+	}
+	if errors.Is(err, query.UnauthenticatedError) {
+		return status.Error(codes.Unauthenticated, err.Error())
+	}
+	if errors.Is(err, query.PermissionDeniedError) {
+		return status.Error(codes.PermissionDenied, err.Error())
+	}
+	if errors.Is(err, context.Canceled) || errors.Is(err, context.DeadlineExceeded) {
+		return status.FromContextError(err).Err()
+	}
+	return status.Error(codes.Unknown, err.Error())
+}
@@ -0,0 +1,123 @@
+// Package grpcquery provides a query.RemoteTransport dispatching the queries to a remote bus using gRPC.
+// The remote bus is served by the Server, registered on a gRPC server.
+package grpcquery
+
+import (
+	"context"
+	"errors"
+	"io"
+
+	"github.com/io-da/query"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+)
+
+// Transport is the query.RemoteTransport dispatching the queries to a remote Server.
+// The queries and the results are serialized using the provided query.Codec.
+type Transport struct {
+	conn  grpc.ClientConnInterface
+	codec query.Codec
+}
+
+// NewTransport initializes a new *Transport using the provided connection.
+// The codec must be able to serialize the queries (e.g. query.JSONCodec or query.GobCodec).
+func NewTransport(conn grpc.ClientConnInterface, codec query.Codec) *Transport {
+	return &Transport{
+		conn:  conn,
+		codec: codec,
+	}
+}
+
+// Query dispatches the query to the remote server, adding the values of the remote result to the provided result.
+func (tpt *Transport) Query(ctx context.Context, qry query.Query, res *query.Result) error {
+	payload, err := tpt.codec.Marshal(qry)
+	if err != nil {
+		return err
+	}
+	if ctx, err = outgoing(ctx, qry); err != nil {
+		return err
+	}
+	data := []byte{}
+	if err = tpt.conn.Invoke(ctx, queryMethod, &payload, &data, grpc.CallContentSubtype(codecName)); err != nil {
+		return fromStatus(qry, err)
+	}
+	remote, err := query.UnmarshalResult(tpt.codec, data)
+	if err != nil {
+		return err
+	}
+	for _, val := range remote.All() {
+		res.Add(val)
+	}
+	res.Handled()
+	return nil
+}
+
+// IteratorQuery dispatches the iterator query to the remote server, yielding the values of the remote result while they are received.
+func (tpt *Transport) IteratorQuery(ctx context.Context, qry query.Query, res *query.IteratorResult) error {
+	payload, err := tpt.codec.Marshal(qry)
+	if err != nil {
+		return err
+	}
+	if ctx, err = outgoing(ctx, qry); err != nil {
+		return err
+	}
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	stream, err := tpt.conn.NewStream(ctx, &serviceDesc.Streams[0], iteratorQueryMethod, grpc.CallContentSubtype(codecName))
+	if err != nil {
+		return fromStatus(qry, err)
+	}
+	if err = stream.SendMsg(&payload); err != nil {
+		return fromStatus(qry, err)
+	}
+	if err = stream.CloseSend(); err != nil {
+		return fromStatus(qry, err)
+	}
+	for {
+		data := []byte{}
+		if err = stream.RecvMsg(&data); err != nil {
+			if errors.Is(err, io.EOF) {
+				break
+			}
+			return fromStatus(qry, err)
+		}
+		values, err := query.UnmarshalValues(tpt.codec, data)
+		if err != nil {
+			return err
+		}
+		for _, val := range values {
+			res.Yield(val)
+		}
+	}
+	res.Handled()
+	return nil
+}
+
+//------Internal------//
+
+// outgoing provides the metadata of the query to the remote server, including the token of its snapshot if pinned (see query.Pin).
+func outgoing(ctx context.Context, qry query.Query) (context.Context, error) {
+	snap, err := query.SnapshotFrom(ctx)
+	if err != nil {
+		return nil, err
+	}
+	if snap != nil {
+		ctx = metadata.AppendToOutgoingContext(ctx, snapshotTokenKey, snap.Token())
+	}
+	return metadata.AppendToOutgoingContext(ctx, queryIDKey, string(qry.ID())), nil
+}
+
+// fromStatus converts the gRPC status errors to the errors of the bus, when applicable.
+func fromStatus(qry query.Query, err error) error {
+	switch status.Code(err) {
+	case codes.NotFound:
+		return query.NewErrorNoQueryHandlersFound(qry)
+	case codes.Canceled:
+		return context.Canceled
+	case codes.DeadlineExceeded:
+		return context.DeadlineExceeded
+	}
+	return err
+}
@@ -0,0 +1,246 @@
+package grpcquery
+
+import (
+	"context"
+	"errors"
+	"net"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/io-da/query"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+	"google.golang.org/grpc/test/bufconn"
+)
+
+type testQuery struct {
+	Name string
+}
+
+func (*testQuery) ID() []byte {
+	return []byte("TEST")
+}
+
+type testErrorQuery struct {
+}
+
+func (testErrorQuery) ID() []byte {
+	return []byte("TEST-ERROR")
+}
+
+type testUnhandledQuery struct {
+}
+
+func (*testUnhandledQuery) ID() []byte {
+	return []byte("TEST-UNHANDLED")
+}
+
+type testUnregisteredQuery struct {
+}
+
+func (*testUnregisteredQuery) ID() []byte {
+	return []byte("TEST-UNREGISTERED")
+}
+
+type testHandler struct {
+}
+
+func (*testHandler) Handle(ctx context.Context, qry query.Query, res *query.Result) error {
+	switch qry := qry.(type) {
+	case *testQuery:
+		res.Add("hello " + qry.Name)
+		res.Add("bye " + qry.Name)
+		if snap, _ := query.SnapshotFrom(ctx); snap != nil {
+			res.Add("as of " + snap.Token())
+		}
+		if p, authenticated := query.PrincipalFrom(ctx); authenticated {
+			res.Add("for " + p.Subject)
+		}
+	case testErrorQuery:
+		return errors.New("query failed")
+	}
+	return nil
+}
+
+type testIteratorHandler struct {
+}
+
+func (*testIteratorHandler) Handle(_ context.Context, qry query.Query, res *query.IteratorResult) error {
+	switch qry := qry.(type) {
+	case *testQuery:
+		res.Yield("hello " + qry.Name)
+		res.Yield("bye " + qry.Name)
+	case testErrorQuery:
+		return errors.New("query failed")
+	}
+	return nil
+}
+
+func setup(t *testing.T, opts ...func(srv *Server)) *query.Bus {
+	remote := query.NewBus()
+	remote.Handlers(&testHandler{})
+	remote.InitializeIteratorHandlers(&testIteratorHandler{})
+	t.Cleanup(remote.Shutdown)
+
+	srv := NewServer(remote, query.JSONCodec{})
+	srv.Register(&testQuery{}, testErrorQuery{}, &testUnhandledQuery{})
+	for _, opt := range opts {
+		opt(srv)
+	}
+	lis := bufconn.Listen(1024 * 1024)
+	s := grpc.NewServer()
+	srv.RegisterService(s)
+	go func() {
+		_ = s.Serve(lis)
+	}()
+	t.Cleanup(s.Stop)
+
+	conn, err := grpc.Dial("bufnet",
+		grpc.WithContextDialer(func(ctx context.Context, _ string) (net.Conn, error) {
+			return lis.DialContext(ctx)
+		}),
+		grpc.WithTransportCredentials(insecure.NewCredentials()),
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		_ = conn.Close()
+	})
+
+	bus := query.NewBus()
+	bus.RemoteTransport(NewTransport(conn, query.JSONCodec{}))
+	bus.InitializeIteratorHandlers()
+	t.Cleanup(bus.Shutdown)
+	return bus
+}
+
+func TestTransport_Query(t *testing.T) {
+	bus := setup(t)
+
+	res, err := bus.Query(context.Background(), &testQuery{Name: "foo"})
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	if !reflect.DeepEqual(res.All(), []interface{}{"hello foo", "bye foo"}) {
+		t.Errorf("Unexpected remote result %v.", res.All())
+	}
+
+	if _, err = bus.Query(context.Background(), testErrorQuery{}); err == nil || !strings.Contains(err.Error(), "query failed") {
+		t.Errorf("Expected the remote error, got %v.", err)
+	}
+	if _, err = bus.Query(context.Background(), &testUnhandledQuery{}); !errors.As(err, &query.ErrorNoQueryHandlersFound{}) {
+		t.Errorf("Expected no handlers to be found, got %v.", err)
+	}
+	if _, err = bus.Query(context.Background(), &testUnregisteredQuery{}); err == nil || !strings.Contains(err.Error(), "not registered") {
+		t.Errorf("Expected the query not to be registered, got %v.", err)
+	}
+}
+
+func TestTransport_IteratorQuery(t *testing.T) {
+	bus := setup(t)
+
+	iterate := func(qry query.Query) ([]interface{}, error) {
+		res, err := bus.IteratorQuery(context.Background(), qry)
+		if err != nil {
+			return nil, err
+		}
+		values := make([]interface{}, 0)
+		for val := range res.Iterate() {
+			values = append(values, val)
+		}
+		return values, res.Err()
+	}
+
+	values, err := iterate(&testQuery{Name: "foo"})
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	if !reflect.DeepEqual(values, []interface{}{"hello foo", "bye foo"}) {
+		t.Errorf("Unexpected remote values %v.", values)
+	}
+
+	if _, err = iterate(testErrorQuery{}); err == nil || !strings.Contains(err.Error(), "query failed") {
+		t.Errorf("Expected the remote error, got %v.", err)
+	}
+	if _, err = iterate(&testUnhandledQuery{}); !errors.As(err, &query.ErrorNoQueryHandlersFound{}) {
+		t.Errorf("Expected no handlers to be found, got %v.", err)
+	}
+}
+
+func TestTransport_SnapshotPinning(t *testing.T) {
+	bus := setup(t)
+
+	opened := 0
+	ctx, release := query.Pin(context.Background(), func(ctx context.Context) (query.Snapshot, error) {
+		opened++
+		return query.SnapshotToken("LSN-42"), nil
+	})
+	defer release()
+	for i := 0; i < 2; i++ {
+		res, err := bus.Query(ctx, &testQuery{Name: "foo"})
+		if err != nil {
+			t.Fatal(err.Error())
+		}
+		if !reflect.DeepEqual(res.All(), []interface{}{"hello foo", "bye foo", "as of LSN-42"}) {
+			t.Errorf("Unexpected remote result %v.", res.All())
+		}
+	}
+	if opened != 1 {
+		t.Errorf("Expected the snapshot to be opened once, got %d.", opened)
+	}
+}
+
+func TestTransport_Authorization(t *testing.T) {
+	auth := query.NewAuthorization(query.AuthenticatorFunc(func(_ context.Context, token string) (*query.Principal, error) {
+		switch token {
+		case "reader":
+			return &query.Principal{Subject: "reader", Permissions: []string{"read"}}, nil
+		case "guest":
+			return &query.Principal{Subject: "guest"}, nil
+		}
+		return nil, errors.New("invalid token")
+	}))
+	auth.Require(&testQuery{}, "read")
+	bus := setup(t, func(srv *Server) {
+		srv.Authorization(auth)
+	})
+	withToken := func(token string) context.Context {
+		return metadata.AppendToOutgoingContext(context.Background(), "authorization", "Bearer "+token)
+	}
+
+	res, err := bus.Query(withToken("reader"), &testQuery{Name: "foo"})
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	if !reflect.DeepEqual(res.All(), []interface{}{"hello foo", "bye foo", "for reader"}) {
+		t.Errorf("Unexpected remote result %v.", res.All())
+	}
+	if _, err = bus.Query(context.Background(), &testQuery{Name: "foo"}); status.Code(err) != codes.Unauthenticated {
+		t.Errorf("Expected the caller not to be authenticated, got %v.", err)
+	}
+	if _, err = bus.Query(withToken("forged"), &testQuery{Name: "foo"}); status.Code(err) != codes.Unauthenticated {
+		t.Errorf("Expected the caller not to be authenticated, got %v.", err)
+	}
+	if _, err = bus.Query(withToken("guest"), &testQuery{Name: "foo"}); status.Code(err) != codes.PermissionDenied {
+		t.Errorf("Expected the permission to be denied, got %v.", err)
+	}
+	// the query types requiring no permission are allowed to every authenticated caller
+	if _, err = bus.Query(withToken("guest"), &testUnhandledQuery{}); !errors.As(err, &query.ErrorNoQueryHandlersFound{}) {
+		t.Errorf("Expected the query to be allowed, got %v.", err)
+	}
+
+	it, err := bus.IteratorQuery(withToken("guest"), &testQuery{Name: "foo"})
+	if err == nil {
+		for range it.Iterate() {
+		}
+		err = it.Err()
+	}
+	if status.Code(err) != codes.PermissionDenied {
+		t.Errorf("Expected the permission to be denied, got %v.", err)
+	}
+}
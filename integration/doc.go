@@ -0,0 +1,7 @@
+// Package integration runs the cache adapter conformance suite (see the cachetest package) against real cache servers.
+// The servers are spun up as docker containers using dockertest, so the tests are opt-in:
+//
+//	go test -tags integration ./...
+//
+// The tests are skipped if docker is not available.
+package integration
@@ -0,0 +1,69 @@
+//go:build integration
+
+package integration
+
+import (
+	"context"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/io-da/query"
+	"github.com/io-da/query/cachetest"
+	"github.com/io-da/query/rediscache"
+	"github.com/ory/dockertest/v3"
+	"github.com/redis/go-redis/v9"
+)
+
+func TestRedisCacheAdapter(t *testing.T) {
+	addr := runContainer(t, "redis", "7-alpine", "6379/tcp", func(addr string) error {
+		client := redis.NewClient(&redis.Options{Addr: addr})
+		defer client.Close()
+		return client.Ping(context.Background()).Err()
+	})
+
+	for name, codec := range map[string]query.Codec{"json": query.JSONCodec{}, "gob": query.GobCodec{}, "binary": query.NewBinaryCodec()} {
+		t.Run(name, func(t *testing.T) {
+			cachetest.Run(t, cachetest.Suite{
+				New: func(t *testing.T) query.CacheAdapter {
+					client := redis.NewClient(&redis.Options{Addr: addr})
+					if err := client.FlushAll(context.Background()).Err(); err != nil {
+						t.Fatal(err.Error())
+					}
+					return rediscache.NewCacheAdapter(client, codec)
+				},
+			})
+		})
+	}
+}
+
+// runContainer starts the container, waiting for it to be ready, and returns the address of the exposed port.
+// The container is purged once the test finishes.
+func runContainer(t *testing.T, repository string, tag string, port string, ready func(addr string) error) string {
+	t.Helper()
+	pool, err := dockertest.NewPool("")
+	if err != nil {
+		t.Skipf("Docker is not available: %s.", err.Error())
+	}
+	if err = pool.Client.Ping(); err != nil {
+		t.Skipf("Docker is not available: %s.", err.Error())
+	}
+	pool.MaxWait = time.Minute
+
+	resource, err := pool.Run(repository, tag, nil)
+	if err != nil {
+		t.Fatalf("Could not start the %s container: %s.", repository, err.Error())
+	}
+	t.Cleanup(func() {
+		if err := pool.Purge(resource); err != nil {
+			t.Errorf("Could not purge the %s container: %s.", repository, err.Error())
+		}
+	})
+	_ = resource.Expire(uint(pool.MaxWait.Seconds()) * 5)
+
+	addr := fmt.Sprintf("localhost:%s", resource.GetPort(port))
+	if err = pool.Retry(func() error { return ready(addr) }); err != nil {
+		t.Fatalf("The %s container is not ready: %s.", repository, err.Error())
+	}
+	return addr
+}
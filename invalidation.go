@@ -0,0 +1,42 @@
+package query
+
+import "context"
+
+// Taggable may optionally be implemented by cacheable queries, tagging their cached results.
+// Every cached result of a tag can then be invalidated at once (see Bus.InvalidateTags).
+type Taggable interface {
+	CacheTags() [][]byte
+}
+
+// TagExpirer may optionally be implemented by cache adapters supporting tags.
+// The adapters are expected to keep track of the tags of the Taggable queries they cache.
+type TagExpirer interface {
+	ExpireTags(ctx context.Context, tags ...[]byte)
+}
+
+// Invalidate forcibly expires the cached result of the query, in every cache adapter.
+func (bus *Bus) Invalidate(ctx context.Context, qry Cacheable) {
+	bus.cache.Expire(ctx, qry)
+	bus.revalidations.forget(qry.CacheKey())
+	if bus.iteratorCacheLimit > 0 {
+		iqry := iteratorCacheQuery(qry)
+		bus.cache.Expire(ctx, iqry)
+		bus.revalidations.forget(iqry.CacheKey())
+	}
+	bus.views.invalidate(qry.CacheKey(), nil)
+	// cacheable queries are expected to be queries, although not required to
+	q, _ := qry.(Query)
+	bus.observe(ctx, Event{Type: CacheInvalidated, Query: q})
+}
+
+// InvalidateTags forcibly expires the cached results of the queries tagged with any of the provided tags.
+// Only the cache adapters implementing the TagExpirer interface are considered.
+func (bus *Bus) InvalidateTags(ctx context.Context, tags ...[]byte) {
+	if len(tags) == 0 {
+		return
+	}
+	bus.cache.ExpireTags(ctx, tags...)
+	bus.revalidations.forgetTags(tags)
+	bus.views.invalidate(nil, tags)
+	bus.observe(ctx, Event{Type: CacheInvalidated, Tags: tags})
+}
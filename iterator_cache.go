@@ -0,0 +1,95 @@
+package query
+
+import (
+	"context"
+	"sync"
+)
+
+// IteratorCache may optionally be provided to cache the iterator queries implementing the Cacheable interface.
+// The values yielded are buffered (up to the provided limit) and stored through the cache adapters once the query is handled successfully.
+// Results yielding more values than the limit are not cached. Subsequent hits replay the cached values through the iterator result,
+// without using the iterator handlers (the middlewares are still used).
+// The values are cached under the cache key of the query prefixed with "iterator|", apart from the result of the same query performed with Query.
+// It defaults to 0, meaning the iterator queries are not cached.
+func (bus *Bus) IteratorCache(limit int) {
+	bus.iteratorCacheLimit = limit
+}
+
+//------Internal------//
+
+// iteratorRecording buffers the values yielded to an iterator result, to be cached.
+type iteratorRecording struct {
+	sync.Mutex
+	limit      int
+	values     []interface{}
+	overflowed bool
+}
+
+func (rec *iteratorRecording) add(data interface{}) {
+	rec.Lock()
+	defer rec.Unlock()
+	if rec.overflowed {
+		return
+	}
+	if len(rec.values) == rec.limit {
+		rec.overflowed = true
+		rec.values = nil
+		return
+	}
+	rec.values = append(rec.values, data)
+}
+
+func (rec *iteratorRecording) recorded() ([]interface{}, bool) {
+	rec.Lock()
+	defer rec.Unlock()
+	return rec.values, !rec.overflowed
+}
+
+// iteratorCacheQuery caches the values of an iterator query apart from the result of the same query, sharing its duration and tags.
+func iteratorCacheQuery(qry Cacheable) Cacheable {
+	return withCacheKey(qry, append([]byte("iterator|"), qry.CacheKey()...))
+}
+
+// iteratorCacheable returns the cacheable query of the values of the iterator query, if the iterator cache is enabled and the query is cached.
+func (bus *Bus) iteratorCacheable(qry Query) (Cacheable, bool) {
+	if bus.iteratorCacheLimit <= 0 {
+		return nil, false
+	}
+	cqry, implements := qry.(Cacheable)
+	if !implements || cqry.CacheDuration() <= 0 {
+		return nil, false
+	}
+	return iteratorCacheQuery(cqry), true
+}
+
+// replayIterator yields the cached values of the query into the result, returning whether they were found.
+func (bus *Bus) replayIterator(ctx context.Context, qry Query, cqry Cacheable, res *IteratorResult) bool {
+	bus.cacheUsage.record(bus.clock.Now(), qry, cqry.CacheKey())
+	cached := bus.cache.Get(ctx, cqry)
+	if cached == nil {
+		bus.observe(ctx, Event{Type: CacheMiss, Query: qry})
+		return false
+	}
+	bus.served(ctx, CacheHit, qry, cached.CachedAt())
+	res.loadedFromCache()
+	res.Handled()
+	for _, data := range cached.All() {
+		if bus.cloner != nil {
+			data = bus.cloner.Clone(data)
+		}
+		res.Yield(data)
+	}
+	return true
+}
+
+// cacheIterator stores the values recorded from the iterator result, unless they exceeded the limit.
+func (bus *Bus) cacheIterator(ctx context.Context, qry Query, cqry Cacheable, res *IteratorResult) {
+	values, complete := res.recording.recorded()
+	if !complete {
+		return
+	}
+	cached := newCacheableResult(cqry)
+	cached.Set(values)
+	cached.Handled()
+	bus.store(ctx, qry, cqry, cached)
+}
@@ -6,3 +6,9 @@ import "context"
 type IteratorHandler interface {
 	Handle(ctx context.Context, qry Query, res *IteratorResult) error
 }
+
+// IteratorQuerier must be implemented for a type to qualify as a destination of forwarded iterator queries.
+// The Bus implements it.
+type IteratorQuerier interface {
+	IteratorQuery(ctx context.Context, qry Query) (*IteratorResult, error)
+}
@@ -0,0 +1,84 @@
+package query
+
+import (
+	"context"
+	"sync"
+)
+
+// iteratorHandlerSet is the set of iterator handlers (and their routes) of the bus, replaced as a whole.
+// Iterator queries are handled using the set in use when their handling starts.
+type iteratorHandlerSet struct {
+	handlers []IteratorHandler
+	routes   map[string][]IteratorHandler
+	active   *sync.WaitGroup
+}
+
+func newIteratorHandlerSet() *iteratorHandlerSet {
+	return &iteratorHandlerSet{
+		handlers: make([]IteratorHandler, 0),
+		routes:   make(map[string][]IteratorHandler),
+		active:   &sync.WaitGroup{},
+	}
+}
+
+// ReplaceIteratorHandlers replaces the iterator handlers (and the routes provided using HandleIterator) of an initialized bus.
+// The iterator queries being handled finish using the previous handlers, while the others use the new ones.
+// A single iterator query is never handled by a mix of both.
+// This function blocks until every iterator query using the previous handlers is handled, unless the context is done first.
+// The handlers are replaced regardless, returning the context error only signals that the previous ones may still be in use.
+func (bus *Bus) ReplaceIteratorHandlers(ctx context.Context, hdls ...IteratorHandler) error {
+	if !bus.isInitialized() {
+		return BusNotInitializedError
+	}
+	set := newIteratorHandlerSet()
+	bus.registry.Lock()
+	set.handlers = route(set.routes, bus.queries, hdls)
+	prev := bus.iteratorHandlerSet
+	bus.iteratorHandlerSet = set
+	bus.registry.Unlock()
+
+	done := make(chan bool)
+	go func() {
+		prev.active.Wait()
+		close(done)
+	}()
+	select {
+	case <-done:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+//------Internal------//
+
+// acquireIteratorHandlers returns the iterator handlers in use, which must be released once the query is handled.
+func (bus *Bus) acquireIteratorHandlers() *iteratorHandlerSet {
+	bus.registry.RLock()
+	defer bus.registry.RUnlock()
+	set := bus.iteratorHandlerSet
+	set.active.Add(1)
+	return set
+}
+
+func (bus *Bus) currentIteratorHandlers() *iteratorHandlerSet {
+	bus.registry.RLock()
+	defer bus.registry.RUnlock()
+	return bus.iteratorHandlerSet
+}
+
+func (set *iteratorHandlerSet) release() {
+	set.active.Done()
+}
+
+func withIteratorHandlers(ctx context.Context, set *iteratorHandlerSet) context.Context {
+	return context.WithValue(ctx, iteratorHandlersKey, set)
+}
+
+// iteratorHandlers returns the iterator handlers acquired for the query, defaulting to the ones in use.
+func (bus *Bus) iteratorHandlers(ctx context.Context) *iteratorHandlerSet {
+	if set, ok := ctx.Value(iteratorHandlersKey).(*iteratorHandlerSet); ok {
+		return set
+	}
+	return bus.currentIteratorHandlers()
+}
@@ -1,12 +1,20 @@
 package query
 
-import "time"
+import (
+	"context"
+	"sync/atomic"
+	"time"
+)
 
 // IteratorResult is the struct returned from iterator queries.
 type IteratorResult struct {
 	resultCore
 	proxy     chan interface{}
 	listening chan bool
+	err       *atomic.Value
+	closed    *uint32
+	recording *iteratorRecording
+	consumer  *iteratorConsumer
 }
 
 func newIteratorResult(buffer int) *IteratorResult {
@@ -14,6 +22,9 @@ func newIteratorResult(buffer int) *IteratorResult {
 		resultCore: newResultCore(),
 		proxy:      make(chan interface{}, buffer),
 		listening:  make(chan bool, 1),
+		err:        new(atomic.Value),
+		closed:     new(uint32),
+		consumer:   &iteratorConsumer{},
 	}
 }
 
@@ -22,7 +33,27 @@ func newIteratorResult(buffer int) *IteratorResult {
 // Yield is used to provide values while they are being processed
 func (res *IteratorResult) Yield(data interface{}) {
 	res.Handled()
-	res.proxy <- data
+	if res.recording != nil {
+		res.recording.add(data)
+	}
+	res.consumer.send(res.proxy, data)
+}
+
+// Forward issues the query to another bus, yielding every value of its result into this result.
+// It blocks until the forwarded result is fully iterated, returning the error that interrupted its handling, if any.
+// Forwarding a query to the bus handling it requires at least one other available iterator worker.
+func (res *IteratorResult) Forward(ctx context.Context, to IteratorQuerier, qry Query) error {
+	fwdRes, err := to.IteratorQuery(ctx, qry)
+	if err != nil {
+		return err
+	}
+	for data := range fwdRes.Iterate() {
+		res.Yield(data)
+	}
+	if fwdRes.isHandled() {
+		res.Handled()
+	}
+	return fwdRes.Err()
 }
 
 //------Fetch Data------//
@@ -33,24 +64,107 @@ func (res *IteratorResult) Iterate() <-chan interface{} {
 	return res.proxy
 }
 
+// ConsumerTimeout may optionally be provided by the consumer, *before* iterating the result, to limit how long the handlers wait for it
+// to read each value once the buffer of the result is full (see Bus.IteratorConsumerTimeout). A consumer stalling longer than the timeout
+// interrupts the handling of the query: the values yielded afterwards are discarded, the context of the handlers is cancelled (freeing the iterator worker)
+// and the result fails with an ErrorConsumerStalled. A timeout lesser or equal to zero waits for the consumer indefinitely.
+func (res *IteratorResult) ConsumerTimeout(d time.Duration) {
+	atomic.StoreInt64(&res.consumer.timeout, int64(d))
+}
+
+// Err returns the error that interrupted the handling of the query, if any.
+// It should be used once the iteration is finished.
+func (res *IteratorResult) Err() error {
+	if fail, failed := res.err.Load().(iteratorFailure); failed {
+		return fail.err
+	}
+	return nil
+}
+
 //------Internal------//
 
-func (res *IteratorResult) waitListener(timeout time.Duration) bool {
+type iteratorFailure struct {
+	err error
+}
+
+func (res *IteratorResult) waitListener(ctx context.Context, clock Clock, timeout time.Duration) (bool, error) {
 	select {
 	case <-res.listening:
-		return true
+		return true, nil
 	default:
-		t := time.NewTimer(timeout)
+		t := clock.NewTimer(timeout)
+		defer t.Stop()
 		select {
 		case <-res.listening:
-			t.Stop()
-			return true
-		case <-t.C:
-			return false
+			return true, nil
+		case <-ctx.Done():
+			return false, ctx.Err()
+		case <-t.C():
+			return false, nil
 		}
 	}
 }
 
+// iteratorConsumer enforces the consumer timeout of an iterator result.
+type iteratorConsumer struct {
+	timeout int64
+	qry     Query
+	clock   Clock
+	cancel  context.CancelFunc
+	stalled atomic.Value
+}
+
+// consume the result from now on, interrupting the handling of the query once the consumer stalls.
+func (res *IteratorResult) consume(qry Query, clock Clock, cancel context.CancelFunc) {
+	res.consumer.qry = qry
+	res.consumer.clock = clock
+	res.consumer.cancel = cancel
+}
+
+// send the value to the consumer, waiting up to the consumer timeout. The values are discarded once the consumer stalled.
+func (c *iteratorConsumer) send(proxy chan interface{}, data interface{}) {
+	if c.err() != nil {
+		return
+	}
+	timeout := time.Duration(atomic.LoadInt64(&c.timeout))
+	if timeout <= 0 || c.clock == nil {
+		proxy <- data
+		return
+	}
+	select {
+	case proxy <- data:
+		return
+	default:
+	}
+	t := c.clock.NewTimer(timeout)
+	defer t.Stop()
+	select {
+	case proxy <- data:
+	case <-t.C():
+		c.stalled.Store(iteratorFailure{err: NewErrorConsumerStalled(c.qry, timeout)})
+		c.cancel()
+	}
+}
+
+// err returns the ErrorConsumerStalled once the consumer stalled, nil otherwise.
+func (c *iteratorConsumer) err() error {
+	if fail, stalled := c.stalled.Load().(iteratorFailure); stalled {
+		return fail.err
+	}
+	return nil
+}
+
+// record buffers the values yielded from now on (up to the limit), to be cached.
+func (res *IteratorResult) record(limit int) {
+	res.recording = &iteratorRecording{limit: limit}
+}
+
+func (res *IteratorResult) fail(err error) {
+	res.err.Store(iteratorFailure{err: err})
+}
+
 func (res *IteratorResult) close() {
-	close(res.proxy)
+	if atomic.CompareAndSwapUint32(res.closed, 0, 1) {
+		close(res.proxy)
+	}
 }
@@ -0,0 +1,142 @@
+package query
+
+import (
+	"context"
+	"reflect"
+	"sort"
+	"sync/atomic"
+	"time"
+)
+
+// Lineage describes the position of a query within a tree of nested queries.
+// Queries issued on the same bus by the handlers of another query (using the context they were provided) are its children.
+type Lineage struct {
+	// ID identifies the query within the bus.
+	ID uint64
+	// Parent is the ID of the query whose handling issued the query, or 0 for root queries.
+	Parent uint64
+	// Root is the ID of the root query of the tree.
+	Root uint64
+	// Depth is the number of ancestors of the query, 0 for root queries.
+	Depth int
+	Query Query
+	// parent is the lineage of the parent query.
+	parent *Lineage
+	// owner is the bus that issued the query.
+	owner *Bus
+}
+
+// Path returns the queries from the root query to the query (included).
+func (lin Lineage) Path() []Query {
+	path := make([]Query, lin.Depth+1)
+	for l := &lin; l != nil; l = l.parent {
+		path[l.Depth] = l.Query
+	}
+	return path
+}
+
+// ownerFrom returns the bus providing the context: the bus of the query being handled (or issued) with it,
+// or the bus performing a background operation on its behalf (e.g. a cache replication).
+func ownerFrom(ctx context.Context) (*Bus, bool) {
+	if lin, ok := ctx.Value(lineageKey).(*Lineage); ok {
+		return lin.owner, true
+	}
+	owner, ok := ctx.Value(ownerKey).(*Bus)
+	return owner, ok && owner != nil
+}
+
+// LineageFrom returns the lineage of the query being handled (or issued) with the given context, if any.
+func LineageFrom(ctx context.Context) (Lineage, bool) {
+	if lin, ok := ctx.Value(lineageKey).(*Lineage); ok {
+		return *lin, true
+	}
+	return Lineage{}, false
+}
+
+// InFlightQuery describes a query in flight (being issued or handled).
+type InFlightQuery struct {
+	Lineage Lineage
+	Started time.Time
+}
+
+// InFlight returns the queries in flight (including the iterator queries waiting to be handled and the subscriptions), ordered by ID.
+// The nested queries are identified by their lineage.
+func (bus *Bus) InFlight() []InFlightQuery {
+	bus.activity.Lock()
+	queries := make([]InFlightQuery, 0, len(bus.activity.inFlight))
+	for _, f := range bus.activity.inFlight {
+		queries = append(queries, InFlightQuery{Lineage: *f.lineage, Started: f.started})
+	}
+	bus.activity.Unlock()
+	sort.Slice(queries, func(i, j int) bool {
+		return queries[i].Lineage.ID < queries[j].Lineage.ID
+	})
+	return queries
+}
+
+// MaxQueryDepth may optionally be provided to limit the depth of the nested queries (see Lineage), catching runaway recursion.
+// Queries nested deeper are rejected with ErrorQueryDepthExceeded. A depth lesser or equal to zero disables the limit.
+// Regardless of the depth, a query issued by the handling of the exact same query (same type and values) is rejected with ErrorQueryCycle.
+// It defaults to 32.
+func (bus *Bus) MaxQueryDepth(depth int) {
+	bus.maxQueryDepth = depth
+}
+
+//------Internal------//
+
+const defaultMaxQueryDepth = 32
+
+// lineage creates the lineage of the query, as a child of the query of the context if issued by the same bus.
+func lineage(ctx context.Context, owner *Bus, id uint64, qry Query) *Lineage {
+	lin := &Lineage{ID: id, Root: id, Query: qry, owner: owner}
+	if parent, nested := ctx.Value(lineageKey).(*Lineage); nested && parent.owner == owner {
+		lin.Parent = parent.ID
+		lin.Root = parent.Root
+		lin.Depth = parent.Depth + 1
+		lin.parent = parent
+	}
+	return lin
+}
+
+// guardReentrancy verifies whether an iterator worker is available for the iterator query, if issued by an iterator handler of the bus.
+// Otherwise the iterator handler would wait for its nested query while occupying the worker it needs, deadlocking the worker pool.
+func (bus *Bus) guardReentrancy(ctx context.Context, qry Query) error {
+	issuer, nested := ctx.Value(iteratorLaneKey).(*iteratorLane)
+	if !nested || !bus.ownsLane(issuer) {
+		return nil
+	}
+	lane := bus.iteratorLane(qry)
+	lane.Lock()
+	idle := lane.workers - int(atomic.LoadInt32(lane.busy)) - len(lane.queue)
+	lane.Unlock()
+	if idle <= 0 {
+		return NewErrorQueryReentrant(qry)
+	}
+	return nil
+}
+
+func (bus *Bus) ownsLane(lane *iteratorLane) bool {
+	for _, l := range bus.iteratorLanes {
+		if l == lane {
+			return true
+		}
+	}
+	return false
+}
+
+// guardLineage verifies the depth of the query and whether it was issued by the handling of the exact same query.
+func (bus *Bus) guardLineage(ctx context.Context, qry Query) error {
+	lin, ok := ctx.Value(lineageKey).(*Lineage)
+	if !ok || lin.parent == nil {
+		return nil
+	}
+	if bus.maxQueryDepth > 0 && lin.Depth > bus.maxQueryDepth {
+		return NewErrorQueryDepthExceeded(qry, *lin, bus.maxQueryDepth)
+	}
+	for ancestor := lin.parent; ancestor != nil; ancestor = ancestor.parent {
+		if ancestor.Query != nil && string(ancestor.Query.ID()) == string(qry.ID()) && reflect.DeepEqual(ancestor.Query, qry) {
+			return NewErrorQueryCycle(qry, *lin)
+		}
+	}
+	return nil
+}
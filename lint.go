@@ -0,0 +1,105 @@
+package query
+
+import (
+	"context"
+	"fmt"
+)
+
+// LintKind identifies the kind of a LintFinding.
+type LintKind int
+
+const (
+	// LintUnhandledProbe is found when a Probeable handler returns no error but does not handle its probe query (no res.Add, res.Set, res.Yield, res.Handled or res.Done).
+	LintUnhandledProbe LintKind = iota
+	// LintDuplicateHandler is found when the same handler is registered more than once for the same query type, handling it repeatedly.
+	LintDuplicateHandler
+	// LintCacheableIteratorQuery is found when an iterator handler is routed a cacheable query type, while the iterator queries are not cached (see IteratorCache).
+	LintCacheableIteratorQuery
+)
+
+// LintFinding describes a likely bug found in the registration of a handler.
+type LintFinding struct {
+	Kind    LintKind
+	Query   Query
+	Handler interface{}
+	Message string
+}
+
+// LintReport is the outcome of the bus checks, containing every LintFinding.
+type LintReport []LintFinding
+
+// Passed can be used to verify if nothing was found.
+func (rep LintReport) Passed() bool {
+	return len(rep) == 0
+}
+
+// Lint checks the handlers and iterator handlers registered, reporting the likely bugs found.
+// The Probeable handlers are provided their probe query (see Bus.SelfTest), verifying they handle it.
+// Queries handled by handlers provided every query can not be known by the bus, only the routed query types are checked.
+func (bus *Bus) Lint(ctx context.Context) LintReport {
+	rep := make(LintReport, 0)
+	set := bus.currentIteratorHandlers()
+	bus.registry.RLock()
+	rep = lintDuplicates(rep, bus.routes, bus.handlers, bus.queries)
+	rep = lintDuplicates(rep, set.routes, set.handlers, bus.queries)
+	if bus.iteratorCacheLimit <= 0 {
+		rep = lintCacheable(rep, set.routes, bus.queries)
+	}
+	bus.registry.RUnlock()
+
+	for _, prb := range bus.SelfTest(ctx) {
+		if _, unhandled := prb.Err.(ErrorNoQueryHandlersFound); unhandled {
+			rep = append(rep, LintFinding{
+				Kind:    LintUnhandledProbe,
+				Query:   prb.Query,
+				Handler: prb.Handler,
+				Message: fmt.Sprintf("the handler %s did not handle its probe query %s", TypeName(prb.Handler), TypeName(prb.Query)),
+			})
+		}
+	}
+	return rep
+}
+
+// LintOnInitialize may optionally be provided to lint the bus once initialized (see Lint), providing the report to the given function.
+// It should be used *before* the bus is initialized.
+func (bus *Bus) LintOnInitialize(fn func(rep LintReport)) {
+	bus.lintReporter = fn
+}
+
+//------Internal------//
+
+func lintDuplicates[H any](rep LintReport, routes map[string][]H, broadcast []H, queries map[string]Query) LintReport {
+	for _, key := range sortedKeys(routes) {
+		seen := make([]H, 0, len(routes[key]))
+		for _, hdl := range routes[key] {
+			if contains(seen, hdl) || contains(broadcast, hdl) {
+				rep = append(rep, LintFinding{
+					Kind:    LintDuplicateHandler,
+					Query:   queries[key],
+					Handler: hdl,
+					Message: fmt.Sprintf("the handler %s is registered more than once for the query %s", TypeName(hdl), TypeName(queries[key])),
+				})
+				continue
+			}
+			seen = append(seen, hdl)
+		}
+	}
+	return rep
+}
+
+func lintCacheable(rep LintReport, routes map[string][]IteratorHandler, queries map[string]Query) LintReport {
+	for _, key := range sortedKeys(routes) {
+		if _, cacheable := queries[key].(Cacheable); !cacheable {
+			continue
+		}
+		for _, hdl := range routes[key] {
+			rep = append(rep, LintFinding{
+				Kind:    LintCacheableIteratorQuery,
+				Query:   queries[key],
+				Handler: hdl,
+				Message: fmt.Sprintf("the iterator handler %s handles the cacheable query %s, iterator queries are not cached", TypeName(hdl), TypeName(queries[key])),
+			})
+		}
+	}
+	return rep
+}
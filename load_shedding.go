@@ -0,0 +1,214 @@
+package query
+
+import (
+	"context"
+	"sort"
+	"sync"
+	"time"
+)
+
+// LoadSheddingPolicy configures the adaptive load shedding (see Bus.LoadShedding).
+type LoadSheddingPolicy struct {
+	// Target is the latency expected of the handling of the queries of each type.
+	Target time.Duration
+	// Window is the interval between the adjustments of the share of the traffic shed. It defaults to 1 second.
+	Window time.Duration
+	// Step is the share of the traffic added to (or removed from) the share shed at every adjustment. It defaults to 0.1 (10%).
+	Step float64
+	// Max is the highest share of the traffic shed, the remaining queries measuring the recovery. It defaults to 0.9 (90%).
+	Max float64
+	// Fallback may optionally be provided to serve the queries shed when no stale result is available, providing the result as a handler would.
+	// Returning an error fails the query shed.
+	Fallback func(ctx context.Context, qry Query, res *Result) error
+}
+
+// LoadSheddingStats describes the load shedding of a query type (see Bus.LoadSheddingStats).
+type LoadSheddingStats struct {
+	// ID is the ID of the query type.
+	ID string
+	// Latency is the mean latency of the queries handled during the last window.
+	Latency time.Duration
+	// Share is the share of the traffic currently shed, between 0 and the maximum of the policy.
+	Share float64
+	// Shed is the number of queries shed.
+	Shed uint64
+}
+
+// LoadShedding may optionally be enabled to progressively shed the traffic of the query types handled slower than the target latency.
+// At the end of every window, the share of the traffic shed by a query type (identified by its ID) grows by a step while its mean latency
+// exceeds the target, and shrinks by a step once it recovers. The queries shed are not handled: the expired results kept for their revalidation
+// are served instead (see Result.Revalidate), otherwise the fallback of the policy, otherwise they fail with an ErrorQueryShed.
+// Only the regular queries are shed, the cached results being still provided. The adjustments are provided to the observers
+// (LoadSheddingAdjusted events, along with the mean latency as duration), as are the queries shed (QueryShed events).
+// A target lesser or equal to zero disables the load shedding. It is disabled by default.
+// It should be used *before* any query is performed.
+func (bus *Bus) LoadShedding(p LoadSheddingPolicy) {
+	if p.Target <= 0 {
+		bus.loadShedding = nil
+		return
+	}
+	if p.Window <= 0 {
+		p.Window = time.Second
+	}
+	if p.Step <= 0 {
+		p.Step = 0.1
+	}
+	if p.Max <= 0 || p.Max > 1 {
+		p.Max = 0.9
+	}
+	bus.loadShedding = &loadShedding{policy: p, shedders: make(map[string]*shedder)}
+}
+
+// LoadSheddingStats returns the load shedding of the query types handled since it was enabled, ordered by ID.
+func (bus *Bus) LoadSheddingStats() []LoadSheddingStats {
+	ls := bus.loadShedding
+	if ls == nil {
+		return nil
+	}
+	ls.Lock()
+	stats := make([]LoadSheddingStats, 0, len(ls.shedders))
+	for id, s := range ls.shedders {
+		s.Lock()
+		stats = append(stats, LoadSheddingStats{ID: id, Latency: s.latency, Share: s.share, Shed: s.shed})
+		s.Unlock()
+	}
+	ls.Unlock()
+	sort.Slice(stats, func(i, j int) bool {
+		return stats[i].ID < stats[j].ID
+	})
+	return stats
+}
+
+//------Internal------//
+
+type loadShedding struct {
+	sync.Mutex
+	policy   LoadSheddingPolicy
+	shedders map[string]*shedder
+}
+
+func (ls *loadShedding) shedder(key string, now time.Time) *shedder {
+	ls.Lock()
+	defer ls.Unlock()
+	s, exists := ls.shedders[key]
+	if !exists {
+		s = &shedder{windowStart: now}
+		ls.shedders[key] = s
+	}
+	return s
+}
+
+// shedder is the feedback controller of the load shedding of a query type.
+type shedder struct {
+	sync.Mutex
+	share       float64
+	credit      float64
+	windowStart time.Time
+	sum         time.Duration
+	samples     int
+	latency     time.Duration
+	shed        uint64
+}
+
+// enter decides whether the query is shed at the given moment, spreading the queries shed evenly. It also reports whether the share was adjusted.
+func (s *shedder) enter(p LoadSheddingPolicy, now time.Time) (bool, bool) {
+	s.Lock()
+	defer s.Unlock()
+	adjusted := s.adjust(p, now)
+	s.credit += s.share
+	if s.credit < 1 {
+		return false, adjusted
+	}
+	s.credit--
+	s.shed++
+	return true, adjusted
+}
+
+// exit records the latency of a query handled until the given moment, reporting whether the share was adjusted.
+func (s *shedder) exit(p LoadSheddingPolicy, now time.Time, latency time.Duration) bool {
+	s.Lock()
+	defer s.Unlock()
+	s.sum += latency
+	s.samples++
+	return s.adjust(p, now)
+}
+
+// adjust the share of the traffic shed once the window passed. The lock must be held by the caller.
+func (s *shedder) adjust(p LoadSheddingPolicy, now time.Time) bool {
+	if now.Sub(s.windowStart) < p.Window {
+		return false
+	}
+	prev := s.share
+	s.latency = 0
+	if s.samples > 0 {
+		s.latency = s.sum / time.Duration(s.samples)
+	}
+	if s.latency > p.Target {
+		if s.share += p.Step; s.share > p.Max {
+			s.share = p.Max
+		}
+	} else if s.share -= p.Step; s.share < p.Step/2 {
+		// avoids floating point leftovers keeping a residual share
+		s.share = 0
+	}
+	if s.share == 0 {
+		s.credit = 0
+	}
+	s.windowStart = now
+	s.sum = 0
+	s.samples = 0
+	return s.share != prev
+}
+
+// shed verifies whether the query is shed, returning the result serving it instead (nil if the query failed with the returned error).
+func (bus *Bus) shed(ctx context.Context, qry Query) (bool, *Result, error) {
+	ls := bus.loadShedding
+	if ls == nil {
+		return false, nil, nil
+	}
+	now := bus.clock.Now()
+	s := ls.shedder(string(qry.ID()), now)
+	shed, adjusted := s.enter(ls.policy, now)
+	if adjusted {
+		bus.observeLoadShedding(ctx, qry, s)
+	}
+	if !shed {
+		return false, nil, nil
+	}
+	bus.observe(ctx, Event{Type: QueryShed, Query: qry})
+	if cqry, implements := qry.(Cacheable); implements {
+		if res, stale := bus.revalidations.stale(now, cqry.CacheKey()); stale {
+			cp := res.copy()
+			cp.loadedFromCache()
+			return true, bus.clone(cp), nil
+		}
+	}
+	if ls.policy.Fallback != nil {
+		res := newResult()
+		if err := ls.policy.Fallback(ctx, qry, res); err != nil {
+			return true, nil, err
+		}
+		return true, res, nil
+	}
+	return true, nil, NewErrorQueryShed(qry)
+}
+
+// measure records the latency of the handling of the query, started at the given moment.
+func (bus *Bus) measure(ctx context.Context, qry Query, start time.Time) {
+	ls := bus.loadShedding
+	if ls == nil {
+		return
+	}
+	now := bus.clock.Now()
+	s := ls.shedder(string(qry.ID()), now)
+	if s.exit(ls.policy, now, now.Sub(start)) {
+		bus.observeLoadShedding(ctx, qry, s)
+	}
+}
+
+func (bus *Bus) observeLoadShedding(ctx context.Context, qry Query, s *shedder) {
+	s.Lock()
+	latency := s.latency
+	s.Unlock()
+	bus.observe(ctx, Event{Type: LoadSheddingAdjusted, Query: qry, Duration: latency})
+}
@@ -8,12 +8,17 @@ import (
 )
 
 // MemoryCacheAdapter is the struct used for memory caching purposes.
+// The results are expired according to its clock, the clock of the bus once provided to it (see ClockSetter).
 type MemoryCacheAdapter struct {
 	sync.RWMutex
 	cachedResults map[string]*Result
+	tags          map[string]map[string]bool
+	keyTags       map[string][]string
 	cleanerSignal chan bool
 	shuttingDown  *uint32
-	sleepTimer    *time.Timer
+	clock         Clock
+	sleepTimer    Timer
+	clockChanged  bool
 	sleepUntil    time.Time
 }
 
@@ -22,8 +27,11 @@ type MemoryCacheAdapter struct {
 func NewMemoryCacheAdapter() *MemoryCacheAdapter {
 	ad := &MemoryCacheAdapter{
 		cachedResults: make(map[string]*Result),
+		tags:          make(map[string]map[string]bool),
+		keyTags:       make(map[string][]string),
 		cleanerSignal: make(chan bool, 1),
 		shuttingDown:  new(uint32),
+		clock:         SystemClock,
 	}
 	go ad.cleaner()
 	return ad
@@ -31,27 +39,65 @@ func NewMemoryCacheAdapter() *MemoryCacheAdapter {
 
 // Set stores the cache value for the given query.
 func (ad *MemoryCacheAdapter) Set(ctx context.Context, qry Cacheable, res *Result) bool {
+	ck := string(qry.CacheKey())
 	ad.Lock()
-	ad.cachedResults[string(qry.CacheKey())] = res
+	ad.untag(ck)
+	ad.cachedResults[ck] = res
+	if tgb, implements := qry.(Taggable); implements {
+		ad.tag(ck, tgb.CacheTags())
+	}
 	ad.Unlock()
 	ad.clean()
 	return true
 }
 
 // Get retrieves the cached result for the provided query.
+// Results past their expiration are never returned, even if the cleaner did not remove them yet.
 func (ad *MemoryCacheAdapter) Get(ctx context.Context, qry Cacheable) *Result {
 	ad.RLock()
 	res := ad.cachedResults[string(qry.CacheKey())]
+	expired := res != nil && ad.expired(res, ad.clock.Now())
 	ad.RUnlock()
+	if expired {
+		return nil
+	}
 	return res
 }
 
+// SetClock replaces the clock used to expire the results (see ClockSetter).
+// It defaults to the SystemClock.
+func (ad *MemoryCacheAdapter) SetClock(clock Clock) {
+	if clock == nil {
+		clock = SystemClock
+	}
+	ad.Lock()
+	ad.clock = clock
+	ad.clockChanged = true
+	ad.Unlock()
+	ad.clean()
+}
+
+// Len returns the number of cached results (see CacheSizer).
+func (ad *MemoryCacheAdapter) Len() int {
+	ad.RLock()
+	defer ad.RUnlock()
+	return len(ad.cachedResults)
+}
+
 // Expire can optionally be used to forcibly expire a query cache.
 func (ad *MemoryCacheAdapter) Expire(ctx context.Context, qry Cacheable) {
-	ck := string(qry.CacheKey())
 	ad.Lock()
-	if _, isCached := ad.cachedResults[ck]; isCached {
-		delete(ad.cachedResults, ck)
+	ad.delete(string(qry.CacheKey()))
+	ad.Unlock()
+}
+
+// ExpireTags forcibly expires the cached results of the queries tagged with any of the provided tags.
+func (ad *MemoryCacheAdapter) ExpireTags(ctx context.Context, tags ...[]byte) {
+	ad.Lock()
+	for _, tag := range tags {
+		for ck := range ad.tags[string(tag)] {
+			ad.delete(ck)
+		}
 	}
 	ad.Unlock()
 }
@@ -66,25 +112,63 @@ func (ad *MemoryCacheAdapter) Shutdown() {
 
 func (ad *MemoryCacheAdapter) cleaner() {
 	for atomic.LoadUint32(ad.shuttingDown) == 0 {
-		now := time.Now()
 		ad.sleepUntil = time.Time{}
 		ad.Lock()
+		clock, clockChanged := ad.clock, ad.clockChanged
+		ad.clockChanged = false
+		now := clock.Now()
 		for key, res := range ad.cachedResults {
-			if !res.CachedAt().IsZero() && now.After(res.ExpiresAt()) {
-				delete(ad.cachedResults, key)
+			if ad.expired(res, now) {
+				ad.delete(key)
 				continue
 			}
 			ad.updateSleepUntil(res.ExpiresAt())
 		}
+		d := ad.determineSleepDuration(now)
 		ad.Unlock()
-		ad.updateSleepTimer(ad.determineSleepDuration())
+		ad.updateSleepTimer(clock, clockChanged, d)
 
 		// allow the cleaner to be triggered either with timer or directly
 		select {
-		case <-ad.sleepTimer.C:
+		case <-ad.sleepTimer.C():
 		case <-ad.cleanerSignal:
 		}
 	}
+	if ad.sleepTimer != nil {
+		ad.sleepTimer.Stop()
+	}
+}
+
+// expired reports whether the result is past its expiration at the given moment.
+func (ad *MemoryCacheAdapter) expired(res *Result, now time.Time) bool {
+	return !res.CachedAt().IsZero() && now.After(res.ExpiresAt())
+}
+
+// delete removes the cached result and its tags. The lock must be held by the caller.
+func (ad *MemoryCacheAdapter) delete(ck string) {
+	delete(ad.cachedResults, ck)
+	ad.untag(ck)
+}
+
+func (ad *MemoryCacheAdapter) tag(ck string, tags [][]byte) {
+	for _, tag := range tags {
+		t := string(tag)
+		if ad.tags[t] == nil {
+			ad.tags[t] = make(map[string]bool)
+		}
+		ad.tags[t][ck] = true
+		ad.keyTags[ck] = append(ad.keyTags[ck], t)
+	}
+}
+
+func (ad *MemoryCacheAdapter) untag(ck string) {
+	for _, t := range ad.keyTags[ck] {
+		delete(ad.tags[t], ck)
+		if len(ad.tags[t]) == 0 {
+			delete(ad.tags, t)
+		}
+	}
+	delete(ad.keyTags, ck)
 }
 
 func (ad *MemoryCacheAdapter) clean() {
@@ -100,17 +184,21 @@ func (ad *MemoryCacheAdapter) updateSleepUntil(expiresAt time.Time) {
 	}
 }
 
-func (ad *MemoryCacheAdapter) determineSleepDuration() time.Duration {
+func (ad *MemoryCacheAdapter) determineSleepDuration(now time.Time) time.Duration {
 	if ad.sleepUntil.IsZero() || len(ad.cachedResults) <= 0 {
 		return time.Hour
 	}
 
-	return ad.sleepUntil.Sub(time.Now())
+	return ad.sleepUntil.Sub(now)
 }
 
-func (ad *MemoryCacheAdapter) updateSleepTimer(d time.Duration) {
-	if ad.sleepTimer == nil {
-		ad.sleepTimer = time.NewTimer(d)
+// updateSleepTimer resets the timer of the cleaner, replacing it if the clock was replaced.
+func (ad *MemoryCacheAdapter) updateSleepTimer(clock Clock, clockChanged bool, d time.Duration) {
+	if ad.sleepTimer == nil || clockChanged {
+		if ad.sleepTimer != nil {
+			ad.sleepTimer.Stop()
+		}
+		ad.sleepTimer = clock.NewTimer(d)
 		return
 	}
 	ad.sleepTimer.Reset(d)
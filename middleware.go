@@ -0,0 +1,31 @@
+package query
+
+import "context"
+
+// QueryFunc is the signature of the function used to dispatch regular queries.
+type QueryFunc func(ctx context.Context, qry Query) (*Result, error)
+
+// IteratorQueryFunc is the signature of the function used to dispatch iterator queries to the iterator handlers.
+type IteratorQueryFunc func(ctx context.Context, qry Query, res *IteratorResult) error
+
+// Middleware must be implemented for a type to qualify as a query middleware.
+// Middlewares wrap the dispatching of queries and must call next to continue the dispatching.
+// Regular queries are wrapped including the cache retrieval, meaning cached results also pass through the middlewares.
+// Iterator queries are wrapped once a listener for the result is available, right before the iterator handlers are used.
+// Errors returned by the middlewares are passed on to the error handlers.
+type Middleware interface {
+	Query(ctx context.Context, qry Query, next QueryFunc) (*Result, error)
+	IteratorQuery(ctx context.Context, qry Query, res *IteratorResult, next IteratorQueryFunc) error
+}
+
+func wrapQuery(mw Middleware, next QueryFunc) QueryFunc {
+	return func(ctx context.Context, qry Query) (*Result, error) {
+		return mw.Query(ctx, qry, next)
+	}
+}
+
+func wrapIteratorQuery(mw Middleware, next IteratorQueryFunc) IteratorQueryFunc {
+	return func(ctx context.Context, qry Query, res *IteratorResult) error {
+		return mw.IteratorQuery(ctx, qry, res, next)
+	}
+}
@@ -0,0 +1,98 @@
+package query
+
+import (
+	"context"
+	"fmt"
+	"sync"
+)
+
+// Migration is the signature of the functions upgrading a value of a result serialized with the previous version of its schema
+// (see Bus.ResultSchema). The values are provided as decoded by the codec (e.g. map[string]interface{} using the JSONCodec).
+type Migration func(val interface{}) (interface{}, error)
+
+// ResultSchema may optionally be provided to version the schema of the results of the query type (identified by its ID),
+// along with the migrations upgrading the results serialized with its previous versions.
+// The version of the schema is the number of migrations, the first migration upgrading the results serialized before any schema was provided
+// (version 0) to version 1, and so on. Adding a migration whenever the values of the results change shape upgrades the results already cached
+// when they are read, instead of discarding them as corrupt (and flushing the caches on every model change).
+// The migrations are applied by the cache adapters serializing the results (see MarshalVersionedResult and UnmarshalVersionedResult).
+// The results serialized with a version newer than the schema (e.g. during a rolling deploy) fail to be deserialized with an ErrorResultMigration.
+// It should be provided *before* any query is performed.
+func (bus *Bus) ResultSchema(qry Query, migrations ...Migration) {
+	bus.migrations.Lock()
+	bus.migrations.types[string(qry.ID())] = migrations
+	bus.migrations.Unlock()
+}
+
+// MarshalVersionedResult serializes the result of the query using the provided codec (see MarshalResult),
+// along with the version of its schema, according to the bus providing the context (see Bus.ResultSchema).
+// It is intended for cache adapters, and is equivalent to MarshalResult for the query types without schema.
+func MarshalVersionedResult(ctx context.Context, c Codec, qry Cacheable, res *Result) ([]byte, error) {
+	enc := encodeResult(res)
+	enc.Version = len(migrationsFrom(ctx, qry))
+	return c.Marshal(enc)
+}
+
+// UnmarshalVersionedResult deserializes a result of the query previously serialized with MarshalVersionedResult (or MarshalResult),
+// upgrading its values to the current version of its schema, according to the bus providing the context (see Bus.ResultSchema).
+// It returns an ErrorResultMigration if a migration fails, or if the result was serialized with a version newer than the schema.
+func UnmarshalVersionedResult(ctx context.Context, c Codec, qry Cacheable, data []byte) (*Result, error) {
+	enc := &encodedResult{}
+	if err := c.Unmarshal(data, enc); err != nil {
+		return nil, err
+	}
+	migrations := migrationsFrom(ctx, qry)
+	// cacheable queries are expected to be queries, although not required to
+	q, _ := queryOf(qry)
+	if enc.Version > len(migrations) {
+		return nil, NewErrorResultMigration(q, enc.Version, fmt.Errorf("the schema is at version %d", len(migrations)))
+	}
+	for _, migrate := range migrations[enc.Version:] {
+		for i, val := range enc.Data {
+			migrated, err := migrate(val)
+			if err != nil {
+				return nil, NewErrorResultMigration(q, enc.Version, err)
+			}
+			enc.Data[i] = migrated
+		}
+	}
+	return decodeResult(enc), nil
+}
+
+//------Internal------//
+
+// migrations keeps the migrations of the result schemas, by query ID.
+type migrations struct {
+	sync.RWMutex
+	types map[string][]Migration
+}
+
+func newMigrations() *migrations {
+	return &migrations{types: make(map[string][]Migration)}
+}
+
+// migrationsFrom returns the migrations of the result schema of the query, according to the bus providing the context.
+func migrationsFrom(ctx context.Context, qry Cacheable) []Migration {
+	owner, ok := ownerFrom(ctx)
+	q, isQuery := queryOf(qry)
+	if !ok || !isQuery {
+		return nil
+	}
+	owner.migrations.RLock()
+	defer owner.migrations.RUnlock()
+	return owner.migrations.types[string(q.ID())]
+}
+
+// queryOf returns the query of the cacheable query, unwrapping the cacheable queries wrapped by the bus (e.g. overriding their cache duration).
+func queryOf(qry Cacheable) (Query, bool) {
+	for {
+		if q, isQuery := qry.(Query); isQuery {
+			return q, true
+		}
+		w, wraps := qry.(interface{ Unwrap() Cacheable })
+		if !wraps {
+			return nil, false
+		}
+		qry = w.Unwrap()
+	}
+}
@@ -0,0 +1,90 @@
+package query
+
+import (
+	"context"
+	"time"
+)
+
+// EventType identifies the type of an Event.
+type EventType int
+
+const (
+	// HandlerFinished is observed whenever a handler or iterator handler finishes handling a query.
+	HandlerFinished EventType = iota
+	// CacheHit is observed whenever the result of a cacheable query is retrieved from cache.
+	CacheHit
+	// CacheMiss is observed whenever the result of a cacheable query is not found in cache.
+	CacheMiss
+	// IteratorQueueSaturated is observed whenever an iterator query is issued while the iterator query queue is full.
+	IteratorQueueSaturated
+	// CircuitOpened is observed whenever the circuit of a query type opens.
+	CircuitOpened
+	// CircuitHalfOpened is observed whenever the circuit of a query type becomes half-open.
+	CircuitHalfOpened
+	// CircuitRecovered is observed whenever the circuit of a query type closes again.
+	CircuitRecovered
+	// ErrorDropped is observed whenever an error is dropped due to the asynchronous error buffer being full.
+	ErrorDropped
+	// CacheInvalidated is observed whenever cached results are forcibly expired, either of a query or of the given tags.
+	CacheInvalidated
+	// CacheRevalidated is observed whenever an expired cached result is found still fresh by its freshness check, and cached again.
+	CacheRevalidated
+	// ViewHit is observed whenever a materialized view serves its result.
+	ViewHit
+	// ViewMiss is observed whenever a materialized view is read while no result is materialized, the query being performed live.
+	ViewMiss
+	// StaleResultServed is observed whenever a result is served from the cache or a materialized view,
+	// older than the freshness SLO of its query type (see FreshnessSLO).
+	StaleResultServed
+	// LoadSheddingAdjusted is observed whenever the share of the traffic shed by a query type changes (see LoadShedding).
+	// The duration is the mean latency of the handling of the query type during the last window.
+	LoadSheddingAdjusted
+	// QueryShed is observed whenever a query is shed, not being handled (see LoadShedding).
+	QueryShed
+)
+
+// Event describes an occurrence within the bus.
+// Only the fields relevant to the event type are provided.
+type Event struct {
+	Type     EventType
+	Query    Query
+	Handler  interface{}
+	Duration time.Duration
+	Err      error
+	Tags     [][]byte
+	// Age is the time elapsed since the result served was produced (cached or refreshed).
+	Age time.Duration
+}
+
+// Observer must be implemented for a type to qualify as a bus observer.
+// Observers are notified synchronously and must not block.
+type Observer interface {
+	Observe(ctx context.Context, evt Event)
+}
+
+// Observers may optionally be provided.
+// They will be notified of the bus activity, for instrumentation purposes.
+func (bus *Bus) Observers(obs ...Observer) {
+	bus.observers = obs
+}
+
+// IteratorQueueLength returns the number of iterator queries waiting in the iterator query queue (every priority lane).
+func (bus *Bus) IteratorQueueLength() int {
+	length := 0
+	for _, lane := range bus.iteratorLanes {
+		length += len(lane.queue)
+	}
+	return length
+}
+
+//------Internal------//
+
+func (bus *Bus) isObserved() bool {
+	return len(bus.observers) > 0
+}
+
+func (bus *Bus) observe(ctx context.Context, evt Event) {
+	for _, obs := range bus.observers {
+		obs.Observe(ctx, evt)
+	}
+}
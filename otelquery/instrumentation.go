@@ -0,0 +1,300 @@
+// Package otelquery provides OpenTelemetry tracing, metrics and logs (events) instrumentation for the query bus.
+package otelquery
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/io-da/query"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/log"
+	logglobal "go.opentelemetry.io/otel/log/global"
+	"go.opentelemetry.io/otel/metric"
+	"go.opentelemetry.io/otel/trace"
+)
+
+const instrumentationName = "github.com/io-da/query/otelquery"
+
+// Instrumentation is the struct used to instrument a query bus.
+// It is both a query.Middleware (creating a span per query) and a query.Observer (recording the metrics).
+// The bus activity is also emitted as structured events through OTLP logs.
+type Instrumentation struct {
+	tracerProvider trace.TracerProvider
+	meterProvider  metric.MeterProvider
+	loggerProvider log.LoggerProvider
+	tracer         trace.Tracer
+	logger         log.Logger
+	duration       metric.Float64Histogram
+	handlerDur     metric.Float64Histogram
+	age            metric.Float64Histogram
+	hits           metric.Int64Counter
+	misses         metric.Int64Counter
+	errors         metric.Int64Counter
+	saturations    metric.Int64Counter
+	circuits       metric.Int64Counter
+	stale          metric.Int64Counter
+	shed           metric.Int64Counter
+}
+
+// NewInstrumentation initializes a new *Instrumentation.
+// It defaults to the global tracer, meter and logger providers.
+func NewInstrumentation() *Instrumentation {
+	return &Instrumentation{
+		tracerProvider: otel.GetTracerProvider(),
+		meterProvider:  otel.GetMeterProvider(),
+		loggerProvider: logglobal.GetLoggerProvider(),
+	}
+}
+
+// TracerProvider may optionally be provided to be used instead of the global tracer provider.
+func (ins *Instrumentation) TracerProvider(tp trace.TracerProvider) {
+	ins.tracerProvider = tp
+}
+
+// MeterProvider may optionally be provided to be used instead of the global meter provider.
+func (ins *Instrumentation) MeterProvider(mp metric.MeterProvider) {
+	ins.meterProvider = mp
+}
+
+// LoggerProvider may optionally be provided to be used instead of the global logger provider.
+func (ins *Instrumentation) LoggerProvider(lp log.LoggerProvider) {
+	ins.loggerProvider = lp
+}
+
+// Instrument creates the instruments and provides the instrumentation to the bus, both as middleware and observer.
+// The instrumentation becomes the outermost middleware, so it should be used *before* any other middleware is provided.
+// Any previously provided observers are replaced.
+func (ins *Instrumentation) Instrument(bus *query.Bus) error {
+	ins.tracer = ins.tracerProvider.Tracer(instrumentationName)
+	ins.logger = ins.loggerProvider.Logger(instrumentationName)
+	meter := ins.meterProvider.Meter(instrumentationName)
+
+	var err error
+	if ins.duration, err = meter.Float64Histogram("query.duration", metric.WithUnit("s"), metric.WithDescription("Duration of the queries, including the cache retrieval.")); err != nil {
+		return err
+	}
+	if ins.handlerDur, err = meter.Float64Histogram("query.handler.duration", metric.WithUnit("s"), metric.WithDescription("Duration of the handling of a query by a single handler.")); err != nil {
+		return err
+	}
+	if ins.age, err = meter.Float64Histogram("query.result.age", metric.WithUnit("s"), metric.WithDescription("Age of the results served from the cache or a materialized view.")); err != nil {
+		return err
+	}
+	if ins.stale, err = meter.Int64Counter("query.result.stale", metric.WithDescription("Number of results served older than the freshness SLO of their query type.")); err != nil {
+		return err
+	}
+	if ins.shed, err = meter.Int64Counter("query.shed", metric.WithDescription("Number of queries shed by the load shedding.")); err != nil {
+		return err
+	}
+	if ins.hits, err = meter.Int64Counter("query.cache.hits", metric.WithDescription("Number of cacheable queries retrieved from cache.")); err != nil {
+		return err
+	}
+	if ins.misses, err = meter.Int64Counter("query.cache.misses", metric.WithDescription("Number of cacheable queries not found in cache.")); err != nil {
+		return err
+	}
+	if ins.errors, err = meter.Int64Counter("query.errors", metric.WithDescription("Number of queries that failed.")); err != nil {
+		return err
+	}
+	if ins.saturations, err = meter.Int64Counter("query.iterator.queue.saturations", metric.WithDescription("Number of iterator queries issued while the iterator query queue was full.")); err != nil {
+		return err
+	}
+	if ins.circuits, err = meter.Int64Counter("query.circuit.transitions", metric.WithDescription("Number of circuit breaker state changes.")); err != nil {
+		return err
+	}
+	_, err = meter.Int64ObservableGauge("query.iterator.queue.length",
+		metric.WithDescription("Number of iterator queries waiting in the iterator query queue."),
+		metric.WithInt64Callback(func(ctx context.Context, o metric.Int64Observer) error {
+			o.Observe(int64(bus.IteratorQueueLength()))
+			return nil
+		}),
+	)
+	if err != nil {
+		return err
+	}
+
+	bus.Use(ins)
+	bus.Observers(ins)
+	return nil
+}
+
+// Query creates a span for the query and records its duration.
+func (ins *Instrumentation) Query(ctx context.Context, qry query.Query, next query.QueryFunc) (*query.Result, error) {
+	attrs := queryAttributes(qry)
+	ctx, span := ins.tracer.Start(ctx, "query "+queryType(qry), trace.WithAttributes(attrs...), trace.WithAttributes(lineageAttributes(ctx)...))
+	defer span.End()
+	ins.emit(ctx, "query.started", log.SeverityDebug, queryLogAttributes(qry)...)
+
+	clock := query.ClockFrom(ctx)
+	start := clock.Now()
+	res, err := next(ctx, qry)
+	cached := res != nil && res.IsCached()
+	if res != nil {
+		span.SetAttributes(attribute.Bool("query.cached", cached))
+	}
+	d := clock.Now().Sub(start)
+	ins.record(ctx, span, d, err, attrs)
+	ins.emitFinished(ctx, qry, d, err, log.Bool("query.cached", cached))
+	return res, err
+}
+
+// IteratorQuery creates a span for the iterator query and records its duration.
+func (ins *Instrumentation) IteratorQuery(ctx context.Context, qry query.Query, res *query.IteratorResult, next query.IteratorQueryFunc) error {
+	attrs := append(queryAttributes(qry), attribute.Bool("query.iterator", true))
+	ctx, span := ins.tracer.Start(ctx, "iterator query "+queryType(qry), trace.WithAttributes(attrs...), trace.WithAttributes(lineageAttributes(ctx)...))
+	defer span.End()
+	ins.emit(ctx, "query.started", log.SeverityDebug, append(queryLogAttributes(qry), log.Bool("query.iterator", true))...)
+
+	clock := query.ClockFrom(ctx)
+	start := clock.Now()
+	err := next(ctx, qry, res)
+	d := clock.Now().Sub(start)
+	ins.record(ctx, span, d, err, attrs)
+	ins.emitFinished(ctx, qry, d, err, log.Bool("query.iterator", true))
+	return err
+}
+
+// Observe records the metrics of the bus events, annotating the span of the respective query.
+// The cache invalidations, stale results, load shedding adjustments, iterator queue saturations, circuit transitions and dropped errors are also emitted as events.
+func (ins *Instrumentation) Observe(ctx context.Context, evt query.Event) {
+	span := trace.SpanFromContext(ctx)
+	switch evt.Type {
+	case query.HandlerFinished:
+		attrs := []attribute.KeyValue{
+			attribute.String("query.type", queryType(evt.Query)),
+			attribute.String("query.handler", fmt.Sprintf("%T", evt.Handler)),
+			attribute.Bool("error", evt.Err != nil),
+		}
+		ins.handlerDur.Record(ctx, evt.Duration.Seconds(), metric.WithAttributes(attrs...))
+		span.AddEvent("query.handler", trace.WithAttributes(append(attrs, attribute.Float64("duration", evt.Duration.Seconds()))...))
+	case query.CacheHit:
+		ins.hits.Add(ctx, 1, metric.WithAttributes(attribute.String("query.type", queryType(evt.Query))))
+		ins.age.Record(ctx, evt.Age.Seconds(), metric.WithAttributes(attribute.String("query.type", queryType(evt.Query)), attribute.String("query.result.source", "cache")))
+		span.AddEvent("query.cache.hit", trace.WithAttributes(attribute.Float64("query.result.age", evt.Age.Seconds())))
+	case query.CacheMiss:
+		ins.misses.Add(ctx, 1, metric.WithAttributes(attribute.String("query.type", queryType(evt.Query))))
+		span.AddEvent("query.cache.miss")
+	case query.CacheRevalidated:
+		ins.hits.Add(ctx, 1, metric.WithAttributes(attribute.String("query.type", queryType(evt.Query)), attribute.Bool("query.cache.revalidated", true)))
+		span.AddEvent("query.cache.revalidated")
+	case query.ViewHit:
+		ins.age.Record(ctx, evt.Age.Seconds(), metric.WithAttributes(attribute.String("query.type", queryType(evt.Query)), attribute.String("query.result.source", "view")))
+	case query.StaleResultServed:
+		ins.stale.Add(ctx, 1, metric.WithAttributes(attribute.String("query.type", queryType(evt.Query))))
+		span.AddEvent("query.result.stale", trace.WithAttributes(attribute.Float64("query.result.age", evt.Age.Seconds())))
+		ins.emit(ctx, "query.result.stale", log.SeverityWarn, append(queryLogAttributes(evt.Query), log.Float64("query.result.age", evt.Age.Seconds()))...)
+	case query.QueryShed:
+		ins.shed.Add(ctx, 1, metric.WithAttributes(attribute.String("query.type", queryType(evt.Query))))
+		span.AddEvent("query.shed")
+	case query.LoadSheddingAdjusted:
+		ins.emit(ctx, "query.load-shedding.adjusted", log.SeverityWarn, append(queryLogAttributes(evt.Query), log.Float64("query.latency", evt.Duration.Seconds()))...)
+	case query.IteratorQueueSaturated:
+		ins.saturations.Add(ctx, 1, metric.WithAttributes(attribute.String("query.type", queryType(evt.Query))))
+		ins.emit(ctx, "query.iterator.queue.saturated", log.SeverityWarn, queryLogAttributes(evt.Query)...)
+	case query.CircuitOpened, query.CircuitHalfOpened, query.CircuitRecovered:
+		state := circuitState(evt.Type)
+		ins.circuits.Add(ctx, 1, metric.WithAttributes(attribute.String("query.type", queryType(evt.Query)), attribute.String("circuit.state", state)))
+		span.AddEvent("query.circuit." + state)
+		severity := log.SeverityInfo
+		if evt.Type == query.CircuitOpened {
+			severity = log.SeverityWarn
+		}
+		ins.emit(ctx, "query.circuit."+state, severity, queryLogAttributes(evt.Query)...)
+	case query.CacheInvalidated:
+		attrs := queryLogAttributes(evt.Query)
+		if len(evt.Tags) > 0 {
+			tags := make([]log.Value, len(evt.Tags))
+			for i, tag := range evt.Tags {
+				tags[i] = log.StringValue(string(tag))
+			}
+			attrs = append(attrs, log.Slice("query.cache.tags", tags...))
+		}
+		ins.emit(ctx, "query.cache.invalidated", log.SeverityInfo, attrs...)
+	case query.ErrorDropped:
+		attrs := queryLogAttributes(evt.Query)
+		if evt.Err != nil {
+			attrs = append(attrs, log.String("error.message", evt.Err.Error()))
+		}
+		ins.emit(ctx, "query.error.dropped", log.SeverityWarn, attrs...)
+	}
+}
+
+//------Internal------//
+
+func (ins *Instrumentation) record(ctx context.Context, span trace.Span, d time.Duration, err error, attrs []attribute.KeyValue) {
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+		ins.errors.Add(ctx, 1, metric.WithAttributes(attrs...))
+	}
+	ins.duration.Record(ctx, d.Seconds(), metric.WithAttributes(append(attrs, attribute.Bool("error", err != nil))...))
+}
+
+// emit the event through the logger, named after the event.name attribute (and body).
+func (ins *Instrumentation) emit(ctx context.Context, name string, severity log.Severity, attrs ...log.KeyValue) {
+	var rec log.Record
+	rec.SetTimestamp(query.ClockFrom(ctx).Now())
+	rec.SetSeverity(severity)
+	rec.SetBody(log.StringValue(name))
+	rec.AddAttributes(log.String("event.name", name))
+	rec.AddAttributes(attrs...)
+	ins.logger.Emit(ctx, rec)
+}
+
+func (ins *Instrumentation) emitFinished(ctx context.Context, qry query.Query, d time.Duration, err error, attrs ...log.KeyValue) {
+	attrs = append(append(queryLogAttributes(qry), attrs...), log.Float64("duration", d.Seconds()), log.Bool("error", err != nil))
+	severity := log.SeverityDebug
+	if err != nil {
+		severity = log.SeverityError
+		attrs = append(attrs, log.String("error.message", err.Error()))
+	}
+	ins.emit(ctx, "query.finished", severity, attrs...)
+}
+
+// queryLogAttributes returns the log attributes of the query, if any (e.g. tag invalidations are not of a query).
+func queryLogAttributes(qry query.Query) []log.KeyValue {
+	if qry == nil {
+		return nil
+	}
+	return []log.KeyValue{
+		log.String("query.type", queryType(qry)),
+		log.String("query.id", string(qry.ID())),
+	}
+}
+
+func queryAttributes(qry query.Query) []attribute.KeyValue {
+	return []attribute.KeyValue{
+		attribute.String("query.type", queryType(qry)),
+		attribute.String("query.id", string(qry.ID())),
+	}
+}
+
+// lineageAttributes describes the position of the query within its tree of nested queries.
+// They are only provided to the spans, the IDs being unsuitable as metric dimensions.
+func lineageAttributes(ctx context.Context) []attribute.KeyValue {
+	lin, ok := query.LineageFrom(ctx)
+	if !ok {
+		return nil
+	}
+	return []attribute.KeyValue{
+		attribute.Int64("query.lineage.id", int64(lin.ID)),
+		attribute.Int64("query.lineage.parent", int64(lin.Parent)),
+		attribute.Int64("query.lineage.root", int64(lin.Root)),
+		attribute.Int("query.lineage.depth", lin.Depth),
+	}
+}
+
+func queryType(qry query.Query) string {
+	return fmt.Sprintf("%T", qry)
+}
+
+func circuitState(typ query.EventType) string {
+	switch typ {
+	case query.CircuitOpened:
+		return "open"
+	case query.CircuitHalfOpened:
+		return "half-open"
+	}
+	return "closed"
+}
@@ -0,0 +1,274 @@
+package otelquery
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/io-da/query"
+	"github.com/io-da/query/querytest"
+	"go.opentelemetry.io/otel/log"
+	sdklog "go.opentelemetry.io/otel/sdk/log"
+	sdkmetric "go.opentelemetry.io/otel/sdk/metric"
+	"go.opentelemetry.io/otel/sdk/metric/metricdata"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+)
+
+type testCacheQuery struct {
+}
+
+func (*testCacheQuery) ID() []byte {
+	return []byte("UUID-CACHE")
+}
+
+func (*testCacheQuery) CacheKey() []byte {
+	return []byte("CACHE-KEY")
+}
+
+func (*testCacheQuery) CacheDuration() time.Duration {
+	return time.Minute
+}
+
+type testQueryError struct {
+}
+
+func (*testQueryError) ID() []byte {
+	return []byte("UUID-ERROR")
+}
+
+type testHandler struct {
+}
+
+func (hdl *testHandler) Handle(ctx context.Context, qry query.Query, res *query.Result) error {
+	switch qry.(type) {
+	case *testCacheQuery:
+		res.Add("bar")
+	case *testQueryError:
+		return errors.New("query failed")
+	}
+	return nil
+}
+
+type testParentQuery struct {
+}
+
+func (*testParentQuery) ID() []byte {
+	return []byte("UUID-PARENT")
+}
+
+type testNestingHandler struct {
+	bus *query.Bus
+}
+
+func (hdl *testNestingHandler) Handle(ctx context.Context, qry query.Query, res *query.Result) error {
+	if _, isParent := qry.(*testParentQuery); isParent {
+		child, err := hdl.bus.Query(ctx, &testCacheQuery{})
+		if err != nil {
+			return err
+		}
+		res.Add(child.First())
+	}
+	return nil
+}
+
+type testLogExporter struct {
+	sync.Mutex
+	records []sdklog.Record
+}
+
+func (exp *testLogExporter) Export(ctx context.Context, records []sdklog.Record) error {
+	exp.Lock()
+	defer exp.Unlock()
+	for _, rec := range records {
+		exp.records = append(exp.records, rec.Clone())
+	}
+	return nil
+}
+
+func (exp *testLogExporter) Shutdown(ctx context.Context) error {
+	return nil
+}
+
+func (exp *testLogExporter) ForceFlush(ctx context.Context) error {
+	return nil
+}
+
+func (exp *testLogExporter) Names() []string {
+	exp.Lock()
+	defer exp.Unlock()
+	names := make([]string, len(exp.records))
+	for i, rec := range exp.records {
+		names[i] = rec.Body().AsString()
+	}
+	return names
+}
+
+func TestInstrumentation(t *testing.T) {
+	spans := tracetest.NewSpanRecorder()
+	reader := sdkmetric.NewManualReader()
+	ins := NewInstrumentation()
+	ins.TracerProvider(sdktrace.NewTracerProvider(sdktrace.WithSpanProcessor(spans)))
+	ins.MeterProvider(sdkmetric.NewMeterProvider(sdkmetric.WithReader(reader)))
+
+	bus := query.NewBus()
+	bus.Handlers(&testHandler{})
+	if err := ins.Instrument(bus); err != nil {
+		t.Fatal(err.Error())
+	}
+	for i := 0; i < 2; i++ {
+		if _, err := bus.Query(context.Background(), &testCacheQuery{}); err != nil {
+			t.Error(err.Error())
+		}
+	}
+	if _, err := bus.Query(context.Background(), &testQueryError{}); err == nil {
+		t.Error("Query was expected to throw an error.")
+	}
+
+	ended := spans.Ended()
+	if len(ended) != 3 {
+		t.Fatalf("Unexpected number of spans %d.", len(ended))
+	}
+	if ended[0].Name() != "query *otelquery.testCacheQuery" {
+		t.Errorf("Unexpected span name %s.", ended[0].Name())
+	}
+	events := ended[0].Events()
+	if len(events) != 2 || events[0].Name != "query.cache.miss" || events[1].Name != "query.handler" {
+		t.Error("Unexpected events of the first span.")
+	}
+	if events = ended[1].Events(); len(events) != 1 || events[0].Name != "query.cache.hit" {
+		t.Error("Unexpected events of the second span.")
+	}
+	if ended[2].Status().Description != "query failed" {
+		t.Error("The span of the failed query was expected to record the error.")
+	}
+
+	rm := metricdata.ResourceMetrics{}
+	if err := reader.Collect(context.Background(), &rm); err != nil {
+		t.Fatal(err.Error())
+	}
+	sums := make(map[string]int64)
+	names := make(map[string]bool)
+	for _, sm := range rm.ScopeMetrics {
+		for _, m := range sm.Metrics {
+			names[m.Name] = true
+			if sum, ok := m.Data.(metricdata.Sum[int64]); ok {
+				for _, dp := range sum.DataPoints {
+					sums[m.Name] += dp.Value
+				}
+			}
+		}
+	}
+	if sums["query.cache.hits"] != 1 || sums["query.cache.misses"] != 1 || sums["query.errors"] != 1 {
+		t.Errorf("Unexpected counters %v.", sums)
+	}
+	for _, name := range []string{"query.duration", "query.handler.duration", "query.result.age", "query.iterator.queue.length"} {
+		if !names[name] {
+			t.Errorf("Expected the %s metric.", name)
+		}
+	}
+	bus.Shutdown()
+}
+
+func TestInstrumentation_Logs(t *testing.T) {
+	spans := tracetest.NewSpanRecorder()
+	exp := &testLogExporter{}
+	ins := NewInstrumentation()
+	ins.TracerProvider(sdktrace.NewTracerProvider(sdktrace.WithSpanProcessor(spans)))
+	ins.LoggerProvider(sdklog.NewLoggerProvider(sdklog.WithProcessor(sdklog.NewSimpleProcessor(exp))))
+
+	clock := querytest.NewClock(time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC))
+	bus := query.NewBus()
+	bus.Clock(clock)
+	bus.Handlers(&testHandler{})
+	if err := ins.Instrument(bus); err != nil {
+		t.Fatal(err.Error())
+	}
+	if _, err := bus.Query(context.Background(), &testCacheQuery{}); err != nil {
+		t.Error(err.Error())
+	}
+	if _, err := bus.Query(context.Background(), &testQueryError{}); err == nil {
+		t.Error("Query was expected to throw an error.")
+	}
+	bus.Invalidate(context.Background(), &testCacheQuery{})
+	bus.InvalidateTags(context.Background(), []byte("products"))
+
+	names := exp.Names()
+	expected := []string{"query.started", "query.finished", "query.started", "query.finished", "query.cache.invalidated", "query.cache.invalidated"}
+	if len(names) != len(expected) {
+		t.Fatalf("Unexpected events %v.", names)
+	}
+	for i, name := range expected {
+		if names[i] != name {
+			t.Fatalf("Unexpected events %v.", names)
+		}
+	}
+
+	exp.Lock()
+	defer exp.Unlock()
+	finished := exp.records[1]
+	if finished.TraceID() != spans.Ended()[0].SpanContext().TraceID() {
+		t.Error("The events were expected to be correlated with the span of the query.")
+	}
+	// the events are timed by the clock of the bus
+	if !finished.Timestamp().Equal(clock.Now()) {
+		t.Errorf("Expected the events to be timed by the clock of the bus, got %v.", finished.Timestamp())
+	}
+	failed := exp.records[3]
+	if failed.Severity() != log.SeverityError {
+		t.Error("The failed query was expected to be emitted as an error.")
+	}
+	attrs := make(map[string]log.Value)
+	failed.WalkAttributes(func(kv log.KeyValue) bool {
+		attrs[kv.Key] = kv.Value
+		return true
+	})
+	if attrs["event.name"].AsString() != "query.finished" || attrs["query.type"].AsString() != "*otelquery.testQueryError" || attrs["error.message"].AsString() != "query failed" {
+		t.Errorf("Unexpected attributes %v.", attrs)
+	}
+	tags := 0
+	exp.records[5].WalkAttributes(func(kv log.KeyValue) bool {
+		if kv.Key == "query.cache.tags" {
+			tags = len(kv.Value.AsSlice())
+		}
+		return true
+	})
+	if tags != 1 {
+		t.Error("The invalidated tags were expected to be emitted.")
+	}
+	bus.Shutdown()
+}
+
+func TestInstrumentation_Lineage(t *testing.T) {
+	spans := tracetest.NewSpanRecorder()
+	ins := NewInstrumentation()
+	ins.TracerProvider(sdktrace.NewTracerProvider(sdktrace.WithSpanProcessor(spans)))
+
+	bus := query.NewBus()
+	bus.Handlers(&testHandler{}, &testNestingHandler{bus: bus})
+	if err := ins.Instrument(bus); err != nil {
+		t.Fatal(err.Error())
+	}
+	if _, err := bus.Query(context.Background(), &testParentQuery{}); err != nil {
+		t.Fatal(err.Error())
+	}
+
+	ended := spans.Ended()
+	if len(ended) != 2 {
+		t.Fatalf("Unexpected number of spans %d.", len(ended))
+	}
+	child, parent := ended[0], ended[1]
+	if child.Parent().SpanID() != parent.SpanContext().SpanID() {
+		t.Error("Expected the span of the nested query to be a child of the span of its parent query.")
+	}
+	attrs := make(map[string]int64)
+	for _, attr := range child.Attributes() {
+		attrs[string(attr.Key)] = attr.Value.AsInt64()
+	}
+	if attrs["query.lineage.depth"] != 1 || attrs["query.lineage.parent"] == 0 || attrs["query.lineage.parent"] != attrs["query.lineage.root"] {
+		t.Errorf("Unexpected lineage attributes %v.", attrs)
+	}
+	bus.Shutdown()
+}
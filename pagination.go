@@ -0,0 +1,92 @@
+package query
+
+import (
+	"context"
+	"net/url"
+	"strconv"
+	"time"
+)
+
+// Pagination must be implemented by the pagination types of list queries.
+// The page key identifies the page within its collection, for caching purposes.
+type Pagination interface {
+	PageKey() []byte
+}
+
+// Page is the standard offset based pagination.
+type Page struct {
+	Number int `json:"number"`
+	Size   int `json:"size"`
+}
+
+// PageKey returns the key of the page.
+func (p Page) PageKey() []byte {
+	return []byte("page=" + strconv.Itoa(p.Number) + "&size=" + strconv.Itoa(p.Size))
+}
+
+// Offset returns the amount of items preceding the page (pages are numbered from 1).
+func (p Page) Offset() int {
+	if p.Number <= 1 {
+		return 0
+	}
+	return (p.Number - 1) * p.Size
+}
+
+// Cursor is the standard cursor based pagination.
+type Cursor struct {
+	After string `json:"after"`
+	Limit int    `json:"limit"`
+}
+
+// PageKey returns the key of the page.
+func (c Cursor) PageKey() []byte {
+	return []byte("after=" + url.QueryEscape(c.After) + "&limit=" + strconv.Itoa(c.Limit))
+}
+
+// PageCacheKey returns the cache key of a page of the collection.
+// The filters (e.g. search terms) must be provided in a consistent order, each of them resulting in a different key.
+// A nil pagination results in the key of the unpaginated collection.
+func PageCacheKey(collection string, pag Pagination, filters ...string) []byte {
+	key := url.QueryEscape(collection)
+	for _, filter := range filters {
+		key += "|" + url.QueryEscape(filter)
+	}
+	if pag == nil {
+		return []byte(key)
+	}
+	return []byte(key + "|" + string(pag.PageKey()))
+}
+
+// CollectionTag returns the cache tag shared by every page of the collection.
+func CollectionTag(collection string) []byte {
+	return []byte("collection:" + collection)
+}
+
+// PaginatedCache may be embedded by list queries to cache their results per page.
+// Every page is tagged with the tag of its collection (see CollectionTag).
+type PaginatedCache struct {
+	Collection string
+	Pagination Pagination
+	Filters    []string
+	Duration   time.Duration
+}
+
+// CacheKey returns the cache key of the page (see PageCacheKey), or of the unpaginated collection if the pagination is nil.
+func (pc PaginatedCache) CacheKey() []byte {
+	return PageCacheKey(pc.Collection, pc.Pagination, pc.Filters...)
+}
+
+// CacheDuration returns the cache duration of the page.
+func (pc PaginatedCache) CacheDuration() time.Duration {
+	return pc.Duration
+}
+
+// CacheTags returns the tag of the collection.
+func (pc PaginatedCache) CacheTags() [][]byte {
+	return [][]byte{CollectionTag(pc.Collection)}
+}
+
+// InvalidateCollection forcibly expires every cached page of the collection.
+func (bus *Bus) InvalidateCollection(ctx context.Context, collection string) {
+	bus.InvalidateTags(ctx, CollectionTag(collection))
+}
@@ -6,4 +6,6 @@ type pendingIteratorQuery struct {
 	ctx context.Context
 	qry Query
 	res *IteratorResult
+	// done must be called once the query is finished
+	done func()
 }
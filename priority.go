@@ -0,0 +1,83 @@
+package query
+
+import (
+	"sort"
+	"sync"
+)
+
+// Prioritized may optionally be implemented by iterator queries, to be handled by a priority lane.
+// Iterator queries not implementing this interface have priority 0.
+type Prioritized interface {
+	Priority() int
+}
+
+// iteratorLane is a queue of iterator queries with dedicated workers.
+// The lock guards the number of workers, which may be scaled while the bus is running.
+type iteratorLane struct {
+	sync.Mutex
+	priority int
+	workers  int
+	stopped  bool
+	busy     *int32
+	queue    chan *pendingIteratorQuery
+	retire   chan bool
+	retired  chan bool
+}
+
+// IteratorPriorityLane may optionally be used to add a priority lane to the iterator query queue, with dedicated workers.
+// The iterator queries are queued in the lane with the highest priority not exceeding their own (or the lowest lane).
+// This way, a flood of low priority queries does not starve the higher priority ones.
+// The default lane has priority 0 and the workers provided using IteratorWorkerPoolSize.
+// It can only be used *before* the bus is initialized.
+func (bus *Bus) IteratorPriorityLane(priority int, workers int) {
+	if bus.isInitialized() || workers <= 0 {
+		return
+	}
+	if priority == 0 {
+		bus.iteratorWorkerPoolSize = workers
+		return
+	}
+	bus.iteratorLaneWorkers[priority] = workers
+}
+
+//------Internal------//
+
+// initializeIteratorLanes creates the iterator lanes, sorted by descending priority.
+func (bus *Bus) initializeIteratorLanes() {
+	workers := bus.iteratorWorkerPoolSize
+	if bus.autoscaling != nil {
+		workers = bus.autoscaling.bound(workers)
+	}
+	bus.iteratorLanes = []*iteratorLane{bus.newIteratorLane(0, workers)}
+	for priority, workers := range bus.iteratorLaneWorkers {
+		bus.iteratorLanes = append(bus.iteratorLanes, bus.newIteratorLane(priority, workers))
+	}
+	sort.Slice(bus.iteratorLanes, func(i, j int) bool {
+		return bus.iteratorLanes[i].priority > bus.iteratorLanes[j].priority
+	})
+}
+
+func (bus *Bus) newIteratorLane(priority int, workers int) *iteratorLane {
+	return &iteratorLane{
+		priority: priority,
+		workers:  workers,
+		busy:     new(int32),
+		queue:    make(chan *pendingIteratorQuery, bus.iteratorQueueBuffer),
+		retire:   make(chan bool),
+		retired:  make(chan bool),
+	}
+}
+
+// iteratorLane returns the lane of the iterator query.
+func (bus *Bus) iteratorLane(qry Query) *iteratorLane {
+	priority := 0
+	if prt, implements := qry.(Prioritized); implements {
+		priority = prt.Priority()
+	}
+	for _, lane := range bus.iteratorLanes {
+		if lane.priority <= priority {
+			return lane
+		}
+	}
+	return bus.iteratorLanes[len(bus.iteratorLanes)-1]
+}
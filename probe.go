@@ -0,0 +1,96 @@
+package query
+
+import (
+	"context"
+	"time"
+)
+
+// Probeable may optionally be implemented by handlers and iterator handlers to take part in the bus self-test.
+// The returned query should be a cheap synthetic query, verifying the availability of the handler dependencies.
+type Probeable interface {
+	Probe() Query
+}
+
+// ProbeReport is the outcome of probing a single handler.
+type ProbeReport struct {
+	Handler  interface{}
+	Query    Query
+	Err      error
+	Duration time.Duration
+}
+
+// SelfTestReport is the outcome of the bus self-test, containing a ProbeReport per Probeable handler.
+type SelfTestReport []ProbeReport
+
+// Passed can be used to verify if every probe succeeded.
+func (rep SelfTestReport) Passed() bool {
+	for _, prb := range rep {
+		if prb.Err != nil {
+			return false
+		}
+	}
+	return true
+}
+
+// SelfTest provides every Probeable handler and iterator handler with its probe query.
+// The handlers are used directly, bypassing the middlewares and the cache adapters.
+// A probe fails if the handler returns an error or does not handle the probe query.
+// Probe failures are also passed on to the error handlers.
+// Iterator handlers are only probed once provided to the bus.
+func (bus *Bus) SelfTest(ctx context.Context) SelfTestReport {
+	rep := make(SelfTestReport, 0)
+	for _, hdl := range registered(bus.routes, bus.handlers) {
+		if prb, implements := forwarded[Probeable](hdl); implements {
+			rep = append(rep, bus.probe(ctx, hdl, prb.Probe()))
+		}
+	}
+	set := bus.currentIteratorHandlers()
+	for _, hdl := range registered(set.routes, set.handlers) {
+		if prb, implements := forwarded[Probeable](hdl); implements {
+			rep = append(rep, bus.probeIterator(ctx, hdl, prb.Probe()))
+		}
+	}
+	return rep
+}
+
+//------Internal------//
+
+func (bus *Bus) probe(ctx context.Context, hdl Handler, qry Query) ProbeReport {
+	start := bus.clock.Now()
+	res := newResult()
+	err := bus.callHandler(qry, hdl, func() error { return hdl.Handle(ctx, qry, res) })
+	if err == nil && !res.isHandled() {
+		err = NewErrorNoQueryHandlersFound(qry)
+	}
+	return bus.probeReport(ctx, hdl, qry, err, bus.since(start))
+}
+
+func (bus *Bus) probeIterator(ctx context.Context, hdl IteratorHandler, qry Query) ProbeReport {
+	start := bus.clock.Now()
+	res := newIteratorResult(bus.iteratorResultBuffer)
+	drained := make(chan bool)
+	go func() {
+		for range res.proxy {
+		}
+		drained <- true
+	}()
+	err := bus.callHandler(qry, hdl, func() error { return hdl.Handle(ctx, qry, res) })
+	res.close()
+	<-drained
+	if err == nil && !res.isHandled() {
+		err = NewErrorNoQueryHandlersFound(qry)
+	}
+	return bus.probeReport(ctx, hdl, qry, err, bus.since(start))
+}
+
+func (bus *Bus) probeReport(ctx context.Context, hdl interface{}, qry Query, err error, d time.Duration) ProbeReport {
+	if err != nil {
+		bus.error(ctx, qry, err)
+	}
+	return ProbeReport{
+		Handler:  hdl,
+		Query:    qry,
+		Err:      err,
+		Duration: d,
+	}
+}
@@ -0,0 +1,91 @@
+package query
+
+import (
+	"context"
+	"time"
+)
+
+// Querier must be implemented for a type to qualify as a query bus facade.
+// The Bus implements it, as do its decorators (see Decorate) and test doubles (see the querytest package).
+// Application code may depend on it instead of the Bus, to be able to mock or decorate the bus.
+type Querier interface {
+	Query(ctx context.Context, qry Query) (*Result, error)
+	IteratorQuerier
+}
+
+// Decorate wraps the querier with the provided middlewares, the first middleware being the outermost.
+// Unlike the middlewares used by the bus (see bus.Use), these wrap the querier as a whole, in the process of the caller.
+// Iterator queries are wrapped once a listener for the result is available. The errors of the querier (and of the middlewares)
+// are then provided by the result Err function. Like the bus, the decorated querier waits for the listener up to the iterator listener timeout,
+// and for the consumer up to the iterator consumer timeout (see Bus.IteratorListenerTimeout and Bus.IteratorConsumerTimeout),
+// those of the decorated bus (or its defaults if the querier is not a bus).
+func Decorate(qr Querier, mws ...Middleware) Querier {
+	dq := &decoratedQuerier{
+		queryChain:         qr.Query,
+		iteratorQueryChain: func(ctx context.Context, qry Query, res *IteratorResult) error { return res.Forward(ctx, qr, qry) },
+	}
+	switch qr := qr.(type) {
+	case *Bus:
+		dq.bus = qr
+	case *decoratedQuerier:
+		dq.bus = qr.bus
+	}
+	for i := len(mws) - 1; i >= 0; i-- {
+		dq.queryChain = wrapQuery(mws[i], dq.queryChain)
+		dq.iteratorQueryChain = wrapIteratorQuery(mws[i], dq.iteratorQueryChain)
+	}
+	return dq
+}
+
+//------Internal------//
+
+type decoratedQuerier struct {
+	queryChain         QueryFunc
+	iteratorQueryChain IteratorQueryFunc
+	// bus is the decorated bus providing the iterator timeouts, if any.
+	bus *Bus
+}
+
+func (dq *decoratedQuerier) Query(ctx context.Context, qry Query) (*Result, error) {
+	return dq.queryChain(ctx, qry)
+}
+
+func (dq *decoratedQuerier) IteratorQuery(ctx context.Context, qry Query) (*IteratorResult, error) {
+	clock, listenerTimeout, consumerTimeout := dq.iteratorTimeouts()
+	res := newIteratorResult(0)
+	res.ConsumerTimeout(consumerTimeout)
+	err := spawn(ctx, qry, "iterator forward", true, func() {
+		defer res.close()
+		start := clock.Now()
+		listening, err := res.waitListener(ctx, clock, listenerTimeout)
+		if err != nil {
+			res.fail(err)
+			return
+		}
+		if !listening {
+			res.fail(ErrorListenerTimedOut{query: qry, elapsed: clock.Now().Sub(start)})
+			return
+		}
+		ctx, stall := context.WithCancel(ctx)
+		defer stall()
+		res.consume(qry, clock, stall)
+		if err = dq.iteratorQueryChain(ctx, qry, res); err != nil {
+			res.fail(err)
+		}
+		if stalled := res.consumer.err(); stalled != nil {
+			res.fail(stalled)
+		}
+	})
+	if err != nil {
+		return nil, err
+	}
+	return res, nil
+}
+
+// iteratorTimeouts returns the clock and the iterator listener and consumer timeouts of the decorated bus, or the defaults.
+func (dq *decoratedQuerier) iteratorTimeouts() (Clock, time.Duration, time.Duration) {
+	if dq.bus == nil {
+		return SystemClock, defaultIteratorListenerTimeout, 0
+	}
+	return dq.bus.clock, dq.bus.iteratorListenerTimeout, dq.bus.iteratorConsumerTimeout
+}
@@ -0,0 +1,172 @@
+// Package querydoc extracts the documentation of query types from Go source code.
+// A query type is any named type declaring an ID() []byte method.
+package querydoc
+
+import (
+	"go/ast"
+	"go/doc"
+	"go/parser"
+	"go/token"
+	"go/types"
+	"io/fs"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"strings"
+)
+
+// QueryDoc is the documentation of a query type.
+type QueryDoc struct {
+	Package string     `json:"package"`
+	Name    string     `json:"name"`
+	Doc     string     `json:"doc,omitempty"`
+	Fields  []FieldDoc `json:"fields,omitempty"`
+}
+
+// Type returns the type name formatted as package.Type, matching query.TypeName.
+func (qd QueryDoc) Type() string {
+	return qd.Package + "." + qd.Name
+}
+
+// FieldDoc is the documentation of an exported field of a query type.
+type FieldDoc struct {
+	Name string `json:"name"`
+	Type string `json:"type"`
+	JSON string `json:"json,omitempty"`
+	Doc  string `json:"doc,omitempty"`
+}
+
+// Extract recursively scans the directory for query types and returns their documentation, sorted by type.
+// Test files, hidden directories, testdata and vendor directories are skipped.
+func Extract(dir string) ([]QueryDoc, error) {
+	docs := make([]QueryDoc, 0)
+	err := filepath.WalkDir(dir, func(path string, entry fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if !entry.IsDir() {
+			return nil
+		}
+		if path != dir && skipDir(entry.Name()) {
+			return filepath.SkipDir
+		}
+		pkgDocs, err := ExtractPackage(path)
+		if err != nil {
+			return err
+		}
+		docs = append(docs, pkgDocs...)
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	sort.Slice(docs, func(i, j int) bool {
+		return docs[i].Type() < docs[j].Type()
+	})
+	return docs, nil
+}
+
+// ExtractPackage scans the Go files of a single directory for query types and returns their documentation.
+func ExtractPackage(dir string) ([]QueryDoc, error) {
+	paths, err := filepath.Glob(filepath.Join(dir, "*.go"))
+	if err != nil {
+		return nil, err
+	}
+
+	fset := token.NewFileSet()
+	pkgs := make(map[string][]*ast.File)
+	names := make([]string, 0)
+	for _, path := range paths {
+		if strings.HasSuffix(path, "_test.go") {
+			continue
+		}
+		file, err := parser.ParseFile(fset, path, nil, parser.ParseComments)
+		if err != nil {
+			return nil, err
+		}
+		name := file.Name.Name
+		if _, exists := pkgs[name]; !exists {
+			names = append(names, name)
+		}
+		pkgs[name] = append(pkgs[name], file)
+	}
+	sort.Strings(names)
+
+	docs := make([]QueryDoc, 0)
+	for _, name := range names {
+		pkg, err := doc.NewFromFiles(fset, pkgs[name], name, doc.AllDecls)
+		if err != nil {
+			return nil, err
+		}
+		for _, typ := range pkg.Types {
+			if !isQuery(typ) {
+				continue
+			}
+			docs = append(docs, QueryDoc{
+				Package: pkg.Name,
+				Name:    typ.Name,
+				Doc:     strings.TrimSpace(typ.Doc),
+				Fields:  fields(typ),
+			})
+		}
+	}
+	return docs, nil
+}
+
+//------Internal------//
+
+func skipDir(name string) bool {
+	return strings.HasPrefix(name, ".") || strings.HasPrefix(name, "_") || name == "testdata" || name == "vendor"
+}
+
+// isQuery verifies if the type declares an ID() []byte method.
+func isQuery(typ *doc.Type) bool {
+	for _, mtd := range typ.Methods {
+		if mtd.Name != "ID" {
+			continue
+		}
+		fn := mtd.Decl.Type
+		if fn.Params.NumFields() != 0 || fn.Results.NumFields() != 1 {
+			return false
+		}
+		return types.ExprString(fn.Results.List[0].Type) == "[]byte"
+	}
+	return false
+}
+
+func fields(typ *doc.Type) []FieldDoc {
+	var fieldDocs []FieldDoc
+	for _, spec := range typ.Decl.Specs {
+		tspec, isType := spec.(*ast.TypeSpec)
+		if !isType || tspec.Name.Name != typ.Name {
+			continue
+		}
+		strct, isStruct := tspec.Type.(*ast.StructType)
+		if !isStruct {
+			continue
+		}
+		for _, field := range strct.Fields.List {
+			fieldDoc := FieldDoc{
+				Type: types.ExprString(field.Type),
+				Doc:  strings.TrimSpace(field.Doc.Text() + field.Comment.Text()),
+			}
+			if field.Tag != nil {
+				tag := reflect.StructTag(strings.Trim(field.Tag.Value, "`"))
+				fieldDoc.JSON = tag.Get("json")
+			}
+			if len(field.Names) == 0 {
+				fieldDoc.Name = strings.TrimLeft(fieldDoc.Type, "*")
+				fieldDocs = append(fieldDocs, fieldDoc)
+				continue
+			}
+			for _, name := range field.Names {
+				if !name.IsExported() {
+					continue
+				}
+				fieldDoc.Name = name.Name
+				fieldDocs = append(fieldDocs, fieldDoc)
+			}
+		}
+	}
+	return fieldDocs
+}
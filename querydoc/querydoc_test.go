@@ -0,0 +1,36 @@
+package querydoc
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestExtract(t *testing.T) {
+	docs, err := Extract("testdata")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expected := []QueryDoc{
+		{
+			Package: "catalog",
+			Name:    "ProductByID",
+			Doc:     "ProductByID retrieves a single product.",
+			Fields: []FieldDoc{
+				{Name: "ProductID", Type: "string", JSON: "product_id", Doc: "ProductID identifies the product."},
+				{Name: "Locale", Type: "string", Doc: "optional locale"},
+			},
+		},
+		{
+			Package: "catalog",
+			Name:    "ProductsInStock",
+			Doc:     "ProductsInStock lists the products in stock.",
+		},
+	}
+	if !reflect.DeepEqual(docs, expected) {
+		t.Fatalf("unexpected docs:\n%+v\nexpected:\n%+v", docs, expected)
+	}
+	if docs[0].Type() != "catalog.ProductByID" {
+		t.Errorf("unexpected type %s", docs[0].Type())
+	}
+}
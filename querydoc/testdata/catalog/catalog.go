@@ -0,0 +1,35 @@
+package catalog
+
+// ProductByID retrieves a single product.
+type ProductByID struct {
+	// ProductID identifies the product.
+	ProductID string `json:"product_id"`
+	Locale    string // optional locale
+	internal  int
+}
+
+// ID returns the query identifier.
+func (*ProductByID) ID() []byte {
+	return []byte("ProductByID")
+}
+
+// ProductsInStock lists the products in stock.
+type ProductsInStock []string
+
+// ID returns the query identifier.
+func (ProductsInStock) ID() []byte {
+	return []byte("ProductsInStock")
+}
+
+// Product is not a query.
+type Product struct {
+	ID string
+}
+
+// Identifier is not a query, its ID method has the wrong signature.
+type Identifier struct{}
+
+// ID returns the identifier.
+func (Identifier) ID() string {
+	return ""
+}
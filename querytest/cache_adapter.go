@@ -0,0 +1,121 @@
+package querytest
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/io-da/query"
+)
+
+// CacheAdapter is an in-memory cache adapter expiring the results according to a Clock, instead of the system time.
+// It also counts the operations performed, to spy on the caching of the bus.
+type CacheAdapter struct {
+	mu      sync.Mutex
+	clock   *Clock
+	entries map[string]cacheEntry
+	sets    int
+	gets    int
+	hits    int
+	expires int
+}
+
+// NewCacheAdapter initializes a new *CacheAdapter, expiring the results according to the given clock.
+func NewCacheAdapter(clock *Clock) *CacheAdapter {
+	return &CacheAdapter{
+		clock:   clock,
+		entries: make(map[string]cacheEntry),
+	}
+}
+
+// Set stores the cache value for the given query, until the clock passes the query CacheDuration.
+func (ad *CacheAdapter) Set(ctx context.Context, qry query.Cacheable, res *query.Result) bool {
+	entry := cacheEntry{res: res, expiresAt: ad.clock.Now().Add(qry.CacheDuration())}
+	if tgb, implements := qry.(query.Taggable); implements {
+		for _, tag := range tgb.CacheTags() {
+			entry.tags = append(entry.tags, string(tag))
+		}
+	}
+	ad.mu.Lock()
+	defer ad.mu.Unlock()
+	ad.sets++
+	ad.entries[string(qry.CacheKey())] = entry
+	return true
+}
+
+// Get retrieves the cached result for the provided query, unless expired according to the clock.
+func (ad *CacheAdapter) Get(ctx context.Context, qry query.Cacheable) *query.Result {
+	ad.mu.Lock()
+	defer ad.mu.Unlock()
+	ad.gets++
+	entry, found := ad.entries[string(qry.CacheKey())]
+	if !found || !ad.clock.Now().Before(entry.expiresAt) {
+		return nil
+	}
+	ad.hits++
+	return entry.res
+}
+
+// Expire forcibly expires the query cache.
+func (ad *CacheAdapter) Expire(ctx context.Context, qry query.Cacheable) {
+	ad.mu.Lock()
+	ad.expires++
+	delete(ad.entries, string(qry.CacheKey()))
+	ad.mu.Unlock()
+}
+
+// ExpireTags forcibly expires the cached results of the queries tagged with any of the provided tags.
+func (ad *CacheAdapter) ExpireTags(ctx context.Context, tags ...[]byte) {
+	ad.mu.Lock()
+	defer ad.mu.Unlock()
+	for key, entry := range ad.entries {
+		if entry.tagged(tags) {
+			ad.expires++
+			delete(ad.entries, key)
+		}
+	}
+}
+
+// Shutdown does nothing, the adapter remains usable.
+func (ad *CacheAdapter) Shutdown() {
+}
+
+// Sets returns the number of results stored.
+func (ad *CacheAdapter) Sets() int {
+	ad.mu.Lock()
+	defer ad.mu.Unlock()
+	return ad.sets
+}
+
+// Gets returns the number of retrievals, and how many of them found a cached result.
+func (ad *CacheAdapter) Gets() (gets int, hits int) {
+	ad.mu.Lock()
+	defer ad.mu.Unlock()
+	return ad.gets, ad.hits
+}
+
+// Expires returns the number of results forcibly expired.
+func (ad *CacheAdapter) Expires() int {
+	ad.mu.Lock()
+	defer ad.mu.Unlock()
+	return ad.expires
+}
+
+//------Internal------//
+
+type cacheEntry struct {
+	res       *query.Result
+	expiresAt time.Time
+	tags      []string
+}
+
+func (entry cacheEntry) tagged(tags [][]byte) bool {
+	for _, tag := range tags {
+		for _, t := range entry.tags {
+			if t == string(tag) {
+				return true
+			}
+		}
+	}
+	return false
+}
@@ -0,0 +1,103 @@
+package querytest
+
+import (
+	"sync"
+	"time"
+
+	"github.com/io-da/query"
+)
+
+// Clock is a deterministic fake clock, only moving when told to. It implements query.Clock.
+// Provided to a bus (see query.Bus.Clock), hours of cache durations, timeouts and circuit breaker windows can be simulated in milliseconds.
+// Its timers fire once the clock is moved past their deadline.
+type Clock struct {
+	mu     sync.Mutex
+	now    time.Time
+	timers map[*timer]bool
+}
+
+// NewClock initializes a new *Clock, starting at the given moment.
+func NewClock(now time.Time) *Clock {
+	return &Clock{now: now, timers: make(map[*timer]bool)}
+}
+
+// Now returns the current moment of the clock.
+func (c *Clock) Now() time.Time {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.now
+}
+
+// NewTimer creates a timer firing once the clock is moved by the given duration.
+func (c *Clock) NewTimer(d time.Duration) query.Timer {
+	t := &timer{clock: c, c: make(chan time.Time, 1)}
+	t.Reset(d)
+	return t
+}
+
+// Advance moves the clock forward by the given duration, firing the timers reaching their deadline.
+func (c *Clock) Advance(d time.Duration) {
+	c.mu.Lock()
+	c.now = c.now.Add(d)
+	c.fire()
+	c.mu.Unlock()
+}
+
+// Set moves the clock to the given moment, firing the timers reaching their deadline.
+func (c *Clock) Set(now time.Time) {
+	c.mu.Lock()
+	c.now = now
+	c.fire()
+	c.mu.Unlock()
+}
+
+// Timers returns the number of timers waiting to fire.
+// It can be used to wait for the bus to be waiting on the clock, before moving it.
+func (c *Clock) Timers() int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return len(c.timers)
+}
+
+//------Internal------//
+
+type timer struct {
+	clock *Clock
+	c     chan time.Time
+	at    time.Time
+}
+
+// fire the timers reaching their deadline. The lock must be held by the caller.
+func (c *Clock) fire() {
+	for t := range c.timers {
+		if !t.at.After(c.now) {
+			delete(c.timers, t)
+			select {
+			case t.c <- c.now:
+			default:
+			}
+		}
+	}
+}
+
+func (t *timer) C() <-chan time.Time {
+	return t.c
+}
+
+func (t *timer) Stop() bool {
+	t.clock.mu.Lock()
+	defer t.clock.mu.Unlock()
+	active := t.clock.timers[t]
+	delete(t.clock.timers, t)
+	return active
+}
+
+func (t *timer) Reset(d time.Duration) bool {
+	t.clock.mu.Lock()
+	defer t.clock.mu.Unlock()
+	active := t.clock.timers[t]
+	t.at = t.clock.now.Add(d)
+	t.clock.timers[t] = true
+	t.clock.fire()
+	return active
+}
@@ -0,0 +1,158 @@
+// Package querytest provides the test doubles of the query bus, so applications can test their usage of the bus.
+// It includes a recording bus, stub handlers returning canned results, a recording error handler,
+// a cache adapter expiring its results according to a fake clock and the respective assertion helpers.
+package querytest
+
+import (
+	"context"
+	"errors"
+	"reflect"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/io-da/query"
+)
+
+// Call describes a query issued to the RecordingBus.
+type Call struct {
+	Query    query.Query
+	Result   *query.Result
+	Err      error
+	Iterator bool
+}
+
+// RecordingBus is a query bus recording the queries issued, their results and errors. It implements query.Querier.
+// It uses an ErrorRecorder as error handler and a CacheAdapter (expiring the results according to the Clock) as cache adapter.
+// The bus itself also uses the Clock, for its timeouts and cache durations.
+type RecordingBus struct {
+	*query.Bus
+	Errors *ErrorRecorder
+	Cache  *CacheAdapter
+	Clock  *Clock
+	mu     sync.Mutex
+	calls  []Call
+}
+
+// NewRecordingBus initializes a new *RecordingBus, shutting it down once the test finishes.
+// The handlers are provided as usual (e.g. StubHandler), before the queries are issued.
+func NewRecordingBus(t testing.TB) *RecordingBus {
+	rb := &RecordingBus{
+		Bus:    query.NewBus(),
+		Errors: &ErrorRecorder{},
+		Clock:  NewClock(time.Now()),
+	}
+	rb.Cache = NewCacheAdapter(rb.Clock)
+	rb.CacheAdapters(rb.Cache)
+	rb.Bus.Clock(rb.Clock)
+	rb.ErrorHandlers(rb.Errors)
+	rb.Use(&recorder{rb: rb})
+	t.Cleanup(rb.Shutdown)
+	return rb
+}
+
+// Calls returns the queries issued to the bus, in the order they were handled.
+func (rb *RecordingBus) Calls() []Call {
+	rb.mu.Lock()
+	defer rb.mu.Unlock()
+	return append([]Call(nil), rb.calls...)
+}
+
+// ErrorRecorder is an error handler recording the errors reported by the bus.
+type ErrorRecorder struct {
+	mu      sync.Mutex
+	reports []query.ErrorReport
+}
+
+// Handle records the error.
+func (rec *ErrorRecorder) Handle(ctx context.Context, qry query.Query, err error) {
+	rec.mu.Lock()
+	rec.reports = append(rec.reports, query.ErrorReport{Context: ctx, Query: qry, Err: err})
+	rec.mu.Unlock()
+}
+
+// Reports returns the errors recorded, in the order they were reported.
+func (rec *ErrorRecorder) Reports() []query.ErrorReport {
+	rec.mu.Lock()
+	defer rec.mu.Unlock()
+	return append([]query.ErrorReport(nil), rec.reports...)
+}
+
+// AssertQueried fails the test unless a query equal to the given query (same type and values) was issued to the bus.
+func AssertQueried(t testing.TB, rb *RecordingBus, qry query.Query) {
+	t.Helper()
+	for _, call := range rb.Calls() {
+		if reflect.DeepEqual(call.Query, qry) {
+			return
+		}
+	}
+	t.Errorf("Expected the query %s to be issued.", query.TypeName(qry))
+}
+
+// AssertNotQueried fails the test if a query equal to the given query (same type and values) was issued to the bus.
+func AssertNotQueried(t testing.TB, rb *RecordingBus, qry query.Query) {
+	t.Helper()
+	for _, call := range rb.Calls() {
+		if reflect.DeepEqual(call.Query, qry) {
+			t.Errorf("Expected the query %s not to be issued.", query.TypeName(qry))
+			return
+		}
+	}
+}
+
+// AssertCached fails the test unless the result of the query is cached (and not expired according to the Clock).
+func AssertCached(t testing.TB, rb *RecordingBus, qry query.Cacheable) {
+	t.Helper()
+	if rb.Cache.Get(context.Background(), qry) == nil {
+		t.Errorf("Expected the result of the query %s to be cached.", query.TypeName(qry))
+	}
+}
+
+// AssertNotCached fails the test if the result of the query is cached (and not expired according to the Clock).
+func AssertNotCached(t testing.TB, rb *RecordingBus, qry query.Cacheable) {
+	t.Helper()
+	if rb.Cache.Get(context.Background(), qry) != nil {
+		t.Errorf("Expected the result of the query %s not to be cached.", query.TypeName(qry))
+	}
+}
+
+// AssertErrored fails the test unless an error matching the target (see errors.Is) was reported for a query equal to the given query.
+// A nil target matches any error.
+func AssertErrored(t testing.TB, rb *RecordingBus, qry query.Query, target error) {
+	t.Helper()
+	for _, rep := range rb.Errors.Reports() {
+		if reflect.DeepEqual(rep.Query, qry) && (target == nil || errors.Is(rep.Err, target)) {
+			return
+		}
+	}
+	if target == nil {
+		t.Errorf("Expected an error to be reported for the query %s.", query.TypeName(qry))
+		return
+	}
+	t.Errorf("Expected the error %q to be reported for the query %s.", target.Error(), query.TypeName(qry))
+}
+
+//------Internal------//
+
+// recorder is the middleware recording the queries issued to the RecordingBus.
+type recorder struct {
+	rb *RecordingBus
+}
+
+func (rec *recorder) Query(ctx context.Context, qry query.Query, next query.QueryFunc) (*query.Result, error) {
+	res, err := next(ctx, qry)
+	rec.rb.record(Call{Query: qry, Result: res, Err: err})
+	return res, err
+}
+
+func (rec *recorder) IteratorQuery(ctx context.Context, qry query.Query, res *query.IteratorResult, next query.IteratorQueryFunc) error {
+	err := next(ctx, qry, res)
+	rec.rb.record(Call{Query: qry, Err: err, Iterator: true})
+	return err
+}
+
+func (rb *RecordingBus) record(call Call) {
+	rb.mu.Lock()
+	rb.calls = append(rb.calls, call)
+	rb.mu.Unlock()
+}
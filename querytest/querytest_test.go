@@ -0,0 +1,194 @@
+package querytest
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/io-da/query"
+	"github.com/io-da/query/cachetest"
+)
+
+type testQuery struct {
+	name string
+}
+
+func (*testQuery) ID() []byte {
+	return []byte("TEST")
+}
+
+type testCacheQuery struct {
+}
+
+func (*testCacheQuery) ID() []byte {
+	return []byte("TEST-CACHE")
+}
+
+func (*testCacheQuery) CacheKey() []byte {
+	return []byte("TEST-CACHE-KEY")
+}
+
+func (*testCacheQuery) CacheDuration() time.Duration {
+	return time.Minute
+}
+
+func (*testCacheQuery) CacheTags() [][]byte {
+	return [][]byte{[]byte("tests")}
+}
+
+var errTestUnavailable = errors.New("unavailable")
+
+func TestRecordingBus(t *testing.T) {
+	rb := NewRecordingBus(t)
+	hdl := NewStubHandler().
+		Returns(&testCacheQuery{}, "foo", "bar").
+		Fails(&testQuery{}, errTestUnavailable)
+	rb.Handlers(hdl)
+
+	var qr query.Querier = rb
+	res, err := qr.Query(context.Background(), &testCacheQuery{})
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	if vals := res.All(); len(vals) != 2 || vals[0] != "foo" || vals[1] != "bar" {
+		t.Errorf("Unexpected values %v.", vals)
+	}
+	AssertQueried(t, rb, &testCacheQuery{})
+	AssertCached(t, rb, &testCacheQuery{})
+
+	if res, _ = rb.Query(context.Background(), &testCacheQuery{}); !res.IsCached() || hdl.Calls(&testCacheQuery{}) != 1 {
+		t.Error("Expected the cached result to be used.")
+	}
+	rb.Clock.Advance(time.Minute)
+	AssertNotCached(t, rb, &testCacheQuery{})
+	if res, _ = rb.Query(context.Background(), &testCacheQuery{}); !res.IsFresh() || hdl.Calls(&testCacheQuery{}) != 2 {
+		t.Error("Expected the result to expire according to the clock.")
+	}
+	if sets, expires := rb.Cache.Sets(), rb.Cache.Expires(); sets != 2 || expires != 0 {
+		t.Errorf("Unexpected cache operations (%d sets, %d expires).", sets, expires)
+	}
+	rb.InvalidateTags(context.Background(), []byte("tests"))
+	AssertNotCached(t, rb, &testCacheQuery{})
+
+	if _, err = rb.Query(context.Background(), &testQuery{name: "foo"}); !errors.Is(err, errTestUnavailable) {
+		t.Error("Expected the stubbed error.")
+	}
+	AssertQueried(t, rb, &testQuery{name: "foo"})
+	AssertNotQueried(t, rb, &testQuery{name: "bar"})
+	AssertErrored(t, rb, &testQuery{name: "foo"}, errTestUnavailable)
+	if calls := rb.Calls(); len(calls) != 4 || calls[3].Err == nil || calls[3].Iterator {
+		t.Error("Unexpected recorded calls.")
+	}
+}
+
+func TestStubIteratorHandler(t *testing.T) {
+	rb := NewRecordingBus(t)
+	rb.InitializeIteratorHandlers(NewStubIteratorHandler().Yields(&testQuery{}, "foo", "bar"))
+
+	res, err := rb.IteratorQuery(context.Background(), &testQuery{name: "foo"})
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	vals := make([]interface{}, 0)
+	for val := range res.Iterate() {
+		vals = append(vals, val)
+	}
+	if len(vals) != 2 || vals[0] != "foo" || vals[1] != "bar" {
+		t.Errorf("Unexpected values %v.", vals)
+	}
+	if calls := rb.Calls(); len(calls) != 1 || !calls[0].Iterator {
+		t.Error("Expected the iterator query to be recorded.")
+	}
+}
+
+func TestCacheAdapter_Conformance(t *testing.T) {
+	clock := NewClock(time.Now())
+	cachetest.Run(t, cachetest.Suite{
+		New: func(t *testing.T) query.CacheAdapter {
+			return NewCacheAdapter(clock)
+		},
+		Advance: clock.Advance,
+	})
+}
+
+func TestMemoryCacheAdapter_Clock(t *testing.T) {
+	bus := query.NewBus()
+	clock := NewClock(time.Now())
+	bus.Clock(clock)
+	hdl := NewStubHandler().Returns(&testCacheQuery{}, "foo")
+	bus.Handlers(hdl)
+	defer bus.Shutdown()
+
+	if _, err := bus.Query(context.Background(), &testCacheQuery{}); err != nil {
+		t.Fatal(err.Error())
+	}
+	if res, _ := bus.Query(context.Background(), &testCacheQuery{}); !res.IsCached() || hdl.Calls(&testCacheQuery{}) != 1 {
+		t.Error("Expected the cached result to be used.")
+	}
+	clock.Advance(time.Hour * 2)
+	if res, _ := bus.Query(context.Background(), &testCacheQuery{}); !res.IsFresh() || hdl.Calls(&testCacheQuery{}) != 2 {
+		t.Error("Expected the default cache adapter to expire the result according to the clock of the bus.")
+	}
+}
+
+type testBlockingHandler struct {
+}
+
+func (hdl *testBlockingHandler) Handle(ctx context.Context, qry query.Query, res *query.Result) error {
+	<-ctx.Done()
+	return ctx.Err()
+}
+
+func TestClock(t *testing.T) {
+	start := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	clock := NewClock(start)
+	tmr := clock.NewTimer(time.Hour)
+	clock.Advance(time.Minute * 59)
+	select {
+	case <-tmr.C():
+		t.Fatal("Expected the timer not to fire before its deadline.")
+	default:
+	}
+	clock.Advance(time.Minute)
+	if at := <-tmr.C(); !at.Equal(start.Add(time.Hour)) || clock.Timers() != 0 {
+		t.Error("Expected the timer to fire once the clock reaches its deadline.")
+	}
+	if tmr.Reset(time.Second) || !tmr.Stop() || clock.Timers() != 0 {
+		t.Error("Expected the timer to be reset and stopped.")
+	}
+}
+
+func TestRecordingBus_VirtualTime(t *testing.T) {
+	rb := NewRecordingBus(t)
+	rb.Handlers(NewStubHandler().Fails(&testQuery{}, errTestUnavailable), &testBlockingHandler{})
+	rb.CircuitBreaker(1, time.Hour)
+	ctx := context.Background()
+
+	// the circuit remains open for an hour of the clock
+	if _, err := rb.Query(ctx, &testQuery{}); !errors.Is(err, errTestUnavailable) {
+		t.Fatal("Expected the stubbed error.")
+	}
+	if _, err := rb.Query(ctx, &testQuery{}); !errors.Is(err, query.CircuitOpenError) {
+		t.Error("Expected the circuit to be open.")
+	}
+	rb.Clock.Advance(time.Hour)
+	if _, err := rb.Query(ctx, &testQuery{}); !errors.Is(err, errTestUnavailable) {
+		t.Error("Expected the circuit to allow a probe once the clock passes the open duration.")
+	}
+
+	// the timeout of the queries expires according to the clock
+	rb.QueryTimeout(time.Hour)
+	errs := make(chan error)
+	go func() {
+		_, err := rb.Query(ctx, &testCacheQuery{})
+		errs <- err
+	}()
+	for rb.Clock.Timers() == 0 {
+		time.Sleep(time.Millisecond)
+	}
+	rb.Clock.Advance(time.Hour)
+	if err := <-errs; !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("Expected the query to time out, got %v.", err)
+	}
+}
@@ -0,0 +1,131 @@
+package querytest
+
+import (
+	"context"
+	"sync"
+
+	"github.com/io-da/query"
+)
+
+// StubHandler is a handler returning canned results, per query type (ID).
+// Queries of other types are ignored, so other handlers may still handle them.
+type StubHandler struct {
+	stubs
+}
+
+// NewStubHandler initializes a new *StubHandler.
+func NewStubHandler() *StubHandler {
+	return &StubHandler{stubs: newStubs()}
+}
+
+// Returns stubs the values added to the results of the queries with the same ID as the given query.
+func (hdl *StubHandler) Returns(qry query.Query, values ...interface{}) *StubHandler {
+	hdl.stub(qry, stub{values: values})
+	return hdl
+}
+
+// Fails stubs the error returned for the queries with the same ID as the given query.
+func (hdl *StubHandler) Fails(qry query.Query, err error) *StubHandler {
+	hdl.stub(qry, stub{err: err})
+	return hdl
+}
+
+// Handle provides the canned result of the query.
+func (hdl *StubHandler) Handle(ctx context.Context, qry query.Query, res *query.Result) error {
+	stb, found := hdl.called(qry)
+	if !found {
+		return nil
+	}
+	if stb.err != nil {
+		return stb.err
+	}
+	res.Handled()
+	for _, val := range stb.values {
+		res.Add(val)
+	}
+	return nil
+}
+
+// StubIteratorHandler is an iterator handler yielding canned values, per query type (ID).
+// Queries of other types are ignored, so other iterator handlers may still handle them.
+type StubIteratorHandler struct {
+	stubs
+}
+
+// NewStubIteratorHandler initializes a new *StubIteratorHandler.
+func NewStubIteratorHandler() *StubIteratorHandler {
+	return &StubIteratorHandler{stubs: newStubs()}
+}
+
+// Yields stubs the values yielded for the queries with the same ID as the given query.
+func (hdl *StubIteratorHandler) Yields(qry query.Query, values ...interface{}) *StubIteratorHandler {
+	hdl.stub(qry, stub{values: values})
+	return hdl
+}
+
+// Fails stubs the error returned for the queries with the same ID as the given query.
+func (hdl *StubIteratorHandler) Fails(qry query.Query, err error) *StubIteratorHandler {
+	hdl.stub(qry, stub{err: err})
+	return hdl
+}
+
+// Handle yields the canned values of the query.
+func (hdl *StubIteratorHandler) Handle(ctx context.Context, qry query.Query, res *query.IteratorResult) error {
+	stb, found := hdl.called(qry)
+	if !found {
+		return nil
+	}
+	if stb.err != nil {
+		return stb.err
+	}
+	res.Handled()
+	for _, val := range stb.values {
+		res.Yield(val)
+	}
+	return nil
+}
+
+//------Internal------//
+
+type stub struct {
+	values []interface{}
+	err    error
+}
+
+// stubs keeps the canned outcomes of the query types and how many times they were used.
+type stubs struct {
+	mu    *sync.Mutex
+	stubs map[string]stub
+	calls map[string]int
+}
+
+func newStubs() stubs {
+	return stubs{
+		mu:    &sync.Mutex{},
+		stubs: make(map[string]stub),
+		calls: make(map[string]int),
+	}
+}
+
+// Calls returns how many times the queries with the same ID as the given query were handled.
+func (s stubs) Calls(qry query.Query) int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.calls[string(qry.ID())]
+}
+
+func (s stubs) stub(qry query.Query, stb stub) {
+	s.mu.Lock()
+	s.stubs[string(qry.ID())] = stb
+	s.mu.Unlock()
+}
+
+func (s stubs) called(qry query.Query) (stub, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	stb, found := s.stubs[string(qry.ID())]
+	if found {
+		s.calls[string(qry.ID())]++
+	}
+	return stb, found
+}
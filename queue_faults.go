@@ -0,0 +1,49 @@
+package query
+
+import (
+	"context"
+	"time"
+)
+
+// QueueFaults describes the faults injected into the iterator query queues (see Bus.InjectQueueFaults).
+type QueueFaults struct {
+	// DequeueDelay delays the handling of every iterator query dequeued by the workers.
+	DequeueDelay time.Duration
+	// Saturated makes the queues behave as if they were full.
+	// The iterator queries are rejected or wait according to the IteratorEnqueueTimeout, until the faults are removed.
+	Saturated bool
+}
+
+// InjectQueueFaults injects faults into the iterator query queues, simulating a saturated bus without constructing real load.
+// It is intended for tests (e.g. verifying the timeouts and fallbacks of an application) and may be used at any moment.
+// Providing an empty QueueFaults removes the faults.
+func (bus *Bus) InjectQueueFaults(faults QueueFaults) {
+	old := bus.queueFaults.Swap(&queueFaults{QueueFaults: faults, changed: make(chan bool)}).(*queueFaults)
+	close(old.changed)
+}
+
+//------Internal------//
+
+// queueFaults are the injected faults, the changed channel being closed once they are replaced.
+type queueFaults struct {
+	QueueFaults
+	changed chan bool
+}
+
+func (bus *Bus) currentQueueFaults() *queueFaults {
+	return bus.queueFaults.Load().(*queueFaults)
+}
+
+// delayDequeue waits for the injected DequeueDelay, if any (or until the context is done).
+func (bus *Bus) delayDequeue(ctx context.Context) {
+	d := bus.currentQueueFaults().DequeueDelay
+	if d <= 0 {
+		return
+	}
+	t := bus.clock.NewTimer(d)
+	defer t.Stop()
+	select {
+	case <-t.C():
+	case <-ctx.Done():
+	}
+}
@@ -0,0 +1,126 @@
+// Package rediscache provides a query.CacheAdapter storing the query results in Redis.
+package rediscache
+
+import (
+	"context"
+	"sync/atomic"
+
+	"github.com/io-da/query"
+	"github.com/redis/go-redis/v9"
+)
+
+// CacheAdapter is the struct used for Redis caching purposes.
+// The results are serialized using the provided query.Codec and expire according to the query CacheDuration.
+// The results are serialized along with the version of their schema, being upgraded when read (see query.Bus.ResultSchema).
+type CacheAdapter struct {
+	client       redis.UniversalClient
+	codec        query.Codec
+	prefix       string
+	shuttingDown *uint32
+}
+
+// NewCacheAdapter initializes a new *CacheAdapter.
+// The adapter takes ownership of the client, closing it on Shutdown.
+func NewCacheAdapter(client redis.UniversalClient, codec query.Codec) *CacheAdapter {
+	return &CacheAdapter{
+		client:       client,
+		codec:        codec,
+		prefix:       "query:",
+		shuttingDown: new(uint32),
+	}
+}
+
+// Prefix may optionally be provided to tweak the prefix of the Redis keys.
+// It defaults to "query:".
+func (ad *CacheAdapter) Prefix(prefix string) {
+	ad.prefix = prefix
+}
+
+// Set stores the serialized result for the given query, using the query CacheDuration as TTL.
+// The keys of query.Taggable queries are also added to a Redis set per tag, expiring with the last of its keys.
+func (ad *CacheAdapter) Set(ctx context.Context, qry query.Cacheable, res *query.Result) bool {
+	if ad.isShuttingDown() {
+		return false
+	}
+	data, err := query.MarshalVersionedResult(ctx, ad.codec, qry, res)
+	if err != nil {
+		return false
+	}
+	tgb, implements := qry.(query.Taggable)
+	if !implements {
+		return ad.client.Set(ctx, ad.key(qry), data, qry.CacheDuration()).Err() == nil
+	}
+	_, err = ad.client.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+		key := ad.key(qry)
+		pipe.Set(ctx, key, data, qry.CacheDuration())
+		for _, tag := range tgb.CacheTags() {
+			tagKey := ad.tagKey(tag)
+			pipe.SAdd(ctx, tagKey, key)
+			pipe.ExpireNX(ctx, tagKey, qry.CacheDuration())
+			pipe.ExpireGT(ctx, tagKey, qry.CacheDuration())
+		}
+		return nil
+	})
+	return err == nil
+}
+
+// Get retrieves and deserializes the cached result for the provided query.
+// The results serialized with a previous version of their schema are migrated. Any failure is considered a cache miss.
+func (ad *CacheAdapter) Get(ctx context.Context, qry query.Cacheable) *query.Result {
+	if ad.isShuttingDown() {
+		return nil
+	}
+	data, err := ad.client.Get(ctx, ad.key(qry)).Bytes()
+	if err != nil {
+		return nil
+	}
+	res, err := query.UnmarshalVersionedResult(ctx, ad.codec, qry, data)
+	if err != nil {
+		return nil
+	}
+	return res
+}
+
+// Expire can optionally be used to forcibly expire a query cache.
+func (ad *CacheAdapter) Expire(ctx context.Context, qry query.Cacheable) {
+	if ad.isShuttingDown() {
+		return
+	}
+	ad.client.Del(ctx, ad.key(qry))
+}
+
+// ExpireTags forcibly expires the cached results of the queries tagged with any of the provided tags.
+func (ad *CacheAdapter) ExpireTags(ctx context.Context, tags ...[]byte) {
+	if ad.isShuttingDown() {
+		return
+	}
+	for _, tag := range tags {
+		tagKey := ad.tagKey(tag)
+		keys, err := ad.client.SMembers(ctx, tagKey).Result()
+		if err != nil {
+			continue
+		}
+		ad.client.Del(ctx, append(keys, tagKey)...)
+	}
+}
+
+// Shutdown stops the adapter and closes the client.
+func (ad *CacheAdapter) Shutdown() {
+	if atomic.CompareAndSwapUint32(ad.shuttingDown, 0, 1) {
+		_ = ad.client.Close()
+	}
+}
+
+//------Internal------//
+
+func (ad *CacheAdapter) key(qry query.Cacheable) string {
+	return ad.prefix + string(qry.CacheKey())
+}
+
+func (ad *CacheAdapter) tagKey(tag []byte) string {
+	return ad.prefix + "tag:" + string(tag)
+}
+
+func (ad *CacheAdapter) isShuttingDown() bool {
+	return atomic.LoadUint32(ad.shuttingDown) == 1
+}
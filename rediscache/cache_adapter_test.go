@@ -0,0 +1,200 @@
+package rediscache
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/io-da/query"
+	"github.com/io-da/query/cachetest"
+	"github.com/redis/go-redis/v9"
+)
+
+type testCacheQuery struct {
+}
+
+func (*testCacheQuery) ID() []byte {
+	return []byte("UUID-CACHE")
+}
+
+func (*testCacheQuery) CacheKey() []byte {
+	return []byte("CACHE-KEY")
+}
+
+func (*testCacheQuery) CacheDuration() time.Duration {
+	return time.Minute
+}
+
+type testTaggedQuery struct {
+	key string
+}
+
+func (*testTaggedQuery) ID() []byte {
+	return []byte("UUID-TAGGED")
+}
+
+func (qry *testTaggedQuery) CacheKey() []byte {
+	return []byte(qry.key)
+}
+
+func (*testTaggedQuery) CacheDuration() time.Duration {
+	return time.Minute
+}
+
+func (*testTaggedQuery) CacheTags() [][]byte {
+	return [][]byte{[]byte("products")}
+}
+
+type testCacheHandler struct {
+	calls int
+}
+
+func (hdl *testCacheHandler) Handle(ctx context.Context, qry query.Query, res *query.Result) error {
+	hdl.calls++
+	res.Add("bar")
+	res.SetTotal(1)
+	res.SetCursor("next")
+	return nil
+}
+
+func TestCacheAdapter_Conformance(t *testing.T) {
+	for name, codec := range map[string]query.Codec{"json": query.JSONCodec{}, "gob": query.GobCodec{}, "binary": query.NewBinaryCodec()} {
+		t.Run(name, func(t *testing.T) {
+			srv := miniredis.RunT(t)
+			cachetest.Run(t, cachetest.Suite{
+				New: func(t *testing.T) query.CacheAdapter {
+					srv.FlushAll()
+					return NewCacheAdapter(redis.NewClient(&redis.Options{Addr: srv.Addr()}), codec)
+				},
+				Advance: srv.FastForward,
+			})
+		})
+	}
+}
+
+func TestCacheAdapter(t *testing.T) {
+	for name, codec := range map[string]query.Codec{"json": query.JSONCodec{}, "gob": query.GobCodec{}} {
+		t.Run(name, func(t *testing.T) {
+			srv := miniredis.RunT(t)
+			adp := NewCacheAdapter(redis.NewClient(&redis.Options{Addr: srv.Addr()}), codec)
+			hdl := &testCacheHandler{}
+			bus := query.NewBus()
+			bus.Handlers(hdl)
+			bus.CacheAdapters(adp)
+			qry := &testCacheQuery{}
+
+			res, err := bus.Query(context.Background(), qry)
+			if err != nil {
+				t.Error(err.Error())
+			}
+			if !res.IsFresh() {
+				t.Error("Result was expected to be fresh.")
+			}
+			if ttl := srv.TTL("query:CACHE-KEY"); ttl != time.Minute {
+				t.Errorf("Unexpected TTL %s.", ttl)
+			}
+
+			res, err = bus.Query(context.Background(), qry)
+			if err != nil {
+				t.Error(err.Error())
+			}
+			if !res.IsCached() {
+				t.Error("Result was expected to be cached.")
+			}
+			if res.First() != "bar" || string(res.CacheKey()) != "CACHE-KEY" || res.CachedAt().IsZero() {
+				t.Error("Cached result was expected to be deserialized.")
+			}
+			if total, known := res.Total(); !known || total != 1 || res.Cursor() != "next" {
+				t.Error("Cached result metadata was expected to be deserialized.")
+			}
+
+			adp.Expire(context.Background(), qry)
+			res, _ = bus.Query(context.Background(), qry)
+			if !res.IsFresh() || hdl.calls != 2 {
+				t.Error("Result was expected to be fresh after expiring the cache.")
+			}
+
+			srv.FastForward(time.Minute)
+			res, _ = bus.Query(context.Background(), qry)
+			if !res.IsFresh() || hdl.calls != 3 {
+				t.Error("Result was expected to be fresh after the TTL.")
+			}
+
+			bus.Shutdown()
+			if adp.Set(context.Background(), qry, res) || adp.Get(context.Background(), qry) != nil {
+				t.Error("The adapter was not expected to be used after shutdown.")
+			}
+		})
+	}
+}
+
+func TestCacheAdapter_ExpireTags(t *testing.T) {
+	srv := miniredis.RunT(t)
+	adp := NewCacheAdapter(redis.NewClient(&redis.Options{Addr: srv.Addr()}), query.JSONCodec{})
+	hdl := &testCacheHandler{}
+	bus := query.NewBus()
+	bus.Handlers(hdl)
+	bus.CacheAdapters(adp)
+	defer bus.Shutdown()
+
+	for _, key := range []string{"A", "B"} {
+		if _, err := bus.Query(context.Background(), &testTaggedQuery{key: key}); err != nil {
+			t.Error(err.Error())
+		}
+	}
+	if members, _ := srv.Members("query:tag:products"); len(members) != 2 {
+		t.Errorf("Unexpected tag members %v.", members)
+	}
+	if ttl := srv.TTL("query:tag:products"); ttl != time.Minute {
+		t.Errorf("Unexpected tag TTL %s.", ttl)
+	}
+
+	bus.InvalidateTags(context.Background(), []byte("products"))
+	if srv.Exists("query:A") || srv.Exists("query:B") || srv.Exists("query:tag:products") {
+		t.Error("The tagged results were expected to be expired.")
+	}
+	res, _ := bus.Query(context.Background(), &testTaggedQuery{key: "A"})
+	if !res.IsFresh() || hdl.calls != 3 {
+		t.Error("Result was expected to be fresh after expiring the tag.")
+	}
+}
+
+func TestCacheAdapter_ResultSchema(t *testing.T) {
+	srv := miniredis.RunT(t)
+	newBus := func(hdl *testCacheHandler, migrations ...query.Migration) *query.Bus {
+		bus := query.NewBus()
+		bus.Handlers(hdl)
+		bus.CacheAdapters(NewCacheAdapter(redis.NewClient(&redis.Options{Addr: srv.Addr()}), query.JSONCodec{}))
+		bus.ResultSchema(&testCacheQuery{}, migrations...)
+		t.Cleanup(bus.Shutdown)
+		return bus
+	}
+	prevHdl := &testCacheHandler{}
+	prev := newBus(prevHdl)
+	nextHdl := &testCacheHandler{}
+	next := newBus(nextHdl, func(val interface{}) (interface{}, error) {
+		return map[string]interface{}{"name": val}, nil
+	})
+
+	if _, err := prev.Query(context.Background(), &testCacheQuery{}); err != nil {
+		t.Fatal(err.Error())
+	}
+	// the result cached by the previous version is upgraded instead of being handled again
+	res, err := next.Query(context.Background(), &testCacheQuery{})
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	if !res.IsCached() || nextHdl.calls != 0 || res.First().(map[string]interface{})["name"] != "bar" {
+		t.Errorf("Expected the cached result to be migrated, got %v.", res.All())
+	}
+
+	// the result cached by the next version is not read by the previous version
+	srv.FlushAll()
+	if _, err = next.Query(context.Background(), &testCacheQuery{}); err != nil {
+		t.Fatal(err.Error())
+	}
+	if res, err = prev.Query(context.Background(), &testCacheQuery{}); err != nil || res.IsCached() || prevHdl.calls != 2 {
+		t.Error("Expected the newer result to be considered a cache miss.")
+	}
+}
@@ -0,0 +1,281 @@
+package query
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+)
+
+// CacheOperationType identifies the type of a CacheOperation.
+type CacheOperationType int
+
+const (
+	// CacheOperationSet is the caching of a result.
+	CacheOperationSet CacheOperationType = iota
+	// CacheOperationExpire is the forced expiration of the cached result of a query.
+	CacheOperationExpire
+	// CacheOperationExpireTags is the forced expiration of the cached results of the given tags.
+	CacheOperationExpireTags
+)
+
+// CacheOperation describes a cache operation applied to the primary cache, to be replicated (see ReplicatedCacheAdapter).
+// Only the fields relevant to the operation type are provided.
+type CacheOperation struct {
+	Type   CacheOperationType
+	Query  Cacheable
+	Result *Result
+	Tags   [][]byte
+	// At is the moment the operation was applied to the primary cache, according to the clock of the bus (see ClockFrom).
+	At time.Time
+	// owner is the bus of the operation, provided to the replicator through the context (e.g. its clock, see ClockFrom)
+	owner *Bus
+	clock Clock
+}
+
+// CacheReplicator must be implemented for a type to qualify as a cache replicator.
+// Cache replicators apply the cache operations to a secondary cache (e.g. of a failover region), so it does not start cold when the traffic shifts.
+// Cache replicators implementing the Shutdown function are shut down along with the ReplicatedCacheAdapter.
+type CacheReplicator interface {
+	Replicate(ctx context.Context, op CacheOperation) error
+}
+
+// ReplicationStats describes the replication to a cache replicator.
+type ReplicationStats struct {
+	// Replicated is the number of operations replicated successfully.
+	Replicated uint64
+	// Failed is the number of operations the replicator failed to replicate.
+	Failed uint64
+	// Dropped is the number of operations dropped due to the replication buffer being full.
+	Dropped uint64
+	// Pending is the number of operations waiting to be replicated.
+	Pending int
+	// Lag is the delay between the last operation being applied to the primary cache and being replicated.
+	Lag time.Duration
+	// MaxLag is the highest delay observed.
+	MaxLag time.Duration
+	// LastError is the last error of the replicator, if any.
+	LastError error
+}
+
+// ReplicatedCacheAdapter is a cache adapter replicating the Set and Expire operations of a primary cache adapter to cache replicators.
+// The replication is asynchronous and best-effort: the operations are buffered per replicator, and dropped once its buffer is full.
+// The retrievals are only served by the primary cache adapter.
+type ReplicatedCacheAdapter struct {
+	sync.RWMutex
+	primary  CacheAdapter
+	replicas []*replica
+	timeout  time.Duration
+	stopped  bool
+}
+
+// NewReplicatedCacheAdapter initializes a new *ReplicatedCacheAdapter, buffering up to the given number of operations per replicator.
+// This function will also initialize the replication routine of each replicator.
+func NewReplicatedCacheAdapter(primary CacheAdapter, buffer int, replicators ...CacheReplicator) *ReplicatedCacheAdapter {
+	ad := &ReplicatedCacheAdapter{primary: primary, replicas: make([]*replica, len(replicators))}
+	for i, rpl := range replicators {
+		ad.replicas[i] = &replica{rpl: rpl, ops: make(chan CacheOperation, buffer), stopped: make(chan bool)}
+		go ad.replicas[i].replicate(ad)
+	}
+	return ad
+}
+
+// ReplicationTimeout may optionally be provided to limit the duration of each replicated operation.
+// It should be used *before* any query is performed.
+// It defaults to 0 (unlimited).
+func (ad *ReplicatedCacheAdapter) ReplicationTimeout(d time.Duration) {
+	ad.timeout = d
+}
+
+// Stats returns the replication stats of each replicator, in the order they were provided.
+func (ad *ReplicatedCacheAdapter) Stats() []ReplicationStats {
+	stats := make([]ReplicationStats, len(ad.replicas))
+	for i, rpl := range ad.replicas {
+		rpl.Lock()
+		stats[i] = rpl.stats
+		rpl.Unlock()
+		stats[i].Pending = len(rpl.ops)
+	}
+	return stats
+}
+
+// Set stores the cache value for the given query in the primary cache adapter, replicating it if cached.
+func (ad *ReplicatedCacheAdapter) Set(ctx context.Context, qry Cacheable, res *Result) bool {
+	if !ad.primary.Set(ctx, qry, res) {
+		return false
+	}
+	ad.replicate(ctx, CacheOperation{Type: CacheOperationSet, Query: qry, Result: res})
+	return true
+}
+
+// Get retrieves the cached result for the provided query from the primary cache adapter.
+func (ad *ReplicatedCacheAdapter) Get(ctx context.Context, qry Cacheable) *Result {
+	return ad.primary.Get(ctx, qry)
+}
+
+// Expire forcibly expires the query cache in the primary cache adapter, replicating the expiration.
+func (ad *ReplicatedCacheAdapter) Expire(ctx context.Context, qry Cacheable) {
+	ad.primary.Expire(ctx, qry)
+	ad.replicate(ctx, CacheOperation{Type: CacheOperationExpire, Query: qry})
+}
+
+// ExpireTags forcibly expires the cached results of the queries tagged with any of the provided tags, replicating the expiration.
+// The primary cache adapter is only considered if it implements the TagExpirer interface, the expiration being replicated regardless.
+func (ad *ReplicatedCacheAdapter) ExpireTags(ctx context.Context, tags ...[]byte) {
+	if exp, implements := ad.primary.(TagExpirer); implements {
+		exp.ExpireTags(ctx, tags...)
+	}
+	ad.replicate(ctx, CacheOperation{Type: CacheOperationExpireTags, Tags: tags})
+}
+
+// SetClock provides the clock to the primary cache adapter and to the replicators implementing the ClockSetter interface.
+func (ad *ReplicatedCacheAdapter) SetClock(clock Clock) {
+	if cs, implements := ad.primary.(ClockSetter); implements {
+		cs.SetClock(clock)
+	}
+	for _, rpl := range ad.replicas {
+		if cs, implements := rpl.rpl.(ClockSetter); implements {
+			cs.SetClock(clock)
+		}
+	}
+}
+
+// Shutdown is used to shut down the primary cache adapter and to stop the replication, once the buffered operations are replicated.
+func (ad *ReplicatedCacheAdapter) Shutdown() {
+	ad.primary.Shutdown()
+	ad.Lock()
+	if ad.stopped {
+		ad.Unlock()
+		return
+	}
+	ad.stopped = true
+	for _, rpl := range ad.replicas {
+		close(rpl.ops)
+	}
+	ad.Unlock()
+	for _, rpl := range ad.replicas {
+		<-rpl.stopped
+		if sd, implements := rpl.rpl.(interface{ Shutdown() }); implements {
+			sd.Shutdown()
+		}
+	}
+}
+
+// ReplicaAdapter returns a CacheReplicator applying the cache operations to the given cache adapter (e.g. of a failover region).
+// The results are stored for the remaining of their duration. The cache adapter is shut down along with the ReplicatedCacheAdapter.
+func ReplicaAdapter(adp CacheAdapter) CacheReplicator {
+	return &adapterReplicator{adp: adp}
+}
+
+//------Internal------//
+
+var (
+	errReplicaNotCached = errors.New("the result was not cached by the replica")
+	errReplicaNoTags    = errors.New("the replica does not support tags")
+)
+
+type replica struct {
+	sync.Mutex
+	rpl     CacheReplicator
+	ops     chan CacheOperation
+	stopped chan bool
+	stats   ReplicationStats
+}
+
+// replicate buffers the operation for every replicator, dropping it for the replicators with a full buffer.
+func (ad *ReplicatedCacheAdapter) replicate(ctx context.Context, op CacheOperation) {
+	op.owner, _ = ownerFrom(ctx)
+	op.clock = ClockFrom(ctx)
+	op.At = op.clock.Now()
+	ad.RLock()
+	defer ad.RUnlock()
+	if ad.stopped {
+		return
+	}
+	for _, rpl := range ad.replicas {
+		select {
+		case rpl.ops <- op:
+		default:
+			rpl.Lock()
+			rpl.stats.Dropped++
+			rpl.Unlock()
+		}
+	}
+}
+
+// replicate the buffered operations until the replication is stopped.
+func (rpl *replica) replicate(ad *ReplicatedCacheAdapter) {
+	defer close(rpl.stopped)
+	for op := range rpl.ops {
+		err := rpl.apply(op, ad.timeout)
+		lag := op.clock.Now().Sub(op.At)
+		rpl.Lock()
+		if err != nil {
+			rpl.stats.Failed++
+			rpl.stats.LastError = err
+		} else {
+			rpl.stats.Replicated++
+		}
+		rpl.stats.Lag = lag
+		if lag > rpl.stats.MaxLag {
+			rpl.stats.MaxLag = lag
+		}
+		rpl.Unlock()
+	}
+}
+
+// apply the operation, isolating the panics of the replicator.
+func (rpl *replica) apply(op CacheOperation, timeout time.Duration) (err error) {
+	ctx := context.Background()
+	if op.owner != nil {
+		ctx = context.WithValue(ctx, ownerKey, op.owner)
+	}
+	if timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, timeout)
+		defer cancel()
+	}
+	defer func() {
+		if r := recover(); r != nil {
+			err = fmt.Errorf("panic: %v", r)
+		}
+	}()
+	return rpl.rpl.Replicate(ctx, op)
+}
+
+type adapterReplicator struct {
+	adp CacheAdapter
+}
+
+func (rpl *adapterReplicator) Replicate(ctx context.Context, op CacheOperation) error {
+	switch op.Type {
+	case CacheOperationSet:
+		d := op.Result.ExpiresAt().Sub(ClockFrom(ctx).Now())
+		if d <= 0 {
+			return nil
+		}
+		if !rpl.adp.Set(ctx, withCacheDuration(op.Query, d), op.Result) {
+			return errReplicaNotCached
+		}
+	case CacheOperationExpire:
+		rpl.adp.Expire(ctx, op.Query)
+	case CacheOperationExpireTags:
+		exp, implements := rpl.adp.(TagExpirer)
+		if !implements {
+			return errReplicaNoTags
+		}
+		exp.ExpireTags(ctx, op.Tags...)
+	}
+	return nil
+}
+
+func (rpl *adapterReplicator) SetClock(clock Clock) {
+	if cs, implements := rpl.adp.(ClockSetter); implements {
+		cs.SetClock(clock)
+	}
+}
+
+func (rpl *adapterReplicator) Shutdown() {
+	rpl.adp.Shutdown()
+}
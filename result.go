@@ -15,6 +15,26 @@ type Result struct {
 	cacheKey  []byte
 	cachedAt  time.Time
 	expiresAt time.Time
+	metadata
+	revalidator Revalidator
+	// bound verifies the values provided to the result, if limited (see Sandbox). Once a value fails, the result admits no other value.
+	bound     *resultBound
+	violation error
+}
+
+// resultBound verifies the values of a result given the count and size of the values it holds, as they are provided.
+type resultBound struct {
+	verify func(v interface{}, count int, size *int) error
+	count  int
+	size   int
+}
+
+// metadata describes the result as a whole (e.g. the total count of a paginated collection).
+type metadata struct {
+	total      int
+	totalKnown bool
+	cursor     string
+	meta       map[string]interface{}
 }
 
 func newResult() *Result {
@@ -57,17 +77,48 @@ func (res *Result) ExpiresAt() time.Time {
 
 // Set all the data of this result
 func (res *Result) Set(data []interface{}) {
+	if res.bound != nil && !res.admit(true, data...) {
+		return
+	}
 	res.data = data
 }
 
 // Add an entry to the data slice
 func (res *Result) Add(data interface{}) {
+	if res.bound != nil && !res.admit(false, data) {
+		return
+	}
 	if len(res.data) == cap(res.data) {
 		res.increaseCapacity()
 	}
 	res.data = append(res.data, data)
 }
 
+// SetTotal sets the total count of the collection (e.g. across every page of a paginated query).
+func (res *Result) SetTotal(total int) {
+	res.Lock()
+	res.total = total
+	res.totalKnown = true
+	res.Unlock()
+}
+
+// SetCursor sets the cursor used to fetch the next page of the collection.
+func (res *Result) SetCursor(cursor string) {
+	res.Lock()
+	res.cursor = cursor
+	res.Unlock()
+}
+
+// Meta attaches arbitrary metadata to this result.
+func (res *Result) Meta(key string, val interface{}) {
+	res.Lock()
+	if res.meta == nil {
+		res.meta = make(map[string]interface{})
+	}
+	res.meta[key] = val
+	res.Unlock()
+}
+
 //------Fetch Data------//
 
 // First returns the first value of the data slice
@@ -83,8 +134,105 @@ func (res *Result) All() []interface{} {
 	return res.data
 }
 
+// Total returns the total count of the collection, if it was provided.
+func (res *Result) Total() (int, bool) {
+	res.Lock()
+	defer res.Unlock()
+	return res.total, res.totalKnown
+}
+
+// Cursor returns the cursor used to fetch the next page of the collection.
+// It returns an empty string if there is no next page (or it was not provided).
+func (res *Result) Cursor() string {
+	res.Lock()
+	defer res.Unlock()
+	return res.cursor
+}
+
+// MetaValue returns the metadata attached to this result with the given key.
+func (res *Result) MetaValue(key string) (interface{}, bool) {
+	res.Lock()
+	defer res.Unlock()
+	val, found := res.meta[key]
+	return val, found
+}
+
+// Metadata returns a copy of the arbitrary metadata attached to this result.
+func (res *Result) Metadata() map[string]interface{} {
+	res.Lock()
+	defer res.Unlock()
+	return res.metadata.copyMeta()
+}
+
 //------Internal------//
 
+func (md *metadata) copyMeta() map[string]interface{} {
+	if md.meta == nil {
+		return nil
+	}
+	meta := make(map[string]interface{}, len(md.meta))
+	for k, v := range md.meta {
+		meta[k] = v
+	}
+	return meta
+}
+
+// copy returns a copy of the result, sharing its values.
+func (res *Result) copy() *Result {
+	cp := &Result{
+		resultCore: newResultCore(),
+		cacheKey:   res.cacheKey,
+		cachedAt:   res.CachedAt(),
+		expiresAt:  res.ExpiresAt(),
+		metadata:   res.copyMetadata(),
+		data:       make([]interface{}, len(res.data)),
+	}
+	copy(cp.data, res.data)
+	atomic.StoreUint32(cp.stopPropagation, atomic.LoadUint32(res.stopPropagation))
+	atomic.StoreUint32(cp.handled, atomic.LoadUint32(res.handled))
+	atomic.StoreUint32(cp.fresh, atomic.LoadUint32(res.fresh))
+	if warning, deprecated := res.deprecation.Load().(string); deprecated {
+		cp.deprecate(warning)
+	}
+	cp.warnings.list = res.Warnings()
+	return cp
+}
+
+func (res *Result) copyMetadata() metadata {
+	res.Lock()
+	defer res.Unlock()
+	md := res.metadata
+	md.meta = res.metadata.copyMeta()
+	return md
+}
+
+// admit verifies the values against the bound of the result, recording the first violation.
+// The values replacing the data of the result (see Set) are verified on their own, the previous values no longer counting.
+func (res *Result) admit(replace bool, vs ...interface{}) bool {
+	res.Lock()
+	defer res.Unlock()
+	if res.violation != nil {
+		return false
+	}
+	if replace {
+		res.bound.count, res.bound.size = 0, 0
+	}
+	for _, v := range vs {
+		res.bound.count++
+		if res.violation = res.bound.verify(v, res.bound.count, &res.bound.size); res.violation != nil {
+			return false
+		}
+	}
+	return true
+}
+
+// boundViolation returns the first value verification that failed, if any.
+func (res *Result) boundViolation() error {
+	res.Lock()
+	defer res.Unlock()
+	return res.violation
+}
+
 func (res *Result) increaseCapacity() {
 	l := len(res.data)
 	c := int(math.Ceil(float64(cap(res.data)) * 1.1))
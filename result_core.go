@@ -1,6 +1,7 @@
 package query
 
 import (
+	"sync"
 	"sync/atomic"
 )
 
@@ -8,6 +9,8 @@ type resultCore struct {
 	stopPropagation *uint32
 	handled         *uint32
 	fresh           *uint32
+	deprecation     *atomic.Value
+	warnings        *warnings
 }
 
 func newResultCore() resultCore {
@@ -15,6 +18,8 @@ func newResultCore() resultCore {
 		stopPropagation: new(uint32),
 		handled:         new(uint32),
 		fresh:           new(uint32),
+		deprecation:     new(atomic.Value),
+		warnings:        &warnings{},
 	}
 	atomic.SwapUint32(res.fresh, 1)
 	return res
@@ -33,6 +38,26 @@ func (res *resultCore) Done() {
 	atomic.CompareAndSwapUint32(res.stopPropagation, 0, 1)
 }
 
+// Warn attaches a non-fatal warning to this result (e.g. a fallback was used to provide the data).
+// Once the query is handled, the warnings are also passed on to the warning handlers.
+func (res *resultCore) Warn(warning error) {
+	res.warnings.Lock()
+	res.warnings.list = append(res.warnings.list, warning)
+	res.warnings.Unlock()
+}
+
+// Warnings returns the warnings attached to this result.
+func (res *resultCore) Warnings() []error {
+	res.warnings.Lock()
+	defer res.warnings.Unlock()
+	if len(res.warnings.list) == 0 {
+		return nil
+	}
+	warnings := make([]error, len(res.warnings.list))
+	copy(warnings, res.warnings.list)
+	return warnings
+}
+
 // IsFresh can be used to verify if this result is fresh.
 func (res *resultCore) IsFresh() bool {
 	return atomic.LoadUint32(res.fresh) == 1
@@ -43,8 +68,22 @@ func (res *resultCore) IsCached() bool {
 	return atomic.LoadUint32(res.fresh) == 0
 }
 
+// DeprecationWarning returns the deprecation warning if the query of this result was marked as deprecated.
+// It returns an empty string otherwise.
+func (res *resultCore) DeprecationWarning() string {
+	if warning, deprecated := res.deprecation.Load().(string); deprecated {
+		return warning
+	}
+	return ""
+}
+
 //------Internal------//
 
+type warnings struct {
+	sync.Mutex
+	list []error
+}
+
 func (res *resultCore) propagationStopped() bool {
 	return atomic.LoadUint32(res.stopPropagation) == 1
 }
@@ -56,3 +95,7 @@ func (res *resultCore) isHandled() bool {
 func (res *resultCore) loadedFromCache() {
 	atomic.CompareAndSwapUint32(res.fresh, 1, 0)
 }
+
+func (res *resultCore) deprecate(warning string) {
+	res.deprecation.Store(warning)
+}
@@ -0,0 +1,188 @@
+package query
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+)
+
+// Revalidator is a cheap freshness check of a cached result, registered by its handler along with the result (see Result.Revalidate).
+// It reports whether the expired result is still fresh (e.g. comparing the version or modification date of the underlying data).
+type Revalidator func(ctx context.Context) (bool, error)
+
+// Revalidate registers the freshness check of this result, invoked by the bus once the cached result expires.
+// Results still fresh are cached again for another cache duration, otherwise the query is fully handled again.
+// The expired results remain revalidatable for one more cache duration, after which the query is fully handled again regardless.
+// Invalidating the result (see Bus.Invalidate and Bus.InvalidateTags) discards its freshness check.
+func (res *Result) Revalidate(fn Revalidator) {
+	res.Lock()
+	res.revalidator = fn
+	res.Unlock()
+}
+
+//------Internal------//
+
+const (
+	// revalidationSweep is the number of registrations between the removals of the revalidations no longer applicable.
+	revalidationSweep = 1024
+	// revalidationLimit bounds the number of revalidations kept, the ones closest to their end being evicted first
+	// (down to a revalidationSweep below the limit, for the evictions not to be repeated on every registration).
+	revalidationLimit = 16384
+)
+
+// revalidations keeps the expired results of the cache keys along with their freshness checks.
+type revalidations struct {
+	sync.Mutex
+	entries       map[string]*revalidation
+	registrations int
+}
+
+type revalidation struct {
+	res   *Result
+	fn    Revalidator
+	tags  [][]byte
+	until time.Time
+}
+
+func newRevalidations() *revalidations {
+	return &revalidations{entries: make(map[string]*revalidation)}
+}
+
+// register the freshness check of the cached result at the given moment, if any, replacing the previous one of the cache key.
+func (rv *revalidations) register(now time.Time, qry Cacheable, res *Result) {
+	res.Lock()
+	fn := res.revalidator
+	res.Unlock()
+	key := string(qry.CacheKey())
+	rv.Lock()
+	defer rv.Unlock()
+	if fn == nil {
+		delete(rv.entries, key)
+		return
+	}
+	// the expired result remains revalidatable for a grace period of one more cache duration
+	expiresAt := res.ExpiresAt()
+	cp := res.copy()
+	cp.revalidator = fn
+	entry := &revalidation{res: cp, fn: fn, until: expiresAt.Add(expiresAt.Sub(res.CachedAt()))}
+	if tgb, implements := qry.(Taggable); implements {
+		entry.tags = tgb.CacheTags()
+	}
+	rv.entries[key] = entry
+
+	rv.registrations++
+	if rv.registrations%revalidationSweep == 0 || len(rv.entries) > revalidationLimit {
+		rv.sweep(now)
+	}
+}
+
+// sweep removes the revalidations no longer applicable as of the given moment,
+// then the ones closest to their end while exceeding the limit.
+func (rv *revalidations) sweep(now time.Time) {
+	for k, e := range rv.entries {
+		if now.After(e.until) {
+			delete(rv.entries, k)
+		}
+	}
+	if len(rv.entries) <= revalidationLimit {
+		return
+	}
+	keys := make([]string, 0, len(rv.entries))
+	for k := range rv.entries {
+		keys = append(keys, k)
+	}
+	sort.Slice(keys, func(i, j int) bool {
+		return rv.entries[keys[i]].until.Before(rv.entries[keys[j]].until)
+	})
+	for _, k := range keys[:len(keys)-revalidationLimit+revalidationSweep] {
+		delete(rv.entries, k)
+	}
+}
+
+// take removes the revalidation of the cache key, returning it if still applicable as of the given moment.
+// Taking it atomically, only one of the concurrent misses of the cache key runs the freshness check, the others handling the query
+// (coalesced with each other, see flightKey).
+func (rv *revalidations) take(now time.Time, key []byte) (*revalidation, bool) {
+	rv.Lock()
+	defer rv.Unlock()
+	entry, exists := rv.entries[string(key)]
+	if !exists {
+		return nil, false
+	}
+	delete(rv.entries, string(key))
+	return entry, now.Before(entry.until)
+}
+
+// stale returns a copy of the expired result of the cache key, without removing it, if still applicable as of the given moment.
+func (rv *revalidations) stale(now time.Time, key []byte) (*Result, bool) {
+	rv.Lock()
+	defer rv.Unlock()
+	entry, exists := rv.entries[string(key)]
+	if !exists || !now.Before(entry.until) {
+		return nil, false
+	}
+	return entry.res.copy(), true
+}
+
+func (rv *revalidations) forget(key []byte) {
+	rv.Lock()
+	delete(rv.entries, string(key))
+	rv.Unlock()
+}
+
+func (rv *revalidations) forgetTags(tags [][]byte) {
+	rv.Lock()
+	defer rv.Unlock()
+	for k, entry := range rv.entries {
+		if sharesTag(entry.tags, tags) {
+			delete(rv.entries, k)
+		}
+	}
+}
+
+func (rv *revalidations) reset() {
+	rv.Lock()
+	rv.entries = make(map[string]*revalidation)
+	rv.Unlock()
+}
+
+func sharesTag(tags [][]byte, others [][]byte) bool {
+	for _, tag := range tags {
+		for _, other := range others {
+			if string(tag) == string(other) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// revalidate the expired result of the cacheable query, caching it again if still fresh.
+// The failing (or panicking) freshness checks are passed on to the warning handlers, the query being fully handled again.
+func (bus *Bus) revalidate(ctx context.Context, qry Query, cqry Cacheable) (*Result, bool) {
+	entry, applicable := bus.revalidations.take(bus.clock.Now(), cqry.CacheKey())
+	if !applicable {
+		return nil, false
+	}
+	fresh, err := entry.check(ctx)
+	if err != nil {
+		bus.warn(ctx, qry, []error{fmt.Errorf("query: the revalidation of the cached result failed: %w", err)})
+		return nil, false
+	}
+	if !fresh || !bus.store(ctx, qry, cqry, entry.res) {
+		return nil, false
+	}
+	bus.observe(ctx, Event{Type: CacheRevalidated, Query: qry})
+	return entry.res, true
+}
+
+func (entry *revalidation) check(ctx context.Context) (fresh bool, err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			err = fmt.Errorf("panic: %v", r)
+		}
+	}()
+	return entry.fn(ctx)
+}
@@ -0,0 +1,83 @@
+package query
+
+import (
+	"reflect"
+	"sort"
+)
+
+// Routable may optionally be implemented by handlers and iterator handlers.
+// Routable handlers are only provided the queries with the same ID as the queries returned by Handles.
+// This avoids propagating every query to handlers that are only interested in specific query types.
+type Routable interface {
+	Handles() []Query
+}
+
+// route registers the Routable handlers in the routes and returns the remaining (broadcast) handlers.
+// The routed queries are retained in the queries map, for description purposes.
+func route[H any](routes map[string][]H, queries map[string]Query, hdls []H) []H {
+	broadcast := make([]H, 0, len(hdls))
+	for _, hdl := range hdls {
+		if rtb, implements := any(hdl).(Routable); implements {
+			for _, qry := range rtb.Handles() {
+				key := string(qry.ID())
+				routes[key] = append(routes[key], hdl)
+				queries[key] = qry
+			}
+			continue
+		}
+		broadcast = append(broadcast, hdl)
+	}
+	return broadcast
+}
+
+// registered returns every unique handler, the routed handlers (sorted by query ID) followed by the broadcast handlers.
+func registered[H any](routes map[string][]H, broadcast []H) []H {
+	hdls := make([]H, 0, len(broadcast))
+	for _, key := range sortedKeys(routes) {
+		for _, hdl := range routes[key] {
+			hdls = appendUnique(hdls, hdl)
+		}
+	}
+	for _, hdl := range broadcast {
+		hdls = appendUnique(hdls, hdl)
+	}
+	return hdls
+}
+
+func appendUnique[H any](hdls []H, hdl H) []H {
+	if contains(hdls, hdl) {
+		return hdls
+	}
+	return append(hdls, hdl)
+}
+
+func contains[H any](hdls []H, hdl H) bool {
+	for _, h := range hdls {
+		if same(h, hdl) {
+			return true
+		}
+	}
+	return false
+}
+
+// sortedKeys returns the query IDs of the routes, sorted.
+func sortedKeys[H any](routes map[string][]H) []string {
+	keys := make([]string, 0, len(routes))
+	for key := range routes {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// same compares two handlers, handlers of non comparable types are always considered different.
+func same(a, b any) bool {
+	ta, tb := reflect.TypeOf(a), reflect.TypeOf(b)
+	if ta != tb {
+		return false
+	}
+	if ta != nil && !ta.Comparable() {
+		return false
+	}
+	return a == b
+}
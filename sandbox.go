@@ -0,0 +1,344 @@
+package query
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"runtime/debug"
+	"time"
+)
+
+// SandboxLimits describes the limits enforced on a sandboxed handler (see Sandbox and SandboxIterator).
+type SandboxLimits struct {
+	// Timeout limits the handling duration. Handlers ignoring their context are abandoned once it passes.
+	Timeout time.Duration
+	// MaxValues limits the number of values provided by the handler.
+	MaxValues int
+	// MaxBytes limits the total size of the values provided by the handler, as measured by the Sizer.
+	MaxBytes int
+	// Sizer measures the size of the values. It defaults to the size of their JSON encoding.
+	Sizer func(v interface{}) (int, error)
+	// DeniedContextKeys are the keys of the context values hidden from the handler.
+	DeniedContextKeys []interface{}
+}
+
+// Sandbox wraps a handler (e.g. provided by a plugin) so it cannot destabilize the host service.
+// The handler is provided a separate result, only merged into the actual result once the handler finishes within the limits.
+// The values are verified against the limits as they are provided, the first value exceeding them interrupting the handler.
+// Its panics are isolated (see ErrorHandlerPanicked), and it is abandoned once the timeout passes even if it ignores its context.
+// Exceeding the value limits fails the handling with an ErrorSandboxViolation. The sandbox remains Routable if the handler is.
+// The Limited, Probeable and CountableHandler interfaces of the handler are forwarded as well, its counts being sandboxed too.
+// The bus identifies the sandbox by the handler it wraps (see TypeName), sharing its circuit and concurrency limit.
+func Sandbox(hdl Handler, limits SandboxLimits) Handler {
+	sbx := &sandboxedHandler{sandbox: newSandbox(hdl, limits), hdl: hdl}
+	if rtb, implements := hdl.(Routable); implements {
+		return &routedSandboxedHandler{sandboxedHandler: sbx, Routable: rtb}
+	}
+	return sbx
+}
+
+// SandboxIterator wraps an iterator handler (e.g. provided by a plugin) so it cannot destabilize the host service.
+// The values yielded by the iterator handler are verified against the limits before being yielded into the actual result.
+// Its panics are isolated (see ErrorHandlerPanicked), and it is abandoned once the timeout passes even if it ignores its context.
+// Exceeding the value limits fails the handling with an ErrorSandboxViolation. The sandbox remains Routable if the iterator handler is.
+// The Limited and Probeable interfaces of the iterator handler are forwarded as well.
+// The bus identifies the sandbox by the iterator handler it wraps (see TypeName), sharing its circuit and concurrency limit.
+func SandboxIterator(hdl IteratorHandler, limits SandboxLimits) IteratorHandler {
+	sbx := &sandboxedIteratorHandler{sandbox: newSandbox(hdl, limits), hdl: hdl}
+	if rtb, implements := hdl.(Routable); implements {
+		return &routedSandboxedIteratorHandler{sandboxedIteratorHandler: sbx, Routable: rtb}
+	}
+	return sbx
+}
+
+//------Internal------//
+
+// unwrap returns the handler wrapped by the given sandboxes, if any.
+func unwrap(v interface{}) interface{} {
+	for {
+		switch w := v.(type) {
+		case interface{ Unwrap() Handler }:
+			v = w.Unwrap()
+		case interface{ Unwrap() IteratorHandler }:
+			v = w.Unwrap()
+		default:
+			return v
+		}
+	}
+}
+
+// forwarded returns the handler as the optional interface T, looking through the sandboxes wrapping it.
+func forwarded[T any](hdl interface{}) (T, bool) {
+	t, implements := unwrap(hdl).(T)
+	return t, implements
+}
+
+// sandboxedCountable is implemented by the sandboxes of handlers, counting within the sandbox if the handler is a CountableHandler.
+type sandboxedCountable interface {
+	counter() (CountableHandler, bool)
+}
+
+type sandbox struct {
+	hdl    interface{}
+	limits SandboxLimits
+}
+
+func newSandbox(hdl interface{}, limits SandboxLimits) sandbox {
+	if limits.Sizer == nil {
+		limits.Sizer = jsonSize
+	}
+	return sandbox{hdl: hdl, limits: limits}
+}
+
+func jsonSize(v interface{}) (int, error) {
+	data, err := json.Marshal(v)
+	return len(data), err
+}
+
+// context prepares the context of the handler, hiding the denied values and applying the timeout.
+func (sbx sandbox) context(ctx context.Context) (context.Context, context.CancelFunc) {
+	if len(sbx.limits.DeniedContextKeys) > 0 {
+		ctx = &sandboxContext{Context: ctx, denied: sbx.limits.DeniedContextKeys}
+	}
+	if sbx.limits.Timeout > 0 {
+		return context.WithTimeout(ctx, sbx.limits.Timeout)
+	}
+	return context.WithCancel(ctx)
+}
+
+// run the handling in a separate goroutine, isolating its panics.
+// The returned channel provides the error of the handling once finished.
+func (sbx sandbox) run(ctx context.Context, qry Query, handle func() error) (<-chan error, error) {
+	errs := make(chan error, 1)
+	err := spawn(ctx, qry, "sandbox", true, func() {
+		defer func() {
+			if r := recover(); r != nil {
+				errs <- NewErrorHandlerPanicked(qry, sbx.hdl, r, debug.Stack())
+			}
+		}()
+		errs <- handle()
+	})
+	return errs, err
+}
+
+// verify the value against the limits, given the count and size of the values verified so far.
+func (sbx sandbox) verify(qry Query, v interface{}, count int, size *int) error {
+	if sbx.limits.MaxValues > 0 && count > sbx.limits.MaxValues {
+		return NewErrorSandboxViolation(qry, sbx.hdl, fmt.Sprintf("more than %d values were provided", sbx.limits.MaxValues))
+	}
+	if sbx.limits.MaxBytes <= 0 {
+		return nil
+	}
+	n, err := sbx.limits.Sizer(v)
+	if err != nil {
+		return NewErrorSandboxViolation(qry, sbx.hdl, fmt.Sprintf("the size of a %T value could not be measured: %s", v, err.Error()))
+	}
+	if *size += n; *size > sbx.limits.MaxBytes {
+		return NewErrorSandboxViolation(qry, sbx.hdl, fmt.Sprintf("more than %d bytes were provided", sbx.limits.MaxBytes))
+	}
+	return nil
+}
+
+// sandboxContext hides the denied values of the context.
+type sandboxContext struct {
+	context.Context
+	denied []interface{}
+}
+
+func (ctx *sandboxContext) Value(key interface{}) interface{} {
+	for _, denied := range ctx.denied {
+		if key == denied {
+			return nil
+		}
+	}
+	return ctx.Context.Value(key)
+}
+
+type sandboxedHandler struct {
+	sandbox
+	hdl Handler
+}
+
+func (sbx *sandboxedHandler) Handle(ctx context.Context, qry Query, res *Result) error {
+	ctx, cancel := sbx.context(ctx)
+	defer cancel()
+	scratch := sbx.scratch(qry, cancel)
+	errs, err := sbx.run(ctx, qry, func() error { return sbx.hdl.Handle(ctx, qry, scratch) })
+	if err != nil {
+		return err
+	}
+	select {
+	case err = <-errs:
+	case <-ctx.Done():
+		// the handler is abandoned, its result remaining separate
+		err = ctx.Err()
+	}
+	if violation := scratch.boundViolation(); violation != nil {
+		return violation
+	}
+	if err != nil {
+		return err
+	}
+	sbx.merge(scratch, res)
+	return nil
+}
+
+// Unwrap returns the sandboxed handler.
+func (sbx *sandboxedHandler) Unwrap() Handler {
+	return sbx.hdl
+}
+
+// counter returns the sandboxed count of the handler, if it implements the CountableHandler interface.
+func (sbx *sandboxedHandler) counter() (CountableHandler, bool) {
+	ch, implements := sbx.hdl.(CountableHandler)
+	if !implements {
+		return nil, false
+	}
+	return &sandboxedCounter{sandboxedHandler: sbx, ch: ch}, true
+}
+
+// scratch returns the separate result of the handler, verifying each value against the limits as it is provided.
+// The handler is interrupted by the first value exceeding the limits.
+func (sbx *sandboxedHandler) scratch(qry Query, interrupt context.CancelFunc) *Result {
+	scratch := newResult()
+	if sbx.limits.MaxValues <= 0 && sbx.limits.MaxBytes <= 0 {
+		return scratch
+	}
+	scratch.bound = &resultBound{verify: func(v interface{}, count int, size *int) error {
+		err := sbx.verify(qry, v, count, size)
+		if err != nil {
+			interrupt()
+		}
+		return err
+	}}
+	return scratch
+}
+
+// merge the separate result of the handler into the actual result.
+func (sbx *sandboxedHandler) merge(scratch *Result, res *Result) {
+	for _, v := range scratch.All() {
+		res.Add(v)
+	}
+	md := scratch.copyMetadata()
+	if md.totalKnown {
+		res.SetTotal(md.total)
+	}
+	if md.cursor != "" {
+		res.SetCursor(md.cursor)
+	}
+	for key, val := range md.meta {
+		res.Meta(key, val)
+	}
+	for _, warning := range scratch.Warnings() {
+		res.Warn(warning)
+	}
+	scratch.Lock()
+	if fn := scratch.revalidator; fn != nil {
+		res.Revalidate(fn)
+	}
+	scratch.Unlock()
+	if scratch.propagationStopped() {
+		res.Done()
+	} else if scratch.isHandled() {
+		res.Handled()
+	}
+}
+
+type routedSandboxedHandler struct {
+	*sandboxedHandler
+	Routable
+}
+
+// sandboxedCounter counts within the limits of the sandbox, only the total being merged into the actual result.
+type sandboxedCounter struct {
+	*sandboxedHandler
+	ch CountableHandler
+}
+
+func (sbx *sandboxedCounter) Count(ctx context.Context, qry Query, res *Result) error {
+	ctx, cancel := sbx.context(ctx)
+	defer cancel()
+	scratch := newResult()
+	errs, err := sbx.run(ctx, qry, func() error { return sbx.ch.Count(ctx, qry, scratch) })
+	if err != nil {
+		return err
+	}
+	select {
+	case err = <-errs:
+	case <-ctx.Done():
+		err = ctx.Err()
+	}
+	if err != nil {
+		return err
+	}
+	if total, known := scratch.Total(); known {
+		res.SetTotal(total)
+	}
+	return nil
+}
+
+type sandboxedIteratorHandler struct {
+	sandbox
+	hdl IteratorHandler
+}
+
+func (sbx *sandboxedIteratorHandler) Handle(ctx context.Context, qry Query, res *IteratorResult) error {
+	ctx, cancel := sbx.context(ctx)
+	defer cancel()
+	scratch := newIteratorResult(0)
+	errs, err := sbx.run(ctx, qry, func() error {
+		defer scratch.close()
+		return sbx.hdl.Handle(ctx, qry, scratch)
+	})
+	if err != nil {
+		return err
+	}
+	// the values yielded after the handling is abandoned are discarded
+	defer func() {
+		_ = spawn(ctx, qry, "sandbox drain", false, func() {
+			for range scratch.proxy {
+			}
+		})
+	}()
+
+	count, size := 0, 0
+	for {
+		select {
+		case v, open := <-scratch.proxy:
+			if !open {
+				err := <-errs
+				sbx.merge(scratch, res)
+				return err
+			}
+			count++
+			if err := sbx.verify(qry, v, count, &size); err != nil {
+				return err
+			}
+			res.Yield(v)
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+}
+
+// Unwrap returns the sandboxed iterator handler.
+func (sbx *sandboxedIteratorHandler) Unwrap() IteratorHandler {
+	return sbx.hdl
+}
+
+// merge the state of the separate result of the iterator handler into the actual result.
+func (sbx *sandboxedIteratorHandler) merge(scratch *IteratorResult, res *IteratorResult) {
+	for _, warning := range scratch.Warnings() {
+		res.Warn(warning)
+	}
+	if scratch.propagationStopped() {
+		res.Done()
+	} else if scratch.isHandled() {
+		res.Handled()
+	}
+}
+
+type routedSandboxedIteratorHandler struct {
+	*sandboxedIteratorHandler
+	Routable
+}
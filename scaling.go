@@ -0,0 +1,130 @@
+package query
+
+import (
+	"sync/atomic"
+	"time"
+)
+
+// autoscaling is the configuration of the iterator worker autoscaler.
+type autoscaling struct {
+	min      int
+	max      int
+	interval time.Duration
+}
+
+// ScaleIteratorWorkers adjusts the number of workers of the default iterator lane (see IteratorWorkerPoolSize) of an initialized bus.
+// Surplus workers finish the iterator query they are handling before stopping.
+// This function blocks until the surplus workers are stopped.
+func (bus *Bus) ScaleIteratorWorkers(workers int) {
+	if !bus.isInitialized() || bus.isShuttingDown() || workers <= 0 {
+		return
+	}
+	bus.scaleIteratorLane(bus.defaultIteratorLane(), workers)
+}
+
+// IteratorWorkers returns the number of workers of the default iterator lane.
+func (bus *Bus) IteratorWorkers() int {
+	if !bus.isInitialized() {
+		return 0
+	}
+	lane := bus.defaultIteratorLane()
+	lane.Lock()
+	defer lane.Unlock()
+	return lane.workers
+}
+
+// IteratorAutoscaling may optionally be provided to scale the workers of the default iterator lane automatically, between the given bounds.
+// Every interval, the workers are scaled up by the number of iterator queries waiting in the queue, or down by one if any of them is idle.
+// It can only be used *before* the bus is initialized.
+func (bus *Bus) IteratorAutoscaling(min int, max int, interval time.Duration) {
+	if bus.isInitialized() || min <= 0 || max < min || interval <= 0 {
+		return
+	}
+	bus.autoscaling = &autoscaling{min: min, max: max, interval: interval}
+}
+
+//------Internal------//
+
+func (as *autoscaling) bound(workers int) int {
+	if workers < as.min {
+		return as.min
+	}
+	if workers > as.max {
+		return as.max
+	}
+	return workers
+}
+
+func (bus *Bus) defaultIteratorLane() *iteratorLane {
+	return bus.iteratorLane(nil)
+}
+
+func (bus *Bus) startIteratorWorkers(lane *iteratorLane, workers int) {
+	for i := 0; i < workers; i++ {
+		bus.iteratorWorkerUp()
+		go bus.runIteratorWorker(lane, bus.closed)
+	}
+}
+
+// scaleIteratorLane adjusts the number of workers of the lane, retiring the surplus workers once the lane is unlocked,
+// since they may first have to finish the iterator query they are handling.
+func (bus *Bus) scaleIteratorLane(lane *iteratorLane, workers int) {
+	lane.Lock()
+	if lane.stopped {
+		lane.Unlock()
+		return
+	}
+	if workers > lane.workers {
+		bus.startIteratorWorkers(lane, workers-lane.workers)
+	}
+	surplus := lane.workers - workers
+	lane.workers = workers
+	lane.Unlock()
+
+	for i := 0; i < surplus; i++ {
+		lane.retire <- true
+		<-lane.retired
+		bus.iteratorWorkerDown()
+	}
+}
+
+func (bus *Bus) startAutoscaler() {
+	if bus.autoscaling == nil {
+		return
+	}
+	bus.autoscalerStop = make(chan bool)
+	go bus.autoscale(bus.defaultIteratorLane(), bus.autoscalerStop)
+}
+
+func (bus *Bus) stopAutoscaler() {
+	if bus.autoscalerStop != nil {
+		close(bus.autoscalerStop)
+		bus.autoscalerStop = nil
+	}
+}
+
+func (bus *Bus) autoscale(lane *iteratorLane, stop <-chan bool) {
+	t := bus.clock.NewTimer(bus.autoscaling.interval)
+	defer t.Stop()
+	for {
+		select {
+		case <-stop:
+			return
+		case <-t.C():
+			t.Reset(bus.autoscaling.interval)
+		}
+
+		lane.Lock()
+		workers := lane.workers
+		lane.Unlock()
+		target := workers
+		if waiting := len(lane.queue); waiting > 0 {
+			target = workers + waiting
+		} else if int(atomic.LoadInt32(lane.busy)) < workers {
+			target = workers - 1
+		}
+		if target = bus.autoscaling.bound(target); target != workers {
+			bus.scaleIteratorLane(lane, target)
+		}
+	}
+}
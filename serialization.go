@@ -0,0 +1,74 @@
+package query
+
+import (
+	"context"
+	"sync"
+)
+
+// Serializable may optionally be implemented by queries that must not be handled concurrently.
+// Queries sharing the same serialization key are handled one at a time (per bus).
+type Serializable interface {
+	SerializeOn() []byte
+}
+
+// serialLock is the lock of a serialization key, shared by the queries handled or waiting to be.
+type serialLock struct {
+	ch   chan bool
+	refs int
+}
+
+// serializer keeps the locks of the serialization keys in use.
+type serializer struct {
+	sync.Mutex
+	locks map[string]*serialLock
+}
+
+func newSerializer() *serializer {
+	return &serializer{
+		locks: make(map[string]*serialLock),
+	}
+}
+
+// lock waits for the lock of the given key, unless the context is done first.
+func (s *serializer) lock(ctx context.Context, key string) (func(), error) {
+	s.Lock()
+	l, exists := s.locks[key]
+	if !exists {
+		l = &serialLock{ch: make(chan bool, 1)}
+		s.locks[key] = l
+	}
+	l.refs++
+	s.Unlock()
+
+	select {
+	case l.ch <- true:
+		return func() {
+			<-l.ch
+			s.release(key, l)
+		}, nil
+	case <-ctx.Done():
+		s.release(key, l)
+		return nil, ctx.Err()
+	}
+}
+
+func (s *serializer) release(key string, l *serialLock) {
+	s.Lock()
+	l.refs--
+	if l.refs == 0 {
+		delete(s.locks, key)
+	}
+	s.Unlock()
+}
+
+//------Bus------//
+
+// serialize waits for the serialization key of the query, if it implements the Serializable interface.
+// The returned function must be used to let the next query with the same key proceed.
+func (bus *Bus) serialize(ctx context.Context, qry Query) (func(), error) {
+	srl, implements := qry.(Serializable)
+	if !implements {
+		return func() {}, nil
+	}
+	return bus.serializer.lock(ctx, string(srl.SerializeOn()))
+}
@@ -0,0 +1,137 @@
+package query
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// ShutdownContext shuts down the query bus gracefully, draining the queries in flight.
+// New queries are rejected, while the queries being handled (and the queued iterator queries) are handled until the context is done.
+// Afterwards, the remaining queries are aborted (their contexts are cancelled) and fail with QueryAbortedError.
+// The cache adapters are only shut down once every query is finished, the aborted queries being waited for during the grace period
+// (see GoroutineGracePeriod) in case their handlers are slow to honor the cancellation.
+// The goroutines spawned on behalf of the queries still running after the grace period are reported (see GoroutineGracePeriod).
+// It returns the context error if the queries had to be aborted.
+func (bus *Bus) ShutdownContext(ctx context.Context) error {
+	if !atomic.CompareAndSwapUint32(bus.shuttingDown, 0, 1) {
+		return nil
+	}
+	// subscriptions are long-lived, they are ended rather than drained
+	bus.subscriptions.end()
+	bus.views.stop()
+	err := bus.activity.wait(ctx)
+	if err != nil {
+		bus.activity.abort()
+		bus.awaitAborted()
+	}
+	bus.auditGoroutines()
+	bus.shutdown()
+	return err
+}
+
+//------Internal------//
+
+// activity keeps track of the queries in flight, to drain (or abort) them on shutdown.
+type activity struct {
+	sync.Mutex
+	nextID   uint64
+	inFlight map[uint64]*inFlight
+	idle     chan bool
+	aborted  *uint32
+}
+
+// inFlight is a query in flight, with the function cancelling its context.
+type inFlight struct {
+	lineage *Lineage
+	started time.Time
+	cancel  context.CancelFunc
+}
+
+func newActivity() *activity {
+	return &activity{
+		inFlight: make(map[uint64]*inFlight),
+		aborted:  new(uint32),
+	}
+}
+
+// start keeps track of a query issued on the given bus,
+// returning its cancellable context (carrying its lineage) and the function to call once it is finished.
+func (a *activity) start(ctx context.Context, owner *Bus, qry Query) (context.Context, func()) {
+	ctx, cancel := context.WithCancel(ctx)
+	a.Lock()
+	a.nextID++
+	id := a.nextID
+	lin := lineage(ctx, owner, id, qry)
+	a.inFlight[id] = &inFlight{lineage: lin, started: owner.clock.Now(), cancel: cancel}
+	a.Unlock()
+	return context.WithValue(ctx, lineageKey, lin), func() {
+		cancel()
+		a.Lock()
+		delete(a.inFlight, id)
+		if len(a.inFlight) == 0 && a.idle != nil {
+			close(a.idle)
+			a.idle = nil
+		}
+		a.Unlock()
+	}
+}
+
+// wait blocks until every query is finished, unless the context is done first.
+func (a *activity) wait(ctx context.Context) error {
+	select {
+	case <-a.idled():
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// idled returns a channel closed once every query is finished.
+func (a *activity) idled() <-chan bool {
+	a.Lock()
+	defer a.Unlock()
+	if len(a.inFlight) == 0 {
+		idle := make(chan bool)
+		close(idle)
+		return idle
+	}
+	if a.idle == nil {
+		a.idle = make(chan bool)
+	}
+	return a.idle
+}
+
+// abort cancels the context of every query in flight.
+func (a *activity) abort() {
+	atomic.StoreUint32(a.aborted, 1)
+	a.Lock()
+	for _, f := range a.inFlight {
+		f.cancel()
+	}
+	a.Unlock()
+}
+
+// awaitAborted waits for the aborted queries to finish, during the grace period of the goroutines at most.
+func (bus *Bus) awaitAborted() {
+	t := bus.clock.NewTimer(bus.goroutines.grace)
+	defer t.Stop()
+	select {
+	case <-bus.activity.idled():
+	case <-t.C():
+	}
+}
+
+func (a *activity) reset() {
+	atomic.StoreUint32(a.aborted, 0)
+}
+
+// abortedError replaces the cancellation errors of the queries aborted by the shutdown.
+func (bus *Bus) abortedError(err error) error {
+	if atomic.LoadUint32(bus.activity.aborted) == 1 && errors.Is(err, context.Canceled) {
+		return QueryAbortedError
+	}
+	return err
+}
@@ -0,0 +1,102 @@
+package query
+
+import (
+	"context"
+	"sync"
+)
+
+// Snapshot must be implemented for a type to qualify as a snapshot.
+// Snapshots describe a consistent point in time of the data, shared by a group of pinned queries (see Pin).
+// e.g. a shared read transaction, or a snapshot token (a read timestamp, a log sequence number, etc.).
+type Snapshot interface {
+	// Token identifies the point in time of the snapshot.
+	Token() string
+	// Release frees the resources of the snapshot (e.g. rolls back the read transaction), once the pinned queries are finished.
+	Release()
+}
+
+// SnapshotOpener is the signature of the functions opening the snapshot of a group of pinned queries (see Pin).
+type SnapshotOpener func(ctx context.Context) (Snapshot, error)
+
+// SnapshotToken is a Snapshot only made of its token, with nothing to release.
+type SnapshotToken string
+
+// Token returns the token of the snapshot.
+func (tok SnapshotToken) Token() string {
+	return string(tok)
+}
+
+// Release does nothing, the token holding no resources.
+func (SnapshotToken) Release() {}
+
+// Pin returns a copy of the context pinning the queries issued with it (and their nested queries) to a shared snapshot,
+// so the queries of a composite page do not mix data from different points in time.
+// The snapshot is opened by the first handler requesting it (see SnapshotFrom), then shared by every other handler.
+// It is opened with the values of the provided context, but without its cancellation nor the cancellation of the query opening it,
+// the snapshot outliving that query.
+// The returned function releases the snapshot, and must be called once the pinned queries are finished.
+// Pinning an already pinned context joins its snapshot instead, the returned function doing nothing.
+// Pinned queries bypass the cache adapters and are not coalesced with other queries, their results belonging to the snapshot.
+func Pin(ctx context.Context, open SnapshotOpener) (context.Context, func()) {
+	if _, pinned := ctx.Value(snapshotKey).(*pin); pinned {
+		return ctx, func() {}
+	}
+	p := &pin{ctx: context.WithoutCancel(ctx), open: open}
+	return context.WithValue(ctx, snapshotKey, p), p.release
+}
+
+// SnapshotFrom returns the snapshot the queries of the context are pinned to, opening it if not opened yet.
+// Handlers participating in the snapshot are expected to read their data as of it.
+// It returns a nil snapshot if the context is not pinned, and SnapshotReleasedError once the snapshot is released.
+// The error of the opening is returned to every handler of the group.
+func SnapshotFrom(ctx context.Context) (Snapshot, error) {
+	p, pinned := ctx.Value(snapshotKey).(*pin)
+	if !pinned {
+		return nil, nil
+	}
+	return p.snapshot()
+}
+
+//------Internal------//
+
+// pin is the snapshot shared by a group of pinned queries, opened once.
+type pin struct {
+	sync.Mutex
+	ctx      context.Context
+	open     SnapshotOpener
+	snap     Snapshot
+	err      error
+	opened   bool
+	released bool
+}
+
+func (p *pin) snapshot() (Snapshot, error) {
+	p.Lock()
+	defer p.Unlock()
+	if p.released {
+		return nil, SnapshotReleasedError
+	}
+	if !p.opened {
+		p.snap, p.err = p.open(p.ctx)
+		p.opened = true
+	}
+	return p.snap, p.err
+}
+
+func (p *pin) release() {
+	p.Lock()
+	defer p.Unlock()
+	if p.released {
+		return
+	}
+	p.released = true
+	if p.opened && p.err == nil && p.snap != nil {
+		p.snap.Release()
+	}
+}
+
+// isPinned reports whether the queries of the context are pinned to a snapshot.
+func isPinned(ctx context.Context) bool {
+	_, pinned := ctx.Value(snapshotKey).(*pin)
+	return pinned
+}
@@ -0,0 +1,152 @@
+package query
+
+import (
+	"context"
+	"sort"
+	"sync"
+	"time"
+)
+
+// StalenessStats describes the age of the data served from the cache and the materialized views, for a query type (see Bus.Staleness).
+// The age is the time elapsed since the result was produced (cached or refreshed), as of the moment it was served.
+type StalenessStats struct {
+	// Type is the type name of the query (see TypeName).
+	Type string
+	// Buckets count the results served by age, each counting the results older than the bound of the previous bucket.
+	// The results older than the bound of the last bucket are counted by Overflow.
+	Buckets  []StalenessBucket
+	Overflow uint64
+	// Count is the number of results served.
+	Count uint64
+	// Sum is the total age of the results served.
+	Sum time.Duration
+	// Max is the highest age served.
+	Max time.Duration
+	// SLO is the freshness SLO of the query type, if any (see FreshnessSLO).
+	SLO time.Duration
+	// Violations is the number of results served older than the freshness SLO.
+	Violations uint64
+}
+
+// StalenessBucket counts the results served up to an age (inclusive).
+type StalenessBucket struct {
+	UpperBound time.Duration
+	Count      uint64
+}
+
+// Staleness returns the age of the data served from the cache and the materialized views, per query type (ordered by type).
+// Only the query types served from the cache (or from a materialized view) are reported.
+func (bus *Bus) Staleness() []StalenessStats {
+	return bus.staleness.stats()
+}
+
+// StalenessBuckets may optionally be provided to replace the upper bounds (in ascending order) of the buckets counting the results served by age.
+// It should be provided *before* any query is performed.
+// It defaults to 1s, 5s, 30s, 1m, 5m, 15m, 1h, 6h and 24h.
+func (bus *Bus) StalenessBuckets(bounds ...time.Duration) {
+	bus.staleness.Lock()
+	bus.staleness.bounds = bounds
+	bus.staleness.types = make(map[string]*StalenessStats)
+	bus.staleness.Unlock()
+}
+
+// FreshnessSLO may optionally be provided to set the maximum age of the data served for the query type, from the cache or a materialized view.
+// Every result served older than it is counted as a violation (see Staleness), and observed as a StaleResultServed event.
+// A duration lesser or equal to zero removes the SLO.
+func (bus *Bus) FreshnessSLO(qry Query, d time.Duration) {
+	typ := TypeName(qry)
+	bus.staleness.Lock()
+	defer bus.staleness.Unlock()
+	if d <= 0 {
+		delete(bus.staleness.slos, typ)
+	} else {
+		bus.staleness.slos[typ] = d
+	}
+	if st, exists := bus.staleness.types[typ]; exists {
+		st.SLO = bus.staleness.slos[typ]
+	}
+}
+
+//------Internal------//
+
+var defaultStalenessBuckets = []time.Duration{
+	time.Second, 5 * time.Second, 30 * time.Second,
+	time.Minute, 5 * time.Minute, 15 * time.Minute,
+	time.Hour, 6 * time.Hour, 24 * time.Hour,
+}
+
+// staleness keeps the histograms of the age of the data served, per query type.
+type staleness struct {
+	sync.Mutex
+	bounds []time.Duration
+	types  map[string]*StalenessStats
+	slos   map[string]time.Duration
+}
+
+func newStaleness() *staleness {
+	return &staleness{
+		bounds: defaultStalenessBuckets,
+		types:  make(map[string]*StalenessStats),
+		slos:   make(map[string]time.Duration),
+	}
+}
+
+// record the age of a result served for the query, returning whether it violates the freshness SLO of its type.
+func (s *staleness) record(qry Query, age time.Duration) bool {
+	typ := TypeName(qry)
+	s.Lock()
+	defer s.Unlock()
+	st, exists := s.types[typ]
+	if !exists {
+		st = &StalenessStats{Type: typ, Buckets: make([]StalenessBucket, len(s.bounds)), SLO: s.slos[typ]}
+		for i, bound := range s.bounds {
+			st.Buckets[i].UpperBound = bound
+		}
+		s.types[typ] = st
+	}
+	i := sort.Search(len(st.Buckets), func(i int) bool {
+		return age <= st.Buckets[i].UpperBound
+	})
+	if i < len(st.Buckets) {
+		st.Buckets[i].Count++
+	} else {
+		st.Overflow++
+	}
+	st.Count++
+	st.Sum += age
+	if age > st.Max {
+		st.Max = age
+	}
+	if st.SLO > 0 && age > st.SLO {
+		st.Violations++
+		return true
+	}
+	return false
+}
+
+func (s *staleness) stats() []StalenessStats {
+	s.Lock()
+	stats := make([]StalenessStats, 0, len(s.types))
+	for _, st := range s.types {
+		cp := *st
+		cp.Buckets = append([]StalenessBucket(nil), st.Buckets...)
+		stats = append(stats, cp)
+	}
+	s.Unlock()
+	sort.Slice(stats, func(i, j int) bool {
+		return stats[i].Type < stats[j].Type
+	})
+	return stats
+}
+
+// served observes the result of the query served from the cache or a materialized view, recording its age.
+func (bus *Bus) served(ctx context.Context, typ EventType, qry Query, producedAt time.Time) {
+	age := bus.since(producedAt)
+	if age < 0 {
+		age = 0
+	}
+	bus.observe(ctx, Event{Type: typ, Query: qry, Age: age})
+	if bus.staleness.record(qry, age) {
+		bus.observe(ctx, Event{Type: StaleResultServed, Query: qry, Age: age})
+	}
+}
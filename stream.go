@@ -0,0 +1,113 @@
+package query
+
+import (
+	"context"
+	"reflect"
+)
+
+// Stream is a typed consumer of an iterator result, sparing the consumers the assertion of every value.
+type Stream[T any] struct {
+	res    *IteratorResult
+	values <-chan interface{}
+	closed bool
+}
+
+// NewStream initializes a new *Stream[T] consuming the provided iterator result.
+// The result must not be iterated otherwise.
+func NewStream[T any](res *IteratorResult) *Stream[T] {
+	return &Stream[T]{res: res}
+}
+
+// Next returns the next value of the result and whether it was provided.
+// Once the result is fully iterated, it returns false and the error that interrupted the handling of the query, if any.
+// Values that are not of type T are skipped, returning an ErrorUnexpectedValueType.
+// Once the stream is closed, it returns false.
+func (s *Stream[T]) Next(ctx context.Context) (T, bool, error) {
+	var zero T
+	if s.closed {
+		return zero, false, nil
+	}
+	if s.values == nil {
+		s.values = s.res.Iterate()
+	}
+	select {
+	case <-ctx.Done():
+		return zero, false, ctx.Err()
+	case val, ok := <-s.values:
+		if !ok {
+			return zero, false, s.res.Err()
+		}
+		typed, isT := val.(T)
+		if !isT {
+			return zero, true, NewErrorUnexpectedValueType(val, reflect.TypeOf(&zero).Elem().String())
+		}
+		return typed, true, nil
+	}
+}
+
+// Close abandons the rest of the stream, consuming the remaining values of the result in the background
+// so its handlers are not left waiting for a consumer. It must be used when the stream is not iterated until its end.
+func (s *Stream[T]) Close() {
+	if s.closed {
+		return
+	}
+	s.closed = true
+	if s.values == nil {
+		s.values = s.res.Iterate()
+	}
+	go func(values <-chan interface{}) {
+		for range values {
+		}
+	}(s.values)
+}
+
+// Seq returns a sequence of the values and errors of the stream, compatible with iter.Seq2[T, error].
+// The sequence ends once the result is fully iterated (or the context is done), the last error being provided, if any.
+// The stream is closed once the sequence ends before the result is fully iterated (see Close).
+func (s *Stream[T]) Seq(ctx context.Context) func(yield func(T, error) bool) {
+	return func(yield func(T, error) bool) {
+		for {
+			val, ok, err := s.Next(ctx)
+			if !ok {
+				if ctx.Err() != nil {
+					s.Close()
+				}
+				if err != nil {
+					yield(val, err)
+				}
+				return
+			}
+			if !yield(val, err) {
+				s.Close()
+				return
+			}
+		}
+	}
+}
+
+// Collect returns every value of the stream, skipping the values that are not of type T.
+// It returns the error that interrupted the handling of the query, if any, otherwise the first ErrorUnexpectedValueType encountered.
+// Once the context is done, it closes the stream (see Close), returning the values collected so far along with the context error.
+func (s *Stream[T]) Collect(ctx context.Context) ([]T, error) {
+	values := make([]T, 0)
+	var skipped error
+	for {
+		val, ok, err := s.Next(ctx)
+		if !ok {
+			if ctx.Err() != nil {
+				s.Close()
+			}
+			if err == nil {
+				err = skipped
+			}
+			return values, err
+		}
+		if err != nil {
+			if skipped == nil {
+				skipped = err
+			}
+			continue
+		}
+		values = append(values, val)
+	}
+}
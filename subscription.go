@@ -0,0 +1,200 @@
+package query
+
+import (
+	"context"
+	"sync"
+	"sync/atomic"
+)
+
+// SubscriptionHandler must be implemented for a type to qualify as a subscription handler.
+// Subscription handlers publish the updates of long-lived queries (e.g. reactive read models) until the context is done.
+// Handlers not interested in the query should return nil right away.
+type SubscriptionHandler interface {
+	Subscribe(ctx context.Context, qry Query, sub *Subscription) error
+}
+
+// Subscription is the struct returned from subscriptions (see Bus.Subscribe).
+// It provides the updates published by the subscription handlers, until it is unsubscribed.
+type Subscription struct {
+	ctx     context.Context
+	cancel  context.CancelFunc
+	updates chan interface{}
+	done    chan bool
+	err     *atomic.Value
+}
+
+// SubscriptionHandlers may optionally be provided.
+// They will receive the queries issued using Bus.Subscribe (Routable subscription handlers are also supported).
+func (bus *Bus) SubscriptionHandlers(hdls ...SubscriptionHandler) {
+	bus.registry.Lock()
+	bus.subscriptionRoutes = make(map[string][]SubscriptionHandler)
+	bus.subscriptionHandlers = route(bus.subscriptionRoutes, bus.queries, hdls)
+	bus.registry.Unlock()
+}
+
+// Subscribe issues a long-lived query to the subscription handlers, each running in its own routine.
+// The subscription lasts until the context is done, Unsubscribe is called or every subscription handler returns.
+// A handler failing ends the subscription, its error being passed on to the error handlers and provided by Subscription.Err.
+// Subscriptions are ended when the bus shuts down. *Subscriptions are not cached and do not pass through the middlewares*.
+func (bus *Bus) Subscribe(ctx context.Context, qry Query) (*Subscription, error) {
+	ctx, done := bus.activity.start(ctx, bus, qry)
+	ctx, cancel := context.WithCancel(ctx)
+	sub := &Subscription{
+		ctx:     ctx,
+		cancel:  cancel,
+		updates: make(chan interface{}, bus.iteratorResultBuffer),
+		done:    make(chan bool),
+		err:     new(atomic.Value),
+	}
+	// the subscription is tracked beforehand, so it is ended by a shutdown started meanwhile
+	bus.subscriptions.add(sub)
+	reject := func(err error) (*Subscription, error) {
+		bus.subscriptions.remove(sub)
+		cancel()
+		done()
+		return nil, err
+	}
+	if err := bus.isValid(ctx, qry); err != nil {
+		return reject(err)
+	}
+	if bus.isShuttingDown() {
+		err := BusIsShuttingDownError
+		bus.error(ctx, qry, err)
+		return reject(err)
+	}
+	ctx, err := bus.decorate(ctx, qry)
+	if err == nil {
+		err = bus.validate(ctx, qry)
+	}
+	if err != nil {
+		return reject(err)
+	}
+	hdls := bus.subscriptionHandlersOf(qry)
+	if len(hdls) == 0 {
+		err = NewErrorNoQueryHandlersFound(qry)
+		bus.error(ctx, qry, err)
+		return reject(err)
+	}
+
+	wg := &sync.WaitGroup{}
+	for _, hdl := range hdls {
+		wg.Add(1)
+		hdl := hdl
+		err = spawn(ctx, qry, "subscription", true, func() {
+			defer wg.Done()
+			if err := bus.callHandler(qry, hdl, func() error { return hdl.Subscribe(ctx, qry, sub) }); err != nil && !(isContextError(err) && ctx.Err() != nil) {
+				bus.error(ctx, qry, err)
+				sub.fail(err)
+			}
+		})
+		if err != nil {
+			bus.error(ctx, qry, err)
+			sub.fail(err)
+			wg.Done()
+		}
+	}
+	_ = spawn(ctx, qry, "subscription", false, func() {
+		wg.Wait()
+		cancel()
+		close(sub.updates)
+		close(sub.done)
+		bus.subscriptions.remove(sub)
+		done()
+	})
+	return sub, nil
+}
+
+//------Provide Data------//
+
+// Publish provides an update to the subscriber.
+// It blocks until the update is received (or buffered), returning the context error if the subscription ended meanwhile.
+// Updates must not be published once the subscription handler returns.
+func (sub *Subscription) Publish(update interface{}) error {
+	select {
+	case <-sub.ctx.Done():
+		return sub.ctx.Err()
+	default:
+	}
+	select {
+	case sub.updates <- update:
+		return nil
+	case <-sub.ctx.Done():
+		return sub.ctx.Err()
+	}
+}
+
+//------Fetch Data------//
+
+// Updates is used to receive the updates published by the subscription handlers.
+// The channel is closed once the subscription ends.
+func (sub *Subscription) Updates() <-chan interface{} {
+	return sub.updates
+}
+
+// Unsubscribe ends the subscription, cancelling the context of the subscription handlers.
+// The subscription is fully ended once the Done channel is closed.
+func (sub *Subscription) Unsubscribe() {
+	sub.cancel()
+}
+
+// Done returns a channel that is closed once the subscription ends (every subscription handler returned).
+func (sub *Subscription) Done() <-chan bool {
+	return sub.done
+}
+
+// Err returns the error of the subscription handler that ended the subscription, if any.
+// It should be used once the subscription ends.
+func (sub *Subscription) Err() error {
+	if fail, failed := sub.err.Load().(iteratorFailure); failed {
+		return fail.err
+	}
+	return nil
+}
+
+//------Internal------//
+
+func (sub *Subscription) fail(err error) {
+	sub.err.CompareAndSwap(nil, iteratorFailure{err: err})
+	sub.cancel()
+}
+
+// subscriptions keeps track of the active subscriptions, to end them on shutdown.
+type subscriptions struct {
+	sync.Mutex
+	active map[*Subscription]bool
+}
+
+func newSubscriptions() *subscriptions {
+	return &subscriptions{active: make(map[*Subscription]bool)}
+}
+
+func (s *subscriptions) add(sub *Subscription) {
+	s.Lock()
+	s.active[sub] = true
+	s.Unlock()
+}
+
+func (s *subscriptions) remove(sub *Subscription) {
+	s.Lock()
+	delete(s.active, sub)
+	s.Unlock()
+}
+
+// end every active subscription.
+func (s *subscriptions) end() {
+	s.Lock()
+	for sub := range s.active {
+		sub.cancel()
+	}
+	s.Unlock()
+}
+
+// subscriptionHandlersOf returns the subscription handlers of the query, the routed handlers followed by the broadcast handlers.
+func (bus *Bus) subscriptionHandlersOf(qry Query) []SubscriptionHandler {
+	bus.registry.RLock()
+	defer bus.registry.RUnlock()
+	routed := bus.subscriptionRoutes[string(qry.ID())]
+	hdls := make([]SubscriptionHandler, 0, len(routed)+len(bus.subscriptionHandlers))
+	hdls = append(hdls, routed...)
+	return append(hdls, bus.subscriptionHandlers...)
+}
@@ -0,0 +1,135 @@
+package query
+
+import (
+	"context"
+	"time"
+)
+
+// TieredCacheAdapter is a cache adapter layering other cache adapters, ordered from the fastest tier to the slowest (e.g. memory and Redis).
+// The tiers are read in order and a result found in a slower tier is written back to the faster tiers (read-through).
+// The results are written to every tier, from the slowest to the fastest, so the faster tiers never hold results missing from the slower ones.
+type TieredCacheAdapter struct {
+	tiers []*cacheTier
+}
+
+// NewTieredCacheAdapter initializes a new *TieredCacheAdapter, using the adapters as tiers in the given order.
+func NewTieredCacheAdapter(adps ...CacheAdapter) *TieredCacheAdapter {
+	ad := &TieredCacheAdapter{tiers: make([]*cacheTier, len(adps))}
+	for i, adp := range adps {
+		ad.tiers[i] = &cacheTier{adp: adp, ttlScale: 1}
+	}
+	return ad
+}
+
+// TTLScale may optionally be provided to scale the cache duration of the results stored in the tier (index of the adapter).
+// e.g. a scale of 0.1 keeps the results in a memory tier for a tenth of the query CacheDuration, while a slower tier keeps them for the whole duration.
+// It should be used *before* any query is performed.
+func (ad *TieredCacheAdapter) TTLScale(tier int, scale float64) {
+	if tier >= 0 && tier < len(ad.tiers) && scale > 0 {
+		ad.tiers[tier].ttlScale = scale
+	}
+}
+
+// Set stores the cache value for the given query in every tier, starting with the slowest.
+// It returns true if any of the tiers cached the result.
+func (ad *TieredCacheAdapter) Set(ctx context.Context, qry Cacheable, res *Result) bool {
+	cached := false
+	for i := len(ad.tiers) - 1; i >= 0; i-- {
+		if ad.tiers[i].set(ctx, qry, res) {
+			cached = true
+		}
+	}
+	return cached
+}
+
+// Get retrieves the cached result for the provided query from the first tier holding it, back-filling the faster tiers.
+// Results older than the maximum staleness of the context (see WithMaxStaleness) are bypassed, the next tiers being checked instead.
+func (ad *TieredCacheAdapter) Get(ctx context.Context, qry Cacheable) *Result {
+	maxStaleness, limited := MaxStaleness(ctx)
+	for i, tier := range ad.tiers {
+		res := tier.adp.Get(ctx, qry)
+		if res == nil || (limited && ClockFrom(ctx).Now().Sub(res.CachedAt()) > maxStaleness) {
+			continue
+		}
+		for j := i - 1; j >= 0; j-- {
+			ad.tiers[j].backfill(ctx, qry, res)
+		}
+		return res
+	}
+	return nil
+}
+
+// Expire forcibly expires the query cache in every tier, starting with the slowest.
+// This way a concurrent retrieval can not back-fill a faster tier with the expired result.
+func (ad *TieredCacheAdapter) Expire(ctx context.Context, qry Cacheable) {
+	for i := len(ad.tiers) - 1; i >= 0; i-- {
+		ad.tiers[i].adp.Expire(ctx, qry)
+	}
+}
+
+// ExpireTags forcibly expires the cached results of the queries tagged with any of the provided tags, starting with the slowest tier.
+// Only the tiers implementing the TagExpirer interface are considered.
+func (ad *TieredCacheAdapter) ExpireTags(ctx context.Context, tags ...[]byte) {
+	for i := len(ad.tiers) - 1; i >= 0; i-- {
+		if exp, implements := ad.tiers[i].adp.(TagExpirer); implements {
+			exp.ExpireTags(ctx, tags...)
+		}
+	}
+}
+
+// SetClock provides the clock to the tiers implementing the ClockSetter interface.
+func (ad *TieredCacheAdapter) SetClock(clock Clock) {
+	for _, tier := range ad.tiers {
+		if cs, implements := tier.adp.(ClockSetter); implements {
+			cs.SetClock(clock)
+		}
+	}
+}
+
+// Shutdown is used to shut down every tier.
+func (ad *TieredCacheAdapter) Shutdown() {
+	for _, tier := range ad.tiers {
+		tier.adp.Shutdown()
+	}
+}
+
+//------Internal------//
+
+type cacheTier struct {
+	adp      CacheAdapter
+	ttlScale float64
+}
+
+func (tier *cacheTier) set(ctx context.Context, qry Cacheable, res *Result) bool {
+	if tier.ttlScale == 1 {
+		return tier.adp.Set(ctx, qry, res)
+	}
+	return tier.store(ctx, qry, res, tier.expiresAt(qry, res))
+}
+
+// backfill stores the result found in a slower tier, for the remaining of its duration.
+func (tier *cacheTier) backfill(ctx context.Context, qry Cacheable, res *Result) bool {
+	expiresAt := tier.expiresAt(qry, res)
+	if res.ExpiresAt().Before(expiresAt) {
+		expiresAt = res.ExpiresAt()
+	}
+	return tier.store(ctx, qry, res, expiresAt)
+}
+
+// store the result in the tier until the given moment.
+// The adapters are provided a copy of the query with the respective duration (and a copy of the result, if its expiration differs).
+func (tier *cacheTier) store(ctx context.Context, qry Cacheable, res *Result, expiresAt time.Time) bool {
+	d := expiresAt.Sub(ClockFrom(ctx).Now())
+	if d <= 0 {
+		return false
+	}
+	if !expiresAt.Equal(res.ExpiresAt()) {
+		res = res.copy()
+		res.expires(expiresAt)
+	}
+	return tier.adp.Set(ctx, withCacheDuration(qry, d), res)
+}
+
+func (tier *cacheTier) expiresAt(qry Cacheable, res *Result) time.Time {
+	return res.CachedAt().Add(time.Duration(float64(qry.CacheDuration()) * tier.ttlScale))
+}
@@ -0,0 +1,49 @@
+package query
+
+import (
+	"context"
+	"errors"
+	"time"
+)
+
+// Timeoutable may optionally be implemented by queries to specify their own handling timeout.
+// It overrides the timeout provided to the bus using the QueryTimeout function.
+// A timeout lesser or equal to zero disables the timeout for the query.
+type Timeoutable interface {
+	Timeout() time.Duration
+}
+
+// QueryTimeout may optionally be provided to limit the handling duration of every query.
+// The context provided to the middlewares and handlers is wrapped with the respective deadline.
+// For iterator queries the timeout starts once the result is being iterated.
+// It defaults to 0 (no timeout).
+func (bus *Bus) QueryTimeout(d time.Duration) {
+	bus.queryTimeout = d
+}
+
+//------Internal------//
+
+// withTimeout wraps the context with the timeout applicable to the query.
+// The returned cancel function is nil if no timeout is applicable.
+func (bus *Bus) withTimeout(ctx context.Context, qry Query) (context.Context, context.CancelFunc) {
+	d := bus.timeout(qry)
+	if d <= 0 {
+		return ctx, nil
+	}
+	if bus.clock != SystemClock {
+		return bus.withDeadline(ctx, qry, d, false)
+	}
+	return context.WithTimeout(ctx, d)
+}
+
+// timeout returns the timeout applicable to the query.
+func (bus *Bus) timeout(qry Query) time.Duration {
+	if qry, implements := qry.(Timeoutable); implements {
+		return qry.Timeout()
+	}
+	return bus.queryTimeout
+}
+
+func isContextError(err error) bool {
+	return errors.Is(err, context.Canceled) || errors.Is(err, context.DeadlineExceeded)
+}
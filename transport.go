@@ -0,0 +1,17 @@
+package query
+
+import "context"
+
+// RemoteTransport may optionally be provided to dispatch the queries without local handlers to a remote peer.
+// The transport populates the provided result, just as a handler would.
+type RemoteTransport interface {
+	Query(ctx context.Context, qry Query, res *Result) error
+	IteratorQuery(ctx context.Context, qry Query, res *IteratorResult) error
+}
+
+// RemoteTransport may optionally be provided.
+// The queries (and iterator queries) not handled by any local handler are then dispatched using the transport.
+// The remote peer should not dispatch the queries back, to avoid loops.
+func (bus *Bus) RemoteTransport(tpt RemoteTransport) {
+	bus.transport = tpt
+}
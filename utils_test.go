@@ -2,6 +2,7 @@ package query
 
 import (
 	"context"
+	"encoding/json"
 	"errors"
 	"sync"
 	"sync/atomic"
@@ -31,12 +32,79 @@ func (*testQueryError) ID() []byte {
 	return []byte("UUID-ERROR")
 }
 
+type testTaggedCacheQuery struct {
+	key string
+}
+
+func (*testTaggedCacheQuery) ID() []byte {
+	return []byte("UUID-TAGGED-CACHE")
+}
+
+func (qry *testTaggedCacheQuery) CacheKey() []byte {
+	return []byte(qry.key)
+}
+
+func (*testTaggedCacheQuery) CacheDuration() time.Duration {
+	return time.Minute
+}
+
+func (*testTaggedCacheQuery) CacheTags() [][]byte {
+	return [][]byte{[]byte("TAG")}
+}
+
+type testSerializedQuery struct {
+	key string
+}
+
+func (*testSerializedQuery) ID() []byte {
+	return []byte("UUID-SERIALIZED")
+}
+
+func (qry *testSerializedQuery) SerializeOn() []byte {
+	return []byte(qry.key)
+}
+
+type testPaginatedQuery struct {
+	PaginatedCache
+}
+
+func (*testPaginatedQuery) ID() []byte {
+	return []byte("UUID-PAGINATED")
+}
+
+func newTestPaginatedQuery(collection string, pag Pagination) *testPaginatedQuery {
+	return &testPaginatedQuery{PaginatedCache{Collection: collection, Pagination: pag, Duration: time.Minute}}
+}
+
+type testPriorityQuery struct {
+	priority int
+}
+
+func (*testPriorityQuery) ID() []byte {
+	return []byte("UUID-PRIORITY")
+}
+
+func (qry *testPriorityQuery) Priority() int {
+	return qry.priority
+}
+
 type testQueryString string
 
 func (testQueryString) ID() []byte {
 	return []byte("UUID")
 }
 
+// testCoalesceQuery is a query that is not cacheable, opting in to be coalesced during the cold start window.
+type testCoalesceQuery string
+
+func (testCoalesceQuery) ID() []byte {
+	return []byte("UUID-COALESCE")
+}
+
+func (qry testCoalesceQuery) CoalesceKey() []byte {
+	return []byte(qry)
+}
+
 type testQueryUnsupported struct {
 }
 
@@ -74,6 +142,18 @@ func (*testCacheQuery2) CacheDuration() time.Duration {
 	return 0
 }
 
+type testTimeoutQuery struct {
+	timeout time.Duration
+}
+
+func (*testTimeoutQuery) ID() []byte {
+	return []byte("UUID-TIMEOUT")
+}
+
+func (qry *testTimeoutQuery) Timeout() time.Duration {
+	return qry.timeout
+}
+
 type testHandlerOrderQuery struct {
 	position  *uint32
 	unordered *uint32
@@ -134,6 +214,71 @@ func (hdl *testHandlerOrder) Handle(ctx context.Context, qry Query, res *Result)
 	return nil
 }
 
+type testRoutedHandler struct {
+	handled *uint32
+}
+
+func (hdl *testRoutedHandler) Handles() []Query {
+	return []Query{&testQueryStruct{}}
+}
+
+func (hdl *testRoutedHandler) Handle(ctx context.Context, qry Query, res *Result) error {
+	atomic.AddUint32(hdl.handled, 1)
+	res.Add("routed")
+	res.Done()
+	return nil
+}
+
+type testRoutedIteratorHandler struct {
+	handled *uint32
+}
+
+func (hdl *testRoutedIteratorHandler) Handles() []Query {
+	return []Query{&testQueryStruct{}}
+}
+
+func (hdl *testRoutedIteratorHandler) Handle(ctx context.Context, qry Query, res *IteratorResult) error {
+	atomic.AddUint32(hdl.handled, 1)
+	res.Yield("routed")
+	res.Done()
+	return nil
+}
+
+// testFuncHandler is a handler of a non comparable type.
+type testFuncHandler func(ctx context.Context, qry Query, res *Result) error
+
+func (hdl testFuncHandler) Handle(ctx context.Context, qry Query, res *Result) error {
+	return hdl(ctx, qry, res)
+}
+
+type testProbeHandler struct {
+	err error
+}
+
+func (hdl *testProbeHandler) Probe() Query {
+	return &testQueryStruct{}
+}
+
+func (hdl *testProbeHandler) Handle(ctx context.Context, qry Query, res *Result) error {
+	if hdl.err != nil {
+		return hdl.err
+	}
+	res.Add("probe")
+	return nil
+}
+
+type testProbeIteratorHandler struct {
+}
+
+func (hdl *testProbeIteratorHandler) Probe() Query {
+	return &testQueryStruct{}
+}
+
+func (hdl *testProbeIteratorHandler) Handle(ctx context.Context, qry Query, res *IteratorResult) error {
+	res.Yield("probe")
+	return nil
+}
+
 type testIteratorHandler struct {
 }
 
@@ -147,6 +292,18 @@ func (hdl *testIteratorHandler) Handle(ctx context.Context, qry Query, res *Iter
 	return nil
 }
 
+type testForwardIteratorHandler struct {
+	to IteratorQuerier
+}
+
+func (hdl *testForwardIteratorHandler) Handle(ctx context.Context, qry Query, res *IteratorResult) error {
+	if err := res.Forward(ctx, hdl.to, qry); err != nil {
+		return err
+	}
+	res.Done()
+	return nil
+}
+
 type testIteratorHandlerWithErrors struct {
 }
 
@@ -174,6 +331,117 @@ func (hdl *testCacheHandler) Handle(ctx context.Context, qry Query, res *Result)
 	return nil
 }
 
+type testCountingCacheHandler struct {
+	calls *uint32
+}
+
+func (hdl *testCountingCacheHandler) Handle(ctx context.Context, qry Query, res *Result) error {
+	switch qry.(type) {
+	case *testCacheQuery:
+		atomic.AddUint32(hdl.calls, 1)
+		time.Sleep(time.Millisecond * 50)
+		res.Add("bar")
+		return nil
+	}
+	return nil
+}
+
+type testSlowHandler struct {
+	calls *uint32
+}
+
+func (hdl *testSlowHandler) Handle(ctx context.Context, qry Query, res *Result) error {
+	atomic.AddUint32(hdl.calls, 1)
+	time.Sleep(time.Millisecond * 30)
+	res.Add("bar")
+	return nil
+}
+
+type testFlakyHandler struct {
+	calls   *uint32
+	failing *uint32
+}
+
+func (hdl *testFlakyHandler) Handle(ctx context.Context, qry Query, res *Result) error {
+	atomic.AddUint32(hdl.calls, 1)
+	if atomic.LoadUint32(hdl.failing) == 1 {
+		return errors.New("query failed")
+	}
+	res.Add("bar")
+	return nil
+}
+
+type testCircuitQuery struct {
+	entered chan bool
+	release chan bool
+}
+
+func (*testCircuitQuery) ID() []byte {
+	return []byte("UUID-CIRCUIT")
+}
+
+// testCircuitHandler handles the circuit queries once released (or interrupted), failing them while failing.
+type testCircuitHandler struct {
+	failing *uint32
+}
+
+func (hdl *testCircuitHandler) Handle(ctx context.Context, qry Query, res *Result) error {
+	cqry, isCircuit := qry.(*testCircuitQuery)
+	if !isCircuit {
+		return nil
+	}
+	if cqry.release != nil {
+		cqry.entered <- true
+		select {
+		case <-cqry.release:
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+	if atomic.LoadUint32(hdl.failing) == 1 {
+		return errors.New("query failed")
+	}
+	res.Add("bar")
+	return nil
+}
+
+// newTestSlowCircuitQuery issues a circuit query in the background, returning once it is being handled.
+// The returned channel releases the handling, then provides its error.
+func newTestSlowCircuitQuery(bus *Bus) chan error {
+	qry := &testCircuitQuery{entered: make(chan bool), release: make(chan bool)}
+	done := make(chan error, 1)
+	go func() {
+		_, err := bus.Query(context.Background(), qry)
+		done <- err
+	}()
+	<-qry.entered
+	release := make(chan error)
+	go func() {
+		<-release
+		close(qry.release)
+		release <- <-done
+	}()
+	return release
+}
+
+type testWarnHandler struct {
+}
+
+func (hdl *testWarnHandler) Handle(ctx context.Context, qry Query, res *Result) error {
+	res.Warn(errors.New("stale index"))
+	res.Add("bar")
+	return nil
+}
+
+type testWarnIteratorHandler struct {
+}
+
+func (hdl *testWarnIteratorHandler) Handle(ctx context.Context, qry Query, res *IteratorResult) error {
+	res.Warn(errors.New("stale index"))
+	res.Yield("bar")
+	return nil
+}
+
 type testIteratorHandlerOrder struct {
 	position uint32
 }
@@ -187,8 +455,103 @@ func (hdl *testIteratorHandlerOrder) Handle(ctx context.Context, qry Query, res
 	return nil
 }
 
+//------Middlewares------//
+
+type testMiddleware struct {
+	sync.Mutex
+	name  string
+	calls *[]string
+	err   error
+}
+
+func (mw *testMiddleware) Query(ctx context.Context, qry Query, next QueryFunc) (*Result, error) {
+	mw.call()
+	if mw.err != nil {
+		return nil, mw.err
+	}
+	return next(ctx, qry)
+}
+
+func (mw *testMiddleware) IteratorQuery(ctx context.Context, qry Query, res *IteratorResult, next IteratorQueryFunc) error {
+	mw.call()
+	if mw.err != nil {
+		return mw.err
+	}
+	return next(ctx, qry, res)
+}
+
+func (mw *testMiddleware) call() {
+	mw.Lock()
+	*mw.calls = append(*mw.calls, mw.name)
+	mw.Unlock()
+}
+
+//------Deprecation Handlers------//
+
+type storeDeprecationsHandler struct {
+	sync.Mutex
+	usages []DeprecatedUsage
+}
+
+func (hdl *storeDeprecationsHandler) Handle(ctx context.Context, qry Query, usg DeprecatedUsage) {
+	hdl.Lock()
+	hdl.usages = append(hdl.usages, usg)
+	hdl.Unlock()
+}
+
+//------Observers------//
+
+type storeEventsObserver struct {
+	sync.Mutex
+	events []Event
+}
+
+func (obs *storeEventsObserver) Observe(ctx context.Context, evt Event) {
+	obs.Lock()
+	obs.events = append(obs.events, evt)
+	obs.Unlock()
+}
+
+func (obs *storeEventsObserver) Types() []EventType {
+	obs.Lock()
+	defer obs.Unlock()
+	types := make([]EventType, 0, len(obs.events))
+	for _, evt := range obs.events {
+		types = append(types, evt.Type)
+	}
+	return types
+}
+
 //------Error Handlers------//
 
+type testBatchErrorHandler struct {
+	sync.Mutex
+	batches [][]ErrorReport
+	started chan bool
+	release chan bool
+}
+
+func (hdl *testBatchErrorHandler) Handle(ctx context.Context, qry Query, err error) {
+	hdl.HandleBatch([]ErrorReport{{Context: ctx, Query: qry, Err: err}})
+}
+
+func (hdl *testBatchErrorHandler) HandleBatch(reports []ErrorReport) {
+	hdl.Lock()
+	hdl.batches = append(hdl.batches, reports)
+	first := len(hdl.batches) == 1
+	hdl.Unlock()
+	if first {
+		hdl.started <- true
+		<-hdl.release
+	}
+}
+
+func (hdl *testBatchErrorHandler) Batches() [][]ErrorReport {
+	hdl.Lock()
+	defer hdl.Unlock()
+	return hdl.batches
+}
+
 type storeErrorsHandler struct {
 	sync.Mutex
 	errs map[string]error
@@ -216,3 +579,794 @@ func (hdl *storeErrorsHandler) key(qry Query) string {
 	}
 	return string(qry.ID())
 }
+
+type testConcurrencyHandler struct {
+	running *int32
+	max     *int32
+}
+
+func (hdl *testConcurrencyHandler) Handle(ctx context.Context, qry Query, res *Result) error {
+	running := atomic.AddInt32(hdl.running, 1)
+	for {
+		max := atomic.LoadInt32(hdl.max)
+		if running <= max || atomic.CompareAndSwapInt32(hdl.max, max, running) {
+			break
+		}
+	}
+	time.Sleep(time.Millisecond * 20)
+	atomic.AddInt32(hdl.running, -1)
+	res.Add("bar")
+	return nil
+}
+
+type testValueIteratorHandler struct {
+	value   string
+	started chan bool
+	release chan bool
+}
+
+func (hdl *testValueIteratorHandler) Handle(ctx context.Context, qry Query, res *IteratorResult) error {
+	if hdl.started != nil {
+		hdl.started <- true
+		<-hdl.release
+	}
+	res.Yield(hdl.value)
+	return nil
+}
+
+type testDrainHandler struct {
+	started chan bool
+	release chan bool
+}
+
+func (hdl *testDrainHandler) Handle(ctx context.Context, qry Query, res *Result) error {
+	hdl.started <- true
+	select {
+	case <-hdl.release:
+		res.Add("drained")
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+type testDrainIteratorHandler struct {
+	started chan bool
+}
+
+func (hdl *testDrainIteratorHandler) Handle(ctx context.Context, qry Query, res *IteratorResult) error {
+	hdl.started <- true
+	<-ctx.Done()
+	return ctx.Err()
+}
+
+type testRecord struct {
+	Tags []string
+	meta map[string]string
+}
+
+type testCloneableRecord struct {
+	meta map[string]string
+}
+
+func (rec *testCloneableRecord) Clone() interface{} {
+	meta := make(map[string]string, len(rec.meta))
+	for k, v := range rec.meta {
+		meta[k] = v
+	}
+	return &testCloneableRecord{meta: meta}
+}
+
+type testMutableHandler struct {
+}
+
+func (hdl *testMutableHandler) Handle(ctx context.Context, qry Query, res *Result) error {
+	res.Add([]string{"foo"})
+	res.Add(&testRecord{Tags: []string{"foo"}})
+	res.Add(&testCloneableRecord{meta: map[string]string{"foo": "bar"}})
+	return nil
+}
+
+type testRemoteTransport struct {
+	calls *uint32
+}
+
+func (tpt *testRemoteTransport) Query(ctx context.Context, qry Query, res *Result) error {
+	atomic.AddUint32(tpt.calls, 1)
+	res.Add("remote")
+	return nil
+}
+
+func (tpt *testRemoteTransport) IteratorQuery(ctx context.Context, qry Query, res *IteratorResult) error {
+	atomic.AddUint32(tpt.calls, 1)
+	res.Yield("remote")
+	return nil
+}
+
+type testPriorityIteratorHandler struct {
+	release chan bool
+}
+
+func (hdl *testPriorityIteratorHandler) Handle(ctx context.Context, qry Query, res *IteratorResult) error {
+	if qry, listens := qry.(*testPriorityQuery); listens {
+		if qry.priority <= 0 {
+			<-hdl.release
+		}
+		res.Yield(qry.priority)
+	}
+	return nil
+}
+
+type testWorkerWrapper struct {
+	wrapped   *int32
+	unwrapped *int32
+}
+
+func (wrp *testWorkerWrapper) Wrap(ctx context.Context, run func(ctx context.Context)) {
+	id := atomic.AddInt32(wrp.wrapped, 1)
+	run(context.WithValue(ctx, testWorkerKey{}, id))
+	atomic.AddInt32(wrp.unwrapped, 1)
+}
+
+type testWorkerKey struct{}
+
+type testWorkerIteratorHandler struct {
+}
+
+func (hdl *testWorkerIteratorHandler) Handle(ctx context.Context, qry Query, res *IteratorResult) error {
+	if wrkCtx, ok := WorkerContext(ctx); ok {
+		res.Yield(wrkCtx.Value(testWorkerKey{}))
+	}
+	return nil
+}
+
+type testTenantKey struct{}
+
+type testContextDecorator struct {
+	tenant string
+}
+
+func (dec *testContextDecorator) Decorate(ctx context.Context, qry Query) (context.Context, error) {
+	if _, implements := qry.(*testQueryError); implements {
+		return ctx, errors.New("missing tenant")
+	}
+	if tenant, ok := ctx.Value(testTenantKey{}).(string); ok {
+		return context.WithValue(ctx, testTenantKey{}, tenant+"/"+dec.tenant), nil
+	}
+	return context.WithValue(ctx, testTenantKey{}, dec.tenant), nil
+}
+
+type testTenantHandler struct {
+}
+
+func (hdl *testTenantHandler) Handle(ctx context.Context, qry Query, res *Result) error {
+	res.Add(ctx.Value(testTenantKey{}))
+	return nil
+}
+
+type testTenantIteratorHandler struct {
+}
+
+func (hdl *testTenantIteratorHandler) Handle(ctx context.Context, qry Query, res *IteratorResult) error {
+	res.Yield(ctx.Value(testTenantKey{}))
+	return nil
+}
+
+type testValidatedQuery struct {
+	name string
+}
+
+func (*testValidatedQuery) ID() []byte {
+	return []byte("UUID-VALIDATED")
+}
+
+func (qry *testValidatedQuery) Validate(ctx context.Context) error {
+	if qry.name == "" {
+		return errors.New("missing name")
+	}
+	return nil
+}
+
+type testNameValidator struct {
+}
+
+func (vld *testNameValidator) Validate(ctx context.Context, qry Query) error {
+	if qry, implements := qry.(*testValidatedQuery); implements && len(qry.name) > 3 {
+		return errors.New("name too long")
+	}
+	return nil
+}
+
+type testCountingHandler struct {
+	calls *uint32
+}
+
+func (hdl *testCountingHandler) Handle(ctx context.Context, qry Query, res *Result) error {
+	atomic.AddUint32(hdl.calls, 1)
+	res.Add("bar")
+	return nil
+}
+
+type testCountingIteratorHandler struct {
+	calls *uint32
+}
+
+func (hdl *testCountingIteratorHandler) Handle(ctx context.Context, qry Query, res *IteratorResult) error {
+	atomic.AddUint32(hdl.calls, 1)
+	res.Yield("bar")
+	return nil
+}
+
+type testTickerSubscriptionHandler struct {
+}
+
+func (hdl *testTickerSubscriptionHandler) Handles() []Query {
+	return []Query{&testQueryStruct{}}
+}
+
+func (hdl *testTickerSubscriptionHandler) Subscribe(ctx context.Context, qry Query, sub *Subscription) error {
+	for i := 1; ; i++ {
+		if err := sub.Publish(i); err != nil {
+			return err
+		}
+	}
+}
+
+type testFailingSubscriptionHandler struct {
+}
+
+func (hdl *testFailingSubscriptionHandler) Handles() []Query {
+	return []Query{&testQueryError{}}
+}
+
+func (hdl *testFailingSubscriptionHandler) Subscribe(ctx context.Context, qry Query, sub *Subscription) error {
+	if err := sub.Publish("foo"); err != nil {
+		return err
+	}
+	return errors.New("feed lost")
+}
+
+type testLazyProbeHandler struct {
+}
+
+func (hdl *testLazyProbeHandler) Probe() Query {
+	return &testQueryStruct{}
+}
+
+func (hdl *testLazyProbeHandler) Handle(ctx context.Context, qry Query, res *Result) error {
+	// forgets to provide the data
+	return nil
+}
+
+type testCacheableIteratorHandler struct {
+}
+
+func (hdl *testCacheableIteratorHandler) Handles() []Query {
+	return []Query{&testCacheQuery{}}
+}
+
+func (hdl *testCacheableIteratorHandler) Handle(ctx context.Context, qry Query, res *IteratorResult) error {
+	res.Yield("bar")
+	return nil
+}
+
+type testPanicHandler struct {
+}
+
+func (hdl *testPanicHandler) Handle(ctx context.Context, qry Query, res *Result) error {
+	panic("handler panic")
+}
+
+type testPanicIteratorHandler struct {
+}
+
+func (hdl *testPanicIteratorHandler) Handle(ctx context.Context, qry Query, res *IteratorResult) error {
+	if qry, isString := qry.(testQueryString); isString && qry == "panic" {
+		panic("iterator handler panic")
+	}
+	res.Yield("bar")
+	return nil
+}
+
+type testPanicMiddleware struct {
+}
+
+func (mw *testPanicMiddleware) Query(ctx context.Context, qry Query, next QueryFunc) (*Result, error) {
+	return next(ctx, qry)
+}
+
+func (mw *testPanicMiddleware) IteratorQuery(ctx context.Context, qry Query, res *IteratorResult, next IteratorQueryFunc) error {
+	if qry, isString := qry.(testQueryString); isString && qry == "middleware" {
+		panic("middleware panic")
+	}
+	return next(ctx, qry, res)
+}
+
+var errTestUnavailable = errors.New("unavailable")
+
+type testSentinelHandler struct {
+}
+
+func (hdl *testSentinelHandler) Handle(ctx context.Context, qry Query, res *Result) error {
+	if _, isStruct := qry.(*testQueryStruct); isStruct {
+		return errTestUnavailable
+	}
+	return nil
+}
+
+type testYieldingIteratorHandler struct {
+	calls  *uint32
+	values []interface{}
+}
+
+func (hdl *testYieldingIteratorHandler) Handle(ctx context.Context, qry Query, res *IteratorResult) error {
+	atomic.AddUint32(hdl.calls, 1)
+	res.Handled()
+	for _, val := range hdl.values {
+		res.Yield(val)
+	}
+	return nil
+}
+
+func iterateAll(res *IteratorResult) []interface{} {
+	vals := make([]interface{}, 0)
+	for val := range res.Iterate() {
+		vals = append(vals, val)
+	}
+	return vals
+}
+
+type testExtendingIteratorHandler struct {
+	extension time.Duration
+	errs      chan error
+}
+
+func (hdl *testExtendingIteratorHandler) Handle(ctx context.Context, qry Query, res *IteratorResult) error {
+	for i := 0; i < 3; i++ {
+		if _, err := ExtendDeadline(ctx, hdl.extension); err != nil {
+			hdl.errs <- err
+		}
+		select {
+		case <-time.After(hdl.extension / 2):
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+		res.Yield(i)
+	}
+	return nil
+}
+
+type testLimitedDrainHandler struct {
+	testDrainHandler
+}
+
+func (hdl *testLimitedDrainHandler) ConcurrencyLimit() int {
+	return 1
+}
+
+type testSandboxedHandler struct {
+	values []interface{}
+	block  chan bool
+}
+
+func (hdl *testSandboxedHandler) Handle(ctx context.Context, qry Query, res *Result) error {
+	if hdl.block != nil {
+		// ignores its context
+		<-hdl.block
+	}
+	for _, val := range hdl.values {
+		res.Add(val)
+	}
+	res.SetTotal(len(hdl.values))
+	res.Meta("tenant", ctx.Value(testTenantKey{}))
+	return nil
+}
+
+// testResettingHandler sets its values twice, the second time replacing the first.
+type testResettingHandler struct {
+	values []interface{}
+}
+
+func (hdl *testResettingHandler) Handle(ctx context.Context, qry Query, res *Result) error {
+	res.Set(hdl.values)
+	res.Set(hdl.values)
+	return nil
+}
+
+// testUnboundedHandler adds values until its context is done, counting them.
+type testUnboundedHandler struct {
+	added int
+	done  chan bool
+}
+
+func (hdl *testUnboundedHandler) Handle(ctx context.Context, qry Query, res *Result) error {
+	defer close(hdl.done)
+	for ctx.Err() == nil {
+		res.Add("foo")
+		hdl.added++
+	}
+	return nil
+}
+
+type testNestedQuery struct {
+	depth int
+	max   int
+}
+
+func (*testNestedQuery) ID() []byte {
+	return []byte("UUID-NESTED")
+}
+
+type testNestingHandler struct {
+	bus      *Bus
+	cycle    bool
+	lineages []Lineage
+	inFlight []InFlightQuery
+}
+
+func (hdl *testNestingHandler) Handles() []Query {
+	return []Query{&testNestedQuery{}}
+}
+
+func (hdl *testNestingHandler) Handle(ctx context.Context, qry Query, res *Result) error {
+	nested := qry.(*testNestedQuery)
+	lin, _ := LineageFrom(ctx)
+	hdl.lineages = append(hdl.lineages, lin)
+	if nested.depth == nested.max {
+		hdl.inFlight = hdl.bus.InFlight()
+		res.Add(nested.depth)
+		return nil
+	}
+	child := &testNestedQuery{depth: nested.depth + 1, max: nested.max}
+	if hdl.cycle {
+		child = nested
+	}
+	childRes, err := hdl.bus.Query(ctx, child)
+	if err != nil {
+		return err
+	}
+	res.Add(childRes.First())
+	return nil
+}
+
+type testNestingIteratorHandler struct {
+	bus *Bus
+}
+
+func (hdl *testNestingIteratorHandler) Handles() []Query {
+	return []Query{&testNestedQuery{}}
+}
+
+func (hdl *testNestingIteratorHandler) Handle(ctx context.Context, qry Query, res *IteratorResult) error {
+	nested := qry.(*testNestedQuery)
+	if nested.depth == nested.max {
+		res.Yield(nested.depth)
+		res.Done()
+		return nil
+	}
+	childRes, err := hdl.bus.IteratorQuery(ctx, &testNestedQuery{depth: nested.depth + 1, max: nested.max})
+	if err != nil {
+		return err
+	}
+	for val := range childRes.Iterate() {
+		res.Yield(val)
+	}
+	res.Done()
+	return childRes.Err()
+}
+
+type testRevalidatedQuery struct {
+}
+
+func (*testRevalidatedQuery) ID() []byte {
+	return []byte("UUID-REVALIDATED")
+}
+
+func (*testRevalidatedQuery) CacheKey() []byte {
+	return []byte("REVALIDATED-KEY")
+}
+
+func (*testRevalidatedQuery) CacheDuration() time.Duration {
+	return time.Millisecond * 200
+}
+
+func (*testRevalidatedQuery) CacheTags() [][]byte {
+	return [][]byte{[]byte("REVALIDATED")}
+}
+
+type testRevalidatingHandler struct {
+	calls  *uint32
+	checks *uint32
+	fresh  *uint32
+}
+
+func (hdl *testRevalidatingHandler) Handle(ctx context.Context, qry Query, res *Result) error {
+	if _, revalidated := qry.(*testRevalidatedQuery); !revalidated {
+		return nil
+	}
+	res.Add(atomic.AddUint32(hdl.calls, 1))
+	res.Revalidate(func(ctx context.Context) (bool, error) {
+		atomic.AddUint32(hdl.checks, 1)
+		return atomic.LoadUint32(hdl.fresh) == 1, nil
+	})
+	return nil
+}
+
+type testBlockingReplicator struct {
+	release chan bool
+}
+
+func (rpl *testBlockingReplicator) Replicate(ctx context.Context, op CacheOperation) error {
+	<-rpl.release
+	return errors.New("replica unavailable")
+}
+
+// testVersionedCacheAdapter serializes the results along with the version of their schema.
+// testPanickingCacheAdapter is a memory cache adapter panicking when storing a result.
+type testPanickingCacheAdapter struct {
+	*MemoryCacheAdapter
+}
+
+func (ad *testPanickingCacheAdapter) Set(ctx context.Context, qry Cacheable, res *Result) bool {
+	panic("cache adapter panic")
+}
+
+// testShutdownCacheAdapter is a memory cache adapter calling the provided function once shut down.
+type testShutdownCacheAdapter struct {
+	*MemoryCacheAdapter
+	shutdown func()
+}
+
+func (ad *testShutdownCacheAdapter) Shutdown() {
+	ad.shutdown()
+	ad.MemoryCacheAdapter.Shutdown()
+}
+
+// testSlowAbortHandler takes a while to honor the cancellation of its context, flagging once finished.
+type testSlowAbortHandler struct {
+	started  chan bool
+	finished *uint32
+}
+
+func (hdl *testSlowAbortHandler) Handle(ctx context.Context, qry Query, res *Result) error {
+	hdl.started <- true
+	<-ctx.Done()
+	time.Sleep(time.Millisecond * 30)
+	atomic.StoreUint32(hdl.finished, 1)
+	return ctx.Err()
+}
+
+type testVersionedCacheAdapter struct {
+	mu      sync.Mutex
+	entries map[string][]byte
+}
+
+func newTestVersionedCacheAdapter() *testVersionedCacheAdapter {
+	return &testVersionedCacheAdapter{entries: map[string][]byte{}}
+}
+
+func (ad *testVersionedCacheAdapter) Set(ctx context.Context, qry Cacheable, res *Result) bool {
+	data, err := MarshalVersionedResult(ctx, JSONCodec{}, qry, res)
+	if err != nil {
+		return false
+	}
+	ad.mu.Lock()
+	ad.entries[string(qry.CacheKey())] = data
+	ad.mu.Unlock()
+	return true
+}
+
+func (ad *testVersionedCacheAdapter) Get(ctx context.Context, qry Cacheable) *Result {
+	ad.mu.Lock()
+	data, exists := ad.entries[string(qry.CacheKey())]
+	ad.mu.Unlock()
+	if !exists {
+		return nil
+	}
+	res, err := UnmarshalVersionedResult(ctx, JSONCodec{}, qry, data)
+	if err != nil {
+		return nil
+	}
+	return res
+}
+
+func (ad *testVersionedCacheAdapter) Expire(ctx context.Context, qry Cacheable) {
+	ad.mu.Lock()
+	delete(ad.entries, string(qry.CacheKey()))
+	ad.mu.Unlock()
+}
+
+func (ad *testVersionedCacheAdapter) ExpireTags(ctx context.Context, tags ...[]byte) {
+}
+
+func (ad *testVersionedCacheAdapter) Shutdown() {
+}
+
+// version returns the schema version the result of the query was serialized with, -1 if not cached.
+func (ad *testVersionedCacheAdapter) version(qry Cacheable) int {
+	ad.mu.Lock()
+	data, exists := ad.entries[string(qry.CacheKey())]
+	ad.mu.Unlock()
+	enc := &encodedResult{}
+	if !exists || json.Unmarshal(data, enc) != nil {
+		return -1
+	}
+	return enc.Version
+}
+
+type testViewQuery struct {
+}
+
+func (*testViewQuery) ID() []byte {
+	return []byte("UUID-VIEW")
+}
+
+func (*testViewQuery) CacheKey() []byte {
+	return []byte("VIEW-KEY")
+}
+
+func (*testViewQuery) CacheDuration() time.Duration {
+	return time.Minute
+}
+
+func (*testViewQuery) CacheTags() [][]byte {
+	return [][]byte{[]byte("VIEW")}
+}
+
+type testViewHandler struct {
+	calls *uint32
+}
+
+func (hdl *testViewHandler) Handle(ctx context.Context, qry Query, res *Result) error {
+	if _, isView := qry.(*testViewQuery); isView {
+		res.Add(atomic.AddUint32(hdl.calls, 1))
+	}
+	return nil
+}
+
+// waitForView waits for the view to serve a result produced by at least the given number of handler calls.
+func waitForView(bus *Bus, name string, calls uint32) bool {
+	for i := 0; i < 100; i++ {
+		if res, err := bus.View(context.Background(), name); err == nil && res.First().(uint32) >= calls {
+			return true
+		}
+		time.Sleep(time.Millisecond * 10)
+	}
+	return false
+}
+
+type testSnapshotQuery struct {
+}
+
+func (*testSnapshotQuery) ID() []byte {
+	return []byte("UUID-SNAPSHOT")
+}
+
+func (*testSnapshotQuery) CacheKey() []byte {
+	return []byte("SNAPSHOT-KEY")
+}
+
+func (*testSnapshotQuery) CacheDuration() time.Duration {
+	return time.Minute
+}
+
+type testSnapshotHandler struct {
+}
+
+func (hdl *testSnapshotHandler) Handle(ctx context.Context, qry Query, res *Result) error {
+	if _, isSnapshot := qry.(*testSnapshotQuery); !isSnapshot {
+		return nil
+	}
+	snap, err := SnapshotFrom(ctx)
+	if err != nil {
+		return err
+	}
+	if snap == nil {
+		res.Add("live")
+		return nil
+	}
+	res.Add(snap.Token())
+	return nil
+}
+
+type testSnapshot struct {
+	released *uint32
+}
+
+func (snap *testSnapshot) Token() string {
+	return "TX-1"
+}
+
+func (snap *testSnapshot) Release() {
+	atomic.AddUint32(snap.released, 1)
+}
+
+type testShedQuery struct {
+}
+
+func (*testShedQuery) ID() []byte {
+	return []byte("UUID-SHED")
+}
+
+type testShedHandler struct {
+	slow *uint32
+}
+
+func (hdl *testShedHandler) Handle(_ context.Context, qry Query, res *Result) error {
+	if _, isShed := qry.(*testShedQuery); !isShed {
+		return nil
+	}
+	if atomic.LoadUint32(hdl.slow) == 1 {
+		time.Sleep(time.Millisecond * 20)
+	}
+	res.Add("handled")
+	return nil
+}
+
+// waitForShedding queries until the share of the traffic shed satisfies the condition.
+func waitForShedding(bus *Bus, cond func(share float64) bool) bool {
+	deadline := time.Now().Add(time.Second * 3)
+	for time.Now().Before(deadline) {
+		_, _ = bus.Query(context.Background(), &testShedQuery{})
+		if stats := bus.LoadSheddingStats(); len(stats) == 1 && cond(stats[0].Share) {
+			return true
+		}
+		time.Sleep(time.Millisecond)
+	}
+	return false
+}
+
+type testStreamQuery struct {
+	count int
+}
+
+func (*testStreamQuery) ID() []byte {
+	return []byte("UUID-STREAM")
+}
+
+type testStreamHandler struct {
+}
+
+func (hdl *testStreamHandler) Handle(ctx context.Context, qry Query, res *IteratorResult) error {
+	sqry, isStream := qry.(*testStreamQuery)
+	if !isStream {
+		return nil
+	}
+	for i := 0; i < sqry.count; i++ {
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+		res.Yield(i)
+	}
+	return nil
+}
+
+type testCountableHandler struct {
+	total  int
+	counts *uint32
+}
+
+func (hdl *testCountableHandler) Handle(_ context.Context, qry Query, res *Result) error {
+	pqry, isPaginated := qry.(*testPaginatedQuery)
+	if !isPaginated {
+		return nil
+	}
+	page := pqry.Pagination.(Page)
+	for i := page.Offset(); i < page.Offset()+page.Size && i < hdl.total; i++ {
+		res.Add(i)
+	}
+	res.Done()
+	return nil
+}
+
+func (hdl *testCountableHandler) Count(_ context.Context, qry Query, res *Result) error {
+	if _, isPaginated := qry.(*testPaginatedQuery); !isPaginated {
+		return nil
+	}
+	atomic.AddUint32(hdl.counts, 1)
+	if hdl.total < 0 {
+		return errors.New("count failed")
+	}
+	res.SetTotal(hdl.total)
+	return nil
+}
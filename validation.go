@@ -0,0 +1,40 @@
+package query
+
+import "context"
+
+// Validatable may optionally be implemented by queries to validate their own input.
+// Invalid queries are rejected before being dispatched, without invoking any handler.
+type Validatable interface {
+	Validate(ctx context.Context) error
+}
+
+// Validator must be implemented for a type to qualify as a query validator.
+// Validators are intended for external validation strategies (e.g. based on struct tags).
+type Validator interface {
+	Validate(ctx context.Context, qry Query) error
+}
+
+// Validators may optionally be provided.
+// They are used in the order they are provided, after the queries validate themselves (Validatable).
+func (bus *Bus) Validators(vlds ...Validator) {
+	bus.validators = vlds
+}
+
+//------Internal------//
+
+// validate the input of the query, once its context is decorated.
+// The validation errors are wrapped with ErrorInvalidQueryInput and passed on to the error handlers.
+func (bus *Bus) validate(ctx context.Context, qry Query) error {
+	var err error
+	if vld, implements := qry.(Validatable); implements {
+		err = vld.Validate(ctx)
+	}
+	for i := 0; err == nil && i < len(bus.validators); i++ {
+		err = bus.validators[i].Validate(ctx, qry)
+	}
+	if err != nil {
+		err = NewErrorInvalidQueryInput(qry, err)
+		bus.error(ctx, qry, err)
+	}
+	return err
+}
@@ -0,0 +1,311 @@
+package query
+
+import (
+	"context"
+	"sort"
+	"sync"
+	"time"
+)
+
+// ViewDefinition describes a materialized view (see Bus.Materialize).
+type ViewDefinition struct {
+	// Query is the query whose result is materialized.
+	Query Query
+	// RefreshInterval refreshes the view periodically, if positive.
+	RefreshInterval time.Duration
+	// Tags refresh the view whenever any of them is invalidated (see Bus.InvalidateTags).
+	// The tags of Taggable queries are considered as well.
+	Tags [][]byte
+}
+
+// ViewStats describes a materialized view (see Bus.Views).
+type ViewStats struct {
+	Name  string
+	Query Query
+	// Materialized reports whether a result is currently materialized.
+	Materialized bool
+	// RefreshedAt is the moment the materialized result was produced, according to the clock of the bus.
+	RefreshedAt time.Time
+	// Hits is the number of reads served by the materialized result.
+	Hits uint64
+	// Misses is the number of reads falling back to the live query.
+	Misses uint64
+	// Refreshes is the number of refreshes performed in the background.
+	Refreshes uint64
+	// LastError is the error of the last refresh, if it failed.
+	LastError error
+}
+
+// Materialize registers the materialized view of the given name, keeping the result of its query in memory.
+// The view is materialized by the first read (see View), and refreshed in the background afterwards:
+// periodically (see ViewDefinition.RefreshInterval), when requested (see RefreshView)
+// and whenever its query or its tags are invalidated (see Invalidate and InvalidateTags).
+// A failed refresh keeps the previous result materialized, while an invalidation discards it until refreshed.
+// The background refreshes bypass the cached results (see WithMaxStaleness). The views are discarded once the bus shuts down.
+// Materializing a view of the same name replaces it.
+func (bus *Bus) Materialize(name string, def ViewDefinition) {
+	v := &view{name: name, def: def, refresh: make(chan bool, 1), stop: make(chan bool)}
+	if cqry, isCacheable := def.Query.(Cacheable); isCacheable {
+		v.key = cqry.CacheKey()
+	}
+	v.tags = append(v.tags, def.Tags...)
+	if tgb, implements := def.Query.(Taggable); implements {
+		v.tags = append(v.tags, tgb.CacheTags()...)
+	}
+	bus.views.Lock()
+	if prev, exists := bus.views.entries[name]; exists {
+		close(prev.stop)
+	}
+	bus.views.entries[name] = v
+	bus.views.Unlock()
+	bus.spawn(def.Query, "view refresh", func() { bus.maintain(v) })
+}
+
+// View returns the materialized result of the view of the given name, at memory speed.
+// While no result is materialized (e.g. before the first read or after an invalidation), the query is performed live instead,
+// its result being materialized. The concurrent reads missing the view share a single live query, performed with the context
+// of the background refreshes rather than the context of the reader (the result being shared by every reader),
+// while each reader waits for it until its own context is done.
+// It returns an ErrorViewNotFound if the view was not materialized (see Materialize).
+func (bus *Bus) View(ctx context.Context, name string) (*Result, error) {
+	v, exists := bus.views.get(name)
+	if !exists {
+		return nil, NewErrorViewNotFound(name)
+	}
+	if res, refreshedAt, materialized := v.read(); materialized {
+		bus.served(ctx, ViewHit, v.def.Query, refreshedAt)
+		return bus.clone(res), nil
+	}
+	bus.observe(ctx, Event{Type: ViewMiss, Query: v.def.Query})
+	f, seq, leader := v.join()
+	if leader {
+		bus.spawn(v.def.Query, "view", func() {
+			res, err := bus.Query(viewContext(), v.def.Query)
+			v.land(f, seq, res, err, bus.clock.Now())
+		})
+	}
+	select {
+	case <-f.done:
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+	if f.err != nil {
+		return nil, f.err
+	}
+	return bus.share(f.res), nil
+}
+
+// RefreshView requests the view of the given name to be refreshed in the background, e.g. when handling a domain event.
+// The current result remains served meanwhile. It returns an ErrorViewNotFound if the view was not materialized (see Materialize).
+func (bus *Bus) RefreshView(name string) error {
+	v, exists := bus.views.get(name)
+	if !exists {
+		return NewErrorViewNotFound(name)
+	}
+	v.requestRefresh()
+	return nil
+}
+
+// Views returns the stats of the materialized views, ordered by name.
+func (bus *Bus) Views() []ViewStats {
+	bus.views.RLock()
+	stats := make([]ViewStats, 0, len(bus.views.entries))
+	for _, v := range bus.views.entries {
+		v.Lock()
+		st := v.stats
+		st.Name = v.name
+		st.Query = v.def.Query
+		st.Materialized = v.res != nil
+		v.Unlock()
+		stats = append(stats, st)
+	}
+	bus.views.RUnlock()
+	sort.Slice(stats, func(i, j int) bool {
+		return stats[i].Name < stats[j].Name
+	})
+	return stats
+}
+
+//------Internal------//
+
+// views keeps the materialized views of the bus, by name.
+type views struct {
+	sync.RWMutex
+	entries map[string]*view
+}
+
+type view struct {
+	sync.Mutex
+	name    string
+	def     ViewDefinition
+	key     []byte
+	tags    [][]byte
+	res     *Result
+	stats   ViewStats
+	refresh chan bool
+	stop    chan bool
+	// seq orders the attempts to materialize the view, only the latest attempt started being materialized.
+	seq          uint64
+	materialized uint64
+	// pending is the live query shared by the reads missing the view, if any.
+	pending *flight
+}
+
+func newViews() *views {
+	return &views{entries: make(map[string]*view)}
+}
+
+func (vs *views) get(name string) (*view, bool) {
+	vs.RLock()
+	defer vs.RUnlock()
+	v, exists := vs.entries[name]
+	return v, exists
+}
+
+// invalidate discards the results of the views of the cache key or sharing any of the tags, refreshing them.
+func (vs *views) invalidate(key []byte, tags [][]byte) {
+	vs.RLock()
+	defer vs.RUnlock()
+	for _, v := range vs.entries {
+		if (key != nil && v.key != nil && string(key) == string(v.key)) || sharesTag(v.tags, tags) {
+			v.discard()
+			v.requestRefresh()
+		}
+	}
+}
+
+// stop the background refreshes of the views, discarding them.
+func (vs *views) stop() {
+	vs.Lock()
+	for name, v := range vs.entries {
+		close(v.stop)
+		delete(vs.entries, name)
+	}
+	vs.Unlock()
+}
+
+func (v *view) read() (*Result, time.Time, bool) {
+	v.Lock()
+	defer v.Unlock()
+	if v.res == nil {
+		v.stats.Misses++
+		return nil, time.Time{}, false
+	}
+	v.stats.Hits++
+	return v.res, v.stats.RefreshedAt, true
+}
+
+// begin an attempt to materialize the view, returning its sequence number.
+func (v *view) begin() uint64 {
+	v.Lock()
+	defer v.Unlock()
+	v.seq++
+	return v.seq
+}
+
+// join the live query shared by the reads missing the view, returning whether the caller is the leader (responsible for landing it),
+// along with the sequence number of its attempt to materialize the view.
+func (v *view) join() (*flight, uint64, bool) {
+	v.Lock()
+	defer v.Unlock()
+	if v.pending != nil {
+		return v.pending, 0, false
+	}
+	v.seq++
+	v.pending = &flight{done: make(chan bool)}
+	return v.pending, v.seq, true
+}
+
+// land the live query shared by the reads missing the view, materializing its result.
+func (v *view) land(f *flight, seq uint64, res *Result, err error, now time.Time) {
+	if err == nil {
+		v.materialize(seq, res, nil, now)
+	}
+	v.Lock()
+	if v.pending == f {
+		v.pending = nil
+	}
+	v.Unlock()
+	f.res = res
+	f.err = err
+	close(f.done)
+}
+
+// materialize the result of the attempt, unless a later attempt was materialized or the view was invalidated meanwhile.
+func (v *view) materialize(seq uint64, res *Result, err error, now time.Time) {
+	v.Lock()
+	defer v.Unlock()
+	if err != nil {
+		v.stats.LastError = err
+		return
+	}
+	if seq <= v.materialized {
+		return
+	}
+	v.res = res
+	v.materialized = seq
+	v.stats.RefreshedAt = now
+	v.stats.LastError = nil
+}
+
+// discard the materialized result, the attempts started beforehand (including the pending live query) no longer being materialized.
+func (v *view) discard() {
+	v.Lock()
+	v.res = nil
+	v.seq++
+	v.materialized = v.seq
+	v.pending = nil
+	v.Unlock()
+}
+
+func (v *view) requestRefresh() {
+	select {
+	case v.refresh <- true:
+	default:
+	}
+}
+
+// maintain refreshes the view in the background, until it is stopped.
+func (bus *Bus) maintain(v *view) {
+	var t Timer
+	var tick <-chan time.Time
+	if v.def.RefreshInterval > 0 {
+		t = bus.clock.NewTimer(v.def.RefreshInterval)
+		defer t.Stop()
+		tick = t.C()
+	}
+	for {
+		select {
+		case <-v.stop:
+			return
+		case <-v.refresh:
+			// the periodic refreshes restart from the requested refresh
+			if t != nil && !t.Stop() {
+				select {
+				case <-tick:
+				default:
+				}
+			}
+		case <-tick:
+		}
+		bus.refreshView(v)
+		if t != nil {
+			t.Reset(v.def.RefreshInterval)
+		}
+	}
+}
+
+func (bus *Bus) refreshView(v *view) {
+	seq := v.begin()
+	res, err := bus.Query(viewContext(), v.def.Query)
+	v.Lock()
+	v.stats.Refreshes++
+	v.Unlock()
+	v.materialize(seq, res, err, bus.clock.Now())
+}
+
+// viewContext returns the context of the queries materializing the views, bypassing the cached results.
+// It is neutral, the materialized results being shared by every reader.
+func viewContext() context.Context {
+	return WithMaxStaleness(context.Background(), -1)
+}
@@ -0,0 +1,8 @@
+package query
+
+import "context"
+
+// WarningHandler must be implemented for a type to qualify as a warning handler.
+type WarningHandler interface {
+	Handle(ctx context.Context, qry Query, warning error)
+}
@@ -0,0 +1,48 @@
+package query
+
+import "context"
+
+// WorkerWrapper may optionally be provided to wrap the lifetime of every iterator worker goroutine.
+// It is intended for per-goroutine setup (e.g. runtime.LockOSThread, pinning a database session, panic telemetry).
+// The run function must be called, blocking until the worker stops. The provided context is the context of the worker.
+type WorkerWrapper interface {
+	Wrap(ctx context.Context, run func(ctx context.Context))
+}
+
+// IteratorWorkerWrapper may optionally be provided to wrap the lifetime of every iterator worker goroutine.
+// It can only be used *before* the bus is initialized.
+func (bus *Bus) IteratorWorkerWrapper(wrp WorkerWrapper) {
+	if !bus.isInitialized() {
+		bus.workerWrapper = wrp
+	}
+}
+
+// WorkerContext returns the context provided by the WorkerWrapper to the worker handling the iterator query, if any.
+// It allows the iterator handlers to use the values of the worker context (e.g. a pinned database session).
+func WorkerContext(ctx context.Context) (context.Context, bool) {
+	wrkCtx, ok := ctx.Value(workerContextKey).(context.Context)
+	return wrkCtx, ok
+}
+
+//------Internal------//
+
+func withWorkerContext(ctx context.Context, wrkCtx context.Context) context.Context {
+	return context.WithValue(ctx, workerContextKey, wrkCtx)
+}
+
+// runIteratorWorker runs an iterator worker, signaling once it is stopped or retired (and unwrapped).
+func (bus *Bus) runIteratorWorker(lane *iteratorLane, closed chan<- bool) {
+	stopped := false
+	if bus.workerWrapper == nil {
+		stopped = bus.iteratorWorker(context.Background(), lane)
+	} else {
+		bus.workerWrapper.Wrap(context.Background(), func(ctx context.Context) {
+			stopped = bus.iteratorWorker(ctx, lane)
+		})
+	}
+	if stopped {
+		closed <- true
+		return
+	}
+	lane.retired <- true
+}